@@ -5,13 +5,11 @@ import (
 
 	"github.com/pkg/errors"
 
-	"github.com/suborbital/e2core/scheduler"
-
 	"github.com/suborbital/sat/engine/runtime"
 )
 
 func (d *defaultAPI) AbortHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		msgPtr := args[0].(int32)
 		msgSize := args[1].(int32)
 		filePtr := args[2].(int32)
@@ -20,28 +18,43 @@ func (d *defaultAPI) AbortHandler() runtime.HostFn {
 		columnNum := args[5].(int32)
 		ident := args[6].(int32)
 
-		d.returnAbort(msgPtr, msgSize, filePtr, fileSize, lineNum, columnNum, ident)
+		if err := d.returnAbort(caller, msgPtr, msgSize, filePtr, fileSize, lineNum, columnNum, ident); err != nil {
+			return nil, errors.Wrap(err, "failed to returnAbort")
+		}
 
 		return nil, nil
 	}
 
-	return runtime.NewHostFn("return_abort", 7, false, fn)
+	return runtime.NewCallerHostFn("return_abort", 7, false, fn)
 }
 
-func (d *defaultAPI) returnAbort(msgPtr int32, msgSize int32, filePtr int32, fileSize int32, lineNum int32, columnNum int32, ident int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(ident, false)
+func (d *defaultAPI) returnAbort(caller *runtime.WasmInstance, msgPtr int32, msgSize int32, filePtr int32, fileSize int32, lineNum int32, columnNum int32, ident int32) error {
+	inst, err := runtime.CallerInstance(caller, ident, false)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return -1
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return nil
 	}
 
-	msg := inst.ReadMemory(msgPtr, msgSize)
-	fileName := inst.ReadMemory(filePtr, fileSize)
+	msg, err := inst.BorrowMemory(msgPtr, msgSize)
+	if err != nil {
+		return errors.Wrap(err, "failed to BorrowMemory message")
+	}
 
-	errMsg := fmt.Sprintf("runnable abort: %s; file: %s, line: %d, col: %d", msg, fileName, lineNum, columnNum)
-	runtime.InternalLogger().ErrorString(errMsg)
+	fileName, err := inst.BorrowMemory(filePtr, fileSize)
+	if err != nil {
+		return errors.Wrap(err, "failed to BorrowMemory file name")
+	}
+
+	// the runner logs the trap, with the backtrace of the trap that usually follows an abort
+	trap := &runtime.Trap{
+		Kind:    runtime.TrapAbort,
+		Message: fmt.Sprintf("runnable abort: %s", msg),
+		Frames: []runtime.TrapFrame{
+			{File: string(fileName), Line: int(lineNum), Column: int(columnNum)},
+		},
+	}
 
-	inst.SendExecutionResult(nil, scheduler.RunErr{Code: -1, Message: errMsg})
+	inst.SendExecutionResult(nil, trap)
 
-	return 0
+	return nil
 }
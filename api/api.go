@@ -1,6 +1,8 @@
 package api
 
 import (
+	"strings"
+
 	"github.com/pkg/errors"
 
 	"github.com/suborbital/appspec/capabilities"
@@ -28,6 +30,14 @@ func New() HostAPI {
 
 // NewWithConfig returns the default engine API with the given config
 func NewWithConfig(config capabilities.CapabilityConfig) (HostAPI, error) {
+	// the env secrets source reads its allowed keys without checking that they were configured, which
+	// the default config doesn't do, so no keys are allowed rather than get_secret_value panicking
+	if config.Secrets != nil && config.Secrets.Env == nil {
+		secrets := *config.Secrets
+		secrets.Env = &capabilities.EnvSecretsConfig{}
+		config.Secrets = &secrets
+	}
+
 	caps, err := capabilities.NewWithConfig(config)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to capabilities.NewWithConfig")
@@ -61,5 +71,68 @@ func (d *defaultAPI) HostFunctions() []runtime.HostFn {
 		d.GetSecretValueHandler(),
 	}
 
+	// the v2 host API is served alongside the original, so that modules built against either can run
+	fns = append(fns, d.v2HostFunctions()...)
+
 	return fns
 }
+
+// the capabilities that host functions are grouped into
+const (
+	CapabilityCore    = "core"
+	CapabilityLogger  = "logger"
+	CapabilityHTTP    = "http"
+	CapabilityGraphQL = "graphql"
+	CapabilityCache   = "cache"
+	CapabilityDB      = "db"
+	CapabilitySecrets = "secrets"
+	CapabilityStatic  = "static"
+	CapabilityRequest = "request"
+)
+
+// swiftSuffix is appended to the names of the host functions imported by Swift modules
+const swiftSuffix = "_swift"
+
+var hostFnCapabilities = map[string]string{
+	"return_result":     CapabilityCore,
+	"return_error":      CapabilityCore,
+	"return_abort":      CapabilityCore,
+	"get_ffi_result":    CapabilityCore,
+	"add_ffi_var":       CapabilityCore,
+	"log_msg":           CapabilityLogger,
+	"fetch_url":         CapabilityHTTP,
+	"graphql_query":     CapabilityGraphQL,
+	"cache_set":         CapabilityCache,
+	"cache_get":         CapabilityCache,
+	"db_exec":           CapabilityDB,
+	"get_secret_value":  CapabilitySecrets,
+	"get_static_file":   CapabilityStatic,
+	"request_get_field": CapabilityRequest,
+	"request_set_field": CapabilityRequest,
+	"resp_set_header":   CapabilityRequest,
+}
+
+// CapabilityFor returns the capability that the named host function (or its Swift variant) belongs to, and false if
+// the default API has no such host function
+func CapabilityFor(hostFn string) (string, bool) {
+	capability, ok := hostFnCapabilities[strings.TrimSuffix(hostFn, swiftSuffix)]
+
+	return capability, ok
+}
+
+// CapabilityForImport returns the capability that a host function imported from the namespace belongs to, whether
+// it's one of the original host functions imported from env or a function of the v2 host API's interfaces, and false
+// if the default API has no such host function
+func CapabilityForImport(namespace, hostFn string) (string, bool) {
+	if namespace == "env" {
+		return CapabilityFor(hostFn)
+	}
+
+	for iface, capability := range v2Capabilities {
+		if namespace == runtime.WITNamespace(iface) {
+			return capability, true
+		}
+	}
+
+	return "", false
+}
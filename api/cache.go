@@ -1,13 +1,15 @@
 package api
 
 import (
+	"strconv"
+
 	"github.com/pkg/errors"
 
 	"github.com/suborbital/sat/engine/runtime"
 )
 
 func (d *defaultAPI) CacheSetHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		keyPointer := args[0].(int32)
 		keySize := args[1].(int32)
 		valPointer := args[2].(int32)
@@ -15,69 +17,112 @@ func (d *defaultAPI) CacheSetHandler() runtime.HostFn {
 		ttl := args[4].(int32)
 		ident := args[5].(int32)
 
-		ret := d.cacheSet(keyPointer, keySize, valPointer, valSize, ttl, ident)
+		ret, err := d.cacheSet(caller, keyPointer, keySize, valPointer, valSize, ttl, ident)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to cacheSet")
+		}
 
 		return ret, nil
 	}
 
-	return runtime.NewHostFn("cache_set", 6, true, fn)
+	return runtime.NewCallerHostFn("cache_set", 6, true, fn)
 }
 
-func (d *defaultAPI) cacheSet(keyPointer int32, keySize int32, valPointer int32, valSize int32, ttl int32, identifier int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(identifier, false)
+func (d *defaultAPI) cacheSet(caller *runtime.WasmInstance, keyPointer int32, keySize int32, valPointer int32, valSize int32, ttl int32, identifier int32) (int32, error) {
+	inst, err := runtime.CallerInstance(caller, identifier, false)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return -1
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return -1, nil
 	}
 
-	key := inst.ReadMemory(keyPointer, keySize)
-	val := inst.ReadMemory(valPointer, valSize)
+	key, err := inst.BorrowMemory(keyPointer, keySize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to BorrowMemory key")
+	}
+
+	// the cache keeps the value, so it is copied out of the guest's memory
+	val, err := inst.ReadMemory(valPointer, valSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to ReadMemory value")
+	}
 
 	runtime.InternalLogger().Debug("[engine] setting cache key", string(key))
 
-	if err := d.capabilities.Cache.Set(string(key), val, int(ttl)); err != nil {
+	if err := d.setCached(inst, string(key), val, int(ttl)); err != nil {
 		runtime.InternalLogger().ErrorString("[engine] failed to set cache key", string(key), err.Error())
-		return -2
+		return -2, nil
 	}
 
-	return 0
+	return 0, nil
 }
 
 func (d *defaultAPI) CacheGetHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		keyPointer := args[0].(int32)
 		keySize := args[1].(int32)
 		ident := args[2].(int32)
 
-		ret := d.cacheGet(keyPointer, keySize, ident)
+		ret, err := d.cacheGet(caller, keyPointer, keySize, ident)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to cacheGet")
+		}
 
 		return ret, nil
 	}
 
-	return runtime.NewHostFn("cache_get", 3, true, fn)
+	return runtime.NewCallerHostFn("cache_get", 3, true, fn)
 }
 
-func (d *defaultAPI) cacheGet(keyPointer int32, keySize int32, identifier int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(identifier, true)
+func (d *defaultAPI) cacheGet(caller *runtime.WasmInstance, keyPointer int32, keySize int32, identifier int32) (int32, error) {
+	inst, err := runtime.CallerInstance(caller, identifier, true)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return -1
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return -1, nil
 	}
 
-	key := inst.ReadMemory(keyPointer, keySize)
+	key, err := inst.BorrowMemory(keyPointer, keySize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to BorrowMemory key")
+	}
 
 	runtime.InternalLogger().Debug("[engine] getting cache key", string(key))
 
-	val, err := d.capabilities.Cache.Get(string(key))
-	if err != nil {
+	val, err := d.getCached(inst, string(key))
+	// a module that runs deterministically is stopped rather than given an error that it could carry on from
+	if errors.Is(err, ErrNondeterministic) {
+		return 0, err
+	} else if err != nil {
 		runtime.InternalLogger().ErrorString("[engine] failed to get cache key", string(key), err.Error())
 	}
 
 	result, err := inst.Ctx().SetFFIResult(val, err)
 	if err != nil {
 		runtime.InternalLogger().ErrorString("[engine] failed to SetFFIResult", err.Error())
-		return -1
+		return -1, nil
 	}
 
-	return result.FFISize()
+	return result.FFISize(), nil
+}
+
+// getCached gets a key from the cache for the instance
+func (d *defaultAPI) getCached(inst *runtime.WasmInstance, key string) ([]byte, error) {
+	call := &HostCall{Name: "cache_get", Args: []string{key}}
+
+	err := callCapability(inst, call, func(call *HostCall) error {
+		val, err := d.capabilities.Cache.Get(key)
+		call.Result = val
+
+		return err
+	})
+
+	return call.Result, err
+}
+
+// setCached sets a key in the cache for the instance
+func (d *defaultAPI) setCached(inst *runtime.WasmInstance, key string, val []byte, ttl int) error {
+	call := &HostCall{Name: "cache_set", Args: []string{key, strconv.Itoa(ttl)}, Data: val}
+
+	return callCapability(inst, call, func(call *HostCall) error {
+		return d.capabilities.Cache.Set(key, val, ttl)
+	})
 }
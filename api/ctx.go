@@ -8,7 +8,11 @@ import (
 
 type ctxKey int
 
-const requestKey = ctxKey(0)
+const (
+	requestKey     = ctxKey(0)
+	invocationKey  = ctxKey(1)
+	determinismKey = ctxKey(2)
+)
 
 // ContextWithRequest returns the provided context with a request object added as a value
 func ContextWithRequest(ctx context.Context, req *request.CoordinatedRequest) context.Context {
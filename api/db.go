@@ -1,6 +1,8 @@
 package api
 
 import (
+	"fmt"
+
 	"github.com/pkg/errors"
 
 	"github.com/suborbital/e2core/scheduler"
@@ -9,28 +11,35 @@ import (
 )
 
 func (d *defaultAPI) DBExecHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		queryType := args[0].(int32)
 		namePointer := args[1].(int32)
 		nameSize := args[2].(int32)
 		ident := args[3].(int32)
 
-		ret := d.dbExec(queryType, namePointer, nameSize, ident)
+		ret, err := d.dbExec(caller, queryType, namePointer, nameSize, ident)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to dbExec")
+		}
 
 		return ret, nil
 	}
 
-	return runtime.NewHostFn("db_exec", 4, true, fn)
+	return runtime.NewCallerHostFn("db_exec", 4, true, fn)
 }
 
-func (d *defaultAPI) dbExec(queryType, namePointer, nameSize, identifier int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(identifier, false)
+func (d *defaultAPI) dbExec(caller *runtime.WasmInstance, queryType, namePointer, nameSize, identifier int32) (int32, error) {
+	inst, err := runtime.CallerInstance(caller, identifier, false)
+	if err != nil {
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return -1, nil
+	}
+
+	nameBytes, err := inst.BorrowMemory(namePointer, nameSize)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return -1
+		return 0, errors.Wrap(err, "failed to BorrowMemory name")
 	}
 
-	nameBytes := inst.ReadMemory(namePointer, nameSize)
 	name := string(nameBytes)
 
 	vars, err := inst.Ctx().UseVars()
@@ -38,17 +47,34 @@ func (d *defaultAPI) dbExec(queryType, namePointer, nameSize, identifier int32)
 		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] failed to UseVars"))
 	}
 
-	queryResult, err := d.capabilities.Database.ExecQuery(queryType, name, varsToInterface(vars))
+	queryResult, err := d.execQuery(inst, queryType, name, varsToInterface(vars))
 	if err != nil {
 		runtime.InternalLogger().ErrorString("[engine] failed to ExecQuery", name, err.Error())
 
 		res, _ := inst.Ctx().SetFFIResult(nil, err)
-		return res.FFISize()
+		return res.FFISize(), nil
 	}
 
 	res, _ := inst.Ctx().SetFFIResult(queryResult, nil)
 
-	return res.FFISize()
+	return res.FFISize(), nil
+}
+
+// execQuery executes a database query for the instance
+func (d *defaultAPI) execQuery(inst *runtime.WasmInstance, queryType int32, name string, vars []interface{}) ([]byte, error) {
+	call := &HostCall{Name: "db_exec", Args: []string{fmt.Sprint(queryType), name}}
+	for _, v := range vars {
+		call.Args = append(call.Args, fmt.Sprint(v))
+	}
+
+	err := callCapability(inst, call, func(call *HostCall) error {
+		queryResult, err := d.capabilities.Database.ExecQuery(queryType, name, vars)
+		call.Result = queryResult
+
+		return err
+	})
+
+	return call.Result, err
 }
 
 func varsToInterface(vars []scheduler.FFIVariable) []interface{} {
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// ErrNondeterministic is returned by a host function whose result could change between invocations when a module that
+// runs deterministically calls it without a stub
+var ErrNondeterministic = errors.New("the host function's result could change between invocations, so it must be stubbed to run deterministically")
+
+// nondeterministicHostFns are the host functions whose results could change between invocations, with the index of
+// the argument that their stubs are keyed by
+var nondeterministicHostFns = map[string]int{
+	"fetch_url":     1, // the URL, after the method
+	"graphql_query": 0, // the endpoint
+	"cache_get":     0, // the key
+}
+
+// determinism is the deterministic config of an invocation, and the first call that it made in violation of it
+type determinism struct {
+	config    runtime.Deterministic
+	violation error
+}
+
+// ContextWithDeterminism returns the context for an invocation of a module that runs deterministically, whose calls
+// to the host functions with results that could change between invocations are answered by the config's stubs
+func ContextWithDeterminism(ctx context.Context, config runtime.Deterministic) context.Context {
+	return context.WithValue(ctx, determinismKey, &determinism{config: config})
+}
+
+// DeterminismViolation returns the error for the first unstubbed call to a nondeterministic host function made by
+// the invocation that ctx was returned for, if it made one
+func DeterminismViolation(ctx context.Context) error {
+	if det := determinismFromContext(ctx); det != nil {
+		return det.violation
+	}
+
+	return nil
+}
+
+func determinismFromContext(ctx context.Context) *determinism {
+	if ctx == nil {
+		return nil
+	}
+
+	det, _ := ctx.Value(determinismKey).(*determinism)
+
+	return det
+}
+
+// call makes the call with do if its result can't change between invocations, and otherwise answers it with its stub
+func (d *determinism) call(call *HostCall, do func(call *HostCall) error) error {
+	arg, nondeterministic := nondeterministicHostFns[call.Name]
+	if !nondeterministic {
+		return do(call)
+	}
+
+	result, stubbed := d.config.Stub(call.Name, call.Args[arg])
+	if !stubbed {
+		err := errors.Wrap(ErrNondeterministic, call.String())
+		if d.violation == nil {
+			d.violation = err
+		}
+
+		return err
+	}
+
+	call.Result = result
+
+	if call.Name == "fetch_url" {
+		call.Status = http.StatusOK
+	}
+
+	return nil
+}
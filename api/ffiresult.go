@@ -7,29 +7,32 @@ import (
 )
 
 func (d *defaultAPI) GetFFIResultHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		pointer := args[0].(int32)
 		ident := args[1].(int32)
 
-		ret := d.getFfiResult(pointer, ident)
+		ret, err := d.getFfiResult(caller, pointer, ident)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to getFfiResult")
+		}
 
 		return ret, nil
 	}
 
-	return runtime.NewHostFn("get_ffi_result", 2, true, fn)
+	return runtime.NewCallerHostFn("get_ffi_result", 2, true, fn)
 }
 
-func (d *defaultAPI) getFfiResult(pointer int32, identifier int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(identifier, false)
+func (d *defaultAPI) getFfiResult(caller *runtime.WasmInstance, pointer int32, identifier int32) (int32, error) {
+	inst, err := runtime.CallerInstance(caller, identifier, false)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] failed to instanceForIdentifier"))
-		return -1
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] failed to CallerInstance"))
+		return -1, nil
 	}
 
 	result, err := inst.Ctx().UseFFIResult()
 	if err != nil {
 		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] failed to useFFIResult"))
-		return -1
+		return -1, nil
 	}
 
 	data := result.Result
@@ -37,7 +40,9 @@ func (d *defaultAPI) getFfiResult(pointer int32, identifier int32) int32 {
 		data = []byte(result.Err.Error())
 	}
 
-	inst.WriteMemoryAtLocation(pointer, data)
+	if err := inst.WriteMemoryAtLocation(pointer, data); err != nil {
+		return 0, errors.Wrap(err, "failed to WriteMemoryAtLocation")
+	}
 
-	return 0
+	return 0, nil
 }
@@ -7,35 +7,46 @@ import (
 )
 
 func (d *defaultAPI) AddFFIVariableHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		namePtr := args[0].(int32)
 		nameLen := args[1].(int32)
 		valPtr := args[2].(int32)
 		valLen := args[3].(int32)
 		ident := args[4].(int32)
 
-		ret := d.addFfiVar(namePtr, nameLen, valPtr, valLen, ident)
+		ret, err := d.addFfiVar(caller, namePtr, nameLen, valPtr, valLen, ident)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to addFfiVar")
+		}
 
 		return ret, nil
 	}
 
-	return runtime.NewHostFn("add_ffi_var", 5, true, fn)
+	return runtime.NewCallerHostFn("add_ffi_var", 5, true, fn)
 }
 
-func (d *defaultAPI) addFfiVar(namePtr, nameLen, valPtr, valLen, identifier int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(identifier, false)
+func (d *defaultAPI) addFfiVar(caller *runtime.WasmInstance, namePtr, nameLen, valPtr, valLen, identifier int32) (int32, error) {
+	inst, err := runtime.CallerInstance(caller, identifier, false)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] failed to instanceForIdentifier"))
-		return -1
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] failed to CallerInstance"))
+		return -1, nil
+	}
+
+	nameBytes, err := inst.BorrowMemory(namePtr, nameLen)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to BorrowMemory name")
 	}
 
-	nameBytes := inst.ReadMemory(namePtr, nameLen)
 	name := string(nameBytes)
 
-	valueBytes := inst.ReadMemory(valPtr, valLen)
+	valueBytes, err := inst.BorrowMemory(valPtr, valLen)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to BorrowMemory value")
+	}
+
 	value := string(valueBytes)
 
 	inst.Ctx().AddVar(name, value)
 
-	return 0
+	return 0, nil
 }
@@ -9,56 +9,81 @@ import (
 )
 
 func (d *defaultAPI) GraphQLQueryHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		endpointPointer := args[0].(int32)
 		endpointSize := args[1].(int32)
 		queryPointer := args[2].(int32)
 		querySize := args[3].(int32)
 		ident := args[4].(int32)
 
-		ret := d.graphqlQuery(endpointPointer, endpointSize, queryPointer, querySize, ident)
+		ret, err := d.graphqlQuery(caller, endpointPointer, endpointSize, queryPointer, querySize, ident)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to graphqlQuery")
+		}
 
 		return ret, nil
 	}
 
-	return runtime.NewHostFn("graphql_query", 5, true, fn)
+	return runtime.NewCallerHostFn("graphql_query", 5, true, fn)
 }
 
-func (d *defaultAPI) graphqlQuery(endpointPointer int32, endpointSize int32, queryPointer int32, querySize int32, identifier int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(identifier, true)
+func (d *defaultAPI) graphqlQuery(caller *runtime.WasmInstance, endpointPointer int32, endpointSize int32, queryPointer int32, querySize int32, identifier int32) (int32, error) {
+	inst, err := runtime.CallerInstance(caller, identifier, true)
+	if err != nil {
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return -1, nil
+	}
+
+	endpointBytes, err := inst.BorrowMemory(endpointPointer, endpointSize)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return -1
+		return 0, errors.Wrap(err, "failed to BorrowMemory endpoint")
 	}
 
-	endpointBytes := inst.ReadMemory(endpointPointer, endpointSize)
 	endpoint := string(endpointBytes)
 
-	queryBytes := inst.ReadMemory(queryPointer, querySize)
+	queryBytes, err := inst.BorrowMemory(queryPointer, querySize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to BorrowMemory query")
+	}
+
 	query := string(queryBytes)
 
-	// wrap everything in a function so any errors get collected
-	resp, err := func() ([]byte, error) {
+	resp, err := d.graphQLQuery(inst, endpoint, query)
+	// a module that runs deterministically is stopped rather than given an error that it could carry on from
+	if errors.Is(err, ErrNondeterministic) {
+		return 0, err
+	}
+
+	result, err := inst.Ctx().SetFFIResult(resp, err)
+	if err != nil {
+		runtime.InternalLogger().ErrorString("[engine] failed to SetFFIResult", err.Error())
+		return -1, nil
+	}
+
+	return result.FFISize(), nil
+}
+
+// graphQLQuery makes a GraphQL query for the instance through the capabilities, returning the response as JSON
+func (d *defaultAPI) graphQLQuery(inst *runtime.WasmInstance, endpoint, query string) ([]byte, error) {
+	call := &HostCall{Name: "graphql_query", Args: []string{endpoint, query}}
+
+	err := callCapability(inst, call, func(call *HostCall) error {
 		resp, err := d.capabilities.GraphQLClient.Do(d.capabilities.Auth, endpoint, query)
 		if err != nil {
 			runtime.InternalLogger().Error(errors.Wrap(err, "failed to GraphQLClient.Do"))
-			return nil, err
+			return err
 		}
 
 		respBytes, err := json.Marshal(resp)
 		if err != nil {
 			runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to Marshal"))
-			return nil, err
+			return err
 		}
 
-		return respBytes, nil
-	}()
+		call.Result = respBytes
 
-	result, err := inst.Ctx().SetFFIResult(resp, err)
-	if err != nil {
-		runtime.InternalLogger().ErrorString("[engine] failed to SetFFIResult", err.Error())
-		return -1
-	}
+		return nil
+	})
 
-	return result.FFISize()
+	return call.Result, err
 }
@@ -2,7 +2,7 @@ package api
 
 import (
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"strings"
 
@@ -38,7 +38,7 @@ var methodValToMethod = map[int32]string{
 }
 
 func (d *defaultAPI) FetchURLHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		method := args[0].(int32)
 		urlPointer := args[1].(int32)
 		urlSize := args[2].(int32)
@@ -46,30 +46,36 @@ func (d *defaultAPI) FetchURLHandler() runtime.HostFn {
 		bodySize := args[4].(int32)
 		ident := args[5].(int32)
 
-		ret := d.fetchUrl(method, urlPointer, urlSize, bodyPointer, bodySize, ident)
+		ret, err := d.fetchUrl(caller, method, urlPointer, urlSize, bodyPointer, bodySize, ident)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetchUrl")
+		}
 
 		return ret, nil
 	}
 
-	return runtime.NewHostFn("fetch_url", 6, true, fn)
+	return runtime.NewCallerHostFn("fetch_url", 6, true, fn)
 }
 
-func (d *defaultAPI) fetchUrl(method int32, urlPointer int32, urlSize int32, bodyPointer int32, bodySize int32, identifier int32) int32 {
+func (d *defaultAPI) fetchUrl(caller *runtime.WasmInstance, method int32, urlPointer int32, urlSize int32, bodyPointer int32, bodySize int32, identifier int32) (int32, error) {
 	// fetch makes a network request on bahalf of the wasm runner.
 	// fetch writes the http response body into memory starting at returnBodyPointer, and the return value is a pointer to that memory
-	inst, err := runtime.InstanceForIdentifier(identifier, true)
+	inst, err := runtime.CallerInstance(caller, identifier, true)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return -1
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return -1, nil
 	}
 
 	httpMethod, exists := methodValToMethod[method]
 	if !exists {
 		runtime.InternalLogger().ErrorString("invalid method provided: ", method)
-		return -2
+		return -2, nil
 	}
 
-	urlBytes := inst.ReadMemory(urlPointer, urlSize)
+	urlBytes, err := inst.BorrowMemory(urlPointer, urlSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to BorrowMemory URL")
+	}
 
 	// the URL is encoded with headers added on the end, each seperated by ::
 	// eg. https://google.com/somepage::authorization:bearer qdouwrnvgoquwnrg::anotherheader:nicetomeetyou
@@ -79,10 +85,14 @@ func (d *defaultAPI) fetchUrl(method int32, urlPointer int32, urlSize int32, bod
 	headers, err := parseHTTPHeaders(urlParts)
 	if err != nil {
 		runtime.InternalLogger().Error(errors.Wrap(err, "could not parse URL headers"))
-		return -2
+		return -2, nil
 	}
 
-	body := inst.ReadMemory(bodyPointer, bodySize)
+	// the client can still be reading the body after it returns, so it is copied out of the guest's memory
+	body, err := inst.ReadMemory(bodyPointer, bodySize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to ReadMemory body")
+	}
 
 	if len(body) > 0 {
 		if headers.Get("Content-Type") == "" {
@@ -90,36 +100,62 @@ func (d *defaultAPI) fetchUrl(method int32, urlPointer int32, urlSize int32, bod
 		}
 	}
 
-	// wrap everything in a function so any errors get collected
-	resp, err := func() ([]byte, error) {
+	status, _, resp, err := d.fetch(inst, httpMethod, urlString, body, *headers)
+	// a module that runs deterministically is stopped rather than given an error that it could carry on from
+	if errors.Is(err, ErrNondeterministic) {
+		return 0, err
+	}
+
+	if err == nil && status > 299 {
+		runtime.InternalLogger().Debug("runnable's http request returned non-200 response:", status)
+		resp, err = nil, fmt.Errorf("%d: %s", status, string(resp))
+	}
+
+	result, err := inst.Ctx().SetFFIResult(resp, err)
+	if err != nil {
+		runtime.InternalLogger().ErrorString("[engine] failed to SetFFIResult", err.Error())
+		return -1, nil
+	}
+
+	return result.FFISize(), nil
+}
+
+// fetch makes an HTTP request for the instance through the capabilities, returning the response's status, headers
+// and body
+func (d *defaultAPI) fetch(inst *runtime.WasmInstance, method, urlString string, body []byte, headers http.Header) (int, http.Header, []byte, error) {
+	call := &HostCall{Name: "fetch_url", Args: []string{method, urlString}, Data: body}
+	for _, h := range headerPairs(headers) {
+		call.Args = append(call.Args, h[0]+": "+h[1])
+	}
+
+	err := callCapability(inst, call, func(call *HostCall) error {
 		// filter the request through the capabilities
-		resp, err := d.capabilities.HTTPClient.Do(d.capabilities.Auth, httpMethod, urlString, body, *headers)
+		resp, err := d.capabilities.HTTPClient.Do(d.capabilities.Auth, method, urlString, body, headers)
 		if err != nil {
-			runtime.InternalLogger().Error(errors.Wrap(err, "failed to Do request"))
-			return nil, err
+			return err
 		}
 
 		defer resp.Body.Close()
-		respBytes, err := ioutil.ReadAll(resp.Body)
+
+		respBytes, err := io.ReadAll(resp.Body)
 		if err != nil {
-			runtime.InternalLogger().Error(errors.Wrap(err, "failed to Read response body"))
+			return errors.Wrap(err, "failed to Read response body")
 		}
 
-		if resp.StatusCode > 299 {
-			runtime.InternalLogger().Debug("runnable's http request returned non-200 response:", resp.StatusCode)
-			return nil, fmt.Errorf("%d: %s", resp.StatusCode, string(respBytes))
-		}
+		call.Status, call.Headers, call.Result = resp.StatusCode, resp.Header, respBytes
 
-		return respBytes, nil
-	}()
+		return nil
+	})
 
-	result, err := inst.Ctx().SetFFIResult(resp, err)
 	if err != nil {
-		runtime.InternalLogger().ErrorString("[engine] failed to SetFFIResult", err.Error())
-		return -1
+		if !errors.Is(err, ErrNondeterministic) {
+			runtime.InternalLogger().Error(errors.Wrap(err, "failed to Do request"))
+		}
+
+		return 0, nil, nil, err
 	}
 
-	return result.FFISize()
+	return call.Status, call.Headers, call.Result, nil
 }
 
 func parseHTTPHeaders(urlParts []string) (*http.Header, error) {
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"bytes"
+
 	"github.com/pkg/errors"
 
 	"github.com/suborbital/appspec/capabilities"
@@ -13,31 +15,92 @@ type logScope struct {
 	Identifier int32  `json:"ident"`
 }
 
+// OutputLogger is implemented by HostAPIs that can log what a module writes to stdout and stderr
+type OutputLogger interface {
+	// LogOutput logs output captured from the instance, line by line, while it is still in use
+	LogOutput(inst *runtime.WasmInstance, level runtime.LogLevel, output []byte)
+}
+
+// TrapLogger is implemented by HostAPIs that can log the traps raised by a module
+type TrapLogger interface {
+	// LogTrap logs a trap raised by the instance, along with its backtrace, while it is still in use
+	LogTrap(inst *runtime.WasmInstance, trap *runtime.Trap)
+}
+
+// outputLevels maps the output log levels to those used by log_msg
+var outputLevels = map[runtime.LogLevel]int32{
+	runtime.LogLevelError: 1,
+	runtime.LogLevelWarn:  2,
+	runtime.LogLevelInfo:  3,
+	runtime.LogLevelDebug: 4,
+}
+
 func (d *defaultAPI) LogMsgHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		pointer := args[0].(int32)
 		size := args[1].(int32)
 		level := args[2].(int32)
 		ident := args[3].(int32)
 
-		d.logMsg(pointer, size, level, ident)
+		if err := d.logMsg(caller, pointer, size, level, ident); err != nil {
+			return nil, errors.Wrap(err, "failed to logMsg")
+		}
 
 		return nil, nil
 	}
 
-	return runtime.NewHostFn("log_msg", 4, false, fn)
+	h := runtime.NewCallerHostFn("log_msg", 4, false, fn)
+
+	// a module that logs as it initializes can still be preinitialized, as the message only goes to the host
+	h.SnapshotSafe = true
+
+	return h
 }
 
-func (d *defaultAPI) logMsg(pointer int32, size int32, level int32, identifier int32) {
-	inst, err := runtime.InstanceForIdentifier(identifier, false)
+func (d *defaultAPI) logMsg(caller *runtime.WasmInstance, pointer int32, size int32, level int32, identifier int32) error {
+	inst, err := runtime.CallerInstance(caller, identifier, false)
+	if err != nil {
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return nil
+	}
+
+	msgBytes, err := inst.BorrowMemory(pointer, size)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
+		return errors.Wrap(err, "failed to BorrowMemory message")
+	}
+
+	d.capabilities.LoggerSource.Log(level, string(msgBytes), d.scopeFor(inst))
+
+	return nil
+}
+
+// LogOutput logs a module's captured output with the same scope as the messages it logs through log_msg
+func (d *defaultAPI) LogOutput(inst *runtime.WasmInstance, level runtime.LogLevel, output []byte) {
+	logLevel, ok := outputLevels[level]
+	if !ok || len(output) == 0 {
 		return
 	}
 
-	msgBytes := inst.ReadMemory(pointer, size)
+	scope := d.scopeFor(inst)
+
+	for _, line := range bytes.Split(bytes.TrimSuffix(output, []byte("\n")), []byte("\n")) {
+		d.capabilities.LoggerSource.Log(logLevel, string(line), scope)
+	}
+}
+
+// LogTrap logs a trap with the same scope as the messages the module logs through log_msg
+func (d *defaultAPI) LogTrap(inst *runtime.WasmInstance, trap *runtime.Trap) {
+	msg := trap.Error()
+	if len(trap.Frames) > 0 {
+		msg += "\n" + trap.Backtrace()
+	}
+
+	d.capabilities.LoggerSource.Log(outputLevels[runtime.LogLevelError], msg, d.scopeFor(inst))
+}
 
-	scope := logScope{Identifier: identifier}
+// scopeFor returns the scope that an instance's log messages are logged with
+func (d *defaultAPI) scopeFor(inst *runtime.WasmInstance) logScope {
+	scope := logScope{Identifier: inst.Ident()}
 
 	req := RequestFromContext(inst.Ctx().Context)
 
@@ -53,5 +116,5 @@ func (d *defaultAPI) logMsg(pointer int32, size int32, level int32, identifier i
 		}
 	}
 
-	d.capabilities.LoggerSource.Log(level, string(msgBytes), scope)
+	return scope
 }
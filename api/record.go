@@ -0,0 +1,378 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/request"
+	"github.com/suborbital/e2core/scheduler"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// ErrReplayDiverged is returned to a module whose host call doesn't match the next call in the recording it is
+// being replayed from
+var ErrReplayDiverged = errors.New("the host call diverged from the recording")
+
+// Recorder is given each invocation of a module as it begins and ends, so that the calls it makes to capabilities
+// can be recorded or replayed
+type Recorder interface {
+	// Begin begins an invocation of the named module with the request, or with the input for jobs that aren't
+	// requests, returning the context for the invocation to run with
+	Begin(ctx context.Context, module string, req *request.CoordinatedRequest, input []byte) context.Context
+	// End ends the invocation that ctx was returned for, with its output or error
+	End(ctx context.Context, output []byte, err error)
+}
+
+// Invocation is the record of a module's invocation: what it was given, the calls it made to capabilities, and the
+// output or error that it ended with
+type Invocation struct {
+	Module    string                      `json:"module"`
+	Request   *request.CoordinatedRequest `json:"request,omitempty"`
+	Input     []byte                      `json:"input,omitempty"`
+	HostCalls []HostCall                  `json:"hostCalls"`
+	Output    []byte                      `json:"output,omitempty"`
+	Error     *scheduler.RunErr           `json:"error,omitempty"`
+}
+
+// HostCall is the record of a call to a capability (named after the host function that makes it, whichever version
+// of the host API the module used) with its arguments and results
+type HostCall struct {
+	Name string   `json:"name"`
+	Args []string `json:"args,omitempty"`
+	// Data is the call's binary argument, such as a request body or the value to cache
+	Data   []byte `json:"data,omitempty"`
+	Result []byte `json:"result,omitempty"`
+	// Status and Headers are those of the response to an HTTP request
+	Status  int         `json:"status,omitempty"`
+	Headers http.Header `json:"headers,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// maxDescribedData is the most data that is included in a call's description
+const maxDescribedData = 64
+
+// String describes the call, such as cache_set("key", "30", "value"), with data that is too long or isn't text
+// described by its size
+func (h HostCall) String() string {
+	args := make([]string, 0, len(h.Args)+1)
+	for _, a := range h.Args {
+		args = append(args, fmt.Sprintf("%q", a))
+	}
+
+	if len(h.Data) > maxDescribedData || !utf8.Valid(h.Data) {
+		args = append(args, fmt.Sprintf("<%d bytes>", len(h.Data)))
+	} else if len(h.Data) > 0 {
+		args = append(args, fmt.Sprintf("%q", h.Data))
+	}
+
+	return fmt.Sprintf("%s(%s)", h.Name, strings.Join(args, ", "))
+}
+
+// matches returns true if the calls are to the same capability with the same arguments
+func (h HostCall) matches(other HostCall) bool {
+	if h.Name != other.Name || len(h.Args) != len(other.Args) || !bytes.Equal(h.Data, other.Data) {
+		return false
+	}
+
+	for i := range h.Args {
+		if h.Args[i] != other.Args[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// err returns the call's error
+func (h HostCall) err() error {
+	if h.Error == "" {
+		return nil
+	}
+
+	return errors.New(h.Error)
+}
+
+// Divergence is a way in which a replayed invocation differed from its recording
+type Divergence struct {
+	// Call is the index of the host call that diverged, or -1 if the invocation's output or error did
+	Call     int
+	Expected string
+	Got      string
+}
+
+func (d Divergence) String() string {
+	if d.Call < 0 {
+		return fmt.Sprintf("result: expected %s, got %s", d.Expected, d.Got)
+	}
+
+	return fmt.Sprintf("host call %d: expected %s, got %s", d.Call+1, d.Expected, d.Got)
+}
+
+// invocation is an invocation that is being recorded, or replayed if it has a recording
+type invocation struct {
+	Invocation
+
+	recorded    *Invocation
+	divergences []Divergence
+}
+
+// begin starts the invocation with a copy of its request, since modules and sequences change the original as they run
+func (i *invocation) begin(module string, req *request.CoordinatedRequest, input []byte) {
+	i.Module = module
+
+	if req == nil {
+		i.Input = input
+		return
+	}
+
+	i.Request = req
+
+	if reqJSON, err := req.ToJSON(); err == nil {
+		if reqCopy, err := request.FromJSON(reqJSON); err == nil {
+			i.Request = reqCopy
+		}
+	}
+}
+
+// end records the output or error that the invocation ended with
+func (i *invocation) end(output []byte, err error) {
+	i.Output = output
+
+	if err != nil {
+		runErr := scheduler.RunErr{}
+		if !errors.As(err, &runErr) {
+			runErr.Message = err.Error()
+		}
+
+		i.Error = &runErr
+	}
+}
+
+// replay serves a call from the recording if it is the call that the recording expects next, and otherwise notes
+// the divergence and fails the call
+func (i *invocation) replay(call *HostCall) error {
+	index := len(i.HostCalls)
+
+	if index >= len(i.recorded.HostCalls) || !i.recorded.HostCalls[index].matches(*call) {
+		i.divergences = append(i.divergences, Divergence{Call: index, Expected: i.recordedCall(index), Got: call.String()})
+
+		call.Error = ErrReplayDiverged.Error()
+		i.HostCalls = append(i.HostCalls, *call)
+
+		return ErrReplayDiverged
+	}
+
+	recorded := i.recorded.HostCalls[index]
+	call.Result, call.Status, call.Headers, call.Error = recorded.Result, recorded.Status, recorded.Headers, recorded.Error
+
+	i.HostCalls = append(i.HostCalls, *call)
+
+	return call.err()
+}
+
+// recordedCall describes the call at index in the recording
+func (i *invocation) recordedCall(index int) string {
+	if index >= len(i.recorded.HostCalls) {
+		return "no host call"
+	}
+
+	return i.recorded.HostCalls[index].String()
+}
+
+// compare notes the ways that the replayed invocation ended differently from its recording, including the host calls
+// in the recording that it didn't make
+func (i *invocation) compare() []Divergence {
+	for index := len(i.HostCalls); index < len(i.recorded.HostCalls); index++ {
+		i.divergences = append(i.divergences, Divergence{Call: index, Expected: i.recordedCall(index), Got: "no host call"})
+	}
+
+	if !bytes.Equal(i.Output, i.recorded.Output) || !sameRunErr(i.Error, i.recorded.Error) {
+		i.divergences = append(i.divergences, Divergence{Call: -1, Expected: describeResult(i.recorded), Got: describeResult(&i.Invocation)})
+	}
+
+	return i.divergences
+}
+
+// describeResult describes the output or error that an invocation ended with
+func describeResult(inv *Invocation) string {
+	if inv.Error != nil {
+		return fmt.Sprintf("error %d %q", inv.Error.Code, inv.Error.Message)
+	}
+
+	return fmt.Sprintf("output %q", inv.Output)
+}
+
+func sameRunErr(a, b *scheduler.RunErr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+// invocationFromContext returns the invocation that is being recorded or replayed with the context, if any
+func invocationFromContext(ctx context.Context) *invocation {
+	if ctx == nil {
+		return nil
+	}
+
+	inv, _ := ctx.Value(invocationKey).(*invocation)
+
+	return inv
+}
+
+// callCapability makes a call to a capability for the instance with do, which sets the call's results. A call made
+// by an invocation that is being recorded is added to its recording, and one made by an invocation that is being
+// replayed is served from its recording without calling do. A call made by an invocation that runs deterministically
+// is answered by its stub if its result could change between invocations.
+func callCapability(inst *runtime.WasmInstance, call *HostCall, do func(call *HostCall) error) error {
+	if det := determinismFromContext(inst.Ctx().Context); det != nil {
+		live := do
+		do = func(call *HostCall) error {
+			return det.call(call, live)
+		}
+	}
+
+	inv := invocationFromContext(inst.Ctx().Context)
+	if inv == nil {
+		return do(call)
+	}
+
+	if inv.recorded != nil {
+		return inv.replay(call)
+	}
+
+	err := do(call)
+	if err != nil {
+		call.Error = err.Error()
+	}
+
+	inv.HostCalls = append(inv.HostCalls, *call)
+
+	return err
+}
+
+// RecordWriter is a Recorder that writes each invocation to a writer as a line of JSON once it ends. Recordings
+// include everything the module was given, including the values of any secrets it read.
+type RecordWriter struct {
+	w    io.Writer
+	lock sync.Mutex
+}
+
+// NewRecordWriter returns a RecordWriter that writes invocations to w
+func NewRecordWriter(w io.Writer) *RecordWriter {
+	return &RecordWriter{w: w}
+}
+
+// Begin begins recording an invocation
+func (r *RecordWriter) Begin(ctx context.Context, module string, req *request.CoordinatedRequest, input []byte) context.Context {
+	inv := &invocation{Invocation: Invocation{HostCalls: []HostCall{}}}
+	inv.begin(module, req, input)
+
+	return context.WithValue(ctx, invocationKey, inv)
+}
+
+// End writes the invocation that ctx was returned for
+func (r *RecordWriter) End(ctx context.Context, output []byte, err error) {
+	inv := invocationFromContext(ctx)
+	if inv == nil {
+		return
+	}
+
+	inv.end(output, err)
+
+	invJSON, marshalErr := json.Marshal(inv.Invocation)
+	if marshalErr != nil {
+		runtime.InternalLogger().Error(errors.Wrap(marshalErr, "failed to Marshal invocation"))
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, err := r.w.Write(append(invJSON, '\n')); err != nil {
+		runtime.InternalLogger().Error(errors.Wrap(err, "failed to Write invocation"))
+	}
+}
+
+// ReadInvocations reads the invocations written by a RecordWriter
+func ReadInvocations(r io.Reader) ([]Invocation, error) {
+	invocations := []Invocation{}
+
+	decoder := json.NewDecoder(r)
+
+	for decoder.More() {
+		inv := Invocation{}
+		if err := decoder.Decode(&inv); err != nil {
+			return nil, errors.Wrapf(err, "failed to Decode invocation %d", len(invocations)+1)
+		}
+
+		invocations = append(invocations, inv)
+	}
+
+	return invocations, nil
+}
+
+// Replayer is a Recorder that serves the calls that invocations make to capabilities from their recordings, one
+// invocation at a time
+type Replayer struct {
+	lock sync.Mutex
+	// next is the invocation that the next invocation to begin replays
+	next atomic.Pointer[invocation]
+}
+
+// NewReplayer returns a Replayer
+func NewReplayer() *Replayer {
+	return &Replayer{}
+}
+
+// Replay runs the recorded invocation again with run, which must invoke the module with the recorded request (or
+// input) and wait for it to end. It returns the replayed invocation, and the ways in which its host calls, output or
+// error diverged from the recording.
+func (r *Replayer) Replay(recorded Invocation, run func()) (Invocation, []Divergence) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	inv := &invocation{Invocation: Invocation{HostCalls: []HostCall{}}, recorded: &recorded}
+	r.next.Store(inv)
+
+	run()
+
+	// an invocation that never began didn't run the module at all
+	if r.next.CompareAndSwap(inv, nil) {
+		return inv.Invocation, []Divergence{{Call: -1, Expected: describeResult(&recorded), Got: "the module was not invoked"}}
+	}
+
+	return inv.Invocation, inv.compare()
+}
+
+// Begin begins replaying the invocation passed to Replay. Invocations that begin otherwise have no recording, so
+// their host calls all diverge rather than reaching the capabilities.
+func (r *Replayer) Begin(ctx context.Context, module string, req *request.CoordinatedRequest, input []byte) context.Context {
+	inv := r.next.Swap(nil)
+	if inv == nil {
+		inv = &invocation{Invocation: Invocation{HostCalls: []HostCall{}}, recorded: &Invocation{}}
+	}
+
+	inv.begin(module, req, input)
+
+	return context.WithValue(ctx, invocationKey, inv)
+}
+
+// End ends the replayed invocation
+func (r *Replayer) End(ctx context.Context, output []byte, err error) {
+	if inv := invocationFromContext(ctx); inv != nil {
+		inv.end(output, err)
+	}
+}
@@ -9,28 +9,35 @@ import (
 )
 
 func (d *defaultAPI) RequestGetFieldHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		fieldType := args[0].(int32)
 		keyPointer := args[1].(int32)
 		keySize := args[2].(int32)
 		ident := args[3].(int32)
 
-		ret := d.requestGetField(fieldType, keyPointer, keySize, ident)
+		ret, err := d.requestGetField(caller, fieldType, keyPointer, keySize, ident)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to requestGetField")
+		}
 
 		return ret, nil
 	}
 
-	return runtime.NewHostFn("request_get_field", 4, true, fn)
+	return runtime.NewCallerHostFn("request_get_field", 4, true, fn)
 }
 
-func (d *defaultAPI) requestGetField(fieldType int32, keyPointer int32, keySize int32, identifier int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(identifier, true)
+func (d *defaultAPI) requestGetField(caller *runtime.WasmInstance, fieldType int32, keyPointer int32, keySize int32, identifier int32) (int32, error) {
+	inst, err := runtime.CallerInstance(caller, identifier, true)
+	if err != nil {
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return -1, nil
+	}
+
+	keyBytes, err := inst.BorrowMemory(keyPointer, keySize)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return -1
+		return 0, errors.Wrap(err, "failed to BorrowMemory key")
 	}
 
-	keyBytes := inst.ReadMemory(keyPointer, keySize)
 	key := string(keyBytes)
 
 	req := RequestFromContext(inst.Ctx().Context)
@@ -50,21 +57,21 @@ func (d *defaultAPI) requestGetField(fieldType int32, keyPointer int32, keySize
 			err = nil
 		} else {
 			runtime.InternalLogger().Error(errors.Wrap(err, "failed to GetField"))
-			return -1
+			return -1, nil
 		}
 	}
 
 	result, err := inst.Ctx().SetFFIResult(val, err)
 	if err != nil {
 		runtime.InternalLogger().ErrorString("[engine] failed to SetFFIResult", err.Error())
-		return -1
+		return -1, nil
 	}
 
-	return result.FFISize()
+	return result.FFISize(), nil
 }
 
 func (d *defaultAPI) RequestSetFieldHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		fieldType := args[0].(int32)
 		keyPointer := args[1].(int32)
 		keySize := args[2].(int32)
@@ -72,25 +79,36 @@ func (d *defaultAPI) RequestSetFieldHandler() runtime.HostFn {
 		valSize := args[4].(int32)
 		ident := args[5].(int32)
 
-		ret := d.requestSetField(fieldType, keyPointer, keySize, valPointer, valSize, ident)
+		ret, err := d.requestSetField(caller, fieldType, keyPointer, keySize, valPointer, valSize, ident)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to requestSetField")
+		}
 
 		return ret, nil
 	}
 
-	return runtime.NewHostFn("request_set_field", 6, true, fn)
+	return runtime.NewCallerHostFn("request_set_field", 6, true, fn)
 }
 
-func (d *defaultAPI) requestSetField(fieldType int32, keyPointer int32, keySize int32, valPointer int32, valSize int32, identifier int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(identifier, true)
+func (d *defaultAPI) requestSetField(caller *runtime.WasmInstance, fieldType int32, keyPointer int32, keySize int32, valPointer int32, valSize int32, identifier int32) (int32, error) {
+	inst, err := runtime.CallerInstance(caller, identifier, true)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return -1
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return -1, nil
+	}
+
+	keyBytes, err := inst.BorrowMemory(keyPointer, keySize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to BorrowMemory key")
 	}
 
-	keyBytes := inst.ReadMemory(keyPointer, keySize)
 	key := string(keyBytes)
 
-	valBytes := inst.ReadMemory(valPointer, valSize)
+	valBytes, err := inst.BorrowMemory(valPointer, valSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to BorrowMemory value")
+	}
+
 	val := string(valBytes)
 
 	req := RequestFromContext(inst.Ctx().Context)
@@ -108,8 +126,8 @@ func (d *defaultAPI) requestSetField(fieldType int32, keyPointer int32, keySize
 	result, err := inst.Ctx().SetFFIResult(nil, err)
 	if err != nil {
 		runtime.InternalLogger().ErrorString("[engine] failed to SetFFIResult", err.Error())
-		return -1
+		return -1, nil
 	}
 
-	return result.FFISize()
+	return result.FFISize(), nil
 }
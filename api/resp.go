@@ -9,32 +9,42 @@ import (
 )
 
 func (d *defaultAPI) RespSetHeaderHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		keyPointer := args[0].(int32)
 		keySize := args[1].(int32)
 		valPointer := args[2].(int32)
 		valSize := args[3].(int32)
 		ident := args[4].(int32)
 
-		d.responseSetHeader(keyPointer, keySize, valPointer, valSize, ident)
+		if _, err := d.responseSetHeader(caller, keyPointer, keySize, valPointer, valSize, ident); err != nil {
+			return nil, errors.Wrap(err, "failed to responseSetHeader")
+		}
 
 		return nil, nil
 	}
 
-	return runtime.NewHostFn("resp_set_header", 5, false, fn)
+	return runtime.NewCallerHostFn("resp_set_header", 5, false, fn)
 }
 
-func (d *defaultAPI) responseSetHeader(keyPointer int32, keySize int32, valPointer int32, valSize int32, ident int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(ident, false)
+func (d *defaultAPI) responseSetHeader(caller *runtime.WasmInstance, keyPointer int32, keySize int32, valPointer int32, valSize int32, ident int32) (int32, error) {
+	inst, err := runtime.CallerInstance(caller, ident, false)
+	if err != nil {
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return -1, nil
+	}
+
+	keyBytes, err := inst.BorrowMemory(keyPointer, keySize)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return -1
+		return 0, errors.Wrap(err, "failed to BorrowMemory key")
 	}
 
-	keyBytes := inst.ReadMemory(keyPointer, keySize)
 	key := string(keyBytes)
 
-	valBytes := inst.ReadMemory(valPointer, valSize)
+	valBytes, err := inst.BorrowMemory(valPointer, valSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to BorrowMemory value")
+	}
+
 	val := string(valBytes)
 
 	req := RequestFromContext(inst.Ctx().Context)
@@ -49,11 +59,11 @@ func (d *defaultAPI) responseSetHeader(keyPointer int32, keySize int32, valPoint
 		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] failed to SetResponseHeader"))
 
 		if err == capabilities.ErrReqNotSet {
-			return -2
+			return -2, nil
 		} else {
-			return -5
+			return -5, nil
 		}
 	}
 
-	return 0
+	return 0, nil
 }
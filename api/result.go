@@ -9,56 +9,70 @@ import (
 )
 
 func (d *defaultAPI) ReturnResultHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		pointer := args[0].(int32)
 		size := args[1].(int32)
 		ident := args[2].(int32)
 
-		d.returnResult(pointer, size, ident)
+		if err := d.returnResult(caller, pointer, size, ident); err != nil {
+			return nil, errors.Wrap(err, "failed to returnResult")
+		}
 
 		return nil, nil
 	}
 
-	return runtime.NewHostFn("return_result", 3, false, fn)
+	return runtime.NewCallerHostFn("return_result", 3, false, fn)
 }
 
-func (d *defaultAPI) returnResult(pointer int32, size int32, identifier int32) {
-	inst, err := runtime.InstanceForIdentifier(identifier, false)
+func (d *defaultAPI) returnResult(caller *runtime.WasmInstance, pointer int32, size int32, identifier int32) error {
+	inst, err := runtime.CallerInstance(caller, identifier, false)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return nil
 	}
 
-	result := inst.ReadMemory(pointer, size)
+	result, err := inst.ReadMemory(pointer, size)
+	if err != nil {
+		return errors.Wrap(err, "failed to ReadMemory result")
+	}
 
 	inst.SendExecutionResult(result, nil)
+
+	return nil
 }
 
 func (d *defaultAPI) ReturnErrorHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		code := args[0].(int32)
 		pointer := args[1].(int32)
 		size := args[2].(int32)
 		ident := args[3].(int32)
 
-		d.returnError(code, pointer, size, ident)
+		if err := d.returnError(caller, code, pointer, size, ident); err != nil {
+			return nil, errors.Wrap(err, "failed to returnError")
+		}
 
 		return nil, nil
 	}
 
-	return runtime.NewHostFn("return_error", 4, false, fn)
+	return runtime.NewCallerHostFn("return_error", 4, false, fn)
 }
 
-func (d *defaultAPI) returnError(code int32, pointer int32, size int32, identifier int32) {
-	inst, err := runtime.InstanceForIdentifier(identifier, false)
+func (d *defaultAPI) returnError(caller *runtime.WasmInstance, code int32, pointer int32, size int32, identifier int32) error {
+	inst, err := runtime.CallerInstance(caller, identifier, false)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return nil
 	}
 
-	result := inst.ReadMemory(pointer, size)
+	result, err := inst.BorrowMemory(pointer, size)
+	if err != nil {
+		return errors.Wrap(err, "failed to BorrowMemory message")
+	}
 
 	runErr := scheduler.RunErr{Code: int(code), Message: string(result)}
 
 	inst.SendExecutionResult(nil, runErr)
+
+	return nil
 }
@@ -7,36 +7,57 @@ import (
 )
 
 func (d *defaultAPI) GetSecretValueHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		pointer := args[0].(int32)
 		size := args[1].(int32)
 		ident := args[2].(int32)
 
-		ret := d.getSecretValue(pointer, size, ident)
+		ret, err := d.getSecretValue(caller, pointer, size, ident)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to getSecretValue")
+		}
 
 		return ret, nil
 	}
 
-	return runtime.NewHostFn("get_secret_value", 3, true, fn)
+	return runtime.NewCallerHostFn("get_secret_value", 3, true, fn)
 }
 
-func (d *defaultAPI) getSecretValue(pointer int32, size int32, identifier int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(identifier, false)
+func (d *defaultAPI) getSecretValue(caller *runtime.WasmInstance, pointer int32, size int32, identifier int32) (int32, error) {
+	inst, err := runtime.CallerInstance(caller, identifier, false)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return -1
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return -1, nil
+	}
+
+	keyBytes, err := inst.BorrowMemory(pointer, size)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to BorrowMemory key")
 	}
 
-	keyBytes := inst.ReadMemory(pointer, size)
 	key := string(keyBytes)
 
-	val := d.capabilities.Secrets.GetSecretValue(key)
+	val := d.secretValue(inst, key)
 
 	result, err := inst.Ctx().SetFFIResult([]byte(val), err)
 	if err != nil {
 		runtime.InternalLogger().ErrorString("[engine] failed to SetFFIResult", err.Error())
-		return -1
+		return -1, nil
 	}
 
-	return result.FFISize()
+	return result.FFISize(), nil
+}
+
+// secretValue gets the value of a secret for the instance, which is empty if it isn't set or the module isn't allowed
+// to read it
+func (d *defaultAPI) secretValue(inst *runtime.WasmInstance, name string) string {
+	call := &HostCall{Name: "get_secret_value", Args: []string{name}}
+
+	callCapability(inst, call, func(call *HostCall) error {
+		call.Result = []byte(d.capabilities.Secrets.GetSecretValue(name))
+
+		return nil
+	})
+
+	return string(call.Result)
 }
@@ -7,29 +7,35 @@ import (
 )
 
 func (d *defaultAPI) GetStaticFileHandler() runtime.HostFn {
-	fn := func(args ...interface{}) (interface{}, error) {
+	fn := func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
 		namePointer := args[0].(int32)
 		nameeSize := args[1].(int32)
 		ident := args[2].(int32)
 
-		ret := d.getStaticFile(namePointer, nameeSize, ident)
+		ret, err := d.getStaticFile(caller, namePointer, nameeSize, ident)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to getStaticFile")
+		}
 
 		return ret, nil
 	}
 
-	return runtime.NewHostFn("get_static_file", 3, true, fn)
+	return runtime.NewCallerHostFn("get_static_file", 3, true, fn)
 }
 
-func (d *defaultAPI) getStaticFile(namePtr int32, nameSize int32, ident int32) int32 {
-	inst, err := runtime.InstanceForIdentifier(ident, true)
+func (d *defaultAPI) getStaticFile(caller *runtime.WasmInstance, namePtr int32, nameSize int32, ident int32) (int32, error) {
+	inst, err := runtime.CallerInstance(caller, ident, true)
 	if err != nil {
-		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to InstanceForIdentifier"))
-		return -1
+		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] alert: failed to CallerInstance"))
+		return -1, nil
 	}
 
-	name := inst.ReadMemory(namePtr, nameSize)
+	name, err := inst.BorrowMemory(namePtr, nameSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to BorrowMemory name")
+	}
 
-	file, err := d.capabilities.FileSource.GetStatic(string(name))
+	file, err := d.staticFile(inst, string(name))
 	if err != nil {
 		runtime.InternalLogger().Error(errors.Wrap(err, "[engine] failed to GetStatic"))
 	}
@@ -37,8 +43,22 @@ func (d *defaultAPI) getStaticFile(namePtr int32, nameSize int32, ident int32) i
 	result, err := inst.Ctx().SetFFIResult(file, err)
 	if err != nil {
 		runtime.InternalLogger().ErrorString("[engine] failed to SetFFIResult", err.Error())
-		return -1
+		return -1, nil
 	}
 
-	return result.FFISize()
+	return result.FFISize(), nil
+}
+
+// staticFile gets a static file for the instance
+func (d *defaultAPI) staticFile(inst *runtime.WasmInstance, name string) ([]byte, error) {
+	call := &HostCall{Name: "get_static_file", Args: []string{name}}
+
+	err := callCapability(inst, call, func(call *HostCall) error {
+		file, err := d.capabilities.FileSource.GetStatic(name)
+		call.Result = file
+
+		return err
+	})
+
+	return call.Result, err
 }
@@ -0,0 +1,276 @@
+package api
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/capabilities"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// The v2 host API is defined in WIT (see wit/host.wit), and its functions are imported by core modules with the
+// signatures that the canonical ABI of the component model lowers them to. Sat's runtimes don't load components, so
+// rather than being served through component-model bindings, the lifting and lowering is done here by hand. Strings
+// and lists are passed as a pointer and a length, and results that don't fit in a single value are stored in a return
+// area whose pointer the module passes as the last argument. Lists returned to the module are allocated with its
+// cabi_realloc, which can grow its memory, so every argument is copied out of the memory before anything is returned.
+
+// the sizes of the values stored in return areas
+const (
+	// a string or list is its pointer and length
+	canonicalListSize = 8
+	// an option or a result of a string or list has a discriminant, then the value aligned to 4 bytes
+	canonicalOptionSize = 12
+	canonicalPayload    = 4
+	// a tuple of two strings is both of their pointers and lengths
+	canonicalTupleSize = 16
+	canonicalListAlign = 4
+)
+
+// v2Capabilities maps the interfaces of the v2 host API to the capabilities that they belong to
+var v2Capabilities = map[string]string{
+	"log":      CapabilityLogger,
+	"request":  CapabilityRequest,
+	"response": CapabilityRequest,
+	"cache":    CapabilityCache,
+	"secrets":  CapabilitySecrets,
+	"files":    CapabilityStatic,
+	"http":     CapabilityHTTP,
+	"graphql":  CapabilityGraphQL,
+	"db":       CapabilityDB,
+}
+
+// v2HostFunctions returns the functions of the v2 host API
+func (d *defaultAPI) v2HostFunctions() []runtime.HostFn {
+	fns := []runtime.HostFn{
+		d.V2LogHandler(),
+		d.V2RequestMetaHandler("method"),
+		d.V2RequestMetaHandler("url"),
+		d.V2RequestMetaHandler("id"),
+		d.V2RequestMetaHandler("body"),
+		d.V2RequestFieldHandler("header", capabilities.RequestFieldTypeHeader),
+		d.V2RequestFieldHandler("url-param", capabilities.RequestFieldTypeParams),
+		d.V2RequestFieldHandler("query-param", capabilities.RequestFieldTypeQuery),
+		d.V2RequestFieldHandler("body-field", capabilities.RequestFieldTypeBody),
+		d.V2RequestFieldHandler("state", capabilities.RequestFieldTypeState),
+		d.V2ResponseSetHeaderHandler(),
+		d.V2CacheGetHandler(),
+		d.V2CacheSetHandler(),
+		d.V2SecretsGetHandler(),
+		d.V2FilesGetHandler(),
+		d.V2HTTPFetchHandler(),
+		d.V2GraphQLQueryHandler(),
+		d.V2DBExecHandler(),
+	}
+
+	return fns
+}
+
+// v2HostFunc is a function of the v2 host API, given the instance that called it. Returning an error traps the
+// module, which is reserved for modules that break the canonical ABI (such as by passing memory out of bounds), as
+// errors from capabilities are returned to the module as values.
+type v2HostFunc func(inst *runtime.WasmInstance, args ...interface{}) error
+
+// newV2HostFn creates a host function that modules import from the named interface of the v2 host API
+func newV2HostFn(iface, name string, argCount int, fn v2HostFunc) runtime.HostFn {
+	h := runtime.NewCallerHostFn(name, argCount, false, func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
+		// v2 modules don't pass an ident, so the caller can only be resolved by the runtime
+		inst, err := runtime.Caller(caller, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to Caller")
+		}
+
+		if err := fn(inst, args...); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	})
+
+	h.Module = runtime.WITNamespace(iface)
+
+	return h
+}
+
+// liftString copies a string out of the instance's memory
+func liftString(inst *runtime.WasmInstance, pointer int32, size int32) (string, error) {
+	borrowed, err := inst.BorrowMemory(pointer, size)
+	if err != nil {
+		return "", err
+	}
+
+	return string(borrowed), nil
+}
+
+// liftStrings copies a list<string> out of the instance's memory
+func liftStrings(inst *runtime.WasmInstance, pointer int32, count int32) ([]string, error) {
+	elements, err := borrowElements(inst, pointer, count, canonicalListSize)
+	if err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, 0, len(elements))
+
+	for _, e := range elements {
+		s, err := liftString(inst, int32(binary.LittleEndian.Uint32(e)), int32(binary.LittleEndian.Uint32(e[4:])))
+		if err != nil {
+			return nil, err
+		}
+
+		strs = append(strs, s)
+	}
+
+	return strs, nil
+}
+
+// liftTuples copies a list<tuple<string, string>> out of the instance's memory
+func liftTuples(inst *runtime.WasmInstance, pointer int32, count int32) ([][2]string, error) {
+	elements, err := borrowElements(inst, pointer, count, canonicalTupleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	tuples := make([][2]string, 0, len(elements))
+
+	for _, e := range elements {
+		var tuple [2]string
+
+		for i := range tuple {
+			field := e[i*canonicalListSize:]
+
+			if tuple[i], err = liftString(inst, int32(binary.LittleEndian.Uint32(field)), int32(binary.LittleEndian.Uint32(field[4:]))); err != nil {
+				return nil, err
+			}
+		}
+
+		tuples = append(tuples, tuple)
+	}
+
+	return tuples, nil
+}
+
+// borrowElements borrows the elements of a list of fixed-size values from the instance's memory
+func borrowElements(inst *runtime.WasmInstance, pointer int32, count int32, size int) ([][]byte, error) {
+	if uint32(count) > math.MaxInt32/uint32(size) {
+		return nil, errors.Wrapf(runtime.ErrMemoryOutOfBounds, "list of %d elements", uint32(count))
+	}
+
+	borrowed, err := inst.BorrowMemory(pointer, count*int32(size))
+	if err != nil {
+		return nil, err
+	}
+
+	elements := make([][]byte, count)
+	for i := range elements {
+		elements[i] = borrowed[i*size : (i+1)*size]
+	}
+
+	return elements, nil
+}
+
+// returnArea is a value being lowered into the return area that a module passes to a host function
+type returnArea []byte
+
+// putU16 stores a u16 in the area
+func (r returnArea) putU16(offset int, v uint16) {
+	binary.LittleEndian.PutUint16(r[offset:], v)
+}
+
+// putList lowers data into the instance's memory and stores its pointer and length in the area
+func (r returnArea) putList(inst *runtime.WasmInstance, offset int, data []byte) error {
+	pointer, err := inst.LowerBytes(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to LowerBytes")
+	}
+
+	binary.LittleEndian.PutUint32(r[offset:], uint32(pointer))
+	binary.LittleEndian.PutUint32(r[offset+4:], uint32(len(data)))
+
+	return nil
+}
+
+// putTuples lowers a list<tuple<string, string>> into the instance's memory and stores its pointer and length
+func (r returnArea) putTuples(inst *runtime.WasmInstance, offset int, tuples [][2]string) error {
+	elements := make(returnArea, len(tuples)*canonicalTupleSize)
+
+	for i, t := range tuples {
+		for j, s := range t {
+			if err := elements.putList(inst, i*canonicalTupleSize+j*canonicalListSize, []byte(s)); err != nil {
+				return err
+			}
+		}
+	}
+
+	pointer, err := inst.Realloc(canonicalListAlign, int32(len(elements)))
+	if err != nil {
+		return errors.Wrap(err, "failed to Realloc")
+	}
+
+	if err := inst.WriteMemoryAtLocation(pointer, elements); err != nil {
+		return errors.Wrap(err, "failed to WriteMemoryAtLocation")
+	}
+
+	binary.LittleEndian.PutUint32(r[offset:], uint32(pointer))
+	binary.LittleEndian.PutUint32(r[offset+4:], uint32(len(tuples)))
+
+	return nil
+}
+
+// store writes the area to the module's memory at retPointer
+func (r returnArea) store(inst *runtime.WasmInstance, retPointer int32) error {
+	if err := inst.WriteMemoryAtLocation(retPointer, r); err != nil {
+		return errors.Wrap(err, "failed to WriteMemoryAtLocation return area")
+	}
+
+	return nil
+}
+
+// lowerList returns a string or list<u8> to the module
+func lowerList(inst *runtime.WasmInstance, retPointer int32, val []byte) error {
+	area := make(returnArea, canonicalListSize)
+
+	if err := area.putList(inst, 0, val); err != nil {
+		return err
+	}
+
+	return area.store(inst, retPointer)
+}
+
+// lowerOption returns an option<string> or option<list<u8>> to the module, which is none unless ok
+func lowerOption(inst *runtime.WasmInstance, retPointer int32, val []byte, ok bool) error {
+	area := make(returnArea, canonicalOptionSize)
+
+	if ok {
+		area[0] = 1
+
+		if err := area.putList(inst, canonicalPayload, val); err != nil {
+			return err
+		}
+	}
+
+	return area.store(inst, retPointer)
+}
+
+// lowerResult returns a result<list<u8>, string> or result<_, string> to the module, which is the error's message if
+// there is one. A nil val is an empty list, so the same layout serves both.
+func lowerResult(inst *runtime.WasmInstance, retPointer int32, val []byte, resultErr error) error {
+	area := make(returnArea, canonicalOptionSize)
+
+	switch {
+	case resultErr != nil:
+		area[0] = 1
+
+		if err := area.putList(inst, canonicalPayload, []byte(resultErr.Error())); err != nil {
+			return err
+		}
+	case val != nil:
+		if err := area.putList(inst, canonicalPayload, val); err != nil {
+			return err
+		}
+	}
+
+	return area.store(inst, retPointer)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// V2CacheGetHandler is cache.get: func(key: string) -> option<list<u8>>
+func (d *defaultAPI) V2CacheGetHandler() runtime.HostFn {
+	fn := func(inst *runtime.WasmInstance, args ...interface{}) error {
+		keyPointer := args[0].(int32)
+		keySize := args[1].(int32)
+		retPointer := args[2].(int32)
+
+		key, err := liftString(inst, keyPointer, keySize)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString key")
+		}
+
+		runtime.InternalLogger().Debug("[engine] getting cache key", key)
+
+		// the cache reports missing keys as errors, so any error is treated as the key not being set
+		val, err := d.getCached(inst, key)
+		// a module that runs deterministically is stopped rather than given an error that it could carry on from
+		if errors.Is(err, ErrNondeterministic) {
+			return err
+		} else if err != nil {
+			runtime.InternalLogger().Debug("[engine] failed to get cache key", key, err.Error())
+		}
+
+		if err := lowerOption(inst, retPointer, val, err == nil); err != nil {
+			return errors.Wrap(err, "failed to lowerOption")
+		}
+
+		return nil
+	}
+
+	return newV2HostFn("cache", "get", 3, fn)
+}
+
+// V2CacheSetHandler is cache.set: func(key: string, value: list<u8>, ttl-seconds: u32) -> result<_, string>
+func (d *defaultAPI) V2CacheSetHandler() runtime.HostFn {
+	fn := func(inst *runtime.WasmInstance, args ...interface{}) error {
+		keyPointer := args[0].(int32)
+		keySize := args[1].(int32)
+		valPointer := args[2].(int32)
+		valSize := args[3].(int32)
+		ttl := args[4].(int32)
+		retPointer := args[5].(int32)
+
+		key, err := liftString(inst, keyPointer, keySize)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString key")
+		}
+
+		// the cache keeps the value, so it is copied out of the guest's memory
+		val, err := inst.ReadMemory(valPointer, valSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to ReadMemory value")
+		}
+
+		runtime.InternalLogger().Debug("[engine] setting cache key", key)
+
+		// the TTL is unsigned, but the cache takes an int
+		setErr := d.setCached(inst, key, val, int(uint32(ttl)))
+		if setErr != nil {
+			runtime.InternalLogger().ErrorString("[engine] failed to set cache key", key, setErr.Error())
+		}
+
+		if err := lowerResult(inst, retPointer, nil, setErr); err != nil {
+			return errors.Wrap(err, "failed to lowerResult")
+		}
+
+		return nil
+	}
+
+	return newV2HostFn("cache", "set", 6, fn)
+}
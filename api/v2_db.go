@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/capabilities"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// V2DBExecHandler is db.exec: func(query-type: query-type, name: string, vars: list<string>) -> result<list<u8>, string>
+func (d *defaultAPI) V2DBExecHandler() runtime.HostFn {
+	fn := func(inst *runtime.WasmInstance, args ...interface{}) error {
+		queryType := args[0].(int32)
+		namePointer := args[1].(int32)
+		nameSize := args[2].(int32)
+		varsPointer := args[3].(int32)
+		varsCount := args[4].(int32)
+		retPointer := args[5].(int32)
+
+		// the query-type enum has the same cases as the database's query types, and the canonical ABI traps on any other
+		if queryType < int32(capabilities.QueryTypeInsert) || queryType > int32(capabilities.QueryTypeDelete) {
+			return fmt.Errorf("invalid query type %d", queryType)
+		}
+
+		name, err := liftString(inst, namePointer, nameSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString name")
+		}
+
+		vars, err := liftStrings(inst, varsPointer, varsCount)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftStrings vars")
+		}
+
+		queryVars := make([]interface{}, len(vars))
+		for i, v := range vars {
+			queryVars[i] = v
+		}
+
+		queryResult, queryErr := d.execQuery(inst, queryType, name, queryVars)
+		if queryErr != nil {
+			runtime.InternalLogger().ErrorString("[engine] failed to ExecQuery", name, queryErr.Error())
+		}
+
+		if err := lowerResult(inst, retPointer, queryResult, queryErr); err != nil {
+			return errors.Wrap(err, "failed to lowerResult")
+		}
+
+		return nil
+	}
+
+	return newV2HostFn("db", "exec", 6, fn)
+}
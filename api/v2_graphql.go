@@ -0,0 +1,42 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// V2GraphQLQueryHandler is graphql.query: func(endpoint: string, query: string) -> result<list<u8>, string>
+func (d *defaultAPI) V2GraphQLQueryHandler() runtime.HostFn {
+	fn := func(inst *runtime.WasmInstance, args ...interface{}) error {
+		endpointPointer := args[0].(int32)
+		endpointSize := args[1].(int32)
+		queryPointer := args[2].(int32)
+		querySize := args[3].(int32)
+		retPointer := args[4].(int32)
+
+		endpoint, err := liftString(inst, endpointPointer, endpointSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString endpoint")
+		}
+
+		query, err := liftString(inst, queryPointer, querySize)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString query")
+		}
+
+		resp, queryErr := d.graphQLQuery(inst, endpoint, query)
+		// a module that runs deterministically is stopped rather than given an error that it could carry on from
+		if errors.Is(queryErr, ErrNondeterministic) {
+			return queryErr
+		}
+
+		if err := lowerResult(inst, retPointer, resp, queryErr); err != nil {
+			return errors.Wrap(err, "failed to lowerResult")
+		}
+
+		return nil
+	}
+
+	return newV2HostFn("graphql", "query", 5, fn)
+}
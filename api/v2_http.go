@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// the layout of the result<response, string> returned by http.fetch, whose response has its status, headers and
+// body after the discriminant
+const (
+	v2FetchResultSize    = 24
+	v2FetchResultStatus  = 4
+	v2FetchResultHeaders = 8
+	v2FetchResultBody    = 16
+)
+
+// V2HTTPFetchHandler is http.fetch: func(req: request) -> result<response, string>
+func (d *defaultAPI) V2HTTPFetchHandler() runtime.HostFn {
+	fn := func(inst *runtime.WasmInstance, args ...interface{}) error {
+		method := args[0].(int32)
+		urlPointer := args[1].(int32)
+		urlSize := args[2].(int32)
+		headersPointer := args[3].(int32)
+		headersCount := args[4].(int32)
+		bodyPointer := args[5].(int32)
+		bodySize := args[6].(int32)
+		retPointer := args[7].(int32)
+
+		// the method enum has the same cases as fetch_url's methods, and the canonical ABI traps on any other
+		httpMethod, exists := methodValToMethod[method]
+		if !exists {
+			return fmt.Errorf("invalid method %d", method)
+		}
+
+		urlString, err := liftString(inst, urlPointer, urlSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString URL")
+		}
+
+		headerTuples, err := liftTuples(inst, headersPointer, headersCount)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftTuples headers")
+		}
+
+		headers := http.Header{}
+		for _, h := range headerTuples {
+			headers.Add(h[0], h[1])
+		}
+
+		// the client can still be reading the body after it returns, so it is copied out of the guest's memory
+		body, err := inst.ReadMemory(bodyPointer, bodySize)
+		if err != nil {
+			return errors.Wrap(err, "failed to ReadMemory body")
+		}
+
+		if len(body) > 0 && headers.Get("Content-Type") == "" {
+			headers.Add("Content-Type", contentTypeOctetStream)
+		}
+
+		area := make(returnArea, v2FetchResultSize)
+
+		status, respHeaders, respBody, fetchErr := d.fetch(inst, httpMethod, urlString, body, headers)
+		// a module that runs deterministically is stopped rather than given an error that it could carry on from
+		if errors.Is(fetchErr, ErrNondeterministic) {
+			return fetchErr
+		}
+
+		if fetchErr == nil {
+			area.putU16(v2FetchResultStatus, uint16(status))
+
+			if err := area.putTuples(inst, v2FetchResultHeaders, headerPairs(respHeaders)); err != nil {
+				return errors.Wrap(err, "failed to putTuples headers")
+			}
+
+			if err := area.putList(inst, v2FetchResultBody, respBody); err != nil {
+				return errors.Wrap(err, "failed to putList body")
+			}
+		}
+
+		if fetchErr != nil {
+			area[0] = 1
+
+			if err := area.putList(inst, canonicalPayload, []byte(fetchErr.Error())); err != nil {
+				return errors.Wrap(err, "failed to putList error")
+			}
+		}
+
+		if err := area.store(inst, retPointer); err != nil {
+			return errors.Wrap(err, "failed to store")
+		}
+
+		return nil
+	}
+
+	return newV2HostFn("http", "fetch", 8, fn)
+}
+
+// headerPairs flattens headers into a pair for each value, sorted by name
+func headerPairs(headers http.Header) [][2]string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	pairs := [][2]string{}
+
+	for _, name := range names {
+		for _, val := range headers[name] {
+			pairs = append(pairs, [2]string{name, val})
+		}
+	}
+
+	return pairs
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// v2LogLevels maps the levels of the log interface's level enum to those used by log_msg
+var v2LogLevels = []int32{
+	outputLevels[runtime.LogLevelError],
+	outputLevels[runtime.LogLevelWarn],
+	outputLevels[runtime.LogLevelInfo],
+	outputLevels[runtime.LogLevelDebug],
+}
+
+// V2LogHandler is log.log: func(level: level, message: string)
+func (d *defaultAPI) V2LogHandler() runtime.HostFn {
+	fn := func(inst *runtime.WasmInstance, args ...interface{}) error {
+		level := args[0].(int32)
+		pointer := args[1].(int32)
+		size := args[2].(int32)
+
+		if level < 0 || int(level) >= len(v2LogLevels) {
+			return fmt.Errorf("invalid log level %d", level)
+		}
+
+		msg, err := liftString(inst, pointer, size)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString message")
+		}
+
+		d.capabilities.LoggerSource.Log(v2LogLevels[level], msg, d.scopeFor(inst))
+
+		return nil
+	}
+
+	h := newV2HostFn("log", "log", 3, fn)
+
+	// a module that logs as it initializes can still be preinitialized, as the message only goes to the host
+	h.SnapshotSafe = true
+
+	return h
+}
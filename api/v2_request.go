@@ -0,0 +1,94 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/capabilities"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// V2RequestMetaHandler is one of request.method, request.url or request.id: func() -> string, or request.body:
+// func() -> list<u8>. Without a request to read them from, they are empty.
+func (d *defaultAPI) V2RequestMetaHandler(field string) runtime.HostFn {
+	fn := func(inst *runtime.WasmInstance, args ...interface{}) error {
+		retPointer := args[0].(int32)
+
+		val, err := d.requestHandler(inst).GetField(capabilities.RequestFieldTypeMeta, field)
+		if err != nil {
+			runtime.InternalLogger().Error(errors.Wrapf(err, "[engine] failed to GetField %s", field))
+		}
+
+		if err := lowerList(inst, retPointer, val); err != nil {
+			return errors.Wrapf(err, "failed to lowerList %s", field)
+		}
+
+		return nil
+	}
+
+	return newV2HostFn("request", field, 1, fn)
+}
+
+// V2RequestFieldHandler is one of request.header, request.url-param, request.query-param and request.body-field:
+// func(name: string) -> option<string>, or request.state: func(key: string) -> option<list<u8>>. Fields that the
+// request doesn't have are none, as are empty query parameters, which can't be told apart from missing ones.
+func (d *defaultAPI) V2RequestFieldHandler(name string, fieldType int32) runtime.HostFn {
+	fn := func(inst *runtime.WasmInstance, args ...interface{}) error {
+		keyPointer := args[0].(int32)
+		keySize := args[1].(int32)
+		retPointer := args[2].(int32)
+
+		key, err := liftString(inst, keyPointer, keySize)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString key")
+		}
+
+		val, err := d.requestHandler(inst).GetField(fieldType, key)
+		if err != nil && err != capabilities.ErrKeyNotFound {
+			runtime.InternalLogger().Error(errors.Wrapf(err, "[engine] failed to GetField %s", name))
+		}
+
+		found := err == nil && !(fieldType == capabilities.RequestFieldTypeQuery && len(val) == 0)
+
+		if err := lowerOption(inst, retPointer, val, found); err != nil {
+			return errors.Wrapf(err, "failed to lowerOption %s", name)
+		}
+
+		return nil
+	}
+
+	return newV2HostFn("request", name, 3, fn)
+}
+
+// V2ResponseSetHeaderHandler is response.set-header: func(name: string, value: string)
+func (d *defaultAPI) V2ResponseSetHeaderHandler() runtime.HostFn {
+	fn := func(inst *runtime.WasmInstance, args ...interface{}) error {
+		keyPointer := args[0].(int32)
+		keySize := args[1].(int32)
+		valPointer := args[2].(int32)
+		valSize := args[3].(int32)
+
+		key, err := liftString(inst, keyPointer, keySize)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString name")
+		}
+
+		val, err := liftString(inst, valPointer, valSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString value")
+		}
+
+		if err := d.requestHandler(inst).SetResponseHeader(key, val); err != nil {
+			runtime.InternalLogger().Error(errors.Wrap(err, "[engine] failed to SetResponseHeader"))
+		}
+
+		return nil
+	}
+
+	return newV2HostFn("response", "set-header", 4, fn)
+}
+
+// requestHandler returns a handler for the request that the instance is handling
+func (d *defaultAPI) requestHandler(inst *runtime.WasmInstance) capabilities.RequestHandlerCapability {
+	return capabilities.NewRequestHandler(*d.capabilities.RequestConfig, RequestFromContext(inst.Ctx().Context))
+}
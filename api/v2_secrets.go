@@ -0,0 +1,32 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// V2SecretsGetHandler is secrets.get: func(name: string) -> option<string>. Secrets that aren't set, or that the
+// module isn't allowed to read, are none.
+func (d *defaultAPI) V2SecretsGetHandler() runtime.HostFn {
+	fn := func(inst *runtime.WasmInstance, args ...interface{}) error {
+		namePointer := args[0].(int32)
+		nameSize := args[1].(int32)
+		retPointer := args[2].(int32)
+
+		name, err := liftString(inst, namePointer, nameSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString name")
+		}
+
+		val := d.secretValue(inst, name)
+
+		if err := lowerOption(inst, retPointer, []byte(val), val != ""); err != nil {
+			return errors.Wrap(err, "failed to lowerOption")
+		}
+
+		return nil
+	}
+
+	return newV2HostFn("secrets", "get", 3, fn)
+}
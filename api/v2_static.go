@@ -0,0 +1,34 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// V2FilesGetHandler is files.get: func(path: string) -> result<list<u8>, string>
+func (d *defaultAPI) V2FilesGetHandler() runtime.HostFn {
+	fn := func(inst *runtime.WasmInstance, args ...interface{}) error {
+		pathPointer := args[0].(int32)
+		pathSize := args[1].(int32)
+		retPointer := args[2].(int32)
+
+		path, err := liftString(inst, pathPointer, pathSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to liftString path")
+		}
+
+		file, getErr := d.staticFile(inst, path)
+		if getErr != nil {
+			runtime.InternalLogger().Error(errors.Wrap(getErr, "[engine] failed to GetStatic"))
+		}
+
+		if err := lowerResult(inst, retPointer, file, getErr); err != nil {
+			return errors.Wrap(err, "failed to lowerResult")
+		}
+
+		return nil
+	}
+
+	return newV2HostFn("files", "get", 3, fn)
+}
@@ -0,0 +1,9 @@
+//go:build wasmedge
+// +build wasmedge
+
+package engine
+
+import (
+	// WasmEdge is only compiled in with the wasmedge build tag, as it requires WasmEdge to be installed
+	_ "github.com/suborbital/sat/engine/runtime/wasmedge"
+)
@@ -0,0 +1,9 @@
+//go:build wasmer
+// +build wasmer
+
+package engine
+
+import (
+	// Wasmer is compiled in (in place of Wasmtime) with the wasmer build tag
+	_ "github.com/suborbital/sat/engine/runtime/wasmer"
+)
@@ -0,0 +1,10 @@
+//go:build cgo && !wasmer
+// +build cgo,!wasmer
+
+package engine
+
+import (
+	// Wasmtime is compiled in by default (when cgo is enabled), but cannot be linked alongside
+	// Wasmer as both libraries export the symbols of the standard Wasm C API
+	_ "github.com/suborbital/sat/engine/runtime/wasmtime"
+)
@@ -0,0 +1,6 @@
+package engine
+
+import (
+	// wazero is written in pure Go, so it is always compiled in and is the only runtime available without cgo
+	_ "github.com/suborbital/sat/engine/runtime/wazero"
+)
@@ -1,50 +1,220 @@
 package engine
 
 import (
+	"os"
+	"sync"
+
 	"github.com/pkg/errors"
 
 	"github.com/suborbital/appspec/tenant"
 	"github.com/suborbital/e2core/scheduler"
 
 	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine/runtime"
 )
 
 // Engine is a Webassembly job scheduler with configurable host APIs
 type Engine struct {
 	*scheduler.Scheduler
-	api api.HostAPI
+	api     api.HostAPI
+	runtime string
+	// recorder records or replays the invocations of the Engine's modules, and is nil if they aren't
+	recorder api.Recorder
+
+	// runners are the Wasm runners that have been registered, by name
+	runners map[string]*wasmRunner
+	lock    sync.RWMutex
+}
+
+// Option configures an Engine
+type Option func(*Engine)
+
+// UseRuntime selects the Wasm runtime that the Engine's modules run on, by the name it was
+// registered with. It defaults to the SAT_RUNTIME environment variable, or Wasmtime (if compiled in) when that is unset.
+func UseRuntime(name string) Option {
+	return func(e *Engine) {
+		e.runtime = name
+	}
+}
+
+// Record has a Recorder record (or replay) every invocation of the Engine's modules
+func Record(recorder api.Recorder) Option {
+	return func(e *Engine) {
+		e.recorder = recorder
+	}
 }
 
 // New creates a new Engine with the default API
-func New() *Engine {
-	return NewWithAPI(api.New())
+func New(opts ...Option) *Engine {
+	return NewWithAPI(api.New(), opts...)
 }
 
 // NewWithAPI creates a new Engine with the given API
-func NewWithAPI(api api.HostAPI) *Engine {
+func NewWithAPI(api api.HostAPI, opts ...Option) *Engine {
 	e := &Engine{
 		Scheduler: scheduler.New(),
 		api:       api,
+		runtime:   os.Getenv("SAT_RUNTIME"),
+		runners:   map[string]*wasmRunner{},
+	}
+
+	for _, o := range opts {
+		o(e)
+	}
+
+	if e.runtime == "" {
+		e.runtime = runtime.DefaultBackend()
 	}
 
 	return e
 }
 
-// Register registers a Wasm module by reference
+// Runtime returns the name of the Wasm runtime used by the Engine
+func (e *Engine) Runtime() string {
+	return e.runtime
+}
+
+// Register registers a Wasm module by reference. A module that fails to load is still registered, with each of its
+// jobs failing with the error, which RegisterWithConfig returns instead.
 func (e *Engine) Register(name string, ref *tenant.WasmModuleRef, opts ...scheduler.Option) scheduler.JobFunc {
-	runner := newRunnerFromRef(ref, e.api)
+	jobFunc, err := e.RegisterWithConfig(name, ref, runtime.Config{}, opts...)
+	if err != nil {
+		return e.Scheduler.Register(name, &failedRunner{err: err}, opts...)
+	}
 
-	return e.Scheduler.Register(name, runner, opts...)
+	return jobFunc
+}
+
+// RegisterWithConfig registers a Wasm module by reference, building and running its instances with the given config.
+// It fails if the Engine's runtime isn't available, the module doesn't match the ABI, or the runtime can't honour the
+// config.
+func (e *Engine) RegisterWithConfig(name string, ref *tenant.WasmModuleRef, config runtime.Config, opts ...scheduler.Option) (scheduler.JobFunc, error) {
+	runner, err := newRunnerFromRef(ref, e.api, e.runtime, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to newRunnerFromRef")
+	}
+
+	return e.register(name, runner, opts...), nil
 }
 
 // RegisterFromFile registers a Wasm module by reference
 func (e *Engine) RegisterFromFile(name, filename string, opts ...scheduler.Option) (scheduler.JobFunc, error) {
-	runner, err := newRunnerFromFile(filename, e.api)
+	runner, err := newRunnerFromFile(filename, e.api, e.runtime, runtime.Config{})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to newRunnerFromFile")
 	}
 
-	jobFunc := e.Scheduler.Register(name, runner, opts...)
+	jobFunc := e.register(name, runner, opts...)
 
 	return jobFunc, nil
 }
+
+// register registers a runner with the scheduler, keeping track of it so that its health can be reported
+func (e *Engine) register(name string, runner *wasmRunner, opts ...scheduler.Option) scheduler.JobFunc {
+	runner.name = name
+	runner.recorder = e.recorder
+
+	e.lock.Lock()
+	e.runners[name] = runner
+	e.lock.Unlock()
+
+	return e.Scheduler.Register(name, runner, opts...)
+}
+
+// failedRunner stands in for a module that failed to load, failing each of its jobs with the error
+type failedRunner struct {
+	err error
+}
+
+// Run returns the error that the module failed to load with
+func (f *failedRunner) Run(scheduler.Job, *scheduler.Ctx) (interface{}, error) {
+	return nil, f.err
+}
+
+// OnChange does nothing, as there are no instances to manage
+func (f *failedRunner) OnChange(scheduler.ChangeEvent) error {
+	return nil
+}
+
+// PreWarm builds instances of the named module ahead of time, which its workers adopt as the scheduler starts them
+func (e *Engine) PreWarm(name string, count int) error {
+	e.lock.RLock()
+	runner, ok := e.runners[name]
+	e.lock.RUnlock()
+
+	if !ok {
+		return errors.Errorf("module %s is not registered", name)
+	}
+
+	if err := runner.PreWarm(count); err != nil {
+		return errors.Wrap(err, "failed to PreWarm")
+	}
+
+	return nil
+}
+
+// Reload replaces the named module with a new version, without restarting its workers. The new version is compiled
+// and instantiated before any of the module's instances are replaced, so a version that fails to build returns an
+// error and the old version keeps serving.
+func (e *Engine) Reload(name string, ref *tenant.WasmModuleRef) error {
+	e.lock.RLock()
+	runner, ok := e.runners[name]
+	e.lock.RUnlock()
+
+	if !ok {
+		return errors.Errorf("module %s is not registered", name)
+	}
+
+	if err := runner.Reload(ref, e.api, e.runtime); err != nil {
+		return errors.Wrap(err, "failed to Reload")
+	}
+
+	return nil
+}
+
+// Health returns the health of the named module's pool of instances, or false if it wasn't registered with the Engine
+func (e *Engine) Health(name string) (runtime.PoolHealth, bool) {
+	e.lock.RLock()
+	runner, ok := e.runners[name]
+	e.lock.RUnlock()
+
+	if !ok {
+		return runtime.PoolHealth{}, false
+	}
+
+	return runner.Health(), true
+}
+
+// CheckABI inspects a Wasm module's imports and exports, returning an error that reports every problem if the
+// module doesn't match the ABI provided by the Engine's API
+func (e *Engine) CheckABI(ref *tenant.WasmModuleRef) error {
+	_, err := checkABI(ref, e.api.HostFunctions())
+
+	return err
+}
+
+// Precompile compiles a Wasm module ahead of time using the given config, which fills the compile cache (if configured).
+// A module that is preinitialized is preinitialized first, which caches its snapshot too.
+func (e *Engine) Precompile(ref *tenant.WasmModuleRef, config runtime.Config) error {
+	builderFunc, err := runtime.Backend(e.runtime)
+	if err != nil {
+		return errors.Wrap(err, "failed to runtime.Backend")
+	}
+
+	if config.Preinit {
+		if ref, err = runtime.Preinitialize(ref, e.api.HostFunctions(), e.runtime, config); err != nil {
+			return errors.Wrap(err, "failed to Preinitialize")
+		}
+	}
+
+	precompiler, ok := builderFunc(ref, e.api.HostFunctions(), config).(runtime.Precompiler)
+	if !ok {
+		return runtime.ErrNoPrecompile
+	}
+
+	if err := precompiler.Precompile(); err != nil {
+		return errors.Wrap(err, "failed to Precompile")
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/wasmbinary"
+)
+
+var ErrABIMismatch = errors.New("module does not match the sat ABI")
+
+// hostModule is the namespace that host functions are imported from
+const hostModule = "env"
+
+// swiftSuffix is appended to the names of the host functions used by Swift modules, which take two extra i32s
+const swiftSuffix = "_swift"
+
+// WITPackage and WITVersion identify the WIT package that defines version 2 of the host API. ABIv2 modules import
+// each of its interfaces from a namespace of the form "sat:host/<interface>@2.0.0".
+const (
+	WITPackage = "sat:host"
+	WITVersion = "2.0.0"
+)
+
+// WITNamespace returns the namespace that ABIv2 modules import the named interface of the host API from
+func WITNamespace(iface string) string {
+	return fmt.Sprintf("%s/%s@%s", WITPackage, iface, WITVersion)
+}
+
+// IsWITNamespace returns true if imports from the namespace are from an interface of the v2 host API
+func IsWITNamespace(namespace string) bool {
+	return strings.HasPrefix(namespace, WITPackage+"/")
+}
+
+// ABIVersion is the version of the host API that a module was built against. Both versions are served side by
+// side, so modules built against either can run in the same engine.
+type ABIVersion int
+
+const (
+	// ABIv1 modules import host functions from "env", identify themselves to them and export run_e
+	ABIv1 ABIVersion = 1
+	// ABIv2 modules are core modules built with WIT bindings for the sat:host package, importing its interfaces and
+	// exporting run with the signatures that the canonical ABI lowers them to. Sat does the lowering itself, rather than
+	// loading components, which none of its runtimes can.
+	ABIv2 ABIVersion = 2
+)
+
+// ABIFlavour is the variation of the ABI that a module was built for
+type ABIFlavour string
+
+const (
+	// ABIStandard modules import host functions by their plain names
+	ABIStandard ABIFlavour = "standard"
+	// ABISwift modules import the _swift variants of host functions
+	ABISwift ABIFlavour = "swift"
+)
+
+// abiExport is an export that modules must provide
+type abiExport struct {
+	name string
+	kind byte
+	typ  wasmbinary.FuncType
+}
+
+// abiExports are the exports that every ABIv1 module must provide
+var abiExports = []abiExport{
+	{name: "memory", kind: wasmbinary.KindMemory},
+	{name: "allocate", kind: wasmbinary.KindFunc, typ: wasmbinary.FuncType{Params: []byte{wasmbinary.ValueI32}, Results: []byte{wasmbinary.ValueI32}}},
+	{name: "deallocate", kind: wasmbinary.KindFunc, typ: wasmbinary.FuncType{Params: []byte{wasmbinary.ValueI32, wasmbinary.ValueI32}}},
+	{name: "run_e", kind: wasmbinary.KindFunc, typ: wasmbinary.FuncType{Params: []byte{wasmbinary.ValueI32, wasmbinary.ValueI32, wasmbinary.ValueI32}}},
+}
+
+// abiV2Exports are the exports that every ABIv2 module must provide, as the core functions of the runnable world
+var abiV2Exports = []abiExport{
+	{name: "memory", kind: wasmbinary.KindMemory},
+	{name: "cabi_realloc", kind: wasmbinary.KindFunc, typ: wasmbinary.FuncType{Params: []byte{wasmbinary.ValueI32, wasmbinary.ValueI32, wasmbinary.ValueI32, wasmbinary.ValueI32}, Results: []byte{wasmbinary.ValueI32}}},
+	{name: "run", kind: wasmbinary.KindFunc, typ: wasmbinary.FuncType{Params: []byte{wasmbinary.ValueI32, wasmbinary.ValueI32}, Results: []byte{wasmbinary.ValueI32}}},
+}
+
+// wasiModules are the namespaces that WASI is imported from, which the runtimes provide themselves
+var wasiModules = map[string]bool{
+	"wasi_snapshot_preview1": true,
+	"wasi_unstable":          true,
+}
+
+// IsWASIModule returns true if imports from the namespace are provided by WASI rather than by sat's host functions
+func IsWASIModule(namespace string) bool {
+	return wasiModules[namespace]
+}
+
+// ABIReport describes how a module uses the ABI, and every way in which it doesn't match it
+type ABIReport struct {
+	Version ABIVersion
+	Flavour ABIFlavour
+	// LegacyInit is true if the module exports the deprecated init function, which only some runtimes call
+	LegacyInit bool
+	// Problems lists each missing, extra or mismatched symbol, along with what was expected
+	Problems []string
+}
+
+// ABIError is returned for a module that doesn't match the ABI, and reports every problem with it
+type ABIError struct {
+	Report *ABIReport
+}
+
+// Error lists the problems, one per line
+func (e *ABIError) Error() string {
+	b := &strings.Builder{}
+	if e.Report.Version == ABIv2 {
+		fmt.Fprintf(b, "%s (v2):", ErrABIMismatch.Error())
+	} else {
+		fmt.Fprintf(b, "%s (%s flavour):", ErrABIMismatch.Error(), e.Report.Flavour)
+	}
+
+	for _, p := range e.Report.Problems {
+		fmt.Fprintf(b, "\n  - %s", p)
+	}
+
+	return b.String()
+}
+
+// Is allows the error to be matched against ErrABIMismatch
+func (e *ABIError) Is(target error) bool {
+	return target == ErrABIMismatch
+}
+
+// Err returns an ABIError if the report has problems, or nil otherwise
+func (r *ABIReport) Err() error {
+	if len(r.Problems) == 0 {
+		return nil
+	}
+
+	return &ABIError{Report: r}
+}
+
+// CheckABI inspects a module's imports and exports against the ABI and the host functions that it will be given
+func CheckABI(module []byte, hostFns []HostFn) (*ABIReport, error) {
+	iface, err := wasmbinary.ReadInterface(module)
+	if errors.Is(err, wasmbinary.ErrComponent) {
+		return nil, errors.Wrap(err, "sat's runtimes only load core modules, so ABIv2 modules must be built as core modules rather than componentized")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to ReadInterface")
+	}
+
+	report := &ABIReport{Version: abiVersion(iface), Flavour: ABIStandard}
+
+	// host functions are provided by their namespace and name, with Swift variants of those imported from env
+	provided := map[string]wasmbinary.FuncType{}
+	for _, fn := range hostFns {
+		provided[fn.Namespace()+"."+fn.Name] = hostFnType(fn, false)
+
+		if fn.Module == "" {
+			provided[hostModule+"."+fn.Name+swiftSuffix] = hostFnType(fn, true)
+		}
+	}
+
+	for _, imp := range iface.Imports {
+		if wasiModules[imp.Module] {
+			continue
+		}
+
+		symbol := imp.Module + "." + imp.Name
+
+		switch {
+		case report.Version == ABIv1 && imp.Module != hostModule:
+			report.problem("unknown import %s, sat only provides host functions from %q and WASI", symbol, hostModule)
+			continue
+		case report.Version == ABIv2 && !IsWITNamespace(imp.Module):
+			report.problem("unknown import %s, sat only provides the interfaces of %s@%s and WASI", symbol, WITPackage, WITVersion)
+			continue
+		}
+
+		expected, ok := provided[symbol]
+		if !ok {
+			report.problem("unknown import %s, sat provides no such host function", symbol)
+			continue
+		}
+
+		if report.Version == ABIv1 && strings.HasSuffix(imp.Name, swiftSuffix) {
+			report.Flavour = ABISwift
+		}
+
+		if imp.Kind != wasmbinary.KindFunc {
+			report.problem("import %s is not a function, expected %s", symbol, expected)
+		} else if !imp.Type.Equal(expected) {
+			report.problem("import %s has signature %s, expected %s", symbol, imp.Type, expected)
+		}
+	}
+
+	exports := abiExports
+	if report.Version == ABIv2 {
+		exports = abiV2Exports
+	}
+
+	for _, want := range exports {
+		exp, ok := iface.Export(want.name)
+
+		switch {
+		case !ok && want.kind == wasmbinary.KindMemory:
+			report.problem("missing export %s, expected a memory", want.name)
+		case !ok:
+			report.problem("missing export %s, expected %s", want.name, want.typ)
+		case exp.Kind != want.kind:
+			report.problem("export %s has the wrong kind", want.name)
+		case want.kind == wasmbinary.KindFunc && !exp.Type.Equal(want.typ):
+			report.problem("export %s has signature %s, expected %s", want.name, exp.Type, want.typ)
+		}
+	}
+
+	if exp, ok := iface.Export("init"); ok && exp.Kind == wasmbinary.KindFunc {
+		report.LegacyInit = true
+	}
+
+	return report, nil
+}
+
+// abiVersion determines the version of the host API that a module was built against from its entrypoint, or failing
+// that from where it imports host functions from, so that a module missing its entrypoint is checked against the
+// version it was meant for
+func abiVersion(iface *wasmbinary.Interface) ABIVersion {
+	if _, ok := iface.Export("run_e"); ok {
+		return ABIv1
+	}
+
+	if _, ok := iface.Export("run"); ok {
+		return ABIv2
+	}
+
+	for _, imp := range iface.Imports {
+		if IsWITNamespace(imp.Module) {
+			return ABIv2
+		}
+	}
+
+	return ABIv1
+}
+
+// problem adds a problem to the report
+func (r *ABIReport) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// hostFnType returns the signature that a module must import a host function with
+func hostFnType(fn HostFn, swift bool) wasmbinary.FuncType {
+	typ := wasmbinary.FuncType{
+		Params:  valueTypeBytes(fn.Params),
+		Results: valueTypeBytes(fn.Results),
+	}
+
+	if swift {
+		typ.Params = append(typ.Params, wasmbinary.ValueI32, wasmbinary.ValueI32)
+	}
+
+	return typ
+}
+
+// valueTypeBytes encodes value types as they appear in the Wasm binary format
+func valueTypeBytes(types []ValueType) []byte {
+	encoded := make([]byte, len(types))
+
+	for i, t := range types {
+		switch t {
+		case I64:
+			encoded[i] = wasmbinary.ValueI64
+		case F32:
+			encoded[i] = wasmbinary.ValueF32
+		case F64:
+			encoded[i] = wasmbinary.ValueF64
+		default:
+			encoded[i] = wasmbinary.ValueI32
+		}
+	}
+
+	return encoded
+}
@@ -0,0 +1,135 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/e2core/scheduler"
+)
+
+// the exports that ABIv2 modules provide with the core signatures of the canonical ABI, which sat lowers its calls to
+// itself since its runtimes load core modules rather than components
+const (
+	canonicalRealloc = "cabi_realloc"
+	canonicalRun     = "run"
+	canonicalPostRun = "cabi_post_run"
+)
+
+// Realloc allocates size bytes aligned to align in the instance's memory with the module's cabi_realloc, which is
+// how ABIv2 modules are given the strings and lists that the host passes to them
+func (w *WasmInstance) Realloc(align, size int32) (int32, error) {
+	result, err := w.Call(canonicalRealloc, int32(0), int32(0), align, size)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to Call cabi_realloc")
+	}
+
+	pointer, ok := result.(int32)
+	if !ok {
+		return 0, fmt.Errorf("cabi_realloc returned %T, expected int32", result)
+	}
+
+	return pointer, nil
+}
+
+// LowerBytes copies data into memory allocated with Realloc, returning the pointer to it. Allocating can grow the
+// memory, so anything borrowed from it beforehand must no longer be used.
+func (w *WasmInstance) LowerBytes(data []byte) (int32, error) {
+	pointer, err := w.Realloc(1, int32(len(data)))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to Realloc")
+	}
+
+	if err := w.WriteMemoryAtLocation(pointer, data); err != nil {
+		return 0, errors.Wrap(err, "failed to WriteMemoryAtLocation")
+	}
+
+	return pointer, nil
+}
+
+// RunCanonical calls the run export of an ABIv2 module with input, and sends the output or error that it returns
+// as the instance's execution result, just as ABIv1 modules do by calling return_result or return_error. The error
+// is only returned if the call itself fails.
+func (w *WasmInstance) RunCanonical(input []byte) error {
+	inPointer, err := w.LowerBytes(input)
+	if err != nil {
+		w.failed = true
+		return errors.Wrap(err, "failed to LowerBytes")
+	}
+
+	result, err := w.Call(canonicalRun, inPointer, int32(len(input)))
+	if err != nil {
+		return err
+	}
+
+	retPointer, ok := result.(int32)
+	if !ok {
+		return fmt.Errorf("run returned %T, expected int32", result)
+	}
+
+	output, runErr, err := w.liftRunResult(retPointer)
+	if err != nil {
+		w.failed = true
+		return errors.Wrap(err, "failed to liftRunResult")
+	}
+
+	// the module frees what it allocated for the result once it has been read
+	if _, err := w.Call(canonicalPostRun, retPointer); err != nil && !errors.Is(err, ErrExportNotFound) {
+		return errors.Wrap(err, "failed to Call cabi_post_run")
+	}
+
+	w.SendExecutionResult(output, runErr)
+
+	return nil
+}
+
+// the layout of the result<list<u8>, run-error> returned by run: a discriminant, followed by either the list that
+// the module returned or the code and message of the error that it returned
+const (
+	runResultSize          = 16
+	runResultPayload       = 4
+	runErrorMessagePayload = 8
+)
+
+// liftRunResult reads the result returned by run from its return area, as a copy of the output or a RunErr
+func (w *WasmInstance) liftRunResult(retPointer int32) ([]byte, error, error) {
+	area, err := w.BorrowMemory(retPointer, runResultSize)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to BorrowMemory")
+	}
+
+	switch area[0] {
+	case 0:
+		output, err := w.readSlice(area[runResultPayload:])
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to read the output")
+		}
+
+		// an empty output is still a result, which SendExecutionResult only sends if it isn't nil
+		if output == nil {
+			output = []byte{}
+		}
+
+		return output, nil, nil
+	case 1:
+		code := binary.LittleEndian.Uint16(area[runResultPayload:])
+
+		message, err := w.readSlice(area[runErrorMessagePayload:])
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to read the error message")
+		}
+
+		return nil, scheduler.RunErr{Code: int(code), Message: string(message)}, nil
+	}
+
+	return nil, nil, fmt.Errorf("invalid result discriminant %d", area[0])
+}
+
+// readSlice copies the string or list whose pointer and length are encoded at the start of encoded
+func (w *WasmInstance) readSlice(encoded []byte) ([]byte, error) {
+	pointer := int32(binary.LittleEndian.Uint32(encoded))
+	length := int32(binary.LittleEndian.Uint32(encoded[4:]))
+
+	return w.ReadMemory(pointer, length)
+}
@@ -0,0 +1,157 @@
+// Package compilecache stores the precompiled artifacts produced by Wasm runtimes on disk, so that a module
+// only needs to be compiled once per runtime version rather than every time the process starts.
+package compilecache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrMiss           = errors.New("module is not in the compile cache")
+	ErrCorrupt        = errors.New("cached artifact failed its integrity check")
+	ErrUnknownVersion = errors.New("the version of the runtime that the binary was built with is unknown")
+)
+
+// artifacts are stored with a header used to check their integrity before they are handed to a runtime
+var magic = []byte("satcwasm")
+
+const artifactExt = ".cwasm"
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// Cache is a content-addressed directory of precompiled modules
+type Cache struct {
+	dir string
+}
+
+// New creates a Cache that stores artifacts in dir, creating it if needed
+func New(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to MkdirAll")
+	}
+
+	c := &Cache{
+		dir: dir,
+	}
+
+	return c, nil
+}
+
+// Key identifies the artifact of a module compiled by a particular runtime. The runtime string
+// should include the runtime's version and any settings that affect the generated code.
+func Key(module []byte, runtime string) string {
+	sum := sha256.Sum256(module)
+
+	return filepath.Join(unsafeChars.ReplaceAllString(runtime, "_"), hex.EncodeToString(sum[:])+artifactExt)
+}
+
+// Load returns the artifact stored for key, or ErrMiss if there isn't one. Artifacts that fail
+// their integrity check are removed from the cache and ErrCorrupt is returned.
+func (c *Cache) Load(key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(c.dir, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrMiss
+		}
+
+		return nil, errors.Wrap(err, "failed to ReadFile")
+	}
+
+	artifact, err := verify(data)
+	if err != nil {
+		os.Remove(filepath.Join(c.dir, key))
+
+		return nil, err
+	}
+
+	return artifact, nil
+}
+
+// Store saves an artifact for key. The artifact is written to a temporary file and renamed into
+// place so that concurrent readers never observe a partially written artifact.
+func (c *Cache) Store(key string, artifact []byte) error {
+	path := filepath.Join(c.dir, key)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "failed to MkdirAll")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to CreateTemp")
+	}
+
+	defer os.Remove(tmp.Name())
+
+	sum := sha256.Sum256(artifact)
+
+	for _, b := range [][]byte{magic, sum[:], artifact} {
+		if _, err := tmp.Write(b); err != nil {
+			tmp.Close()
+			return errors.Wrap(err, "failed to Write")
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to Close")
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "failed to Rename")
+	}
+
+	return nil
+}
+
+// verify checks an artifact's header, returning the artifact itself if it is intact
+func verify(data []byte) ([]byte, error) {
+	headerLen := len(magic) + sha256.Size
+
+	if len(data) < headerLen || !bytes.Equal(data[:len(magic)], magic) {
+		return nil, errors.Wrap(ErrCorrupt, "invalid header")
+	}
+
+	artifact := data[headerLen:]
+	sum := sha256.Sum256(artifact)
+
+	if !bytes.Equal(sum[:], data[len(magic):headerLen]) {
+		return nil, errors.Wrap(ErrCorrupt, "checksum mismatch")
+	}
+
+	return artifact, nil
+}
+
+// ModuleVersion returns the version of the Go module at path that the binary was built with, which
+// is used to make sure that artifacts are never shared between different versions of a runtime. It
+// returns ErrUnknownVersion if the binary has no build info, or the module's version isn't recorded
+// in it (such as a replacement by a local directory), in which case the cache must not be used.
+func ModuleVersion(path string) (string, error) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", ErrUnknownVersion
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+
+			if dep.Version == "" || dep.Version == "(devel)" {
+				break
+			}
+
+			return dep.Version, nil
+		}
+	}
+
+	return "", errors.Wrap(ErrUnknownVersion, path)
+}
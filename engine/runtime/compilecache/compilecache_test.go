@@ -0,0 +1,93 @@
+package compilecache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestCache(t *testing.T) {
+	cache, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to New"))
+	}
+
+	key := Key([]byte("module"), "runtime v1.0.0")
+
+	if _, err := cache.Load(key); !errors.Is(err, ErrMiss) {
+		t.Errorf("expected ErrMiss, got %v", err)
+	}
+
+	if err := cache.Store(key, []byte("artifact")); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Store"))
+	}
+
+	artifact, err := cache.Load(key)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Load"))
+	}
+
+	if string(artifact) != "artifact" {
+		t.Errorf("expected artifact, got %q", artifact)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	key := Key([]byte("module"), "runtime v1.0.0")
+
+	if key == Key([]byte("other module"), "runtime v1.0.0") {
+		t.Error("expected different modules to have different keys")
+	}
+
+	if key == Key([]byte("module"), "runtime v1.0.1") {
+		t.Error("expected different runtimes to have different keys")
+	}
+
+	if filepath.Dir(key) != "runtime_v1.0.0" {
+		t.Errorf("expected runtime directory to be sanitized, got %s", filepath.Dir(key))
+	}
+}
+
+func TestCacheCorrupt(t *testing.T) {
+	dir := t.TempDir()
+
+	cache, err := New(dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to New"))
+	}
+
+	key := Key([]byte("module"), "runtime")
+
+	if err := cache.Store(key, []byte("artifact")); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Store"))
+	}
+
+	path := filepath.Join(dir, key)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+	}
+
+	data[len(data)-1] ^= 0xff
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile"))
+	}
+
+	if _, err := cache.Load(key); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected corrupt artifact to be removed")
+	}
+}
+
+func TestModuleVersionUnknown(t *testing.T) {
+	if _, err := ModuleVersion("example.com/not-a-dependency"); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("expected ErrUnknownVersion, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package runtime
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/wasmbinary"
+)
+
+// Config holds the per-module settings used when building and running Wasm instances
+type Config struct {
+	// Timeout is the wall-clock budget for a single invocation, zero means unbounded
+	Timeout time.Duration `yaml:"timeout" json:"timeout"`
+	// Fuel is the instruction budget for a single invocation (on runtimes that support metering), zero means unbounded
+	Fuel uint64 `yaml:"fuel" json:"fuel"`
+	// Limits bounds the resources that the module's instances can use
+	Limits Limits `yaml:"limits" json:"limits"`
+	// Pool configures how the module's instances are reused between invocations
+	Pool Pool `yaml:"pool" json:"pool"`
+	// WASI configures the system interface given to the module
+	WASI WASI `yaml:"wasi" json:"wasi"`
+	// Output configures how the module's stdout and stderr are captured and logged
+	Output Output `yaml:"output" json:"output"`
+	// Preinit runs the module's initialization once and builds its instances from a snapshot of the memory and globals
+	// that it left, rather than having every instance initialize itself
+	Preinit bool `yaml:"preinit" json:"preinit"`
+	// Deterministic makes the module's invocations repeatable, and is nil unless the module runs deterministically
+	Deterministic *Deterministic `yaml:"deterministic" json:"deterministic"`
+	// DebugTraps returns the details of a trap, including the guest's backtrace, in the error response
+	DebugTraps bool `yaml:"debugTraps" json:"debugTraps"`
+	// CacheDir is the directory where compiled modules are cached, empty disables the cache
+	CacheDir string `yaml:"-" json:"-"`
+}
+
+// Prepare rewrites a module's binary as needed by the configuration before it is compiled
+func (c Config) Prepare(module []byte) ([]byte, error) {
+	prepared, err := c.Limits.Apply(module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to Limits.Apply")
+	}
+
+	if c.Pool.Mode == PoolSnapshot {
+		// expose the module's globals so that they can be captured alongside its memory
+		prepared, err = wasmbinary.ExportGlobals(prepared, snapshotGlobalPrefix)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to ExportGlobals")
+		}
+	}
+
+	// the flag added to signal limits isn't one of the module's own globals, so it isn't exported to be snapshotted.
+	// Builders whose runtime enforces a limit itself (and reports hitting it as a LimitReporter) leave it out of the
+	// config that they prepare with, so that the module is only rewritten for the limits their runtime can't enforce.
+	if prepared, err = c.Limits.signal(prepared); err != nil {
+		return nil, errors.Wrap(err, "failed to Limits.signal")
+	}
+
+	return prepared, nil
+}
+
+// limitsHitExport is the function added to modules by Limits.signal, which reports the limits they were refused by
+const limitsHitExport = "__sat_limits_hit"
+
+// Limits bounds the resources used by a module's instances, zero values mean unlimited
+type Limits struct {
+	// MemoryPages is the maximum size of each instance's linear memory, in 64KiB pages
+	MemoryPages uint32 `yaml:"memoryPages" json:"memoryPages"`
+	// TableElements is the maximum number of elements in each of an instance's tables
+	TableElements uint32 `yaml:"tableElements" json:"tableElements"`
+	// Instances is the maximum number of instances of the module that can exist at once. With a Timeout, an invocation
+	// that waits longer than it for one of them to be free fails with ErrInstanceLimit.
+	Instances int `yaml:"instances" json:"instances"`
+}
+
+// PoolMode determines what happens to an instance after it has been used
+type PoolMode string
+
+const (
+	// PoolFresh destroys each instance after a single invocation, giving every invocation a clean slate
+	PoolFresh PoolMode = "fresh"
+	// PoolSnapshot reuses instances, restoring their memory and globals to a pristine snapshot after each invocation
+	PoolSnapshot PoolMode = "snapshot"
+	// PoolReuse reuses instances as they are, replacing them only after MaxUses invocations or a trap
+	PoolReuse PoolMode = "reuse"
+)
+
+// Pool configures how a module's instances are reused between invocations
+type Pool struct {
+	// Mode selects how instances are recycled, and defaults to PoolFresh
+	Mode PoolMode `yaml:"mode" json:"mode"`
+	// MaxUses is the number of invocations an instance serves before being replaced, zero means unlimited
+	MaxUses int `yaml:"maxUses" json:"maxUses"`
+	// Size is the most instances that can be idle at once, which is the number of workers that use the module (as
+	// each adds an instance) when the instances aren't limited. It defaults to defaultPoolSize, and sat sets it from
+	// its scheduler's maximum number of workers.
+	Size int `yaml:"-" json:"-"`
+}
+
+// defaultPoolSize is the size of the pool when neither its size nor the number of instances is set
+const defaultPoolSize = 64
+
+// Validate returns an error if the pool mode is unknown
+func (p Pool) Validate() error {
+	if p.Size < 0 {
+		return fmt.Errorf("pool size can't be negative, got %d", p.Size)
+	}
+
+	switch p.Mode {
+	case "", PoolFresh, PoolSnapshot, PoolReuse:
+		return nil
+	}
+
+	return fmt.Errorf("unknown instance pool mode %q", p.Mode)
+}
+
+// poolSize returns the most instances that can be idle at once
+func (c Config) poolSize() int {
+	switch {
+	case c.Limits.Instances > 0:
+		return c.Limits.Instances
+	case c.Pool.Size > 0:
+		return c.Pool.Size
+	}
+
+	return defaultPoolSize
+}
+
+// reuses returns true if instances are returned to the pool after being used
+func (p Pool) reuses() bool {
+	return p.Mode == PoolSnapshot || p.Mode == PoolReuse
+}
+
+// Apply rewrites a module's memory and table declarations so that the guest is unable to grow beyond the limits
+func (l Limits) Apply(module []byte) ([]byte, error) {
+	limited, err := wasmbinary.ApplyLimits(module, l.MemoryPages, l.TableElements)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ApplyLimits")
+	}
+
+	return limited, nil
+}
+
+// signal rewrites a module so that WasmInstance.LimitHit can tell when it is refused growth by the limits. It is the
+// fallback for runtimes without a limiter of their own (wasmtime-go v5 has no Store.Limiter, and neither wasmer-go nor
+// WasmEdge expose one), which refuse growth beyond the maximum that Apply declares without saying why. A module whose
+// code can't be decoded is refused, rather than running with limits that it would fail on without reporting them.
+func (l Limits) signal(module []byte) ([]byte, error) {
+	signalled, err := wasmbinary.SignalLimits(module, l.MemoryPages, l.TableElements, limitsHitExport)
+	if errors.Is(err, wasmbinary.ErrUnknownInstruction) {
+		return nil, errors.Wrap(err, "failed to SignalLimits, so the limits hit by the module couldn't be reported")
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to SignalLimits")
+	}
+
+	return signalled, nil
+}
@@ -0,0 +1,30 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/wasmbinary"
+)
+
+func TestPrepareLimitsUnknownInstruction(t *testing.T) {
+	// a module with a memory and a function whose body uses an instruction that can't be decoded
+	module := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+		0x03, 0x02, 0x01, 0x00,
+		0x05, 0x03, 0x01, 0x00, 0x01,
+		0x0a, 0x05, 0x01, 0x03, 0x00, 0xff, 0x0b,
+	}
+
+	// the limits hit by the module couldn't be reported, so it is refused rather than limited without them
+	config := Config{Limits: Limits{MemoryPages: 16}}
+	if _, err := config.Prepare(module); !errors.Is(err, wasmbinary.ErrUnknownInstruction) {
+		t.Errorf("expected ErrUnknownInstruction, got %v", err)
+	}
+
+	if _, err := (Config{}).Prepare(module); err != nil {
+		t.Errorf("expected a module without limits to be prepared, got %v", err)
+	}
+}
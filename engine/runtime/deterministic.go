@@ -0,0 +1,90 @@
+package runtime
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DeterministicClock determines the time seen by a module that runs deterministically
+type DeterministicClock string
+
+const (
+	// ClockVirtual starts at the same time for every invocation, and advances each time that it is read
+	ClockVirtual DeterministicClock = "virtual"
+	// ClockFixed reads the same time for the whole of every invocation
+	ClockFixed DeterministicClock = "fixed"
+)
+
+// Deterministic makes a module's invocations repeatable, so that invoking it with the same request gives the same
+// result every time. Its clock starts at the same time for every invocation, its random numbers are seeded from the
+// request's ID, and the host functions whose results could change between invocations fail the invocation unless
+// they are stubbed.
+type Deterministic struct {
+	// Clock selects how the module's clock behaves, and defaults to ClockVirtual
+	Clock DeterministicClock `yaml:"clock" json:"clock"`
+	// Time is the time that the clock starts at, and defaults to the start of 2022
+	Time time.Time `yaml:"time" json:"time"`
+	// Stubs answer calls to the nondeterministic host functions, mapping the name of each to its results by URL
+	// (fetch_url), endpoint (graphql_query) or key (cache_get)
+	Stubs map[string]map[string]string `yaml:"stubs" json:"stubs"`
+}
+
+// Validate returns an error if the clock is unknown, or if the module is given a source or pool that can't be
+// repeated between invocations
+func (d Deterministic) Validate(wasi WASI, pool Pool) error {
+	switch d.Clock {
+	case "", ClockVirtual, ClockFixed:
+	default:
+		return fmt.Errorf("unknown deterministic clock %q", d.Clock)
+	}
+
+	if wasi.HostClock() || wasi.HostRandom() {
+		return errors.New("a deterministic module can't be given the host's clock or random source")
+	}
+
+	// an instance that is reused as it is carries the state of its previous invocations into the next
+	if pool.Mode == PoolReuse {
+		return errors.New("a deterministic module's instances can't be reused as they are, use the fresh or snapshot pool")
+	}
+
+	return nil
+}
+
+// Stub returns the result that the named host function is stubbed with for key
+func (d Deterministic) Stub(name, key string) ([]byte, bool) {
+	result, ok := d.Stubs[name][key]
+	if !ok {
+		return nil, false
+	}
+
+	return []byte(result), true
+}
+
+// start returns the time that the clock starts at
+func (d Deterministic) start() time.Time {
+	if d.Time.IsZero() {
+		return fakeEpoch
+	}
+
+	return d.Time
+}
+
+// seed returns the random seed for an invocation identified by id
+func (d Deterministic) seed(id string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(id))
+
+	return int64(hash.Sum64())
+}
+
+// ResetWASI restarts the instance's fake clock and random sources for a deterministic invocation identified by id
+// (such as its request's ID), so that it sees the same time and random numbers as any other invocation with that id
+func (w *WasmInstance) ResetWASI(config Deterministic, id string) {
+	host := w.runtime.HostContext()
+
+	host.Clock().Reset(config.start(), config.Clock == ClockFixed)
+	host.Random().Seed(config.seed(id))
+}
@@ -1,7 +1,11 @@
 package runtime
 
 import (
+	"fmt"
+	"math"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -18,111 +22,491 @@ import (
  In order to accomplish this, engine creates 'WasmEnvironments' which represent a single instantiated module. Each environment contains a pool of
  'wasmInstances', which are provided for use on a rotating basis. Instances can be added and removed from the pool as needed by the `wasmRunner`.
 
- When a WASM function calls one of the FFI API functions, it includes the `ident` value that was provided at the beginning
- of job execution, which allows engine to look up the wasmInstance from the global `instanceMapper` and send the result on
- the appropriate result channel. This is needed due to the way Go makes functions available to the FFI.
+ When a WASM function calls one of the FFI API functions, the runtime resolves the calling wasmInstance from its store through
+ the instance's HostContext, which allows engine to send the result on the appropriate result channel. The `ident` value
+ that is provided at the beginning of job execution is still passed back by the module, and must be that of the calling instance.
 */
 
 // the internal Logger used by the Wasm runtime system
 var internalLogger = vlog.Default()
 
+// the delays between attempts to replace an instance that failed to build, which double up to the maximum
+var (
+	minReplaceBackoff = 100 * time.Millisecond
+	maxReplaceBackoff = 30 * time.Second
+)
+
 // WasmEnvironment is an environment in which Wasm instances run
 type WasmEnvironment struct {
 	UUID    string
 	builder RuntimeBuilder
+	config  Config
 
 	availableInstances chan *WasmInstance
 
+	// slots bounds the number of instances that can exist at once, and is nil when unlimited
+	slots chan struct{}
+	// sharing counts the callers of AddInstance that were turned away by the instance
+	// limit, and are therefore sharing the instances that already exist
+	sharing int
+	// prewarmed counts the instances built by PreWarm that haven't yet been adopted by a caller of AddInstance
+	prewarmed int
+
+	// health tracks the instances that exist and those that are failing to be built
+	health *poolHealth
+
+	// generation is incremented each time the builder is swapped, and instances of older generations are replaced
+	generation atomic.Int64
+
+	// lastIdent is the ident most recently given to an instance that isn't registered with the instance mapper
+	lastIdent atomic.Uint32
+
 	lock sync.RWMutex
 }
 
 // NewEnvironment creates a new environment with a pool of available wasmInstances
-func NewEnvironment(builder RuntimeBuilder) *WasmEnvironment {
+func NewEnvironment(builder RuntimeBuilder, config Config) *WasmEnvironment {
 	e := &WasmEnvironment{
 		UUID:               uuid.New().String(),
 		builder:            builder,
-		availableInstances: make(chan *WasmInstance, 64),
+		config:             config,
+		availableInstances: make(chan *WasmInstance, config.poolSize()),
+		health:             newPoolHealth(),
 		lock:               sync.RWMutex{},
 	}
 
+	if config.Limits.Instances > 0 {
+		e.slots = make(chan struct{}, config.Limits.Instances)
+	}
+
 	return e
 }
 
-// AddInstance adds a new Wasm instance to the environment's pool
+// AddInstance adds a new Wasm instance to the environment's pool. If the environment is
+// at its instance limit, the caller instead shares the instances that already exist.
 func (w *WasmEnvironment) AddInstance() error {
 	w.lock.Lock()
-	defer w.lock.Unlock()
+	if w.prewarmed > 0 {
+		w.prewarmed--
+		w.lock.Unlock()
+
+		return nil
+	}
+	w.lock.Unlock()
+
+	if w.slots != nil {
+		select {
+		case w.slots <- struct{}{}:
+		default:
+			w.lock.Lock()
+			w.sharing++
+			w.lock.Unlock()
+
+			return nil
+		}
+	}
+
+	err := w.addInstance()
+	w.health.addResult(err)
+
+	return err
+}
+
+// PreWarm builds instances ahead of time, which callers of AddInstance then adopt rather than building their own.
+// Instances beyond the environment's instance limit are not built.
+func (w *WasmEnvironment) PreWarm(count int) error {
+	for i := 0; i < count; i++ {
+		if w.slots != nil {
+			select {
+			case w.slots <- struct{}{}:
+			default:
+				return nil
+			}
+		}
 
-	inst, err := w.builder.New()
+		err := w.addInstance()
+		w.health.addResult(err)
+
+		if err != nil {
+			return errors.Wrap(err, "failed to addInstance")
+		}
+
+		w.lock.Lock()
+		w.prewarmed++
+		w.lock.Unlock()
+	}
+
+	return nil
+}
+
+// identify gives an instance its ident. Instances whose host functions are all given their caller only need an ident
+// to check the one passed back by the guest against, so they are numbered by the environment, and only those that may
+// be looked up with InstanceForIdentifier are given a random ident that is registered with the instance mapper.
+func (w *WasmEnvironment) identify(inst *WasmInstance) error {
+	if lookup, ok := inst.builder.(IdentifierLookup); ok && lookup.UsesIdentifierLookup() {
+		ident, err := setupNewIdentifier(inst)
+		if err != nil {
+			return errors.Wrap(err, "failed to setupNewIdentifier")
+		}
+
+		inst.ident = ident
+		inst.registered = true
+
+		return nil
+	}
+
+	// idents are positive, so that no instance has the zero ident of an unbound instance
+	for inst.ident == 0 {
+		inst.ident = int32(w.lastIdent.Add(1) & math.MaxInt32)
+	}
+
+	return nil
+}
+
+// Health returns the health of the environment's pool of instances
+func (w *WasmEnvironment) Health() PoolHealth {
+	return w.health.health()
+}
+
+// addInstance builds an instance and adds it to the pool, once a slot has been taken for it. The instance is built
+// outside of the lock, so a slow build doesn't hold up Swap or the other instances, and it is marked with the builder's
+// generation so that one built while the builder is swapped is retired when it's taken.
+func (w *WasmEnvironment) addInstance() error {
+	w.lock.RLock()
+	builder := w.builder
+	generation := w.generation.Load()
+	w.lock.RUnlock()
+
+	inst, err := builder.New()
 	if err != nil {
+		w.releaseSlot()
 		return errors.Wrap(err, "failed to builder.New")
 	}
 
-	instance := &WasmInstance{
-		runtime:    inst,
-		resultChan: make(chan []byte, 1),
-		errChan:    make(chan error, 1),
+	// the runtime's store was linked to the instance before it was created, so that _start's host calls were refused
+	// as coming from an instance that isn't in use
+	instance := inst.HostContext().Instance()
+	if instance == nil {
+		instance = &WasmInstance{}
+	}
+
+	instance.runtime = inst
+	instance.resultChan = make(chan []byte, 1)
+	instance.errChan = make(chan error, 1)
+	instance.builder = builder
+	instance.generation = generation
+
+	// the instance keeps its ident for as long as it exists
+	if err := w.identify(instance); err != nil {
+		inst.Close()
+		w.releaseSlot()
+
+		return errors.Wrap(err, "failed to identify")
 	}
 
+	// output written by _start isn't part of any invocation, so it is discarded
+	instance.ReadOutput()
+
+	if w.config.Pool.Mode == PoolSnapshot {
+		pristine, err := takeSnapshot(inst)
+		if err != nil {
+			forgetIdentifier(instance)
+			inst.Close()
+			w.releaseSlot()
+
+			return errors.Wrap(err, "failed to takeSnapshot")
+		}
+
+		instance.pristine = pristine
+	}
+
+	w.health.instanceAdded()
 	w.availableInstances <- instance
 
 	return nil
 }
 
+// Swap replaces the builder that the environment's instances are built by, such as with a new version of the module.
+// An instance is built with the new builder beforehand, so that a builder that can't build instances leaves the
+// environment as it was. Idle instances of the old builder are then replaced, and those in use are replaced once
+// they have been released.
+func (w *WasmEnvironment) Swap(builder RuntimeBuilder) error {
+	trial, err := builder.New()
+	if err != nil {
+		return errors.Wrap(err, "failed to builder.New")
+	}
+
+	trial.Close()
+
+	w.lock.Lock()
+	w.builder = builder
+	w.generation.Add(1)
+	w.lock.Unlock()
+
+	// the idle instances are taken from the pool one at a time, so count them up front
+	for i := len(w.availableInstances); i > 0; i-- {
+		select {
+		case inst := <-w.availableInstances:
+			if w.isStale(inst) {
+				w.retireInstance(inst)
+			} else {
+				w.availableInstances <- inst
+			}
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// isStale returns true if the instance was built by a builder that has since been swapped
+func (w *WasmEnvironment) isStale(inst *WasmInstance) bool {
+	return inst.generation != w.generation.Load()
+}
+
+// retireInstance destroys a stale instance that has been taken out of rotation, and replaces it with an instance of
+// the current builder
+func (w *WasmEnvironment) retireInstance(inst *WasmInstance) {
+	w.destroyInstance(inst)
+
+	go w.replaceInstance()
+}
+
 // RemoveInstance removes one of the active instances from rotation and destroys it
 func (w *WasmEnvironment) RemoveInstance() error {
+	// callers that were sharing instances have nothing to destroy
+	w.lock.Lock()
+	if w.sharing > 0 {
+		w.sharing--
+		w.lock.Unlock()
+
+		return nil
+	}
+	w.lock.Unlock()
+
+	// an instance that is failing to be replaced doesn't exist, so giving up on it is enough
+	if w.health.abandonReplacement() {
+		return nil
+	}
+
 	// grab an instance from the available queue
 	// and we won't give it back becuase it's being destroyed
-	inst := <-w.availableInstances
+	w.destroyInstance(<-w.availableInstances)
+
+	return nil
+}
 
-	// 4.
+// destroyInstance destroys an instance that has been taken out of rotation
+func (w *WasmEnvironment) destroyInstance(inst *WasmInstance) {
+	forgetIdentifier(inst)
 	inst.runtime.Close()
 	inst.runtime = nil
 	inst.ctx = nil
 	inst.resultChan = nil
 	inst.errChan = nil
-	inst = nil
 
-	return nil
+	w.health.instanceRemoved()
+	w.releaseSlot()
 }
 
-// UseInstance provides an instance from the environment's pool to be used by a callback function
+// UseInstance provides an instance from the environment's pool to be used by a callback function. It returns
+// ErrPoolUnavailable if the pool is empty and can't be refilled, and ErrInstanceLimit if the environment's instances
+// are limited and none of them was free within the timeout.
 func (w *WasmEnvironment) UseInstance(ctx *scheduler.Ctx, instFunc func(*WasmInstance, int32)) error {
 	// grab an instance from the available queue and then
 	// return it to the environment when finished
-	inst := <-w.availableInstances
+	inst, err := w.takeInstance()
+	if err != nil {
+		return err
+	}
+
+	if !w.config.Pool.reuses() {
+		// the instance will be destroyed after use, so start building its replacement right away
+		go w.replaceInstance()
+	}
+
+	// setup the instance's temporary state
+	inst.ctx = ctx
+
+	if err := inst.runtime.SetBudget(w.config.Timeout, w.config.Fuel); err != nil {
+		inst.failed = true
+		w.releaseInstance(inst)
+
+		return errors.Wrap(err, "failed to SetBudget")
+	}
+
+	if w.config.Timeout == 0 {
+		// do the actual call into the Wasm module
+		instFunc(inst, inst.ident)
+
+		w.releaseInstance(inst)
+
+		return nil
+	}
+
+	done := make(chan struct{})
 
 	go func() {
-		if err := w.AddInstance(); err != nil {
-			panic(err)
-		}
+		instFunc(inst, inst.ident)
+		close(done)
 	}()
 
-	defer func() {
-		inst.runtime.Close()
-		inst = nil
-	}()
+	timer := time.NewTimer(w.config.Timeout)
+	defer timer.Stop()
 
-	// generate a random identifier as a reference to the instance in use to
-	// easily allow the Wasm module to reference itself when calling back over the FFI
-	ident, err := setupNewIdentifier(inst)
-	if err != nil {
-		return errors.Wrap(err, "failed to setupNewIdentifier")
+	select {
+	case <-done:
+		w.releaseInstance(inst)
+	case <-timer.C:
+		// the runtimes interrupt the guest on their own, but not while it is blocked in a host function. The call is
+		// still waited for, as until it returns it writes to the instance and to the caller's state (and recording).
+		<-done
+
+		inst.failed = true
+		w.releaseInstance(inst)
+
+		return ErrExecutionTimeout
 	}
 
-	// setup the instance's temporary state
-	inst.ctx = ctx
+	return nil
+}
+
+// takeInstance takes an instance from the pool, waiting for one to be available unless the pool is unavailable. At the
+// instance limit, the wait is no longer than an invocation is allowed to take.
+func (w *WasmEnvironment) takeInstance() (*WasmInstance, error) {
+	var limited <-chan time.Time
+
+	for {
+		var inst *WasmInstance
 
-	// do the actual call into the Wasm module
-	instFunc(inst, ident)
+		select {
+		case inst = <-w.availableInstances:
+		default:
+			if limited == nil && w.slots != nil && w.config.Timeout > 0 {
+				timer := time.NewTimer(w.config.Timeout)
+				defer timer.Stop()
 
+				limited = timer.C
+			}
+
+			select {
+			case inst = <-w.availableInstances:
+			case <-w.health.unavailableChan():
+				return nil, ErrPoolUnavailable
+			case <-limited:
+				return nil, ErrInstanceLimit
+			}
+		}
+
+		// an instance built while the builder was being swapped can still be of the old version
+		if !w.isStale(inst) {
+			return inst, nil
+		}
+
+		w.retireInstance(inst)
+	}
+}
+
+// releaseInstance clears an instance's temporary state and returns it to the pool if it can be reused,
+// otherwise it is destroyed
+func (w *WasmEnvironment) releaseInstance(inst *WasmInstance) {
 	// clear the instance's temporary state
 	inst.ctx = nil
+	inst.uses++
 
-	// remove the instance from global state
-	removeIdentifier(ident)
+	if !w.resetInstance(inst) {
+		w.discardInstance(inst)
+		return
+	}
 
-	return nil
+	w.availableInstances <- inst
+}
+
+// resetInstance prepares an instance to be reused, and returns false if it should be replaced instead
+func (w *WasmEnvironment) resetInstance(inst *WasmInstance) bool {
+	if !w.config.Pool.reuses() || inst.failed || w.isStale(inst) {
+		return false
+	}
+
+	if w.config.Pool.MaxUses > 0 && inst.uses >= w.config.Pool.MaxUses {
+		return false
+	}
+
+	inst.clearResults()
+
+	if inst.pristine != nil {
+		if err := inst.pristine.restore(inst.runtime); err != nil {
+			internalLogger.Debug("replacing instance that could not be restored:", err.Error())
+			return false
+		}
+	}
+
+	return true
+}
+
+// discardInstance destroys an instance, and replaces it if the environment reuses instances
+// (otherwise its replacement was started when it was taken from the pool)
+func (w *WasmEnvironment) discardInstance(inst *WasmInstance) {
+	forgetIdentifier(inst)
+	inst.runtime.Close()
+
+	w.health.instanceRemoved()
+	w.releaseSlot()
+
+	if w.config.Pool.reuses() {
+		go w.replaceInstance()
+	}
+}
+
+// replaceInstance adds an instance to the pool in place of one that has been destroyed. Instances can fail to build
+// for transient reasons, such as running out of memory, so failures are retried with backoff while the pool is
+// degraded, until an instance is built or the scheduler removes the instance that was being replaced.
+func (w *WasmEnvironment) replaceInstance() {
+	backoff := minReplaceBackoff
+
+	for attempt := 1; ; attempt++ {
+		// if the environment is at its instance limit, this waits for the instance being replaced to be released
+		w.acquireSlot()
+
+		err := w.addInstance()
+		if err == nil {
+			if attempt > 1 && w.health.retrySucceeded() {
+				// the scheduler gave up on this instance while it was being built
+				w.destroyInstance(<-w.availableInstances)
+			}
+
+			return
+		}
+
+		w.health.replaceFailed(err, attempt == 1)
+
+		internalLogger.Warn(fmt.Sprintf("failed to replace instance (attempt %d), retrying in %s: %s", attempt, backoff, err.Error()))
+
+		time.Sleep(backoff)
+
+		if w.health.abandonRetry() {
+			return
+		}
+
+		if backoff *= 2; backoff > maxReplaceBackoff {
+			backoff = maxReplaceBackoff
+		}
+	}
+}
+
+// acquireSlot waits for the environment to have room for another instance
+func (w *WasmEnvironment) acquireSlot() {
+	if w.slots != nil {
+		w.slots <- struct{}{}
+	}
+}
+
+// releaseSlot makes room for another instance
+func (w *WasmEnvironment) releaseSlot() {
+	if w.slots != nil {
+		<-w.slots
+	}
 }
 
 // UseInternalLogger sets the logger to be used log internal wasm runtime messages
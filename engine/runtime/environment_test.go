@@ -0,0 +1,44 @@
+package runtime
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// BenchmarkIdentify measures giving instances their idents, which the environment numbers itself unless the builder's
+// host functions may look instances up, when a random ident is registered with the instance mapper instead
+func BenchmarkIdentify(b *testing.B) {
+	for _, lookup := range []bool{false, true} {
+		b.Run(fmt.Sprintf("lookup=%t", lookup), func(b *testing.B) {
+			builder := identBuilder{lookup: lookup}
+			env := NewEnvironment(builder, Config{})
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					inst := &WasmInstance{builder: builder}
+
+					if err := env.identify(inst); err != nil {
+						b.Error(errors.Wrap(err, "failed to identify"))
+					}
+
+					forgetIdentifier(inst)
+				}
+			})
+		})
+	}
+}
+
+// identBuilder is a builder that says whether its instances may be looked up by their ident, and builds nothing
+type identBuilder struct {
+	lookup bool
+}
+
+func (i identBuilder) New() (RuntimeInstance, error) {
+	return nil, errors.New("identBuilder does not build instances")
+}
+
+func (i identBuilder) UsesIdentifierLookup() bool {
+	return i.lookup
+}
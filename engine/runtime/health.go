@@ -0,0 +1,211 @@
+package runtime
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPoolUnavailable is returned when an environment has no instances and can't build any
+var ErrPoolUnavailable = errors.New("no instances are available and the pool could not be refilled")
+
+// PoolState is the health of an environment's pool of instances
+type PoolState string
+
+const (
+	// PoolHealthy means that every instance the pool needs has been built
+	PoolHealthy PoolState = "healthy"
+	// PoolDegraded means that some instances failed to build and are being retried, while others keep serving
+	PoolDegraded PoolState = "degraded"
+	// PoolUnavailable means that the pool has no instances and is failing to build any, so invocations fail
+	PoolUnavailable PoolState = "unavailable"
+)
+
+// PoolHealth describes the health of an environment's pool of instances
+type PoolHealth struct {
+	State     PoolState `json:"state"`
+	Instances int       `json:"instances"`
+	// Retrying is the number of instances that failed to build and are being retried with backoff
+	Retrying  int    `json:"retrying"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Ready returns true if the pool can serve invocations
+func (h PoolHealth) Ready() bool {
+	return h.State != PoolUnavailable
+}
+
+// poolHealth tracks the instances that an environment has built, and those it is failing to build
+type poolHealth struct {
+	instances int
+	retrying  int
+	// abandoned counts the retries that should give up, because the instances they replace have since been removed
+	abandoned int
+	// addFailed is set while the last instance added by the scheduler failed to build
+	addFailed bool
+	lastErr   error
+
+	// unavailable is closed while the pool is unavailable, which wakes the callers waiting for an instance
+	unavailable chan struct{}
+	closed      bool
+
+	lock sync.Mutex
+}
+
+func newPoolHealth() *poolHealth {
+	return &poolHealth{unavailable: make(chan struct{})}
+}
+
+// pending returns the number of replacements being retried that haven't been abandoned, and must be called with the
+// lock held
+func (p *poolHealth) pending() int {
+	return p.retrying - p.abandoned
+}
+
+// state returns the state of the pool, and must be called with the lock held
+func (p *poolHealth) state() PoolState {
+	failing := p.pending() > 0 || p.addFailed
+
+	if failing && p.instances == 0 {
+		return PoolUnavailable
+	}
+
+	if failing {
+		return PoolDegraded
+	}
+
+	return PoolHealthy
+}
+
+// update closes or replaces the unavailable channel to match the state of the pool, and must be called with the
+// lock held
+func (p *poolHealth) update() {
+	unavailable := p.state() == PoolUnavailable
+
+	if unavailable && !p.closed {
+		close(p.unavailable)
+		p.closed = true
+	} else if !unavailable && p.closed {
+		p.unavailable = make(chan struct{})
+		p.closed = false
+	}
+}
+
+// instanceAdded records that an instance was built
+func (p *poolHealth) instanceAdded() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.instances++
+	p.update()
+}
+
+// instanceRemoved records that an instance was destroyed
+func (p *poolHealth) instanceRemoved() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.instances--
+	p.update()
+}
+
+// addResult records whether the last instance added by the scheduler could be built
+func (p *poolHealth) addResult(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.addFailed = err != nil
+	if err != nil {
+		p.lastErr = err
+	}
+
+	p.update()
+}
+
+// replaceFailed records that an instance could not be replaced, and that the replacement will be retried
+func (p *poolHealth) replaceFailed(err error, firstAttempt bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if firstAttempt {
+		p.retrying++
+	}
+
+	p.lastErr = err
+	p.update()
+}
+
+// retrySucceeded records that a replacement was built after failing, and returns true if it was abandoned while it
+// was being built, in which case the instance is surplus
+func (p *poolHealth) retrySucceeded() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.retrying--
+
+	abandoned := p.abandoned > 0
+	if abandoned {
+		p.abandoned--
+	}
+
+	p.update()
+
+	return abandoned
+}
+
+// abandonRetry returns true if a retrying replacement should give up, because an instance has since been removed
+func (p *poolHealth) abandonRetry() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.abandoned == 0 {
+		return false
+	}
+
+	p.abandoned--
+	p.retrying--
+	p.update()
+
+	return true
+}
+
+// abandonReplacement gives up on one of the replacements that are being retried, and returns false if there are none
+func (p *poolHealth) abandonReplacement() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.pending() == 0 {
+		return false
+	}
+
+	p.abandoned++
+	p.update()
+
+	return true
+}
+
+// unavailableChan returns a channel that is closed while the pool is unavailable
+func (p *poolHealth) unavailableChan() <-chan struct{} {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.unavailable
+}
+
+// health returns a description of the pool's health
+func (p *poolHealth) health() PoolHealth {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	h := PoolHealth{
+		State:     p.state(),
+		Instances: p.instances,
+		Retrying:  p.pending(),
+	}
+
+	if h.State != PoolHealthy && p.lastErr != nil {
+		h.LastError = p.lastErr.Error()
+	}
+
+	return h
+}
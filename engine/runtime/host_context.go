@@ -0,0 +1,55 @@
+package runtime
+
+// HostContext links a runtime instance to the WasmInstance that wraps it. Runtimes keep it alongside the store
+// (in its data, the environment of its host functions or the context of its calls), so that their host functions
+// can be given the instance that called them without the guest having to identify itself. It also holds the fake
+// clock and random sources that runtimes give the instance in place of the host's.
+type HostContext struct {
+	inst   *WasmInstance
+	clock  *FakeClock
+	random *FakeRandom
+}
+
+// NewHostContext creates a HostContext for a runtime instance, bound to the WasmInstance that the environment wraps
+// the runtime instance in. It is bound before the runtime instance is created, so that host functions called as it
+// initializes (such as from _start) are given their caller rather than having to trust the guest to identify itself.
+func NewHostContext() *HostContext {
+	return &HostContext{inst: &WasmInstance{}, clock: NewFakeClock(), random: NewFakeRandom()}
+}
+
+// Instance returns the WasmInstance that the context is bound to
+func (h *HostContext) Instance() *WasmInstance {
+	if h == nil {
+		return nil
+	}
+
+	return h.inst
+}
+
+// Clock returns the instance's fake clock. A nil context, which belongs to no instance, returns a clock of its own.
+func (h *HostContext) Clock() *FakeClock {
+	if h == nil {
+		return NewFakeClock()
+	}
+
+	return h.clock
+}
+
+// Random returns the instance's fake random source. A nil context, which belongs to no instance, returns a source of
+// its own.
+func (h *HostContext) Random() *FakeRandom {
+	if h == nil {
+		return NewFakeRandom()
+	}
+
+	return h.random
+}
+
+// sourcesRead returns whether the instance has read its fake clock or random source
+func (h *HostContext) sourcesRead() bool {
+	if h == nil {
+		return false
+	}
+
+	return h.clock.wasRead() || h.random.wasRead()
+}
@@ -1,23 +1,202 @@
 package runtime
 
+import (
+	"fmt"
+)
+
 type innerFunc func(args ...interface{}) (interface{}, error)
 
-// HostFn describes a host function callable from within a Runnable module
+// callerFunc is a host function that is given the instance that called it
+type callerFunc func(caller *WasmInstance, args ...interface{}) (interface{}, error)
+
+// ValueType is the Wasm type of a host function's parameter or result
+type ValueType int
+
+const (
+	// I32 values are passed to and from host functions as int32
+	I32 ValueType = iota
+	// I64 values are passed to and from host functions as int64
+	I64
+	// F32 values are passed to and from host functions as float32
+	F32
+	// F64 values are passed to and from host functions as float64
+	F64
+)
+
+// String returns the type's name in the Wasm text format
+func (v ValueType) String() string {
+	switch v {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	}
+
+	return fmt.Sprintf("ValueType(%d)", int(v))
+}
+
+// Zero returns the type's zero value
+func (v ValueType) Zero() interface{} {
+	switch v {
+	case I64:
+		return int64(0)
+	case F32:
+		return float32(0)
+	case F64:
+		return float64(0)
+	}
+
+	return int32(0)
+}
+
+// HostFn describes a host function callable from within a Runnable module. The function is given its arguments as
+// the Go types of Params, and returns a single result directly or several as a []interface{}.
 type HostFn struct {
-	Name     string
-	ArgCount int
-	Returns  bool
-	HostFn   innerFunc
+	// Module is the namespace that modules import the function from, which is "env" if it's empty
+	Module  string
+	Name    string
+	Params  []ValueType
+	Results []ValueType
+	HostFn  innerFunc
+	// CallerFn is called in place of HostFn by runtimes that can resolve the instance calling the function
+	CallerFn callerFunc
+	// SnapshotSafe is set for functions that give nothing back to the module that a snapshot would capture, so that
+	// they can be called as the module is preinitialized
+	SnapshotSafe bool
 }
 
-// NewHostFn creates a new host function
+// NewHostFn creates a new host function that takes argCount i32s, and returns an i32 if returns is true
 func NewHostFn(name string, argCount int, returns bool, fn innerFunc) HostFn {
+	params := make([]ValueType, argCount)
+	for i := range params {
+		params[i] = I32
+	}
+
+	results := []ValueType{}
+	if returns {
+		results = append(results, I32)
+	}
+
+	return NewTypedHostFn(name, params, results, fn)
+}
+
+// NewCallerHostFn creates a new host function like NewHostFn, which is given the instance that called it. Runtimes
+// that can't resolve the caller call it with a nil instance.
+func NewCallerHostFn(name string, argCount int, returns bool, fn callerFunc) HostFn {
+	h := NewHostFn(name, argCount, returns, func(args ...interface{}) (interface{}, error) {
+		return fn(nil, args...)
+	})
+
+	h.CallerFn = fn
+
+	return h
+}
+
+// NeedsIdentifierLookup returns true if any of the host functions isn't given the instance that called it, and so may
+// look it up with InstanceForIdentifier
+func NeedsIdentifierLookup(fns []HostFn) bool {
+	for _, fn := range fns {
+		if fn.CallerFn == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Namespace returns the namespace that modules import the host function from
+func (h HostFn) Namespace() string {
+	if h.Module == "" {
+		return hostModule
+	}
+
+	return h.Module
+}
+
+// HostFnsByNamespace groups host functions by the namespace that they are imported from, for runtimes that
+// register each namespace separately
+func HostFnsByNamespace(fns []HostFn) map[string][]HostFn {
+	namespaces := map[string][]HostFn{}
+
+	for _, fn := range fns {
+		namespaces[fn.Namespace()] = append(namespaces[fn.Namespace()], fn)
+	}
+
+	return namespaces
+}
+
+// Call calls the host function on behalf of the instance bound to host. A host function that is given its caller
+// fails with ErrUnknownCaller if host is nil, rather than being called on behalf of an instance named by the guest.
+func (h HostFn) Call(host *HostContext, args ...interface{}) (interface{}, error) {
+	if h.CallerFn == nil {
+		return h.HostFn(args...)
+	}
+
+	if host.Instance() == nil {
+		return nil, ErrUnknownCaller
+	}
+
+	return h.CallerFn(host.Instance(), args...)
+}
+
+// NewTypedHostFn creates a new host function with the given signature
+func NewTypedHostFn(name string, params []ValueType, results []ValueType, fn innerFunc) HostFn {
 	h := HostFn{
-		Name:     name,
-		ArgCount: argCount,
-		Returns:  returns,
-		HostFn:   fn,
+		Name:    name,
+		Params:  params,
+		Results: results,
+		HostFn:  fn,
 	}
 
 	return h
 }
+
+// ResultValues checks what the host function returned against its signature and returns it as one value per
+// result. A nil result is treated as the zero value of every result, so that functions can bail out early.
+func (h HostFn) ResultValues(result interface{}) ([]interface{}, error) {
+	if result == nil {
+		values := make([]interface{}, len(h.Results))
+		for i, r := range h.Results {
+			values[i] = r.Zero()
+		}
+
+		return values, nil
+	}
+
+	values, ok := result.([]interface{})
+	if !ok {
+		values = []interface{}{result}
+	}
+
+	if len(values) != len(h.Results) {
+		return nil, fmt.Errorf("%s returned %d values, expected %d", h.Name, len(values), len(h.Results))
+	}
+
+	for i, r := range h.Results {
+		if !r.holds(values[i]) {
+			return nil, fmt.Errorf("%s returned %T for result %d, expected %s", h.Name, values[i], i, r)
+		}
+	}
+
+	return values, nil
+}
+
+// holds returns true if value is of the Go type used for the type
+func (v ValueType) holds(value interface{}) bool {
+	switch value.(type) {
+	case int32:
+		return v == I32
+	case int64:
+		return v == I64
+	case float32:
+		return v == F32
+	case float64:
+		return v == F64
+	}
+
+	return false
+}
@@ -1,21 +1,74 @@
 package runtime
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/pkg/errors"
 
 	"github.com/suborbital/e2core/scheduler"
+
+	"github.com/suborbital/sat/engine/wasmbinary"
 )
 
-var ErrExportNotFound = errors.New("the requested export is not found in the module")
+var (
+	ErrExportNotFound      = errors.New("the requested export is not found in the module")
+	ErrExecutionTimeout    = errors.New("execution deadline exceeded")
+	ErrFuelExhausted       = errors.New("execution fuel exhausted")
+	ErrFuelNotSupported    = errors.New("fuel metering is not supported by this runtime")
+	ErrTimeoutNotSupported = errors.New("execution timeouts are not supported by this runtime")
+	ErrMemoryOutOfBounds   = errors.New("memory access out of bounds")
+	ErrNoPrecompile        = errors.New("ahead-of-time compilation is not supported by this runtime")
+)
+
+// the errors of invocations stopped by one of the module's resource limits
+var (
+	ErrMemoryLimit   = LimitError{Limit: "memory"}
+	ErrTableLimit    = LimitError{Limit: "table"}
+	ErrInstanceLimit = LimitError{Limit: "instances"}
+)
+
+// LimitError is the error of an invocation that was stopped by one of the module's resource limits, rather than by
+// something that the module itself got wrong
+type LimitError struct {
+	// Limit names the resource that was limited
+	Limit string
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded", e.Limit)
+}
 
 // WasmInstance is an instance of a Wasm runtime
 type WasmInstance struct {
 	runtime RuntimeInstance
 
+	// ident is passed to the guest, which passes it back to host functions. It is kept for compatibility, since
+	// host functions are given the instance that called them, and is only registered with the instance mapper (so
+	// that it must be unique in the process) when the builder has host functions that may look it up.
+	ident      int32
+	registered bool
+
 	ctx *scheduler.Ctx
 
 	resultChan chan []byte
 	errChan    chan error
+
+	// the number of invocations the instance has served, whether one of them failed,
+	// and the state to restore between them when the environment reuses instances
+	uses     int
+	failed   bool
+	pristine *snapshot
+
+	// builder built the instance, and generation is the environment's generation when it did, which changes each time
+	// the environment's builder is swapped
+	builder    RuntimeBuilder
+	generation int64
+}
+
+// Builder returns the builder that built the instance, which identifies the version of the module that it runs
+func (w *WasmInstance) Builder() RuntimeBuilder {
+	return w.builder
 }
 
 // RuntimeBuilder is a factory-style interface that can build Wasm runtimes
@@ -23,13 +76,42 @@ type RuntimeBuilder interface {
 	New() (RuntimeInstance, error)
 }
 
+// Precompiler is implemented by RuntimeBuilders that can compile their module
+// ahead of time, without creating an instance
+type Precompiler interface {
+	Precompile() error
+}
+
+// IdentifierLookup is implemented by RuntimeBuilders that can tell whether their host functions may look instances up
+// with InstanceForIdentifier, which is only possible for the instances of builders that do
+type IdentifierLookup interface {
+	UsesIdentifierLookup() bool
+}
+
+// ConfigChecker is implemented by RuntimeBuilders that can determine up front
+// whether they are able to honour their configuration
+type ConfigChecker interface {
+	CheckConfig() error
+}
+
+// LimitReporter is implemented by RuntimeInstances whose runtime enforces the memory limit itself, which tells them
+// when the guest was refused growth by it without the module being rewritten to record that
+type LimitReporter interface {
+	LimitHit() error
+}
+
 // RuntimeInstance is an interface that wraps various underlying Wasm runtimes like Wasmer, Wasmtime
 type RuntimeInstance interface {
 	Call(fn string, args ...interface{}) (interface{}, error)
-	ReadMemory(pointer int32, size int32) []byte
-	WriteMemory(data []byte) (int32, error)
-	WriteMemoryAtLocation(pointer int32, data []byte)
-	Deallocate(pointer int32, length int)
+	// Memory returns the instance's linear memory, or nil if the module doesn't export one. The slice is backed by
+	// the instance and is only valid until it is next called, since the guest can grow (and so move) its memory.
+	Memory() []byte
+	MemoryPages() uint32
+	SetBudget(timeout time.Duration, fuel uint64) error
+	SnapshotGlobals() ([]interface{}, error)
+	RestoreGlobals(values []interface{}) error
+	// HostContext returns the context through which the instance's host functions are given their caller
+	HostContext() *HostContext
 	Close()
 }
 
@@ -47,7 +129,40 @@ type instanceReference struct {
 
 // Call executes a function from the Wasm Module
 func (w *WasmInstance) Call(fn string, args ...interface{}) (interface{}, error) {
-	return w.runtime.Call(fn, args...)
+	result, err := w.runtime.Call(fn, args...)
+	if err != nil && !errors.Is(err, ErrExportNotFound) {
+		// the instance may have been left in an inconsistent state, so it should not be reused
+		w.failed = true
+	}
+
+	return result, err
+}
+
+// LimitHit returns the error for the memory or table limit that the module was refused growth by since LimitHit was
+// last called, if any. The runtime is asked first if it enforces limits itself, and otherwise modules are only able
+// to tell when they are prepared with limits.
+func (w *WasmInstance) LimitHit() error {
+	if reporter, ok := w.runtime.(LimitReporter); ok {
+		if hit := reporter.LimitHit(); hit != nil {
+			return hit
+		}
+	}
+
+	result, err := w.runtime.Call(limitsHitExport)
+	if err != nil {
+		return nil
+	}
+
+	hit, _ := result.(int32)
+
+	switch {
+	case hit&wasmbinary.LimitHitMemory != 0:
+		return ErrMemoryLimit
+	case hit&wasmbinary.LimitHitTable != 0:
+		return ErrTableLimit
+	}
+
+	return nil
 }
 
 // ExecutionResult gets the runnable's execution results
@@ -74,23 +189,120 @@ func (w *WasmInstance) SendExecutionResult(result []byte, runErr error) {
 	}
 }
 
+// clearResults discards any execution result that was sent but never collected
+func (w *WasmInstance) clearResults() {
+	for {
+		select {
+		case <-w.resultChan:
+		case <-w.errChan:
+		default:
+			return
+		}
+	}
+}
+
+// Ident returns the instance's ident
+func (w *WasmInstance) Ident() int32 {
+	return w.ident
+}
+
 // Ctx returns the instance's Ctx
 func (w *WasmInstance) Ctx() *scheduler.Ctx {
 	return w.ctx
 }
 
-func (w *WasmInstance) ReadMemory(pointer int32, size int32) []byte {
-	return w.runtime.ReadMemory(pointer, size)
+// ReadMemory returns a copy of size bytes of the instance's memory at pointer, or ErrMemoryOutOfBounds if they
+// aren't all within it
+func (w *WasmInstance) ReadMemory(pointer int32, size int32) ([]byte, error) {
+	borrowed, err := w.BorrowMemory(pointer, size)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, len(borrowed))
+	copy(result, borrowed)
+
+	return result, nil
 }
 
+// BorrowMemory returns size bytes of the instance's memory at pointer without copying them, or ErrMemoryOutOfBounds
+// if they aren't all within it. The slice belongs to the guest, so it must not be kept once the host function that
+// borrowed it returns; use ReadMemory for anything that outlives the call.
+func (w *WasmInstance) BorrowMemory(pointer int32, size int32) ([]byte, error) {
+	return memoryRange(w.runtime.Memory(), pointer, size)
+}
+
+// WriteMemory allocates memory in the instance and copies data into it, returning the pointer to it
 func (w *WasmInstance) WriteMemory(data []byte) (int32, error) {
-	return w.runtime.WriteMemory(data)
+	pointer, err := w.writeMemory(data)
+	if err != nil {
+		w.failed = true
+	}
+
+	return pointer, err
+}
+
+func (w *WasmInstance) writeMemory(data []byte) (int32, error) {
+	allocateResult, err := w.runtime.Call("allocate", int32(len(data)))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to Call allocate")
+	}
+
+	pointer, ok := allocateResult.(int32)
+	if !ok {
+		return 0, fmt.Errorf("allocate returned %T, expected int32", allocateResult)
+	}
+
+	if err := w.WriteMemoryAtLocation(pointer, data); err != nil {
+		return 0, errors.Wrap(err, "failed to WriteMemoryAtLocation")
+	}
+
+	return pointer, nil
 }
 
-func (w *WasmInstance) WriteMemoryAtLocation(pointer int32, data []byte) {
-	w.runtime.WriteMemoryAtLocation(pointer, data)
+// WriteMemoryAtLocation copies data into the instance's memory at pointer, or returns ErrMemoryOutOfBounds
+// (writing nothing) if it doesn't fit
+func (w *WasmInstance) WriteMemoryAtLocation(pointer int32, data []byte) error {
+	scoped, err := memoryRange(w.runtime.Memory(), pointer, int32(len(data)))
+	if err != nil {
+		return err
+	}
+
+	copy(scoped, data)
+
+	return nil
 }
 
+// Deallocate frees memory allocated by WriteMemory
 func (w *WasmInstance) Deallocate(pointer int32, length int) {
-	w.runtime.Deallocate(pointer, length)
+	w.runtime.Call("deallocate", pointer, int32(length))
+}
+
+// memoryRange returns the size bytes of memory at pointer, which the guest passes as unsigned values, or
+// ErrMemoryOutOfBounds if they don't all fit
+func memoryRange(memory []byte, pointer int32, size int32) ([]byte, error) {
+	start := uint64(uint32(pointer))
+	end := start + uint64(uint32(size))
+
+	if end > uint64(len(memory)) {
+		return nil, errors.Wrapf(ErrMemoryOutOfBounds, "%d bytes at %d with %d bytes of memory", uint32(size), uint32(pointer), len(memory))
+	}
+
+	return memory[start:end:end], nil
+}
+
+// ReadOutput returns what the instance has written to stdout and stderr since it was last called, or nothing if
+// the runtime doesn't capture output
+func (w *WasmInstance) ReadOutput() (stdout []byte, stderr []byte) {
+	reader, ok := w.runtime.(OutputReader)
+	if !ok {
+		return nil, nil
+	}
+
+	return reader.ReadOutput()
+}
+
+// MemoryPages returns the current size of the instance's memory in 64KiB pages
+func (w *WasmInstance) MemoryPages() uint32 {
+	return w.runtime.MemoryPages()
 }
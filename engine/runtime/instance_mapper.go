@@ -9,24 +9,66 @@ import (
 	"github.com/pkg/errors"
 )
 
-// the instance mapper is a global var that maps a random int32 to a wasm instance to make bi-directional FFI calls "easy"
+// ErrUnknownCaller is returned to a host function that the runtime couldn't resolve the calling instance of
+var ErrUnknownCaller = errors.New("the instance calling the host function could not be resolved")
+
+// the instance mapper maps the random ident given to an instance to the instance itself. Host functions are given the
+// instance that called them by the runtime, so the mapper is only used by host APIs that look instances up with
+// InstanceForIdentifier, and only the instances of builders with such host functions are registered with it.
 var instanceMapper = sync.Map{}
 
+// CallerInstance returns the instance that called a host function, which the runtime resolved from the calling store.
+// The ident passed by the guest must be the caller's own, so that a guest can't act on behalf of another instance.
+func CallerInstance(caller *WasmInstance, ident int32, needsFFIResult bool) (*WasmInstance, error) {
+	if caller == nil {
+		return nil, ErrUnknownCaller
+	}
+
+	if ident != caller.ident {
+		return nil, errors.New("ident does not belong to the calling instance")
+	}
+
+	return Caller(caller, needsFFIResult)
+}
+
+// Caller returns the instance that called a host function, for ABIs in which the guest doesn't pass an ident
+func Caller(caller *WasmInstance, needsFFIResult bool) (*WasmInstance, error) {
+	if caller == nil {
+		return nil, ErrUnknownCaller
+	}
+
+	return usableInstance(caller, needsFFIResult)
+}
+
+// InstanceForIdentifier returns the instance with the given ident, which must have been built by a builder that
+// implements IdentifierLookup and has host functions that aren't given their caller.
+//
+// Deprecated: host functions created with NewCallerHostFn are given the instance that called them, which
+// CallerInstance checks the ident against rather than trusting it.
 func InstanceForIdentifier(ident int32, needsFFIResult bool) (*WasmInstance, error) {
 	rawRef, exists := instanceMapper.Load(ident)
 	if !exists {
 		return nil, errors.New("instance does not exist")
 	}
 
-	ref := rawRef.(instanceReference)
+	return usableInstance(rawRef.(instanceReference).Inst, needsFFIResult)
+}
+
+// usableInstance returns the instance if a host function can be called on its behalf
+func usableInstance(inst *WasmInstance, needsFFIResult bool) (*WasmInstance, error) {
+	// an instance that isn't running an invocation (such as while _start runs) can't be called from
+	if inst.Ctx() == nil {
+		return nil, errors.New("instance is not in use")
+	}
 
-	if needsFFIResult && ref.Inst.Ctx().HasFFIResult() {
+	if needsFFIResult && inst.Ctx().HasFFIResult() {
 		return nil, errors.New("cannot use instance for host call with existing call in progress")
 	}
 
-	return ref.Inst, nil
+	return inst, nil
 }
 
+// setupNewIdentifier gives an instance the random ident that it keeps for as long as it exists
 func setupNewIdentifier(inst *WasmInstance) (int32, error) {
 	for {
 		ident, err := randomIdentifier()
@@ -36,30 +78,27 @@ func setupNewIdentifier(inst *WasmInstance) (int32, error) {
 
 		// ensure we don't accidentally overwrite something else
 		// (however unlikely that may be)
-		if _, exists := instanceMapper.Load(ident); exists {
+		if _, exists := instanceMapper.LoadOrStore(ident, instanceReference{Inst: inst}); exists {
 			continue
 		}
 
-		ref := instanceReference{
-			Inst: inst,
-		}
-
-		instanceMapper.Store(ident, ref)
-
 		return ident, nil
 	}
 }
 
-func removeIdentifier(ident int32) {
-	instanceMapper.Delete(ident)
+// forgetIdentifier removes an instance from the instance mapper, if it was registered with it
+func forgetIdentifier(inst *WasmInstance) {
+	if inst.registered {
+		instanceMapper.Delete(inst.ident)
+	}
 }
 
+// randomIdentifier returns a random positive int32, so that no instance has the zero ident of an unbound instance
 func randomIdentifier() (int32, error) {
-	// generate a random number between 0 and the largest possible int32
 	num, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt32))
 	if err != nil {
 		return -1, errors.Wrap(err, "failed to rand.Int")
 	}
 
-	return int32(num.Int64()), nil
+	return int32(num.Int64()) + 1, nil
 }
@@ -0,0 +1,177 @@
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// LogLevel is the level that one of a module's output streams is logged at
+type LogLevel string
+
+const (
+	// LogLevelNone discards the stream rather than capturing it
+	LogLevelNone  LogLevel = "none"
+	LogLevelError LogLevel = "error"
+	LogLevelWarn  LogLevel = "warn"
+	LogLevelInfo  LogLevel = "info"
+	LogLevelDebug LogLevel = "debug"
+)
+
+// DefaultOutputMaxBytes is the amount of each output stream kept per invocation when no cap is configured
+const DefaultOutputMaxBytes = 64 * 1024
+
+// Output configures how the stdout and stderr of a module's invocations are captured and logged
+type Output struct {
+	// StdoutLevel is the level that stdout is logged at, and defaults to info
+	StdoutLevel LogLevel `yaml:"stdoutLevel" json:"stdoutLevel"`
+	// StderrLevel is the level that stderr is logged at, and defaults to warn
+	StderrLevel LogLevel `yaml:"stderrLevel" json:"stderrLevel"`
+	// MaxBytes is the amount of each stream kept per invocation, and defaults to DefaultOutputMaxBytes
+	MaxBytes int `yaml:"maxBytes" json:"maxBytes"`
+	// DebugHeader returns the captured output to the caller in the X-Sat-Stdout and X-Sat-Stderr response headers
+	DebugHeader bool `yaml:"debugHeader" json:"debugHeader"`
+}
+
+// Validate returns an error if a level is unknown or the size cap is negative
+func (o Output) Validate() error {
+	for _, level := range []LogLevel{o.StdoutLevel, o.StderrLevel} {
+		switch level {
+		case "", LogLevelNone, LogLevelError, LogLevelWarn, LogLevelInfo, LogLevelDebug:
+		default:
+			return fmt.Errorf("unknown output log level %q", level)
+		}
+	}
+
+	if o.MaxBytes < 0 {
+		return fmt.Errorf("invalid output size cap %d", o.MaxBytes)
+	}
+
+	return nil
+}
+
+// Stdout returns the level that stdout is logged at
+func (o Output) Stdout() LogLevel {
+	if o.StdoutLevel == "" {
+		return LogLevelInfo
+	}
+
+	return o.StdoutLevel
+}
+
+// Stderr returns the level that stderr is logged at
+func (o Output) Stderr() LogLevel {
+	if o.StderrLevel == "" {
+		return LogLevelWarn
+	}
+
+	return o.StderrLevel
+}
+
+// Captures returns true if either of the streams is captured
+func (o Output) Captures() bool {
+	return o.Stdout() != LogLevelNone || o.Stderr() != LogLevelNone
+}
+
+// Max returns the amount of each stream kept per invocation
+func (o Output) Max() int {
+	if o.MaxBytes == 0 {
+		return DefaultOutputMaxBytes
+	}
+
+	return o.MaxBytes
+}
+
+// OutputReader is implemented by RuntimeInstances that capture the module's stdout and stderr
+type OutputReader interface {
+	// ReadOutput returns what the module has written to stdout and stderr since it was last called
+	ReadOutput() (stdout []byte, stderr []byte)
+}
+
+// OutputBuffer captures one of a module's output streams, keeping up to a maximum number of bytes between drains
+type OutputBuffer struct {
+	buf     bytes.Buffer
+	max     int
+	dropped int
+	lock    sync.Mutex
+}
+
+// NewOutputBuffer creates an OutputBuffer that keeps up to max bytes
+func NewOutputBuffer(max int) *OutputBuffer {
+	return &OutputBuffer{max: max}
+}
+
+// Write captures p, dropping whatever doesn't fit. It never fails, so that the guest is unaware of the cap.
+func (o *OutputBuffer) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	keep := o.max - o.buf.Len()
+	if keep > len(p) {
+		keep = len(p)
+	} else if keep < 0 {
+		keep = 0
+	}
+
+	o.buf.Write(p[:keep])
+	o.dropped += len(p) - keep
+
+	return len(p), nil
+}
+
+// Drain returns the captured output, noting how much was dropped, and empties the buffer
+func (o *OutputBuffer) Drain() []byte {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.buf.Len() == 0 && o.dropped == 0 {
+		return nil
+	}
+
+	out := make([]byte, o.buf.Len())
+	copy(out, o.buf.Bytes())
+
+	if o.dropped > 0 {
+		out = append(out, fmt.Sprintf("\n[%d bytes of output dropped]", o.dropped)...)
+	}
+
+	o.buf.Reset()
+	o.dropped = 0
+
+	return out
+}
+
+// OutputCapture holds the buffers that an instance's output streams are captured into, a stream's buffer is nil if
+// it is not captured
+type OutputCapture struct {
+	Stdout *OutputBuffer
+	Stderr *OutputBuffer
+}
+
+// NewOutputCapture creates the buffers for the streams that the config captures
+func NewOutputCapture(config Output) *OutputCapture {
+	c := &OutputCapture{}
+
+	if config.Stdout() != LogLevelNone {
+		c.Stdout = NewOutputBuffer(config.Max())
+	}
+
+	if config.Stderr() != LogLevelNone {
+		c.Stderr = NewOutputBuffer(config.Max())
+	}
+
+	return c
+}
+
+// ReadOutput drains the captured streams
+func (c *OutputCapture) ReadOutput() (stdout []byte, stderr []byte) {
+	if c.Stdout != nil {
+		stdout = c.Stdout.Drain()
+	}
+
+	if c.Stderr != nil {
+		stderr = c.Stderr.Drain()
+	}
+
+	return stdout, stderr
+}
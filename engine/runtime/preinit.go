@@ -0,0 +1,240 @@
+package runtime
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/tenant"
+
+	"github.com/suborbital/sat/engine/runtime/compilecache"
+	"github.com/suborbital/sat/engine/wasmbinary"
+)
+
+var (
+	// ErrNotSnapshotSafe is returned to a module that calls one of the Runnable API's host functions (other than those
+	// that are SnapshotSafe, such as logging) as it is being preinitialized. They serve invocations, and what they give
+	// to or do for the module couldn't be captured in a snapshot that every instance starts from.
+	ErrNotSnapshotSafe = errors.New("host function is not snapshot-safe, so it can't be called while the module is preinitialized")
+	// ErrPreinitHostSources is returned for a module that is preinitialized with the host's clock or random source,
+	// since every instance would then share the time and random numbers that its initialization read
+	ErrPreinitHostSources = errors.New("a module given the host's clock or random source can't be preinitialized")
+	// ErrPreinitReadSources is returned for a module whose initialization reads its clock or random numbers, which its
+	// snapshot would capture, so that every instance would start with the same ones
+	ErrPreinitReadSources = errors.New("a module that reads the clock or random numbers as it is initialized can't be preinitialized")
+)
+
+// preinitExports are the exports that runtimes call to initialize each instance
+var preinitExports = []string{"_start", "init"}
+
+// preinitVersion changes whenever the way that modules are preinitialized does, so that older snapshots aren't used
+const preinitVersion = 2
+
+// CheckPreinit returns an error if the config preinitializes the module, but its initialization couldn't be captured
+func (c Config) CheckPreinit() error {
+	if !c.Preinit {
+		return nil
+	}
+
+	return c.checkPreinitSources()
+}
+
+func (c Config) checkPreinitSources() error {
+	if c.WASI.HostClock() || c.WASI.HostRandom() {
+		return ErrPreinitHostSources
+	}
+
+	return nil
+}
+
+// Preinitialize runs the module's initialization (its _start function, and its legacy init function on the runtimes
+// that call it) once on the named runtime, and returns the module rewritten to start with the memory and globals
+// that its initialization left, so that its instances don't initialize themselves. The module is refused if its
+// initialization calls any of hostFns that aren't SnapshotSafe, or reads its (fake) clock or random numbers. Everything
+// else it can read through WASI comes from the config, apart from the contents of preopened directories, which it
+// sees as they were.
+//
+// The rewritten module is cached alongside compiled modules in config.CacheDir.
+func Preinitialize(ref *tenant.WasmModuleRef, hostFns []HostFn, backend string, config Config) (*tenant.WasmModuleRef, error) {
+	if err := config.checkPreinitSources(); err != nil {
+		return nil, err
+	}
+
+	builderFunc, err := Backend(backend)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to Backend")
+	}
+
+	cache, key := preinitCache(ref, backend, config)
+
+	if cache != nil {
+		data, err := cache.Load(key)
+		if err == nil {
+			InternalLogger().Debug("loaded preinitialized module from cache", key)
+			return tenant.NewWasmModuleRef(ref.Name, ref.FQMN, data), nil
+		} else if !errors.Is(err, compilecache.ErrMiss) {
+			InternalLogger().Warn(errors.Wrap(err, "failed to cache.Load, preinitializing again").Error())
+		}
+	}
+
+	data, err := preinitialize(ref, builderFunc, hostFns, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != nil {
+		if err := cache.Store(key, data); err != nil {
+			InternalLogger().Warn(errors.Wrap(err, "failed to cache.Store").Error())
+		}
+	}
+
+	return tenant.NewWasmModuleRef(ref.Name, ref.FQMN, data), nil
+}
+
+// preinitialize builds an instance of the module to run its initialization, and rewrites the module from a
+// snapshot of the instance
+func preinitialize(ref *tenant.WasmModuleRef, builderFunc BuilderFunc, hostFns []HostFn, config Config) ([]byte, error) {
+	// the instance exports its globals to be snapshotted, and is thrown away rather than filling the compile cache
+	initConfig := config
+	initConfig.Pool = Pool{Mode: PoolSnapshot}
+	initConfig.CacheDir = ""
+
+	guard := &snapshotGuard{}
+
+	inst, err := builderFunc(ref, guard.hostFns(hostFns), initConfig).New()
+	if guard.refused != nil {
+		// not every runtime traps a module when a host function fails, so the refused call is checked for here too
+		err = guard.refused
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to New")
+	}
+
+	defer inst.Close()
+
+	if inst.HostContext().sourcesRead() {
+		return nil, ErrPreinitReadSources
+	}
+
+	state, err := takeSnapshot(inst)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to takeSnapshot")
+	}
+
+	snapshot := wasmbinary.Snapshot{MemoryPages: state.pages, Memory: state.memory}
+
+	for i, value := range state.globals {
+		bits, err := globalBits(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read global %d", i)
+		}
+
+		snapshot.Globals = append(snapshot.Globals, bits)
+	}
+
+	data, err := wasmbinary.Preinitialize(ref.Data, snapshot, preinitExports...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to wasmbinary.Preinitialize")
+	}
+
+	return data, nil
+}
+
+// snapshotGuard refuses the host functions that aren't snapshot-safe when they are called by a module as it is being
+// preinitialized
+type snapshotGuard struct {
+	// refused is the first call that was refused
+	refused error
+}
+
+// hostFns returns copies of the host functions, in which those that aren't SnapshotSafe fail with ErrNotSnapshotSafe
+// when they are called, which traps the module on the runtimes that trap modules for failed host functions
+func (g *snapshotGuard) hostFns(hostFns []HostFn) []HostFn {
+	guards := make([]HostFn, len(hostFns))
+
+	for i, fn := range hostFns {
+		if fn.SnapshotSafe {
+			guards[i] = fn
+			continue
+		}
+
+		name := fn.Name
+
+		fn.HostFn = func(args ...interface{}) (interface{}, error) {
+			err := errors.Wrap(ErrNotSnapshotSafe, name)
+			if g.refused == nil {
+				g.refused = err
+			}
+
+			return nil, err
+		}
+
+		fn.CallerFn = nil
+		guards[i] = fn
+	}
+
+	return guards
+}
+
+// globalBits returns the bits of a global's value, which runtimes give either as its Go type or as its bits
+func globalBits(value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case int32:
+		return uint64(uint32(v)), nil
+	case int64:
+		return uint64(v), nil
+	case float32:
+		return uint64(math.Float32bits(v)), nil
+	case float64:
+		return math.Float64bits(v), nil
+	case uint64:
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("unsupported global value %T", value)
+}
+
+// preinitInputs are the parts of the config that a module's initialization can see, which its snapshot depends on
+type preinitInputs struct {
+	Version   int
+	Backend   string
+	Args      []string
+	EnvKeys   []string
+	EnvValues []string
+	Preopens  []Preopen
+	Limits    Limits
+}
+
+// preinitCache returns the cache that the module's snapshot is kept in and its key, or a nil cache if there isn't one
+func preinitCache(ref *tenant.WasmModuleRef, backend string, config Config) (*compilecache.Cache, string) {
+	if config.CacheDir == "" {
+		return nil, ""
+	}
+
+	cache, err := compilecache.New(config.CacheDir)
+	if err != nil {
+		InternalLogger().Warn(errors.Wrap(err, "failed to compilecache.New, preinitializing without cache").Error())
+		return nil, ""
+	}
+
+	inputs := preinitInputs{
+		Version:  preinitVersion,
+		Backend:  backend,
+		Args:     config.WASI.Argv(ref.Name),
+		Preopens: config.WASI.Preopens,
+		Limits:   config.Limits,
+	}
+
+	inputs.EnvKeys, inputs.EnvValues = config.WASI.Environ()
+
+	// the environment's values are hashed along with the module, rather than being written into the key
+	fingerprint := sha256.New()
+	fingerprint.Write(ref.Data)
+	json.NewEncoder(fingerprint).Encode(inputs)
+
+	return cache, compilecache.Key(fingerprint.Sum(nil), "preinit-"+backend)
+}
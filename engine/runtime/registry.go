@@ -0,0 +1,83 @@
+package runtime
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/tenant"
+)
+
+// preferredBackends is the order in which runtimes are chosen when none has been selected
+var preferredBackends = []string{"wasmtime", "wasmer", "wasmedge", "wazero"}
+
+var ErrBackendNotAvailable = errors.New("runtime is not available")
+
+// BuilderFunc creates a RuntimeBuilder for a module, whose instances are given access to hostFns
+type BuilderFunc func(ref *tenant.WasmModuleRef, hostFns []HostFn, config Config) RuntimeBuilder
+
+var (
+	backends     = map[string]BuilderFunc{}
+	backendsLock = sync.RWMutex{}
+)
+
+// RegisterBackend makes a runtime available under the given name,
+// and is called by each runtime package when it is compiled in
+func RegisterBackend(name string, builderFunc BuilderFunc) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	backends[name] = builderFunc
+}
+
+// Backend returns the BuilderFunc of the named runtime, or an error if it was not compiled in
+func Backend(name string) (BuilderFunc, error) {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+
+	builderFunc, exists := backends[name]
+	if !exists {
+		return nil, errors.Wrapf(ErrBackendNotAvailable, "%q was not compiled into this build (available: %s)", name, strings.Join(backendNames(), ", "))
+	}
+
+	return builderFunc, nil
+}
+
+// DefaultBackend returns the name of the runtime to use when none has been selected
+func DefaultBackend() string {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+
+	for _, name := range preferredBackends {
+		if _, exists := backends[name]; exists {
+			return name
+		}
+	}
+
+	if names := backendNames(); len(names) > 0 {
+		return names[0]
+	}
+
+	return ""
+}
+
+// Backends returns the names of the runtimes that are available
+func Backends() []string {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+
+	return backendNames()
+}
+
+func backendNames() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,67 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// the size of a Wasm memory page, in bytes
+const wasmPageSize = 65536
+
+// snapshotGlobalPrefix is used to name the globals exported by Config.Prepare so that they can be snapshotted
+const snapshotGlobalPrefix = "__sat_global_"
+
+var errMemoryGrown = errors.New("memory has grown since the snapshot was taken")
+
+// SnapshotGlobalName returns the export name of the nth global captured in snapshots
+func SnapshotGlobalName(n int) string {
+	return fmt.Sprintf("%s%d", snapshotGlobalPrefix, n)
+}
+
+// snapshot is the pristine state of an instance, used to reset it between invocations
+type snapshot struct {
+	pages   uint32
+	memory  []byte
+	globals []interface{}
+}
+
+// takeSnapshot captures an instance's memory and mutable globals
+func takeSnapshot(inst RuntimeInstance) (*snapshot, error) {
+	pages := inst.MemoryPages()
+
+	size := int64(pages) * wasmPageSize
+	if size > math.MaxInt32 {
+		return nil, fmt.Errorf("memory of %d pages is too large to snapshot", pages)
+	}
+
+	globals, err := inst.SnapshotGlobals()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to SnapshotGlobals")
+	}
+
+	s := &snapshot{
+		pages:   pages,
+		memory:  append([]byte(nil), inst.Memory()...),
+		globals: globals,
+	}
+
+	return s, nil
+}
+
+// restore resets an instance to the state captured in the snapshot. Memory cannot shrink,
+// so an instance whose memory has grown cannot be restored and must be replaced instead.
+func (s *snapshot) restore(inst RuntimeInstance) error {
+	if inst.MemoryPages() != s.pages {
+		return errMemoryGrown
+	}
+
+	copy(inst.Memory(), s.memory)
+
+	if err := inst.RestoreGlobals(s.globals); err != nil {
+		return errors.Wrap(err, "failed to RestoreGlobals")
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package runtime
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/wasmbinary"
+)
+
+// dwarfSectionPrefix starts the names of the custom sections that hold DWARF debug info
+const dwarfSectionPrefix = ".debug_"
+
+// dwarf5Sections are the sections that debug/dwarf needs to be given separately for DWARF 5
+var dwarf5Sections = []string{".debug_addr", ".debug_line_str", ".debug_rnglists", ".debug_str_offsets"}
+
+// Symbols resolves positions in a module's code to function names, from its name section, and to source lines, from
+// its DWARF debug info. Either can be missing, in which case frames are symbolized as far as possible.
+type Symbols struct {
+	names map[uint32]string
+	// codeStart is the position of the code section within the module, which DWARF addresses are relative to
+	codeStart uint64
+	debug     *dwarf.Data
+
+	// debug/dwarf readers aren't safe for concurrent use, and several instances can trap at once
+	lock sync.Mutex
+}
+
+// NewSymbols reads the symbols of a module, which must be the exact bytes that were compiled
+func NewSymbols(module []byte) (*Symbols, error) {
+	sections, err := wasmbinary.Sections(module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to Sections")
+	}
+
+	customs, err := wasmbinary.CustomSections(module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to CustomSections")
+	}
+
+	s := &Symbols{names: map[uint32]string{}}
+
+	for _, section := range sections {
+		if section.ID == wasmbinary.SectionCode {
+			s.codeStart = uint64(section.Offset)
+		}
+	}
+
+	debugSections := map[string][]byte{}
+
+	for _, c := range customs {
+		switch {
+		case c.Name == wasmbinary.NameSection:
+			if s.names, err = wasmbinary.ReadFunctionNames(c.Data); err != nil {
+				return nil, errors.Wrap(err, "failed to ReadFunctionNames")
+			}
+		case strings.HasPrefix(c.Name, dwarfSectionPrefix):
+			debugSections[c.Name] = c.Data
+		}
+	}
+
+	if debugSections[".debug_info"] != nil && debugSections[".debug_line"] != nil {
+		// debug info that can't be parsed only costs the source lines, so it isn't treated as an error
+		s.debug, _ = dwarfData(debugSections)
+	}
+
+	return s, nil
+}
+
+// dwarfData parses the DWARF debug info held in a module's custom sections
+func dwarfData(sections map[string][]byte) (*dwarf.Data, error) {
+	d, err := dwarf.New(
+		sections[".debug_abbrev"],
+		sections[".debug_aranges"],
+		sections[".debug_frame"],
+		sections[".debug_info"],
+		sections[".debug_line"],
+		sections[".debug_pubnames"],
+		sections[".debug_ranges"],
+		sections[".debug_str"],
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dwarf.New")
+	}
+
+	for _, name := range dwarf5Sections {
+		if data, ok := sections[name]; ok {
+			if err := d.AddSection(name, data); err != nil {
+				return nil, errors.Wrapf(err, "failed to AddSection %s", name)
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// Frame symbolizes the instruction at moduleOffset in the module, within the function at funcIndex
+func (s *Symbols) Frame(funcIndex uint32, moduleOffset uint64) TrapFrame {
+	frame := TrapFrame{Function: s.FunctionName(funcIndex)}
+
+	if moduleOffset < s.codeStart {
+		return frame
+	}
+
+	frame.Offset = moduleOffset - s.codeStart
+	frame.File, frame.Line, frame.Column = s.SourceLine(frame.Offset)
+
+	return frame
+}
+
+// FunctionName returns the name of the function at funcIndex, or its index as $N if it has none
+func (s *Symbols) FunctionName(funcIndex uint32) string {
+	if name, ok := s.names[funcIndex]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("$%d", funcIndex)
+}
+
+// SourceLine returns the source position of the instruction at codeOffset in the code section, or an empty file if
+// the module has no debug info for it
+func (s *Symbols) SourceLine(codeOffset uint64) (file string, line int, column int) {
+	if s.debug == nil {
+		return "", 0, 0
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	unit := s.compileUnit(codeOffset)
+	if unit == nil {
+		return "", 0, 0
+	}
+
+	lines, err := s.debug.LineReader(unit)
+	if err != nil || lines == nil {
+		return "", 0, 0
+	}
+
+	// the line table isn't necessarily sorted, so find the closest entry at or before the instruction
+	var best *dwarf.LineEntry
+
+	for {
+		entry := &dwarf.LineEntry{}
+		if err := lines.Next(entry); err != nil {
+			if err != io.EOF {
+				return "", 0, 0
+			}
+
+			break
+		}
+
+		if entry.EndSequence || entry.Address > codeOffset {
+			continue
+		}
+
+		if best == nil || entry.Address > best.Address {
+			best = entry
+		}
+	}
+
+	if best == nil || best.File == nil {
+		return "", 0, 0
+	}
+
+	return best.File.Name, best.Line, best.Column
+}
+
+// compileUnit returns the compile unit whose code contains codeOffset
+func (s *Symbols) compileUnit(codeOffset uint64) *dwarf.Entry {
+	reader := s.debug.Reader()
+
+	for {
+		entry, err := reader.Next()
+		if err != nil || entry == nil {
+			return nil
+		}
+
+		if entry.Tag != dwarf.TagCompileUnit {
+			reader.SkipChildren()
+			continue
+		}
+
+		ranges, err := s.debug.Ranges(entry)
+		if err == nil {
+			for _, r := range ranges {
+				// the linker marks the ranges of discarded code with tombstones of 0 or -1
+				if r[0] == 0 || int32(r[0]) == -1 || int32(r[0]) == -2 {
+					continue
+				}
+
+				if r[0] <= codeOffset && codeOffset < r[1] {
+					return entry
+				}
+			}
+		}
+
+		reader.SkipChildren()
+	}
+}
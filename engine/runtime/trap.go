@@ -0,0 +1,136 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TrapKind is the reason that a guest trapped
+type TrapKind string
+
+const (
+	// TrapUnreachable is raised by the unreachable instruction, which is how most languages panic
+	TrapUnreachable TrapKind = "unreachable"
+	// TrapOutOfBounds is raised by an access outside of a memory or table
+	TrapOutOfBounds TrapKind = "out_of_bounds"
+	// TrapStackOverflow is raised when the call stack is exhausted
+	TrapStackOverflow TrapKind = "stack_overflow"
+	// TrapIntegerDivide is raised by an integer division or remainder by zero
+	TrapIntegerDivide TrapKind = "integer_divide_by_zero"
+	// TrapIntegerOverflow is raised by an integer division or float conversion that overflows
+	TrapIntegerOverflow TrapKind = "integer_overflow"
+	// TrapIndirectCall is raised by a call_indirect to a null or mismatched function
+	TrapIndirectCall TrapKind = "indirect_call"
+	// TrapAbort is raised by the guest itself through the return_abort host function
+	TrapAbort TrapKind = "abort"
+	// TrapUnknown is any other trap
+	TrapUnknown TrapKind = "unknown"
+)
+
+// trapMessages are the fragments of the runtimes' trap messages that identify each kind of trap
+var trapMessages = []struct {
+	fragment string
+	kind     TrapKind
+}{
+	{fragment: "unreachable", kind: TrapUnreachable},
+	{fragment: "out of bounds", kind: TrapOutOfBounds},
+	{fragment: "invalid table access", kind: TrapOutOfBounds},
+	{fragment: "undefined element", kind: TrapOutOfBounds},
+	{fragment: "stack overflow", kind: TrapStackOverflow},
+	{fragment: "call stack exhausted", kind: TrapStackOverflow},
+	{fragment: "divide by zero", kind: TrapIntegerDivide},
+	{fragment: "division by zero", kind: TrapIntegerDivide},
+	{fragment: "integer overflow", kind: TrapIntegerOverflow},
+	{fragment: "invalid conversion to integer", kind: TrapIntegerOverflow},
+	{fragment: "indirect call", kind: TrapIndirectCall},
+	{fragment: "uninitialized element", kind: TrapIndirectCall},
+}
+
+// TrapFrame is a function that was on the guest's stack when it trapped
+type TrapFrame struct {
+	// Function is the function's name from the name section, or its index as $N if it has none
+	Function string `json:"function,omitempty"`
+	// Offset is the position of the instruction within the module's code section
+	Offset uint64 `json:"offset,omitempty"`
+	// File, Line and Column locate the instruction in the source, if the module carries DWARF debug info
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+// String returns the frame as it appears in a backtrace
+func (f TrapFrame) String() string {
+	if f.File == "" {
+		return f.Function
+	}
+
+	location := f.File
+	if f.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, f.Line)
+	}
+
+	if f.Column > 0 {
+		location = fmt.Sprintf("%s:%d", location, f.Column)
+	}
+
+	if f.Function == "" {
+		return location
+	}
+
+	return fmt.Sprintf("%s (%s)", f.Function, location)
+}
+
+// maxTrapFrames is the number of frames kept in a backtrace, which a stack overflow would otherwise make enormous
+const maxTrapFrames = 64
+
+// Trap is the error returned when a guest traps, describing why and where it happened
+type Trap struct {
+	Kind    TrapKind `json:"kind"`
+	Message string   `json:"message"`
+	// Frames is the guest's backtrace, innermost first
+	Frames []TrapFrame `json:"frames,omitempty"`
+	// Omitted is the number of outer frames left out of the backtrace
+	Omitted int `json:"omittedFrames,omitempty"`
+}
+
+// AddFrame adds an outer frame to the backtrace, or counts it as omitted once the backtrace is full
+func (t *Trap) AddFrame(frame TrapFrame) {
+	if len(t.Frames) >= maxTrapFrames {
+		t.Omitted++
+		return
+	}
+
+	t.Frames = append(t.Frames, frame)
+}
+
+// TrapKindFromMessage classifies a trap by the message that the runtime gave it
+func TrapKindFromMessage(message string) TrapKind {
+	lower := strings.ToLower(message)
+
+	for _, m := range trapMessages {
+		if strings.Contains(lower, m.fragment) {
+			return m.kind
+		}
+	}
+
+	return TrapUnknown
+}
+
+// Error returns the kind of the trap and its message
+func (t *Trap) Error() string {
+	return fmt.Sprintf("wasm trap (%s): %s", t.Kind, t.Message)
+}
+
+// Backtrace returns the frames of the trap, one per line
+func (t *Trap) Backtrace() string {
+	lines := make([]string, len(t.Frames))
+	for i, f := range t.Frames {
+		lines[i] = fmt.Sprintf("%d: %s", i, f)
+	}
+
+	if t.Omitted > 0 {
+		lines = append(lines, fmt.Sprintf("... %d more frames", t.Omitted))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,193 @@
+package runtime
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/wasmbinary"
+)
+
+var ErrReadOnlyPreopenNotSupported = errors.New("runtime can't enforce read-only preopened directories, mount them as writable (host:guest:rw)")
+var ErrWASISourceNotSupported = errors.New("runtime can only expose the host's clock and random sources")
+
+// WASISource determines where a module's clock or random numbers come from
+type WASISource string
+
+const (
+	// SourceNone withholds the host's source, a fake clock or deterministic random stream is provided in its place
+	SourceNone WASISource = "none"
+	// SourceHost exposes the host's real clock or cryptographically secure random source
+	SourceHost WASISource = "host"
+)
+
+// WASI configures the system interface given to modules compiled for WASI, nothing from the host is exposed by default
+type WASI struct {
+	// Env lists the host environment variables that the module can read, as a NAME to pass the host's value
+	// through or as NAME=value to set it explicitly
+	Env []string `yaml:"env" json:"env"`
+	// Args are the command line arguments given to the module, following the program name (the module's name)
+	Args []string `yaml:"args" json:"args"`
+	// Preopens are the host directories that the module can access
+	Preopens []Preopen `yaml:"preopens" json:"preopens"`
+	// Clock is the source of the module's wall and monotonic clocks, and defaults to SourceNone
+	Clock WASISource `yaml:"clock" json:"clock"`
+	// Random is the source of the module's random numbers, and defaults to SourceNone
+	Random WASISource `yaml:"random" json:"random"`
+}
+
+// Preopen is a host directory mounted into a module's filesystem
+type Preopen struct {
+	// Host is the directory on the host
+	Host string `yaml:"host" json:"host"`
+	// Guest is the path that the module sees the directory at, and defaults to Host
+	Guest string `yaml:"guest" json:"guest"`
+	// Writable allows the module to modify the directory, which is otherwise read-only. Wasmer and WasmEdge can't
+	// enforce read-only directories, so they refuse to mount them
+	Writable bool `yaml:"writable" json:"writable"`
+}
+
+// ParsePreopen parses a preopen in the form host[:guest[:ro|rw]], which is read-only unless rw is given
+func ParsePreopen(preopen string) (Preopen, error) {
+	parts := strings.Split(preopen, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return Preopen{}, fmt.Errorf("invalid preopen %q, expected host[:guest[:ro|rw]]", preopen)
+	}
+
+	p := Preopen{Host: parts[0]}
+
+	if len(parts) > 1 {
+		p.Guest = parts[1]
+	}
+
+	if len(parts) > 2 {
+		switch parts[2] {
+		case "ro":
+		case "rw":
+			p.Writable = true
+		default:
+			return Preopen{}, fmt.Errorf("invalid preopen mode %q, expected ro or rw", parts[2])
+		}
+	}
+
+	return p, nil
+}
+
+// GuestPath returns the path that the module sees the directory at
+func (p Preopen) GuestPath() string {
+	if p.Guest == "" {
+		return p.Host
+	}
+
+	return p.Guest
+}
+
+// Validate returns an error if a source is unknown or a preopened directory does not exist
+func (w WASI) Validate() error {
+	for _, source := range []WASISource{w.Clock, w.Random} {
+		switch source {
+		case "", SourceNone, SourceHost:
+		default:
+			return fmt.Errorf("unknown WASI source %q", source)
+		}
+	}
+
+	for _, env := range w.Env {
+		if name, _, _ := strings.Cut(env, "="); name == "" {
+			return fmt.Errorf("invalid WASI environment variable %q", env)
+		}
+	}
+
+	for _, p := range w.Preopens {
+		info, err := os.Stat(p.Host)
+		if err != nil {
+			return errors.Wrapf(err, "failed to Stat preopen %s", p.Host)
+		}
+
+		if !info.IsDir() {
+			return fmt.Errorf("preopen %s is not a directory", p.Host)
+		}
+	}
+
+	return nil
+}
+
+// Environ resolves the environment variables given to the module, skipping those not set on the host
+func (w WASI) Environ() (keys []string, values []string) {
+	for _, env := range w.Env {
+		name, value, explicit := strings.Cut(env, "=")
+		if !explicit {
+			hostValue, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+
+			value = hostValue
+		}
+
+		keys = append(keys, name)
+		values = append(values, value)
+	}
+
+	return keys, values
+}
+
+// Argv returns the module's command line, starting with the program name
+func (w WASI) Argv(program string) []string {
+	return append([]string{program}, w.Args...)
+}
+
+// HostClock returns true if the module can read the host's clock
+func (w WASI) HostClock() bool {
+	return w.Clock == SourceHost
+}
+
+// HostRandom returns true if the module can read the host's random source
+func (w WASI) HostRandom() bool {
+	return w.Random == SourceHost
+}
+
+// ReadOnlyPreopens returns true if any of the preopened directories is read-only
+func (w WASI) ReadOnlyPreopens() bool {
+	for _, p := range w.Preopens {
+		if !p.Writable {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckSourceImports returns ErrWASISourceNotSupported if the module imports a clock or random function whose host
+// source is withheld, for runtimes that can't replace those functions with fakes
+func (w WASI) CheckSourceImports(module []byte) error {
+	if w.HostClock() && w.HostRandom() {
+		return nil
+	}
+
+	iface, err := wasmbinary.ReadInterface(module)
+	if err != nil {
+		return errors.Wrap(err, "failed to ReadInterface")
+	}
+
+	for _, imp := range iface.Imports {
+		if !wasiModules[imp.Module] {
+			continue
+		}
+
+		switch imp.Name {
+		case "clock_time_get", "clock_res_get":
+			if !w.HostClock() {
+				return errors.Wrapf(ErrWASISourceNotSupported, "module imports %s.%s, set the clock to %s", imp.Module, imp.Name, SourceHost)
+			}
+		case "random_get":
+			if !w.HostRandom() {
+				return errors.Wrapf(ErrWASISourceNotSupported, "module imports %s.%s, set random to %s", imp.Module, imp.Name, SourceHost)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// fakeEpoch is the wall clock time at which a module's fake clock starts
+var fakeEpoch = time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// FakeClockResolution is the amount that a fake clock advances each time it is read
+const FakeClockResolution = time.Millisecond
+
+// fakeRandomSeed seeds the deterministic random stream given to modules without the host's random source
+const fakeRandomSeed = 42
+
+// WASI clock IDs and error numbers used by the backends that emulate the WASI clock and random functions
+const (
+	wasiClockRealtime  = 0
+	wasiClockThreadCPU = 3
+
+	wasiErrnoSuccess = 0
+	wasiErrnoFault   = 21
+	wasiErrnoInval   = 28
+)
+
+// FakeClock is the clock seen by modules that have not been given the host's clock. It starts at a fixed time and advances
+// every time that it is read, so that guests see time moving forwards without learning the real time.
+type FakeClock struct {
+	start time.Time
+	fixed bool
+	ticks int64
+	read  bool
+	lock  sync.Mutex
+}
+
+// NewFakeClock creates a FakeClock
+func NewFakeClock() *FakeClock {
+	return &FakeClock{start: fakeEpoch}
+}
+
+// Reset restarts the clock at start. A fixed clock stays there rather than advancing each time that it is read.
+func (c *FakeClock) Reset(start time.Time, fixed bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.start = start
+	c.fixed = fixed
+	c.ticks = 0
+}
+
+// Walltime returns the fake wall clock time, as seconds and nanoseconds since the Unix epoch
+func (c *FakeClock) Walltime() (int64, int32) {
+	start, elapsed := c.tick()
+	now := start.Add(elapsed)
+
+	return now.Unix(), int32(now.Nanosecond())
+}
+
+// Nanotime returns the fake monotonic clock time, in nanoseconds
+func (c *FakeClock) Nanotime() int64 {
+	_, elapsed := c.tick()
+
+	return int64(elapsed)
+}
+
+// tick advances the clock unless it is fixed, returning the time it started at and the time since
+func (c *FakeClock) tick() (time.Time, time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.read = true
+
+	if !c.fixed {
+		c.ticks++
+	}
+
+	return c.start, time.Duration(c.ticks) * FakeClockResolution
+}
+
+// wasRead returns whether the clock has been read since it was created
+func (c *FakeClock) wasRead() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.read
+}
+
+// ClockTimeGet implements WASI's clock_time_get, writing the time of the clock with the given ID into the guest's memory
+func (c *FakeClock) ClockTimeGet(memory []byte, id uint32, pointer uint32) uint32 {
+	var nanos uint64
+
+	switch {
+	case id == wasiClockRealtime:
+		sec, nsec := c.Walltime()
+		nanos = uint64(sec)*uint64(time.Second) + uint64(nsec)
+	case id <= wasiClockThreadCPU:
+		nanos = uint64(c.Nanotime())
+	default:
+		return wasiErrnoInval
+	}
+
+	return writeUint64(memory, pointer, nanos)
+}
+
+// ClockResGet implements WASI's clock_res_get, writing the resolution of the clock with the given ID into the guest's memory
+func (c *FakeClock) ClockResGet(memory []byte, id uint32, pointer uint32) uint32 {
+	if id > wasiClockThreadCPU {
+		return wasiErrnoInval
+	}
+
+	return writeUint64(memory, pointer, uint64(FakeClockResolution))
+}
+
+// FakeRandom is the random source seen by modules that have not been given the host's. It produces the same stream of
+// bytes every time, so it must not be relied upon for anything security sensitive.
+type FakeRandom struct {
+	rand *rand.Rand
+	read bool
+	lock sync.Mutex
+}
+
+// NewFakeRandom creates a FakeRandom
+func NewFakeRandom() *FakeRandom {
+	return &FakeRandom{rand: rand.New(rand.NewSource(fakeRandomSeed))}
+}
+
+// Read fills p with the next bytes of the stream
+func (r *FakeRandom) Read(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.read = true
+
+	return r.rand.Read(p)
+}
+
+// wasRead returns whether any of the stream has been read since the source was created
+func (r *FakeRandom) wasRead() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.read
+}
+
+// Seed restarts the stream from seed, so that it produces the same bytes as any other stream with that seed
+func (r *FakeRandom) Seed(seed int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.rand.Seed(seed)
+}
+
+// RandomGet implements WASI's random_get, filling a buffer in the guest's memory from source
+func RandomGet(source io.Reader, memory []byte, pointer uint32, size uint32) uint32 {
+	if uint64(pointer)+uint64(size) > uint64(len(memory)) {
+		return wasiErrnoFault
+	}
+
+	if _, err := io.ReadFull(source, memory[pointer:pointer+size]); err != nil {
+		return wasiErrnoFault
+	}
+
+	return wasiErrnoSuccess
+}
+
+func writeUint64(memory []byte, pointer uint32, value uint64) uint32 {
+	if uint64(pointer)+8 > uint64(len(memory)) {
+		return wasiErrnoFault
+	}
+
+	binary.LittleEndian.PutUint64(memory[pointer:], value)
+
+	return wasiErrnoSuccess
+}
@@ -17,62 +17,133 @@ import (
 type WasmEdgeBuilder struct {
 	ref     *tenant.WasmModuleRef
 	hostFns []runtime.HostFn
+	config  runtime.Config
+}
+
+func init() {
+	runtime.RegisterBackend("wasmedge", newBuilder)
 }
 
 // NewBuilder create a new WasmEdgeBuilder
 func NewBuilder(ref *tenant.WasmModuleRef, hostAPI api.HostAPI) runtime.RuntimeBuilder {
+	return newBuilder(ref, hostAPI.HostFunctions(), runtime.Config{})
+}
+
+// NewBuilderWithConfig creates a new WasmEdgeBuilder whose instances are built and run with the given config
+func NewBuilderWithConfig(ref *tenant.WasmModuleRef, hostAPI api.HostAPI, config runtime.Config) runtime.RuntimeBuilder {
+	return newBuilder(ref, hostAPI.HostFunctions(), config)
+}
+
+func newBuilder(ref *tenant.WasmModuleRef, hostFns []runtime.HostFn, config runtime.Config) runtime.RuntimeBuilder {
 	w := &WasmEdgeBuilder{
 		ref:     ref,
-		hostFns: hostAPI.HostFunctions(),
+		hostFns: hostFns,
+		config:  config,
 	}
+
 	return w
 }
 
+// CheckConfig returns an error if the builder's config can't be honoured
+func (w *WasmEdgeBuilder) CheckConfig() error {
+	return checkWASI(w.config.WASI, w.ref.Data)
+}
+
 func (w *WasmEdgeBuilder) New() (runtime.RuntimeInstance, error) {
-	imports, ast, err := w.setupAST()
+	host := runtime.NewHostContext()
+
+	imports, ast, err := w.setupAST(host)
 	if err != nil {
 		return nil, err
 	}
 
+	wasiImports, err := wasiImportObject(w.ref.Name, w.config.WASI, w.ref.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to wasiImportObject")
+	}
+
 	// Create store
 	store := wasmedge.NewStore()
 
-	// Create executor
-	executor := wasmedge.NewExecutor()
+	// Create a VM on the store, measuring instruction cost if a fuel budget is configured. Invocations are run
+	// through the VM (rather than an executor) so that they can be cancelled once their deadline has passed.
+	conf := wasmedge.NewConfigure()
+	conf.SetStatisticsCostMeasuring(w.config.Fuel > 0)
+
+	vm := wasmedge.NewVMWithConfigAndStore(conf, store)
+	conf.Release()
+
+	inst := &WasmEdgeRuntime{
+		imports: imports,
+		store:   store,
+		vm:      vm,
+		host:    host,
+	}
+
+	if w.config.Fuel > 0 {
+		inst.stats = vm.GetStatistics()
+	}
+
+	// Register import objects
+	for _, namespace := range imports {
+		if err := vm.RegisterImport(namespace); err != nil {
+			inst.Close()
+			return nil, errors.Wrap(err, "failed to RegisterImport")
+		}
+	}
 
-	// Register import object
-	executor.RegisterImport(store, imports)
+	if err := vm.RegisterImport(wasiImports); err != nil {
+		inst.Close()
+		return nil, errors.Wrap(err, "failed to RegisterImport WASI")
+	}
 
-	wasiImports := wasmedge.NewWasiImportObject(nil, nil, nil)
-	executor.RegisterImport(store, wasiImports)
+	// Instantiate the module as the VM's active module
+	if err := instantiate(vm, ast); err != nil {
+		inst.Close()
+		return nil, errors.Wrap(err, "failed to instantiate")
+	}
 
-	// Instantiate store
-	executor.Instantiate(store, ast)
-	ast.Release()
+	// _start runs under the same budget as a normal invocation
+	if err := inst.SetBudget(w.config.Timeout, w.config.Fuel); err != nil {
+		return nil, errors.Wrap(err, "failed to SetBudget")
+	}
 
 	wasiStart := store.FindFunction("_start")
 	if wasiStart != nil {
-		if _, err := executor.Invoke(store, "_start"); err != nil {
+		if _, err := inst.Call("_start"); err != nil {
 			return nil, errors.Wrap(err, "failed to _start")
 		}
 	}
 	init := store.FindFunction("init")
 	if init != nil {
-		if _, err := executor.Invoke(store, "init"); err != nil {
+		if _, err := inst.Call("init"); err != nil {
 			return nil, errors.Wrap(err, "failed to init")
 		}
 	}
 
-	inst := &WasmEdgeRuntime{
-		imports:  imports,
-		store:    store,
-		executor: executor,
+	return inst, nil
+}
+
+// instantiate loads, validates and instantiates the module in the VM
+func instantiate(vm *wasmedge.VM, ast *wasmedge.AST) error {
+	defer ast.Release()
+
+	if err := vm.LoadWasmAST(ast); err != nil {
+		return errors.Wrap(err, "failed to LoadWasmAST")
 	}
 
-	return inst, nil
+	if err := vm.Validate(); err != nil {
+		return errors.Wrap(err, "failed to Validate")
+	}
+
+	if err := vm.Instantiate(); err != nil {
+		return errors.Wrap(err, "failed to Instantiate")
+	}
+
+	return nil
 }
 
-func (w *WasmEdgeBuilder) setupAST() (*wasmedge.ImportObject, *wasmedge.AST, error) {
+func (w *WasmEdgeBuilder) setupAST(host *runtime.HostContext) ([]*wasmedge.ImportObject, *wasmedge.AST, error) {
 	// Set not to print debug info
 	wasmedge.SetLogErrorLevel()
 
@@ -81,6 +152,11 @@ func (w *WasmEdgeBuilder) setupAST() (*wasmedge.ImportObject, *wasmedge.AST, err
 		return nil, nil, errors.Wrap(err, "failed to get ref ModuleBytes")
 	}
 
+	moduleBytes, err = w.config.Prepare(moduleBytes)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to config.Prepare")
+	}
+
 	// Create Loader
 	loader := wasmedge.NewLoader()
 
@@ -99,11 +175,15 @@ func (w *WasmEdgeBuilder) setupAST() (*wasmedge.ImportObject, *wasmedge.AST, err
 	}
 	val.Release()
 
-	// Create import object
-	imports := wasmedge.NewImportObject("env")
+	// Create an import object for each namespace, and mount the Runnable API host functions to them
+	imports := []*wasmedge.ImportObject{}
 
-	// mount the Runnable API host functions to the module's imports
-	addHostFns(imports, w.hostFns...)
+	for namespace, fns := range runtime.HostFnsByNamespace(w.hostFns) {
+		namespaceImports := wasmedge.NewImportObject(namespace)
+		addHostFns(namespaceImports, host, fns...)
+
+		imports = append(imports, namespaceImports)
+	}
 
 	return imports, ast, nil
 }
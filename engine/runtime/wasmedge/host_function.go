@@ -15,38 +15,65 @@ import (
 // toWasmEdgeHostFn creates a new host funcion from a generic host fn
 func toWasmEdgeHostFn(hostFn runtime.HostFn) func(data interface{}, mem *wasmedge.Memory, params []interface{}) ([]interface{}, wasmedge.Result) {
 	return func(data interface{}, mem *wasmedge.Memory, params []interface{}) ([]interface{}, wasmedge.Result) {
-		hostResult, hostErr := hostFn.HostFn(params...)
+		// the function's data is the HostContext of the instance that imports it
+		host, _ := data.(*runtime.HostContext)
+
+		// the Swift variant's extra arguments are not passed on
+		hostResult, hostErr := hostFn.Call(host, params[:len(hostFn.Params)]...)
 		if hostErr != nil {
 			return nil, wasmedge.Result_Fail
 		}
 
-		return []interface{}{hostResult}, wasmedge.Result_Success
+		results, err := hostFn.ResultValues(hostResult)
+		if err != nil {
+			return nil, wasmedge.Result_Fail
+		}
+
+		return results, wasmedge.Result_Success
 	}
 }
 
-// addHostFns adds a list of host functions to an import object
-func addHostFns(imports *wasmedge.ImportObject, fns ...runtime.HostFn) {
+// addHostFns adds a list of host functions to an instance's import object, with the instance's HostContext as their data
+func addHostFns(imports *wasmedge.ImportObject, host *runtime.HostContext, fns ...runtime.HostFn) {
 	for _, fn := range fns {
 		wasmHostFn := toWasmEdgeHostFn(fn)
 
-		argsType := make([]wasmedge.ValType, fn.ArgCount)
-		for i := 0; i < fn.ArgCount; i++ {
-			argsType[i] = wasmedge.ValType_I32
-		}
-
-		retType := []wasmedge.ValType{}
-		if fn.Returns {
-			retType = append(retType, wasmedge.ValType_I32)
-		}
+		argsType := valTypes(fn.Params)
+		retType := valTypes(fn.Results)
 		funcType := wasmedge.NewFunctionType(argsType, retType)
 
-		wasmEdgeHostFn := wasmedge.NewFunction(funcType, wasmHostFn, nil, 0)
+		wasmEdgeHostFn := wasmedge.NewFunction(funcType, wasmHostFn, host, 0)
 		imports.AddFunction(fn.Name, wasmEdgeHostFn)
 
+		// only the functions imported from env have Swift variations
+		if fn.Module != "" {
+			continue
+		}
+
 		swiftArgsType := append(argsType, wasmedge.ValType_I32, wasmedge.ValType_I32)
 		swiftFuncType := wasmedge.NewFunctionType(swiftArgsType, retType)
-		swiftWasmEdgeHostFn := wasmedge.NewFunction(swiftFuncType, wasmHostFn, nil, 0)
+		swiftWasmEdgeHostFn := wasmedge.NewFunction(swiftFuncType, wasmHostFn, host, 0)
 		swiftFuncName := fmt.Sprintf("%s_swift", fn.Name)
 		imports.AddFunction(swiftFuncName, swiftWasmEdgeHostFn)
 	}
 }
+
+// valTypes maps a host function's types to WasmEdge's
+func valTypes(types []runtime.ValueType) []wasmedge.ValType {
+	valTypes := make([]wasmedge.ValType, len(types))
+
+	for i, t := range types {
+		switch t {
+		case runtime.I64:
+			valTypes[i] = wasmedge.ValType_I64
+		case runtime.F32:
+			valTypes[i] = wasmedge.ValType_F32
+		case runtime.F64:
+			valTypes[i] = wasmedge.ValType_F64
+		default:
+			valTypes[i] = wasmedge.ValType_I32
+		}
+	}
+
+	return valTypes
+}
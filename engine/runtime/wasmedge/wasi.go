@@ -0,0 +1,45 @@
+//go:build wasmedge
+// +build wasmedge
+
+package runtimewasmedge
+
+import (
+	"fmt"
+
+	"github.com/second-state/WasmEdge-go/wasmedge"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// checkWASI returns an error if WasmEdge can't honour the WASI config for the module
+func checkWASI(config runtime.WASI, module []byte) error {
+	// WasmEdge grants every preopened directory full access, and its WASI functions can't be wrapped to prevent that
+	if config.ReadOnlyPreopens() {
+		return runtime.ErrReadOnlyPreopenNotSupported
+	}
+
+	// WASI is registered as a whole, so its clock and random functions can't be replaced with fakes, and only modules
+	// that don't import them can run with the host's sources withheld
+	return config.CheckSourceImports(module)
+}
+
+// wasiImportObject creates the WASI imports for an instance
+func wasiImportObject(name string, config runtime.WASI, module []byte) (*wasmedge.ImportObject, error) {
+	if err := checkWASI(config, module); err != nil {
+		return nil, err
+	}
+
+	keys, values := config.Environ()
+
+	envs := make([]string, len(keys))
+	for i := range keys {
+		envs[i] = fmt.Sprintf("%s=%s", keys[i], values[i])
+	}
+
+	preopens := make([]string, len(config.Preopens))
+	for i, p := range config.Preopens {
+		preopens[i] = fmt.Sprintf("%s:%s", p.GuestPath(), p.Host)
+	}
+
+	return wasmedge.NewWasiImportObject(config.Argv(name), envs, preopens), nil
+}
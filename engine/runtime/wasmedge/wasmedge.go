@@ -6,20 +6,52 @@
 package runtimewasmedge
 
 import (
+	"strings"
+	"sync/atomic"
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/second-state/WasmEdge-go/wasmedge"
+	"github.com/suborbital/sat/engine/runtime"
 )
 
+// the size of a Wasm memory page, in bytes
+const wasmPageSize = 65536
+
 // WasmEdgeRuntime is a WasmEdge implementation of the runtimeInstance interface
 type WasmEdgeRuntime struct {
-	imports  *wasmedge.ImportObject
-	store    *wasmedge.Store
-	executor *wasmedge.Executor
+	// imports has an import object for each namespace that host functions are imported from
+	imports []*wasmedge.ImportObject
+	store   *wasmedge.Store
+	vm      *wasmedge.VM
+	stats   *wasmedge.Statistics
+
+	// deadline is when the current invocation must be cancelled by, and is zero when it has no timeout
+	deadline time.Time
+	// depth counts the calls in progress, as host functions can call back into the guest
+	depth atomic.Int32
+
+	// host is the data of the instance's host functions
+	host *runtime.HostContext
 }
 
 func (w *WasmEdgeRuntime) Call(fn string, args ...interface{}) (interface{}, error) {
-	wasmResult, wasmErr := w.executor.Invoke(w.store, fn, args...)
+	wasmResult, wasmErr := w.execute(fn, args...)
 	if wasmErr != nil {
+		if errors.Is(wasmErr, runtime.ErrExecutionTimeout) {
+			return nil, errors.Wrapf(wasmErr, "failed to execute wasm func %s", fn)
+		}
+
+		if strings.Contains(wasmErr.Error(), "cost limit exceeded") {
+			return nil, errors.Wrapf(runtime.ErrFuelExhausted, "failed to execute wasm func %s", fn)
+		}
+
+		// WasmEdge doesn't report backtraces, so only the kind of trap is known
+		if kind := runtime.TrapKindFromMessage(wasmErr.Error()); kind != runtime.TrapUnknown {
+			trap := &runtime.Trap{Kind: kind, Message: wasmErr.Error()}
+			return nil, errors.Wrapf(trap, "failed to execute wasm func %s", fn)
+		}
+
 		return nil, errors.Wrap(wasmErr, "failed to execute wasm func")
 	}
 
@@ -30,58 +62,113 @@ func (w *WasmEdgeRuntime) Call(fn string, args ...interface{}) (interface{}, err
 	}
 }
 
-// ReadMemory reads memory from the vm
-func (w *WasmEdgeRuntime) ReadMemory(pointer int32, size int32) []byte {
+// execute runs the function, cancelling it if the invocation's deadline passes first. Calls made by host functions
+// run within the outermost call, which is what gets cancelled.
+func (w *WasmEdgeRuntime) execute(fn string, args ...interface{}) ([]interface{}, error) {
+	depth := w.depth.Add(1)
+	defer w.depth.Add(-1)
+
+	if w.deadline.IsZero() || depth > 1 {
+		return w.vm.Execute(fn, args...)
+	}
+
+	async := w.vm.AsyncExecute(fn, args...)
+	defer async.Release()
+
+	// WaitFor takes whole milliseconds, so the remaining time is rounded up
+	remaining := time.Until(w.deadline)
+	if remaining <= 0 || !async.WaitFor(int((remaining+time.Millisecond-1)/time.Millisecond)) {
+		// the guest is stopped before the instance is released, so GetResult waits for the cancellation
+		async.Cancel()
+		_, _ = async.GetResult()
+
+		return nil, runtime.ErrExecutionTimeout
+	}
+
+	return async.GetResult()
+}
+
+// Memory returns the instance's exported memory
+func (w *WasmEdgeRuntime) Memory() []byte {
 	memory := w.store.FindMemory("memory")
 	if memory == nil {
-		return []byte{}
+		return nil
 	}
 
-	data, err := memory.GetData(uint(pointer), uint(size))
-	if err != nil || data == nil {
-		return []byte{}
+	// GetData returns a view of the memory rather than a copy
+	data, err := memory.GetData(0, memory.GetPageSize()*wasmPageSize)
+	if err != nil {
+		return nil
 	}
 
-	result := make([]byte, size)
+	return data
+}
 
-	copy(result, data)
+// MemoryPages returns the size of the instance's memory in pages
+func (w *WasmEdgeRuntime) MemoryPages() uint32 {
+	memory := w.store.FindMemory("memory")
+	if memory == nil {
+		return 0
+	}
 
-	return result
+	return uint32(memory.GetPageSize())
 }
 
-// WriteMemory writes memory into the instance
-func (w *WasmEdgeRuntime) WriteMemory(data []byte) (int32, error) {
-	lengthOfInput := len(data)
-	allocateResult, err := w.Call("allocate", int32(lengthOfInput))
-	if err != nil {
-		return 0, errors.Wrap(err, "failed to Call allocate")
+// SetBudget sets the deadline by which the next invocation is cancelled, and raises the instruction cost limit by the
+// fuel available to it
+func (w *WasmEdgeRuntime) SetBudget(timeout time.Duration, fuel uint64) error {
+	w.deadline = time.Time{}
+	if timeout > 0 {
+		w.deadline = time.Now().Add(timeout)
+	}
+
+	if fuel > 0 && w.stats != nil {
+		w.stats.SetCostLimit(w.stats.GetTotalCost() + uint(fuel))
 	}
 
-	pointer := allocateResult.(int32)
+	return nil
+}
 
-	w.WriteMemoryAtLocation(pointer, data)
+// SnapshotGlobals returns the values of the globals exported for snapshots
+func (w *WasmEdgeRuntime) SnapshotGlobals() ([]interface{}, error) {
+	values := []interface{}{}
 
-	return pointer, nil
+	for i := 0; ; i++ {
+		global := w.store.FindGlobal(runtime.SnapshotGlobalName(i))
+		if global == nil {
+			return values, nil
+		}
+
+		values = append(values, global.GetValue())
+	}
 }
 
-// WriteMemoryAtLocation writes memory at the given location
-func (w *WasmEdgeRuntime) WriteMemoryAtLocation(pointer int32, data []byte) {
-	memory := w.store.FindMemory("memory")
-	if memory == nil {
-		return
+// RestoreGlobals sets the globals exported for snapshots to the given values
+func (w *WasmEdgeRuntime) RestoreGlobals(values []interface{}) error {
+	for i, value := range values {
+		global := w.store.FindGlobal(runtime.SnapshotGlobalName(i))
+		if global == nil {
+			return errors.Errorf("global %d not found", i)
+		}
+
+		global.SetValue(value)
 	}
 
-	memory.SetData(data, uint(pointer), uint(len(data)))
+	return nil
 }
 
-// Deallocate deallocates memory in the instance
-func (w *WasmEdgeRuntime) Deallocate(pointer int32, length int) {
-	w.Call("deallocate", pointer, int32(length))
+// HostContext returns the context through which the instance's host functions are given their caller
+func (w *WasmEdgeRuntime) HostContext() *runtime.HostContext {
+	return w.host
 }
 
 // Close closes the instance
 func (w *WasmEdgeRuntime) Close() {
-	w.executor.Release()
+	// the statistics belong to the VM, and are released with it
+	w.vm.Release()
 	w.store.Release()
-	w.imports.Release()
+
+	for _, imports := range w.imports {
+		imports.Release()
+	}
 }
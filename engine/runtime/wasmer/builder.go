@@ -1,6 +1,8 @@
 package runtimewasmer
 
 import (
+	"sync"
+
 	"github.com/pkg/errors"
 	"github.com/wasmerio/wasmer-go/wasmer"
 
@@ -12,34 +14,79 @@ import (
 
 // WasmerBuilder is a Wasmer implementation of the instanceBuilder interface
 type WasmerBuilder struct {
-	ref     *tenant.WasmModuleRef
-	hostFns []runtime.HostFn
-	module  *wasmer.Module
-	store   *wasmer.Store
-	imports *wasmer.ImportObject
+	ref        *tenant.WasmModuleRef
+	hostFns    []runtime.HostFn
+	config     runtime.Config
+	module     *wasmer.Module
+	store      *wasmer.Store
+	hostFnSets *hostFnPool
+	symbols    *runtime.Symbols
+	lock       sync.Mutex
+}
+
+func init() {
+	runtime.RegisterBackend("wasmer", newBuilder)
 }
 
 // NewBuilder creates a new WasmerBuilder
 func NewBuilder(ref *tenant.WasmModuleRef, API api.HostAPI) runtime.RuntimeBuilder {
+	return newBuilder(ref, API.HostFunctions(), runtime.Config{})
+}
+
+// NewBuilderWithConfig creates a new WasmerBuilder whose instances are built and run with the given config
+func NewBuilderWithConfig(ref *tenant.WasmModuleRef, API api.HostAPI, config runtime.Config) runtime.RuntimeBuilder {
+	return newBuilder(ref, API.HostFunctions(), config)
+}
+
+func newBuilder(ref *tenant.WasmModuleRef, hostFns []runtime.HostFn, config runtime.Config) runtime.RuntimeBuilder {
 	w := &WasmerBuilder{
 		ref:     ref,
-		hostFns: API.HostFunctions(),
+		hostFns: hostFns,
+		config:  config,
 	}
 
 	return w
 }
 
+// CheckConfig returns an error if the builder's config can't be honoured
+func (w *WasmerBuilder) CheckConfig() error {
+	return checkWASI(w.config.WASI)
+}
+
+// metered returns whether the module is compiled with the metering middleware, which enforces its budgets
+func (w *WasmerBuilder) metered() bool {
+	return w.config.Timeout > 0 || w.config.Fuel > 0
+}
+
 func (w *WasmerBuilder) New() (runtime.RuntimeInstance, error) {
-	module, _, imports, err := w.internals()
+	if err := w.CheckConfig(); err != nil {
+		return nil, err
+	}
+
+	module, store, err := w.internals()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to ModuleBytes")
 	}
 
+	memory := &guestMemory{}
+	host := runtime.NewHostContext()
+
+	hostFnSet := w.hostFnSets.get(host)
+
+	imports, env, err := w.imports(module, store, memory, host, hostFnSet)
+	if err != nil {
+		w.hostFnSets.put(hostFnSet)
+		return nil, errors.Wrap(err, "failed to imports")
+	}
+
 	wasmerInst, err := wasmer.NewInstance(module, imports)
 	if err != nil {
+		w.hostFnSets.put(hostFnSet)
 		return nil, errors.Wrap(err, "failed to NewInstance")
 	}
 
+	memory.inst = wasmerInst
+
 	// if the module has exported a WASI start, call it
 	wasiStart, err := wasmerInst.Exports.GetWasiStartFunction()
 	if err == nil && wasiStart != nil {
@@ -65,40 +112,96 @@ func (w *WasmerBuilder) New() (runtime.RuntimeInstance, error) {
 	}
 
 	inst := &WasmerRuntime{
-		inst: wasmerInst,
+		inst:       wasmerInst,
+		env:        env,
+		output:     runtime.NewOutputCapture(w.config.Output),
+		symbols:    w.symbols,
+		host:       host,
+		hostFnSet:  hostFnSet,
+		hostFnSets: w.hostFnSets,
+	}
+
+	if w.metered() {
+		inst.meter = newMeter(wasmerInst)
 	}
 
 	return inst, nil
 }
 
-func (w *WasmerBuilder) internals() (*wasmer.Module, *wasmer.Store, *wasmer.ImportObject, error) {
+// Precompile compiles the module without instantiating it, which fills the compile cache
+func (w *WasmerBuilder) Precompile() error {
+	if _, _, err := w.internals(); err != nil {
+		return errors.Wrap(err, "failed to internals")
+	}
+
+	return nil
+}
+
+func (w *WasmerBuilder) internals() (*wasmer.Module, *wasmer.Store, error) {
+	// instances are built concurrently, so the builder's internals are guarded while they're set up
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
 	if w.module == nil {
-		engine := wasmer.NewEngine()
+		var engine *wasmer.Engine
+		if w.metered() {
+			// the middleware counts the guest's instructions, and is also what interrupts it at its deadline
+			engine = wasmer.NewEngineWithConfig(meteredConfig())
+		} else {
+			engine = wasmer.NewEngine()
+		}
+
 		store := wasmer.NewStore(engine)
 
-		// Compiles the module
-		mod, err := wasmer.NewModule(store, w.ref.Data)
+		data, err := w.config.Prepare(w.ref.Data)
 		if err != nil {
-			return nil, nil, nil, errors.Wrap(err, "failed to NewModule")
+			return nil, nil, errors.Wrap(err, "failed to config.Prepare")
 		}
 
-		env, err := wasmer.NewWasiStateBuilder(w.ref.Name).Finalize()
+		// traps are symbolized against the prepared module, since preparing it can move the code section
+		symbols, err := runtime.NewSymbols(data)
 		if err != nil {
-			return nil, nil, nil, errors.Wrap(err, "failed to NewWasiStateBuilder.Finalize")
+			return nil, nil, errors.Wrap(err, "failed to NewSymbols")
 		}
 
-		imports, err := env.GenerateImportObject(store, mod)
+		// Compiles the module
+		mod, err := w.compile(store, data)
 		if err != nil {
-			imports = wasmer.NewImportObject() // for now, defaulting to creating non-WASI imports if there's a failure.
+			return nil, nil, errors.Wrap(err, "failed to compile")
 		}
 
-		// mount the Runnable API host functions to the module's imports
-		addHostFns(imports, store, w.hostFns...)
+		// the Runnable API host functions are converted once, and each instance's imports are given a set of them
+		w.hostFnSets = &hostFnPool{store: store}
+		for _, fn := range w.hostFns {
+			w.hostFnSets.fns = append(w.hostFnSets.fns, toWasmerHostFn(fn))
+		}
 
 		w.module = mod
 		w.store = store
-		w.imports = imports
+		w.symbols = symbols
+	}
+
+	return w.module, w.store, nil
+}
+
+// imports creates the imports for a new instance, each of which has its own WASI environment and host functions
+func (w *WasmerBuilder) imports(module *wasmer.Module, store *wasmer.Store, memory *guestMemory, host *runtime.HostContext, hostFnSet *hostFnSet) (*wasmer.ImportObject, *wasmer.WasiEnvironment, error) {
+	env, err := wasiEnvironment(w.ref.Name, w.config.WASI)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to wasiEnvironment")
+	}
+
+	imports, err := env.GenerateImportObject(store, module)
+	if err != nil {
+		imports = wasmer.NewImportObject() // for now, defaulting to creating non-WASI imports if there's a failure.
+	}
+
+	w.shadowWASI(imports, memory, host)
+
+	// mount the Runnable API host functions to the module's imports, under each of their namespaces
+	for namespace, externs := range hostFnSet.externs {
+		imports.Register(namespace, externs)
 	}
 
-	return w.module, w.store, w.imports, nil
+	return imports, env, nil
 }
@@ -0,0 +1,76 @@
+package runtimewasmer
+
+import (
+	"github.com/pkg/errors"
+	"github.com/wasmerio/wasmer-go/wasmer"
+
+	"github.com/suborbital/sat/engine/runtime"
+	"github.com/suborbital/sat/engine/runtime/compilecache"
+)
+
+// compile compiles the module, using the compile cache (if configured) to skip compilation when a
+// compatible artifact already exists. Cache failures are logged rather than returned, as the module
+// can always be compiled from scratch.
+func (w *WasmerBuilder) compile(store *wasmer.Store, data []byte) (*wasmer.Module, error) {
+	if w.config.CacheDir == "" {
+		return wasmer.NewModule(store, data)
+	}
+
+	tag, err := w.cacheTag()
+	if err != nil {
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to cacheTag, compiling without cache").Error())
+		return wasmer.NewModule(store, data)
+	}
+
+	cache, err := compilecache.New(w.config.CacheDir)
+	if err != nil {
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to compilecache.New, compiling without cache").Error())
+		return wasmer.NewModule(store, data)
+	}
+
+	key := compilecache.Key(data, tag)
+
+	artifact, err := cache.Load(key)
+	if err == nil {
+		mod, err := wasmer.DeserializeModule(store, artifact)
+		if err == nil {
+			runtime.InternalLogger().Debug("loaded compiled module from cache", key)
+			return mod, nil
+		}
+
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to DeserializeModule, recompiling").Error())
+	} else if !errors.Is(err, compilecache.ErrMiss) {
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to cache.Load, recompiling").Error())
+	}
+
+	mod, err := wasmer.NewModule(store, data)
+	if err != nil {
+		return nil, err
+	}
+
+	artifact, err = mod.Serialize()
+	if err != nil {
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to Serialize compiled module").Error())
+		return mod, nil
+	}
+
+	if err := cache.Store(key, artifact); err != nil {
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to cache.Store").Error())
+	}
+
+	return mod, nil
+}
+
+// cacheTag identifies the version of Wasmer that compiled code depends on, and whether it was instrumented for metering
+func (w *WasmerBuilder) cacheTag() (string, error) {
+	version, err := compilecache.ModuleVersion("github.com/wasmerio/wasmer-go")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to ModuleVersion")
+	}
+
+	if w.metered() {
+		return "wasmer-" + version + "-metered", nil
+	}
+
+	return "wasmer-" + version, nil
+}
@@ -2,7 +2,9 @@ package runtimewasmer
 
 import (
 	"fmt"
+	"sync"
 
+	"github.com/pkg/errors"
 	"github.com/wasmerio/wasmer-go/wasmer"
 
 	"github.com/suborbital/sat/engine/runtime"
@@ -10,70 +12,127 @@ import (
 
 // WasmerHostFn describes a host function callable from within a Runnable module
 type WasmerHostFn struct {
+	module string
 	name   string
 	args   []wasmer.ValueKind
 	ret    []wasmer.ValueKind
-	hostFn func(...wasmer.Value) (interface{}, error)
+	hostFn func(*runtime.HostContext, ...wasmer.Value) ([]interface{}, error)
 }
 
 // toWasmerHostFn creates a new host funcion from a generic host fn
 func toWasmerHostFn(hostFn runtime.HostFn) *WasmerHostFn {
-	retVals := []wasmer.ValueKind{}
-	if hostFn.Returns {
-		retVals = append(retVals, wasmer.I32)
-	}
-
-	args := make([]wasmer.ValueKind, hostFn.ArgCount)
-	for i := 0; i < hostFn.ArgCount; i++ {
-		args[i] = wasmer.I32
-	}
-
 	// create a wasmer-specific representation of the generic host function
 	hfn := &WasmerHostFn{
-		name: hostFn.Name,
-		args: args,
-		ret:  retVals,
-		hostFn: func(wasmerArgs ...wasmer.Value) (interface{}, error) {
-			funcArgs := make([]interface{}, len(wasmerArgs))
-			for i, a := range wasmerArgs {
-				funcArgs[i] = a.I32()
+		module: hostFn.Namespace(),
+		name:   hostFn.Name,
+		args:   valueKinds(hostFn.Params),
+		ret:    valueKinds(hostFn.Results),
+		hostFn: func(host *runtime.HostContext, wasmerArgs ...wasmer.Value) ([]interface{}, error) {
+			// the Swift variant's extra arguments are not passed on
+			funcArgs := make([]interface{}, len(hostFn.Params))
+			for i := range funcArgs {
+				funcArgs[i] = wasmerArgs[i].Unwrap()
 			}
 
-			return hostFn.HostFn(funcArgs...)
+			// wasmer-go frees the traps raised by host functions twice, so rather than crashing the process
+			// errors (such as the guest passing memory out of bounds) are reported and the guest receives zero values
+			result, err := hostFn.Call(host, funcArgs...)
+			if err != nil {
+				runtime.InternalLogger().Error(errors.Wrapf(err, "failed to HostFn for %s", hostFn.Name))
+
+				return hostFn.ResultValues(nil)
+			}
+
+			results, err := hostFn.ResultValues(result)
+			if err != nil {
+				runtime.InternalLogger().Error(errors.Wrap(err, "failed to ResultValues"))
+
+				return hostFn.ResultValues(nil)
+			}
+
+			return results, nil
 		},
 	}
 
 	return hfn
 }
 
-// addHostFns adds a list of host functions to an import object
-func addHostFns(imports *wasmer.ImportObject, store *wasmer.Store, fns ...runtime.HostFn) {
-	externMap := map[string]wasmer.IntoExtern{}
+// hostFnSet is the host functions imported by one instance at a time. The set is the environment of each function,
+// and host is the HostContext of the instance currently importing them, which is how they are given their caller.
+type hostFnSet struct {
+	host *runtime.HostContext
+	// externs holds the functions of each namespace that they are imported from
+	externs map[string]map[string]wasmer.IntoExtern
+}
 
-	for _, fn := range fns {
-		wasmerHostFn := toWasmerHostFn(fn)
+// hostFnPool gives each new instance a set of host functions, recycling those of closed instances. wasmer-go keeps
+// every host function in a process-wide registry that gets slower to add to as it grows, and only removes them once
+// they are collected, so creating functions for every instance would make each one slower to build than the last.
+type hostFnPool struct {
+	store *wasmer.Store
+	fns   []*WasmerHostFn
+	free  []*hostFnSet
+	lock  sync.Mutex
+}
 
-		externMap[wasmerHostFn.fnName()] = wasmerHostFn.toWasmerFn(store)
-		externMap[wasmerHostFn.fnSwiftName()] = wasmerHostFn.toWasmerSwiftFn(store)
+// get returns a set of host functions for an instance with the given HostContext
+func (p *hostFnPool) get(host *runtime.HostContext) *hostFnSet {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if n := len(p.free); n > 0 {
+		set := p.free[n-1]
+		p.free = p.free[:n-1]
+		set.host = host
+
+		return set
 	}
 
-	imports.Register("env", externMap)
+	set := &hostFnSet{host: host, externs: map[string]map[string]wasmer.IntoExtern{}}
+
+	for _, wasmerHostFn := range p.fns {
+		externs, ok := set.externs[wasmerHostFn.module]
+		if !ok {
+			externs = map[string]wasmer.IntoExtern{}
+			set.externs[wasmerHostFn.module] = externs
+		}
+
+		externs[wasmerHostFn.fnName()] = wasmerHostFn.toWasmerFn(p.store, set)
+
+		// only the functions imported from env have Swift variations
+		if wasmerHostFn.module == "env" {
+			externs[wasmerHostFn.fnSwiftName()] = wasmerHostFn.toWasmerSwiftFn(p.store, set)
+		}
+	}
+
+	return set
+}
+
+// put returns the set of host functions of an instance that has been closed
+func (p *hostFnPool) put(set *hostFnSet) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	set.host = nil
+	p.free = append(p.free, set)
 }
 
-func (h *WasmerHostFn) toWasmerFn(store *wasmer.Store) *wasmer.Function {
-	wasmerFn := wasmer.NewFunction(
+func (h *WasmerHostFn) toWasmerFn(store *wasmer.Store, set *hostFnSet) *wasmer.Function {
+	wasmerFn := wasmer.NewFunctionWithEnvironment(
 		store,
 		wasmer.NewFunctionType(h.fnArgs(), h.fnReturns()),
+		set,
 		h.innerFn(),
 	)
 
 	return wasmerFn
 }
 
-func (h *WasmerHostFn) toWasmerSwiftFn(store *wasmer.Store) *wasmer.Function {
-	wasmerFn := wasmer.NewFunction(
+func (h *WasmerHostFn) toWasmerSwiftFn(store *wasmer.Store, set *hostFnSet) *wasmer.Function {
+	wasmerFn := wasmer.NewFunctionWithEnvironment(
 		store,
 		wasmer.NewFunctionType(h.fnSwiftArgs(), h.fnReturns()),
+		set,
 		h.innerFn(),
 	)
 
@@ -108,18 +167,38 @@ func (h *WasmerHostFn) fnReturns() []*wasmer.ValueType {
 }
 
 // innerFn translates wraps the host fn in a Wasmer fn
-func (h *WasmerHostFn) innerFn() func([]wasmer.Value) ([]wasmer.Value, error) {
-	return func(argL []wasmer.Value) ([]wasmer.Value, error) {
-		result, err := h.hostFn(argL...)
+func (h *WasmerHostFn) innerFn() func(interface{}, []wasmer.Value) ([]wasmer.Value, error) {
+	return func(env interface{}, argL []wasmer.Value) ([]wasmer.Value, error) {
+		results, err := h.hostFn(env.(*hostFnSet).host, argL...)
 		if err != nil {
 			return nil, err
 		}
 
-		retVals := []wasmer.Value{}
-		if result != nil {
-			retVals = append(retVals, wasmer.NewValue(result, wasmer.I32))
+		retVals := make([]wasmer.Value, len(results))
+		for i, r := range results {
+			retVals[i] = wasmer.NewValue(r, h.ret[i])
 		}
 
 		return retVals, nil
 	}
 }
+
+// valueKinds maps a host function's types to Wasmer's
+func valueKinds(types []runtime.ValueType) []wasmer.ValueKind {
+	kinds := make([]wasmer.ValueKind, len(types))
+
+	for i, t := range types {
+		switch t {
+		case runtime.I64:
+			kinds[i] = wasmer.I64
+		case runtime.F32:
+			kinds[i] = wasmer.F32
+		case runtime.F64:
+			kinds[i] = wasmer.F64
+		default:
+			kinds[i] = wasmer.I32
+		}
+	}
+
+	return kinds
+}
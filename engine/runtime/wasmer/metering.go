@@ -0,0 +1,123 @@
+package runtimewasmer
+
+/*
+#include <stdbool.h>
+#include <stdint.h>
+
+// wasmer-go doesn't expose the metering middleware that its packaged libwasmer is built with, so the parts of
+// wasmer.h that are needed are declared here, and resolved against the libwasmer that wasmer-go links
+
+typedef struct wasm_config_t wasm_config_t;
+typedef struct wasm_instance_t wasm_instance_t;
+typedef struct wasmer_metering_t wasmer_metering_t;
+typedef struct wasmer_middleware_t wasmer_middleware_t;
+
+typedef uint64_t (*wasmer_metering_cost_function_t)(int wasm_operator);
+
+wasmer_metering_t *wasmer_metering_new(uint64_t initial_limit, wasmer_metering_cost_function_t cost_function);
+wasmer_middleware_t *wasmer_metering_as_middleware(wasmer_metering_t *metering);
+void wasm_config_push_middleware(wasm_config_t *config, wasmer_middleware_t *middleware);
+void wasmer_metering_set_remaining_points(const wasm_instance_t *instance, uint64_t new_limit);
+bool wasmer_metering_points_are_exhausted(const wasm_instance_t *instance);
+
+// every operator costs one point, so fuel counts instructions as it does for the other runtimes
+static uint64_t sat_operator_cost(int wasm_operator) {
+	return 1;
+}
+
+static void sat_push_metering(wasm_config_t *config) {
+	wasm_config_push_middleware(config, wasmer_metering_as_middleware(wasmer_metering_new(UINT64_MAX, sat_operator_cost)));
+}
+*/
+import "C"
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+)
+
+// interruptInterval is how often a guest that has run past its deadline is told again that it has no points left,
+// as the guest's own accounting can overwrite the first time it is told
+const interruptInterval = time.Millisecond
+
+// meteredConfig returns an engine config whose compiler instruments modules with the metering middleware
+func meteredConfig() *wasmer.Config {
+	config := wasmer.NewConfig()
+
+	// the wasm_config_t is the first (and only) field of wasmer-go v1's Config
+	C.sat_push_metering((*C.wasm_config_t)(*(*unsafe.Pointer)(unsafe.Pointer(config))))
+
+	return config
+}
+
+// meter sets and interrupts the metering points of an instance that was compiled with the middleware
+type meter struct {
+	inst *C.wasm_instance_t
+
+	// interrupted is set once the watchdog has taken the instance's points away at its deadline
+	interrupted atomic.Bool
+}
+
+func newMeter(inst *wasmer.Instance) *meter {
+	// the wasm_instance_t is the first field of wasmer-go v1's Instance
+	return &meter{inst: (*C.wasm_instance_t)(*(*unsafe.Pointer)(unsafe.Pointer(inst)))}
+}
+
+// setFuel sets the points that the next invocation can use, which are unlimited if it has no fuel
+func (m *meter) setFuel(fuel uint64) {
+	if fuel == 0 {
+		fuel = math.MaxUint64
+	}
+
+	m.interrupted.Store(false)
+	C.wasmer_metering_set_remaining_points(m.inst, C.uint64_t(fuel))
+}
+
+// exhausted returns whether the guest was stopped for running out of points
+func (m *meter) exhausted() bool {
+	return bool(C.wasmer_metering_points_are_exhausted(m.inst))
+}
+
+// watch takes the instance's points away once the deadline passes, which stops the guest when it next accounts for
+// them. The returned func stops the watchdog, and must be called once the call has returned.
+func (m *meter) watch(deadline time.Time) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		m.interrupted.Store(true)
+
+		ticker := time.NewTicker(interruptInterval)
+		defer ticker.Stop()
+
+		for {
+			C.wasmer_metering_set_remaining_points(m.inst, 0)
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
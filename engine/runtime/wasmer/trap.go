@@ -0,0 +1,35 @@
+package runtimewasmer
+
+import (
+	"strconv"
+
+	"github.com/wasmerio/wasmer-go/wasmer"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// symbolizeTrap converts a Wasmer trap into a Trap with a symbolized backtrace, or returns nil if err isn't a trap
+func symbolizeTrap(err error, symbols *runtime.Symbols) *runtime.Trap {
+	trapErr, ok := err.(*wasmer.TrapError)
+	if !ok {
+		return nil
+	}
+
+	t := &runtime.Trap{
+		Kind:    runtime.TrapKindFromMessage(trapErr.Error()),
+		Message: trapErr.Error(),
+	}
+
+	// Wasmer only reports where each frame is, so the names and source lines come from the module itself
+	for _, f := range trapErr.Trace() {
+		frame := runtime.TrapFrame{Function: "$" + strconv.FormatUint(uint64(f.FunctionIndex()), 10)}
+
+		if symbols != nil {
+			frame = symbols.Frame(f.FunctionIndex(), uint64(f.ModuleOffset()))
+		}
+
+		t.AddFrame(frame)
+	}
+
+	return t
+}
@@ -0,0 +1,124 @@
+package runtimewasmer
+
+import (
+	"github.com/pkg/errors"
+	"github.com/wasmerio/wasmer-go/wasmer"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// wasiModule is the namespace that Wasmer defines WASI in
+const wasiModule = "wasi_snapshot_preview1"
+
+// guestMemory gives the WASI fakes access to the memory of the instance that they are imported into
+type guestMemory struct {
+	inst *wasmer.Instance
+}
+
+// data returns the instance's memory, or nil if the instance doesn't exist yet or has no exported memory
+func (g *guestMemory) data() []byte {
+	if g.inst == nil {
+		return nil
+	}
+
+	memory, err := g.inst.Exports.GetMemory("memory")
+	if err != nil {
+		return nil
+	}
+
+	return memory.Data()
+}
+
+// checkWASI returns an error if Wasmer can't honour the WASI config
+func checkWASI(config runtime.WASI) error {
+	// Wasmer grants every preopened directory full access, and its WASI functions can't be wrapped to prevent that
+	if config.ReadOnlyPreopens() {
+		return runtime.ErrReadOnlyPreopenNotSupported
+	}
+
+	return nil
+}
+
+// wasiEnvironment creates the WASI environment for an instance
+func wasiEnvironment(name string, config runtime.WASI) (*wasmer.WasiEnvironment, error) {
+	if err := checkWASI(config); err != nil {
+		return nil, err
+	}
+
+	// the program name is always passed as the first argument
+	builder := wasmer.NewWasiStateBuilder(name)
+
+	for _, arg := range config.Args {
+		builder.Argument(arg)
+	}
+
+	keys, values := config.Environ()
+	for i := range keys {
+		builder.Environment(keys[i], values[i])
+	}
+
+	for _, p := range config.Preopens {
+		builder.MapDirectory(p.GuestPath(), p.Host)
+	}
+
+	// output is always captured so that it never reaches the host's stdout, streams that aren't logged are discarded
+	builder.CaptureStdout()
+	builder.CaptureStderr()
+
+	env, err := builder.Finalize()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to NewWasiStateBuilder.Finalize")
+	}
+
+	return env, nil
+}
+
+// shadowWASI replaces WASI's clock and random functions with the fakes in the instance's host context unless the module
+// is given the host's sources
+func (w *WasmerBuilder) shadowWASI(imports *wasmer.ImportObject, memory *guestMemory, host *runtime.HostContext) {
+	externMap := map[string]wasmer.IntoExtern{}
+
+	store := w.store
+	clock := host.Clock()
+	random := host.Random()
+
+	i32 := wasmer.NewValueTypes(wasmer.I32)
+
+	if !w.config.WASI.HostClock() {
+		externMap["clock_time_get"] = wasmer.NewFunction(
+			store,
+			wasmer.NewFunctionType(wasmer.NewValueTypes(wasmer.I32, wasmer.I64, wasmer.I32), i32),
+			func(args []wasmer.Value) ([]wasmer.Value, error) {
+				errno := clock.ClockTimeGet(memory.data(), uint32(args[0].I32()), uint32(args[2].I32()))
+
+				return []wasmer.Value{wasmer.NewI32(int32(errno))}, nil
+			},
+		)
+
+		externMap["clock_res_get"] = wasmer.NewFunction(
+			store,
+			wasmer.NewFunctionType(wasmer.NewValueTypes(wasmer.I32, wasmer.I32), i32),
+			func(args []wasmer.Value) ([]wasmer.Value, error) {
+				errno := clock.ClockResGet(memory.data(), uint32(args[0].I32()), uint32(args[1].I32()))
+
+				return []wasmer.Value{wasmer.NewI32(int32(errno))}, nil
+			},
+		)
+	}
+
+	if !w.config.WASI.HostRandom() {
+		externMap["random_get"] = wasmer.NewFunction(
+			store,
+			wasmer.NewFunctionType(wasmer.NewValueTypes(wasmer.I32, wasmer.I32), i32),
+			func(args []wasmer.Value) ([]wasmer.Value, error) {
+				errno := runtime.RandomGet(random, memory.data(), uint32(args[0].I32()), uint32(args[1].I32()))
+
+				return []wasmer.Value{wasmer.NewI32(int32(errno))}, nil
+			},
+		)
+	}
+
+	if len(externMap) > 0 {
+		imports.Register(wasiModule, externMap)
+	}
+}
@@ -1,13 +1,36 @@
 package runtimewasmer
 
 import (
+	"sync/atomic"
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/wasmerio/wasmer-go/wasmer"
+
+	"github.com/suborbital/sat/engine/runtime"
 )
 
 // WasmerRuntime is a Wasmer implementation of the runtimeInstance interface
 type WasmerRuntime struct {
 	inst *wasmer.Instance
+
+	// env holds the module's captured stdout and stderr, which are copied into output
+	env    *wasmer.WasiEnvironment
+	output *runtime.OutputCapture
+
+	symbols *runtime.Symbols
+
+	// meter enforces the invocation's budget, and is nil if the builder's config has none
+	meter *meter
+	// deadline is when the current invocation must be interrupted by, and is zero when it has no timeout
+	deadline time.Time
+	// depth counts the calls in progress, as host functions can call back into the guest
+	depth atomic.Int32
+
+	// host is the HostContext of the instance's host functions, which are returned to hostFnSets once it is closed
+	host       *runtime.HostContext
+	hostFnSet  *hostFnSet
+	hostFnSets *hostFnPool
 }
 
 func (w *WasmerRuntime) Call(fn string, args ...interface{}) (interface{}, error) {
@@ -16,65 +39,145 @@ func (w *WasmerRuntime) Call(fn string, args ...interface{}) (interface{}, error
 		return nil, errors.New("missing required FFI function: " + fn)
 	}
 
-	wasmResult, wasmErr := wasmFunc(args...)
+	wasmResult, wasmErr := w.execute(wasmFunc, args...)
 	if wasmErr != nil {
+		if budgetErr := w.budgetError(); budgetErr != nil {
+			return nil, errors.Wrapf(budgetErr, "failed to wasmFunc %s", fn)
+		}
+
+		if trap := symbolizeTrap(wasmErr, w.symbols); trap != nil {
+			return nil, errors.Wrapf(trap, "failed to wasmFunc %s", fn)
+		}
+
 		return nil, errors.Wrap(wasmErr, "failed to wasmFunc")
 	}
 
 	return wasmResult, nil
 }
 
-// ReadMemory reads memory from the instance
-func (w *WasmerRuntime) ReadMemory(pointer int32, size int32) []byte {
-	memory, err := w.inst.Exports.GetMemory("memory")
-	if err != nil || memory == nil {
-		// we failed
-		return []byte{}
+// execute runs the function, interrupting it if the invocation's deadline passes first. Calls made by host functions
+// run within the outermost call, which is what gets interrupted.
+func (w *WasmerRuntime) execute(wasmFunc wasmer.NativeFunction, args ...interface{}) (interface{}, error) {
+	depth := w.depth.Add(1)
+	defer w.depth.Add(-1)
+
+	if w.meter == nil || w.deadline.IsZero() || depth > 1 {
+		return wasmFunc(args...)
 	}
 
-	data := memory.Data()[pointer:]
-	result := make([]byte, size)
+	// the watchdog is stopped before the instance is released, so it can't interrupt the next invocation
+	stop := w.meter.watch(w.deadline)
+	defer stop()
 
-	copy(result, data)
+	return wasmFunc(args...)
+}
 
-	return result
+// budgetError determines if a failed call was stopped for running out of time or fuel
+func (w *WasmerRuntime) budgetError() error {
+	if w.meter == nil || !w.meter.exhausted() {
+		return nil
+	}
+
+	if w.meter.interrupted.Load() {
+		return runtime.ErrExecutionTimeout
+	}
+
+	return runtime.ErrFuelExhausted
 }
 
-// WriteMemory writes memory into the instance
-func (w *WasmerRuntime) WriteMemory(data []byte) (int32, error) {
-	lengthOfInput := len(data)
+// ReadOutput returns what the module has written to stdout and stderr since it was last called
+func (w *WasmerRuntime) ReadOutput() ([]byte, []byte) {
+	stdout := w.env.ReadStdout()
+	if w.output.Stdout != nil {
+		w.output.Stdout.Write(stdout)
+	}
 
-	allocateResult, err := w.Call("allocate", lengthOfInput)
-	if err != nil {
-		return 0, errors.Wrap(err, "failed to Call allocate")
+	stderr := w.env.ReadStderr()
+	if w.output.Stderr != nil {
+		w.output.Stderr.Write(stderr)
 	}
 
-	pointer := allocateResult.(int32)
+	return w.output.ReadOutput()
+}
 
-	w.WriteMemoryAtLocation(pointer, data)
+// Memory returns the instance's exported memory
+func (w *WasmerRuntime) Memory() []byte {
+	memory, err := w.inst.Exports.GetMemory("memory")
+	if err != nil || memory == nil {
+		return nil
+	}
 
-	return pointer, nil
+	return memory.Data()
 }
 
-// WriteMemoryAtLocation writes memory at the given location
-func (w *WasmerRuntime) WriteMemoryAtLocation(pointer int32, data []byte) {
+// MemoryPages returns the size of the instance's memory in pages
+func (w *WasmerRuntime) MemoryPages() uint32 {
 	memory, err := w.inst.Exports.GetMemory("memory")
 	if err != nil || memory == nil {
-		// we failed
-		return
+		return 0
+	}
+
+	return uint32(memory.Size())
+}
+
+// SetBudget sets the deadline by which the next invocation is interrupted, and the metering points available to it
+func (w *WasmerRuntime) SetBudget(timeout time.Duration, fuel uint64) error {
+	if w.meter == nil {
+		return nil
+	}
+
+	w.deadline = time.Time{}
+	if timeout > 0 {
+		w.deadline = time.Now().Add(timeout)
+	}
+
+	w.meter.setFuel(fuel)
+
+	return nil
+}
+
+// SnapshotGlobals returns the values of the globals exported for snapshots
+func (w *WasmerRuntime) SnapshotGlobals() ([]interface{}, error) {
+	values := []interface{}{}
+
+	for i := 0; ; i++ {
+		global, err := w.inst.Exports.GetGlobal(runtime.SnapshotGlobalName(i))
+		if err != nil || global == nil {
+			return values, nil
+		}
+
+		value, err := global.Get()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to Get global %d", i)
+		}
+
+		values = append(values, value)
 	}
+}
 
-	scopedMemory := memory.Data()[pointer:]
+// RestoreGlobals sets the globals exported for snapshots to the given values
+func (w *WasmerRuntime) RestoreGlobals(values []interface{}) error {
+	for i, value := range values {
+		global, err := w.inst.Exports.GetGlobal(runtime.SnapshotGlobalName(i))
+		if err != nil || global == nil {
+			return errors.Errorf("global %d not found", i)
+		}
+
+		if err := global.Set(value, global.Type().ValueType().Kind()); err != nil {
+			return errors.Wrapf(err, "failed to Set global %d", i)
+		}
+	}
 
-	copy(scopedMemory, data)
+	return nil
 }
 
-// Deallocate deallocates memory in the instance
-func (w *WasmerRuntime) Deallocate(pointer int32, length int) {
-	w.Call("deallocate", pointer, length)
+// HostContext returns the context through which the instance's host functions are given their caller
+func (w *WasmerRuntime) HostContext() *runtime.HostContext {
+	return w.host
 }
 
 // Close closes the instance
 func (w *WasmerRuntime) Close() {
 	w.inst.Close()
+	w.hostFnSets.put(w.hostFnSet)
 }
@@ -1,6 +1,8 @@
 package runtimewasmtime
 
 import (
+	"sync"
+
 	"github.com/bytecodealliance/wasmtime-go/v5"
 	"github.com/pkg/errors"
 
@@ -14,16 +16,36 @@ import (
 type WasmtimeBuilder struct {
 	ref     *tenant.WasmModuleRef
 	hostFns []runtime.HostFn
+	config  runtime.Config
 	module  *wasmtime.Module
 	engine  *wasmtime.Engine
 	linker  *wasmtime.Linker
+	symbols *runtime.Symbols
+	hosts   *hostContexts
+	shadows *wasiShadows
+	lock    sync.Mutex
+}
+
+func init() {
+	runtime.RegisterBackend("wasmtime", newBuilder)
 }
 
 // NewBuilder creates a new WasmtimeBuilder
 func NewBuilder(ref *tenant.WasmModuleRef, api api.HostAPI) runtime.RuntimeBuilder {
+	return newBuilder(ref, api.HostFunctions(), runtime.Config{})
+}
+
+// NewBuilderWithConfig creates a new WasmtimeBuilder whose instances are built and run with the given config
+func NewBuilderWithConfig(ref *tenant.WasmModuleRef, api api.HostAPI, config runtime.Config) runtime.RuntimeBuilder {
+	return newBuilder(ref, api.HostFunctions(), config)
+}
+
+func newBuilder(ref *tenant.WasmModuleRef, hostFns []runtime.HostFn, config runtime.Config) runtime.RuntimeBuilder {
 	w := &WasmtimeBuilder{
 		ref:     ref,
-		hostFns: api.HostFunctions(),
+		hostFns: hostFns,
+		config:  config,
+		hosts:   &hostContexts{},
 	}
 
 	return w
@@ -35,25 +57,58 @@ func (w *WasmtimeBuilder) New() (runtime.RuntimeInstance, error) {
 		return nil, errors.Wrap(err, "failed to internals")
 	}
 
+	wasi, err := wasiConfig(w.ref.Name, w.config.WASI)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to wasiConfig")
+	}
+
+	output := runtime.NewOutputCapture(w.config.Output)
+
 	store := wasmtime.NewStore(engine)
+	store.SetWasi(wasi)
+
+	host := runtime.NewHostContext()
+	w.hosts.add(store, host)
 
-	wasiConfig := wasmtime.NewWasiConfig()
-	store.SetWasi(wasiConfig)
+	if w.shadows != nil {
+		w.shadows.add(store, output)
+	}
+
+	inst := &WasmtimeInstance{
+		store:   store,
+		output:  output,
+		symbols: w.symbols,
+		host:    host,
+		hosts:   w.hosts,
+		shadows: w.shadows,
+	}
 
 	wasmTimeInst, err := linker.Instantiate(store, module)
 	if err != nil {
+		inst.Close()
 		return nil, errors.Wrap(err, "failed to linker.Instantiate")
 	}
 
-	inst := &WasmtimeInstance{
-		inst:  *wasmTimeInst,
-		store: store,
+	inst.inst = *wasmTimeInst
+
+	if w.shadows != nil {
+		if err := w.shadows.link(store, wasmTimeInst); err != nil {
+			inst.Close()
+			return nil, errors.Wrap(err, "failed to shadows.link")
+		}
+	}
+
+	// _start runs under the same budget as a normal invocation
+	if err := inst.SetBudget(w.config.Timeout, w.config.Fuel); err != nil {
+		inst.Close()
+		return nil, errors.Wrap(err, "failed to SetBudget")
 	}
 
 	if _, err := inst.Call("_start"); err != nil {
 		if errors.Is(err, runtime.ErrExportNotFound) {
 			// that's ok, not all modules will have _start
 		} else {
+			inst.Close()
 			return nil, errors.Wrap(err, "failed to call exported _start")
 		}
 	}
@@ -63,14 +118,38 @@ func (w *WasmtimeBuilder) New() (runtime.RuntimeInstance, error) {
 	return inst, nil
 }
 
+// Precompile compiles the module without instantiating it, which fills the compile cache
+func (w *WasmtimeBuilder) Precompile() error {
+	if _, _, _, err := w.internals(); err != nil {
+		return errors.Wrap(err, "failed to internals")
+	}
+
+	return nil
+}
+
 func (w *WasmtimeBuilder) internals() (*wasmtime.Module, *wasmtime.Engine, *wasmtime.Linker, error) {
+	// instances are built concurrently, so the builder's internals are guarded while they're set up
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
 	if w.module == nil {
-		engine := wasmtime.NewEngine()
+		engine := sharedEngine(engineSettings{epochs: w.config.Timeout > 0, fuel: w.config.Fuel > 0})
+
+		data, err := w.config.Prepare(w.ref.Data)
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "failed to config.Prepare")
+		}
+
+		// traps are symbolized against the prepared module, since preparing it can move the code section
+		symbols, err := runtime.NewSymbols(data)
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "failed to NewSymbols")
+		}
 
 		// Compiles the module
-		mod, err := wasmtime.NewModule(engine, w.ref.Data)
+		mod, err := w.compile(engine, data)
 		if err != nil {
-			return nil, nil, nil, errors.Wrap(err, "failed to NewModule")
+			return nil, nil, nil, errors.Wrap(err, "failed to compile")
 		}
 
 		// Create a linker with WASI functions defined within it
@@ -79,12 +158,29 @@ func (w *WasmtimeBuilder) internals() (*wasmtime.Module, *wasmtime.Engine, *wasm
 			return nil, nil, nil, errors.Wrap(err, "failed to DefineWasi")
 		}
 
+		if err := shadowWASI(linker, w.config.WASI, w.hosts); err != nil {
+			return nil, nil, nil, errors.Wrap(err, "failed to shadowWASI")
+		}
+
+		shadows, err := newWASIShadows(engine, append(readOnlyFuncs(w.config.WASI), outputFuncs(w.config.Output)...))
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "failed to newWASIShadows")
+		}
+
+		if shadows != nil {
+			if err := shadows.shadow(linker); err != nil {
+				return nil, nil, nil, errors.Wrap(err, "failed to shadows.shadow")
+			}
+		}
+
 		// mount the Runnable API
-		addHostFns(linker, w.hostFns...)
+		addHostFns(linker, w.hosts, w.hostFns...)
 
 		w.module = mod
 		w.engine = engine
 		w.linker = linker
+		w.symbols = symbols
+		w.shadows = shadows
 	}
 
 	return w.module, w.engine, w.linker, nil
@@ -0,0 +1,74 @@
+package runtimewasmtime
+
+import (
+	"fmt"
+
+	"github.com/bytecodealliance/wasmtime-go/v5"
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/runtime"
+	"github.com/suborbital/sat/engine/runtime/compilecache"
+)
+
+// compile compiles the module, using the compile cache (if configured) to skip compilation when a
+// compatible artifact already exists. Cache failures are logged rather than returned, as the module
+// can always be compiled from scratch.
+func (w *WasmtimeBuilder) compile(engine *wasmtime.Engine, data []byte) (*wasmtime.Module, error) {
+	if w.config.CacheDir == "" {
+		return wasmtime.NewModule(engine, data)
+	}
+
+	tag, err := w.cacheTag()
+	if err != nil {
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to cacheTag, compiling without cache").Error())
+		return wasmtime.NewModule(engine, data)
+	}
+
+	cache, err := compilecache.New(w.config.CacheDir)
+	if err != nil {
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to compilecache.New, compiling without cache").Error())
+		return wasmtime.NewModule(engine, data)
+	}
+
+	key := compilecache.Key(data, tag)
+
+	artifact, err := cache.Load(key)
+	if err == nil {
+		mod, err := wasmtime.NewModuleDeserialize(engine, artifact)
+		if err == nil {
+			runtime.InternalLogger().Debug("loaded compiled module from cache", key)
+			return mod, nil
+		}
+
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to NewModuleDeserialize, recompiling").Error())
+	} else if !errors.Is(err, compilecache.ErrMiss) {
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to cache.Load, recompiling").Error())
+	}
+
+	mod, err := wasmtime.NewModule(engine, data)
+	if err != nil {
+		return nil, err
+	}
+
+	artifact, err = mod.Serialize()
+	if err != nil {
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to Serialize compiled module").Error())
+		return mod, nil
+	}
+
+	if err := cache.Store(key, artifact); err != nil {
+		runtime.InternalLogger().Warn(errors.Wrap(err, "failed to cache.Store").Error())
+	}
+
+	return mod, nil
+}
+
+// cacheTag identifies the version of Wasmtime and the engine settings that compiled code depends on
+func (w *WasmtimeBuilder) cacheTag() (string, error) {
+	version, err := compilecache.ModuleVersion("github.com/bytecodealliance/wasmtime-go/v5")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to ModuleVersion")
+	}
+
+	return fmt.Sprintf("wasmtime-%s-epoch-%t-fuel-%t", version, w.config.Timeout > 0, w.config.Fuel > 0), nil
+}
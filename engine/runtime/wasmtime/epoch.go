@@ -0,0 +1,69 @@
+package runtimewasmtime
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bytecodealliance/wasmtime-go/v5"
+)
+
+// epochInterval is how often an engine's epoch advances when execution deadlines are enabled,
+// which is also the granularity at which a running guest can be interrupted
+const epochInterval = 10 * time.Millisecond
+
+// engineSettings are the settings that an engine is created with
+type engineSettings struct {
+	epochs bool
+	fuel   bool
+}
+
+// engines are shared by every builder with the same settings. Builders are created for each version of a module, and
+// for trial builds and precompiling, so an engine of their own would each need a ticker that outlives them; instead
+// the engine that interrupts guests has a single ticker that runs for as long as the process.
+var engines = struct {
+	sync.Mutex
+	bySettings map[engineSettings]*wasmtime.Engine
+}{bySettings: map[engineSettings]*wasmtime.Engine{}}
+
+// sharedEngine returns the engine with the settings, creating it the first time that it's needed
+func sharedEngine(settings engineSettings) *wasmtime.Engine {
+	engines.Lock()
+	defer engines.Unlock()
+
+	if engine, exists := engines.bySettings[settings]; exists {
+		return engine
+	}
+
+	config := wasmtime.NewConfig()
+	config.SetEpochInterruption(settings.epochs)
+	config.SetConsumeFuel(settings.fuel)
+
+	engine := wasmtime.NewEngineWithConfig(config)
+
+	if settings.epochs {
+		go tickEpochs(engine)
+	}
+
+	engines.bySettings[settings] = engine
+
+	return engine
+}
+
+// tickEpochs advances the engine's epoch forever so that stores with an epoch deadline get interrupted
+func tickEpochs(engine *wasmtime.Engine) {
+	ticker := time.NewTicker(epochInterval)
+
+	for range ticker.C {
+		engine.IncrementEpoch()
+	}
+}
+
+// epochTicks converts a timeout into a number of epoch ticks, rounding up
+func epochTicks(timeout time.Duration) uint64 {
+	ticks := uint64(timeout / epochInterval)
+	if timeout%epochInterval != 0 {
+		ticks++
+	}
+
+	return ticks
+}
@@ -2,6 +2,8 @@ package runtimewasmtime
 
 import (
 	"fmt"
+	"sync"
+	"unsafe"
 
 	"github.com/bytecodealliance/wasmtime-go/v5"
 	"github.com/pkg/errors"
@@ -11,52 +13,82 @@ import (
 
 var i32Type = wasmtime.NewValType(wasmtime.KindI32)
 
-// addHostFns adds a list of host functions to an import object
-func addHostFns(linker *wasmtime.Linker, fns ...runtime.HostFn) {
+// hostContexts maps the stores of a builder's instances to their HostContexts. wasmtime-go doesn't give stores data
+// of their own, so host functions (which the linker shares between stores) look their caller's store up here.
+type hostContexts struct {
+	stores sync.Map
+}
+
+// add links a store to its instance's HostContext
+func (h *hostContexts) add(store *wasmtime.Store, host *runtime.HostContext) {
+	h.stores.Store(storeKey(store), host)
+}
+
+// remove unlinks a store from its instance's HostContext
+func (h *hostContexts) remove(store *wasmtime.Store) {
+	h.stores.Delete(storeKey(store))
+}
+
+// forCaller returns the HostContext of the store that a host function was called from
+func (h *hostContexts) forCaller(caller *wasmtime.Caller) *runtime.HostContext {
+	host, ok := h.stores.Load(storeKey(caller))
+	if !ok {
+		return nil
+	}
+
+	return host.(*runtime.HostContext)
+}
+
+// storeKey identifies a store by its context, which is the same whether it is reached through the store or a caller
+func storeKey(store wasmtime.Storelike) uintptr {
+	return uintptr(unsafe.Pointer(store.Context()))
+}
+
+// addHostFns adds a list of host functions to a linker, which resolve their caller's HostContext from hosts
+func addHostFns(linker *wasmtime.Linker, hosts *hostContexts, fns ...runtime.HostFn) {
 	for i := range fns {
 		// we create a copy inside the loop otherwise things get overwritten
 		fn := fns[i]
 
-		// all function params are currently expressed as i32s, which will be improved upon
-		// in the future with the introduction of witx-bindgen and/or interface types
-		params := make([]*wasmtime.ValType, fn.ArgCount)
-		for i := 0; i < fn.ArgCount; i++ {
-			params[i] = i32Type
-		}
-
-		returns := []*wasmtime.ValType{}
-		if fn.Returns {
-			returns = append(returns, i32Type)
-		}
+		params := valTypes(fn.Params)
+		returns := valTypes(fn.Results)
 
 		fnType := wasmtime.NewFuncType(params, returns)
 
 		// this is reused across the normal and Swift variations of the function
-		wasmtimeFunc := func(_ *wasmtime.Caller, args []wasmtime.Val) ([]wasmtime.Val, *wasmtime.Trap) {
-			hostArgs := make([]interface{}, fn.ArgCount)
+		wasmtimeFunc := func(caller *wasmtime.Caller, args []wasmtime.Val) ([]wasmtime.Val, *wasmtime.Trap) {
+			hostArgs := make([]interface{}, len(fn.Params))
 
 			// args can be longer than hostArgs (swift, lame), so use hostArgs to control the loop
 			for i := range hostArgs {
-				ha := args[i].I32()
-				hostArgs[i] = ha
+				hostArgs[i] = args[i].Get()
 			}
 
-			result, err := fn.HostFn(hostArgs...)
+			result, err := fn.Call(hosts.forCaller(caller), hostArgs...)
 			if err != nil {
 				return nil, wasmtime.NewTrap(errors.Wrapf(err, "failed to HostFn for %s", fn.Name).Error())
 			}
 
-			// function may return nothing, so nil check before trying to convert it
-			returnVals := []wasmtime.Val{}
-			if result != nil {
-				returnVals = append(returnVals, wasmtime.ValI32(result.(int32)))
+			results, err := fn.ResultValues(result)
+			if err != nil {
+				return nil, wasmtime.NewTrap(errors.Wrap(err, "failed to ResultValues").Error())
+			}
+
+			returnVals := make([]wasmtime.Val, len(results))
+			for i, r := range results {
+				returnVals[i] = toVal(r)
 			}
 
 			return returnVals, nil
 		}
 
 		// this can return an error but there's nothing we can do about it
-		_ = linker.FuncNew("env", fn.Name, fnType, wasmtimeFunc)
+		_ = linker.FuncNew(fn.Namespace(), fn.Name, fnType, wasmtimeFunc)
+
+		// only the functions imported from env have Swift variations
+		if fn.Module != "" {
+			continue
+		}
 
 		// add swift params and mount swift variation
 		params = append(params, i32Type, i32Type)
@@ -65,3 +97,37 @@ func addHostFns(linker *wasmtime.Linker, fns ...runtime.HostFn) {
 		_ = linker.FuncNew("env", fmt.Sprintf("%s_swift", fn.Name), swiftFnType, wasmtimeFunc)
 	}
 }
+
+// valTypes maps a host function's types to Wasmtime's
+func valTypes(types []runtime.ValueType) []*wasmtime.ValType {
+	valTypes := make([]*wasmtime.ValType, len(types))
+
+	for i, t := range types {
+		switch t {
+		case runtime.I64:
+			valTypes[i] = wasmtime.NewValType(wasmtime.KindI64)
+		case runtime.F32:
+			valTypes[i] = wasmtime.NewValType(wasmtime.KindF32)
+		case runtime.F64:
+			valTypes[i] = wasmtime.NewValType(wasmtime.KindF64)
+		default:
+			valTypes[i] = i32Type
+		}
+	}
+
+	return valTypes
+}
+
+// toVal converts a value checked by ResultValues to a Wasmtime value
+func toVal(value interface{}) wasmtime.Val {
+	switch v := value.(type) {
+	case int64:
+		return wasmtime.ValI64(v)
+	case float32:
+		return wasmtime.ValF32(v)
+	case float64:
+		return wasmtime.ValF64(v)
+	}
+
+	return wasmtime.ValI32(value.(int32))
+}
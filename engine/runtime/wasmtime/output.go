@@ -0,0 +1,64 @@
+package runtimewasmtime
+
+import (
+	"encoding/binary"
+
+	"github.com/bytecodealliance/wasmtime-go/v5"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// errnoFault is the WASI errno returned when a pointer is outside of the guest's memory
+const errnoFault = 21
+
+// outputFuncs captures an instance's output streams. Wasmtime can only redirect WASI output to a file, which would
+// cost each instance a descriptor per stream and grow for as long as the instance lives, so fd_write is shadowed
+// instead and writes to stdout and stderr go straight to the store's capture.
+func outputFuncs(config runtime.Output) []shadowFunc {
+	if config.Stdout() == runtime.LogLevelNone && config.Stderr() == runtime.LogLevelNone {
+		return nil
+	}
+
+	return []shadowFunc{{name: "fd_write", params: []wasmtime.ValKind{i32, i32, i32, i32}, handle: captureWrite}}
+}
+
+// captureWrite writes the iovecs that the guest writes to a captured stream into its buffer
+func captureWrite(caller *wasmtime.Caller, store *shadowStore, args []wasmtime.Val) (int32, bool) {
+	var buffer *runtime.OutputBuffer
+
+	switch args[0].I32() {
+	case 1:
+		buffer = store.output.Stdout
+	case 2:
+		buffer = store.output.Stderr
+	}
+
+	if buffer == nil {
+		return 0, false
+	}
+
+	memory := guestMemory(caller)
+	iovs, iovsLen, written := uint64(uint32(args[1].I32())), uint64(uint32(args[2].I32())), uint64(uint32(args[3].I32()))
+
+	if iovs+iovsLen*8 > uint64(len(memory)) || written+4 > uint64(len(memory)) {
+		return errnoFault, true
+	}
+
+	total := uint32(0)
+
+	for i := uint64(0); i < iovsLen; i++ {
+		pointer := uint64(binary.LittleEndian.Uint32(memory[iovs+i*8:]))
+		size := uint64(binary.LittleEndian.Uint32(memory[iovs+i*8+4:]))
+
+		if pointer+size > uint64(len(memory)) {
+			return errnoFault, true
+		}
+
+		buffer.Write(memory[pointer : pointer+size])
+		total += uint32(size)
+	}
+
+	binary.LittleEndian.PutUint32(memory[written:], total)
+
+	return 0, true
+}
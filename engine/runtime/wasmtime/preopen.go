@@ -0,0 +1,100 @@
+package runtimewasmtime
+
+import (
+	"github.com/bytecodealliance/wasmtime-go/v5"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+const (
+	// errnoROFS and errnoNotCapable are the WASI errnos returned for writes refused by a read-only preopen
+	errnoROFS       = 69
+	errnoNotCapable = 76
+
+	// oflagsWrite are the path_open flags that create or truncate a file
+	oflagsWrite = 1<<0 | 1<<3
+
+	// rightsWrite are the WASI rights that change a file or directory (datasync, fd_write, fd_allocate, creating,
+	// linking, renaming and removing paths, setting sizes and times, and creating symlinks)
+	rightsWrite = 1<<0 | 1<<6 | 1<<8 | 1<<9 | 1<<10 | 1<<11 | 1<<12 | 1<<16 | 1<<17 | 1<<19 | 1<<20 | 1<<22 | 1<<23 |
+		1<<24 | 1<<25 | 1<<26
+)
+
+// fsFunc is a WASI function that is shadowed for read-only preopens, refused when any of the descriptors in fds is one
+type fsFunc struct {
+	name   string
+	params []wasmtime.ValKind
+	fds    []int
+	errno  int32
+}
+
+var fsFuncs = []fsFunc{
+	{name: "path_open", params: []wasmtime.ValKind{i32, i32, i32, i32, i32, i64, i64, i32, i32}},
+	{name: "path_create_directory", params: []wasmtime.ValKind{i32, i32, i32}, fds: []int{0}, errno: errnoROFS},
+	{name: "path_filestat_set_times", params: []wasmtime.ValKind{i32, i32, i32, i32, i64, i64, i32}, fds: []int{0}, errno: errnoROFS},
+	{name: "path_link", params: []wasmtime.ValKind{i32, i32, i32, i32, i32, i32, i32}, fds: []int{0, 4}, errno: errnoROFS},
+	{name: "path_remove_directory", params: []wasmtime.ValKind{i32, i32, i32}, fds: []int{0}, errno: errnoROFS},
+	{name: "path_rename", params: []wasmtime.ValKind{i32, i32, i32, i32, i32, i32}, fds: []int{0, 3}, errno: errnoROFS},
+	{name: "path_symlink", params: []wasmtime.ValKind{i32, i32, i32, i32, i32}, fds: []int{2}, errno: errnoROFS},
+	{name: "path_unlink_file", params: []wasmtime.ValKind{i32, i32, i32}, fds: []int{0}, errno: errnoROFS},
+	{name: "fd_filestat_set_times", params: []wasmtime.ValKind{i32, i64, i64, i32}, fds: []int{0}, errno: errnoROFS},
+	// a read-only preopen can't be moved to another descriptor, or replaced by one that isn't read-only
+	{name: "fd_renumber", params: []wasmtime.ValKind{i32, i32}, fds: []int{0, 1}, errno: errnoNotCapable},
+}
+
+// readOnlyFuncs enforces read-only preopened directories, which Wasmtime would otherwise give full access to. WASI's
+// filesystem functions are shadowed so that they refuse to change anything through a read-only preopen, and so that
+// what is opened through one is only given the rights to read. Wasmtime never gives a descriptor more rights than the
+// one that it was opened from, so everything beneath a read-only preopen is read-only.
+func readOnlyFuncs(config runtime.WASI) []shadowFunc {
+	if !config.ReadOnlyPreopens() {
+		return nil
+	}
+
+	// Wasmtime numbers the preopens from 3 in the order they're configured
+	fds := map[int32]bool{}
+	for i, p := range config.Preopens {
+		if !p.Writable {
+			fds[int32(3+i)] = true
+		}
+	}
+
+	funcs := make([]shadowFunc, len(fsFuncs))
+	for i := range fsFuncs {
+		fn := fsFuncs[i]
+
+		funcs[i] = shadowFunc{name: fn.name, params: fn.params, handle: func(_ *wasmtime.Caller, _ *shadowStore, args []wasmtime.Val) (int32, bool) {
+			errno := refuse(fds, fn, args)
+			return errno, errno != 0
+		}}
+	}
+
+	return funcs
+}
+
+// refuse returns the errno for a call that would change a read-only preopen, or 0 if it can go ahead. Opening a path
+// through a read-only preopen strips the rights to write from args, and is refused if it would create or truncate.
+func refuse(fds map[int32]bool, fn fsFunc, args []wasmtime.Val) int32 {
+	if fn.name == "path_open" {
+		if !fds[args[0].I32()] {
+			return 0
+		}
+
+		if args[4].I32()&oflagsWrite != 0 {
+			return errnoROFS
+		}
+
+		args[5] = wasmtime.ValI64(args[5].I64() &^ rightsWrite)
+		args[6] = wasmtime.ValI64(args[6].I64() &^ rightsWrite)
+
+		return 0
+	}
+
+	for _, i := range fn.fds {
+		if fds[args[i].I32()] {
+			return fn.errno
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,204 @@
+package runtimewasmtime
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bytecodealliance/wasmtime-go/v5"
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+const i32, i64 = wasmtime.KindI32, wasmtime.KindI64
+
+// shadowFunc is a WASI function whose behaviour Wasmtime can't be configured for, so it is shadowed by one that calls
+// handle. handle returns the errno of a call that it has dealt with, or false for one that the original should make.
+type shadowFunc struct {
+	name   string
+	params []wasmtime.ValKind
+	handle func(caller *wasmtime.Caller, store *shadowStore, args []wasmtime.Val) (int32, bool)
+}
+
+// shadowStore is what the shadows need of an instance's store
+type shadowStore struct {
+	output     *runtime.OutputCapture
+	trampoline *wasmtime.Instance
+}
+
+// wasiShadows replaces WASI functions in a builder's linker. The originals read and write the memory exported by the
+// instance that calls them, which a host function isn't, so they are called through a trampoline module that is
+// created for each store with the memory of its instance.
+type wasiShadows struct {
+	funcs []shadowFunc
+	// wasi defines the original WASI functions, which the trampolines import
+	wasi       *wasmtime.Linker
+	trampoline *wasmtime.Module
+	stores     sync.Map
+}
+
+// newWASIShadows returns nil if there are no funcs to shadow
+func newWASIShadows(engine *wasmtime.Engine, funcs []shadowFunc) (*wasiShadows, error) {
+	if len(funcs) == 0 {
+		return nil, nil
+	}
+
+	s := &wasiShadows{funcs: funcs, wasi: wasmtime.NewLinker(engine)}
+
+	if err := s.wasi.DefineWasi(); err != nil {
+		return nil, errors.Wrap(err, "failed to DefineWasi")
+	}
+
+	wasm, err := wasmtime.Wat2Wasm(s.trampolineWAT())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to Wat2Wasm")
+	}
+
+	if s.trampoline, err = wasmtime.NewModule(engine, wasm); err != nil {
+		return nil, errors.Wrap(err, "failed to NewModule")
+	}
+
+	return s, nil
+}
+
+// trampolineWAT is a module that calls the original WASI functions. WASI reads and writes the memory exported by the
+// instance that calls it, so the trampoline exports the memory of the instance that it is created for.
+func (s *wasiShadows) trampolineWAT() string {
+	b := &strings.Builder{}
+	b.WriteString("(module\n  (import \"env\" \"memory\" (memory 0))\n  (export \"memory\" (memory 0))\n")
+
+	for _, module := range wasiModules {
+		for _, fn := range s.funcs {
+			params := make([]string, len(fn.params))
+			for i, kind := range fn.params {
+				params[i] = kind.String()
+			}
+
+			fmt.Fprintf(b, "  (import %q %q (func $%s.%s (param %s) (result i32)))\n", module, fn.name, module, fn.name, strings.Join(params, " "))
+		}
+	}
+
+	for _, module := range wasiModules {
+		for _, fn := range s.funcs {
+			fmt.Fprintf(b, "  (func (export \"%s.%s\") (param", module, fn.name)
+			for _, kind := range fn.params {
+				fmt.Fprintf(b, " %s", kind)
+			}
+
+			b.WriteString(") (result i32)")
+			for i := range fn.params {
+				fmt.Fprintf(b, " local.get %d", i)
+			}
+
+			fmt.Fprintf(b, " call $%s.%s)\n", module, fn.name)
+		}
+	}
+
+	b.WriteString(")\n")
+
+	return b.String()
+}
+
+// shadow replaces the linker's WASI functions with the shadows
+func (s *wasiShadows) shadow(linker *wasmtime.Linker) error {
+	linker.AllowShadowing(true)
+	defer linker.AllowShadowing(false)
+
+	for _, module := range wasiModules {
+		for i := range s.funcs {
+			fn := s.funcs[i]
+			symbol := module + "." + fn.name
+
+			params := make([]*wasmtime.ValType, len(fn.params))
+			for i, kind := range fn.params {
+				params[i] = wasmtime.NewValType(kind)
+			}
+
+			fnType := wasmtime.NewFuncType(params, []*wasmtime.ValType{i32Type})
+
+			if err := linker.FuncNew(module, fn.name, fnType, func(caller *wasmtime.Caller, args []wasmtime.Val) ([]wasmtime.Val, *wasmtime.Trap) {
+				store, ok := s.stores.Load(storeKey(caller))
+				if !ok {
+					return nil, wasmtime.NewTrap(fmt.Sprintf("failed to call %s, the store is closed", symbol))
+				}
+
+				if errno, handled := fn.handle(caller, store.(*shadowStore), args); handled {
+					return []wasmtime.Val{wasmtime.ValI32(errno)}, nil
+				}
+
+				return s.callOriginal(caller, store.(*shadowStore), symbol, args)
+			}); err != nil {
+				return errors.Wrapf(err, "failed to FuncNew %s", symbol)
+			}
+		}
+	}
+
+	return nil
+}
+
+// add sets up the shadows for a store before its instance is created
+func (s *wasiShadows) add(store *wasmtime.Store, output *runtime.OutputCapture) {
+	s.stores.Store(storeKey(store), &shadowStore{output: output})
+}
+
+// link creates the trampoline of an instance's store, which must happen before the instance calls WASI
+func (s *wasiShadows) link(store *wasmtime.Store, inst *wasmtime.Instance) error {
+	// without an exported memory, WASI can't be used at all
+	memory := inst.GetExport(store, "memory")
+	if memory == nil || memory.Memory() == nil {
+		return nil
+	}
+
+	imports := []wasmtime.AsExtern{memory.Memory()}
+
+	for _, module := range wasiModules {
+		for _, fn := range s.funcs {
+			extern := s.wasi.Get(store, module, fn.name)
+			if extern == nil {
+				return errors.Errorf("WASI does not define %s.%s", module, fn.name)
+			}
+
+			imports = append(imports, extern)
+		}
+	}
+
+	trampoline, err := wasmtime.NewInstance(store, s.trampoline, imports)
+	if err != nil {
+		return errors.Wrap(err, "failed to NewInstance")
+	}
+
+	if state, ok := s.stores.Load(storeKey(store)); ok {
+		state.(*shadowStore).trampoline = trampoline
+	}
+
+	return nil
+}
+
+// remove drops a store's shadow state
+func (s *wasiShadows) remove(store *wasmtime.Store) {
+	s.stores.Delete(storeKey(store))
+}
+
+// callOriginal calls the original WASI function through the store's trampoline
+func (s *wasiShadows) callOriginal(caller *wasmtime.Caller, store *shadowStore, symbol string, args []wasmtime.Val) ([]wasmtime.Val, *wasmtime.Trap) {
+	if store.trampoline == nil {
+		return nil, wasmtime.NewTrap(fmt.Sprintf("failed to call %s, the module exports no memory", symbol))
+	}
+
+	params := make([]interface{}, len(args))
+	for i := range args {
+		params[i] = args[i].Get()
+	}
+
+	result, err := store.trampoline.GetFunc(caller, symbol).Call(caller, params...)
+	if err != nil {
+		if trap, ok := err.(*wasmtime.Trap); ok {
+			return nil, trap
+		}
+
+		return nil, wasmtime.NewTrap(errors.Wrapf(err, "failed to Call %s", symbol).Error())
+	}
+
+	return []wasmtime.Val{wasmtime.ValI32(result.(int32))}, nil
+}
@@ -0,0 +1,82 @@
+package runtimewasmtime
+
+import (
+	"strings"
+
+	"github.com/bytecodealliance/wasmtime-go/v5"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// trapKinds maps Wasmtime's trap codes to the kinds of trap that sat reports
+var trapKinds = map[wasmtime.TrapCode]runtime.TrapKind{
+	wasmtime.StackOverflow:          runtime.TrapStackOverflow,
+	wasmtime.MemoryOutOfBounds:      runtime.TrapOutOfBounds,
+	wasmtime.HeapMisaligned:         runtime.TrapOutOfBounds,
+	wasmtime.TableOutOfBounds:       runtime.TrapOutOfBounds,
+	wasmtime.IndirectCallToNull:     runtime.TrapIndirectCall,
+	wasmtime.BadSignature:           runtime.TrapIndirectCall,
+	wasmtime.IntegerOverflow:        runtime.TrapIntegerOverflow,
+	wasmtime.BadConversionToInteger: runtime.TrapIntegerOverflow,
+	wasmtime.IntegerDivisionByZero:  runtime.TrapIntegerDivide,
+	wasmtime.UnreachableCodeReached: runtime.TrapUnreachable,
+}
+
+// symbolizeTrap converts a Wasmtime trap into a Trap with a symbolized backtrace, or returns nil if err isn't a trap
+func symbolizeTrap(err error, symbols *runtime.Symbols) *runtime.Trap {
+	// the traps raised by host functions are returned as errors, which have a message but no frames
+	if hostErr, ok := err.(*wasmtime.Error); ok {
+		if _, exited := hostErr.ExitStatus(); exited {
+			return nil
+		}
+
+		message := trapMessage(hostErr.Error())
+
+		return &runtime.Trap{Kind: runtime.TrapKindFromMessage(message), Message: message}
+	}
+
+	trap, ok := err.(*wasmtime.Trap)
+	if !ok {
+		return nil
+	}
+
+	message := trapMessage(trap.Message())
+
+	t := &runtime.Trap{
+		Kind:    runtime.TrapKindFromMessage(message),
+		Message: message,
+	}
+
+	if code := trap.Code(); code != nil {
+		if kind, ok := trapKinds[*code]; ok {
+			t.Kind = kind
+		}
+	}
+
+	for _, f := range trap.Frames() {
+		frame := runtime.TrapFrame{}
+
+		if symbols != nil {
+			frame = symbols.Frame(f.FuncIndex(), uint64(f.ModuleOffset()))
+		}
+
+		if name := f.FuncName(); name != nil {
+			frame.Function = *name
+		}
+
+		t.AddFrame(frame)
+	}
+
+	return t
+}
+
+// trapMessage strips the backtrace that Wasmtime puts in a trap's message, which the frames replace, leaving the cause
+func trapMessage(message string) string {
+	if _, cause, ok := strings.Cut(message, "Caused by:"); ok {
+		message = strings.TrimSpace(cause)
+	}
+
+	first, _, _ := strings.Cut(message, "\n")
+
+	return strings.TrimPrefix(first, "wasm trap: ")
+}
@@ -0,0 +1,70 @@
+package runtimewasmtime
+
+import (
+	"github.com/bytecodealliance/wasmtime-go/v5"
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// wasiModules are the namespaces that Wasmtime defines WASI in
+var wasiModules = []string{"wasi_snapshot_preview1", "wasi_unstable"}
+
+// wasiConfig creates the WASI context for an instance's store. Wasmtime gives every preopened directory full access,
+// so read-only preopens are enforced by shadowing its filesystem functions with readOnlyFuncs.
+func wasiConfig(name string, config runtime.WASI) (*wasmtime.WasiConfig, error) {
+	wasiConfig := wasmtime.NewWasiConfig()
+	wasiConfig.SetArgv(config.Argv(name))
+	wasiConfig.SetEnv(config.Environ())
+
+	for _, p := range config.Preopens {
+		if err := wasiConfig.PreopenDir(p.Host, p.GuestPath()); err != nil {
+			return nil, errors.Wrapf(err, "failed to PreopenDir %s", p.Host)
+		}
+	}
+
+	return wasiConfig, nil
+}
+
+// shadowWASI replaces WASI's clock and random functions with fakes unless the module is given the host's sources. The
+// linker is shared by the builder's instances, so each call uses the fakes of the instance that made it.
+func shadowWASI(linker *wasmtime.Linker, config runtime.WASI, hosts *hostContexts) error {
+	linker.AllowShadowing(true)
+	defer linker.AllowShadowing(false)
+
+	for _, module := range wasiModules {
+		if !config.HostClock() {
+			if err := linker.FuncWrap(module, "clock_time_get", func(caller *wasmtime.Caller, id int32, _ int64, pointer int32) int32 {
+				return int32(hosts.forCaller(caller).Clock().ClockTimeGet(guestMemory(caller), uint32(id), uint32(pointer)))
+			}); err != nil {
+				return errors.Wrap(err, "failed to FuncWrap clock_time_get")
+			}
+
+			if err := linker.FuncWrap(module, "clock_res_get", func(caller *wasmtime.Caller, id int32, pointer int32) int32 {
+				return int32(hosts.forCaller(caller).Clock().ClockResGet(guestMemory(caller), uint32(id), uint32(pointer)))
+			}); err != nil {
+				return errors.Wrap(err, "failed to FuncWrap clock_res_get")
+			}
+		}
+
+		if !config.HostRandom() {
+			if err := linker.FuncWrap(module, "random_get", func(caller *wasmtime.Caller, pointer int32, size int32) int32 {
+				return int32(runtime.RandomGet(hosts.forCaller(caller).Random(), guestMemory(caller), uint32(pointer), uint32(size)))
+			}); err != nil {
+				return errors.Wrap(err, "failed to FuncWrap random_get")
+			}
+		}
+	}
+
+	return nil
+}
+
+// guestMemory returns the memory exported by the calling module, if any
+func guestMemory(caller *wasmtime.Caller) []byte {
+	export := caller.GetExport("memory")
+	if export == nil || export.Memory() == nil {
+		return nil
+	}
+
+	return export.Memory().UnsafeData(caller)
+}
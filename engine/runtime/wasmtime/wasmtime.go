@@ -1,6 +1,9 @@
 package runtimewasmtime
 
 import (
+	"strings"
+	"time"
+
 	"github.com/bytecodealliance/wasmtime-go/v5"
 	"github.com/pkg/errors"
 
@@ -8,8 +11,17 @@ import (
 )
 
 type WasmtimeInstance struct {
-	inst  wasmtime.Instance
-	store *wasmtime.Store
+	inst    wasmtime.Instance
+	store   *wasmtime.Store
+	output  *runtime.OutputCapture
+	symbols *runtime.Symbols
+
+	// host is the instance's HostContext, which its builder's host functions find in hosts
+	host  *runtime.HostContext
+	hosts *hostContexts
+
+	// shadows holds the store's trampoline and output capture, if its builder shadows any WASI functions
+	shadows *wasiShadows
 }
 
 func (w *WasmtimeInstance) Call(fn string, args ...interface{}) (interface{}, error) {
@@ -21,62 +33,126 @@ func (w *WasmtimeInstance) Call(fn string, args ...interface{}) (interface{}, er
 
 	wasmResult, wasmErr := wasmFunc.Func().Call(w.store, args...)
 	if wasmErr != nil {
+		if budgetErr := budgetError(wasmErr); budgetErr != nil {
+			return nil, errors.Wrapf(budgetErr, "failed to wasmFunc %s", fn)
+		}
+
+		if trap := symbolizeTrap(wasmErr, w.symbols); trap != nil {
+			return nil, errors.Wrapf(trap, "failed to wasmFunc %s", fn)
+		}
+
 		return nil, errors.Wrap(wasmErr, "failed to wasmFunc")
 	}
 
 	return wasmResult, nil
 }
 
-// ReadMemory reads memory from the instance
-func (w *WasmtimeInstance) ReadMemory(pointer int32, size int32) []byte {
-	memory := w.inst.GetExport(w.store, "memory").Memory()
-
+// Memory returns the instance's exported memory
+func (w *WasmtimeInstance) Memory() []byte {
+	memory := w.memory()
 	if memory == nil {
-		// we failed
-		return []byte{}
+		return nil
 	}
 
-	data := memory.UnsafeData(w.store)[pointer:]
-	result := make([]byte, size)
-
-	copy(result, data)
+	return memory.UnsafeData(w.store)
+}
 
-	return result
+// ReadOutput returns what the module has written to stdout and stderr since it was last called
+func (w *WasmtimeInstance) ReadOutput() ([]byte, []byte) {
+	return w.output.ReadOutput()
 }
 
-// WriteMemory writes memory into the instance
-func (w *WasmtimeInstance) WriteMemory(data []byte) (int32, error) {
-	lengthOfInput := len(data)
+// MemoryPages returns the size of the instance's memory in pages
+func (w *WasmtimeInstance) MemoryPages() uint32 {
+	memory := w.memory()
 
-	allocateResult, err := w.Call("allocate", lengthOfInput)
-	if err != nil {
-		return 0, errors.Wrap(err, "failed to Call allocate")
+	if memory == nil {
+		return 0
 	}
 
-	pointer := allocateResult.(int32)
+	return uint32(memory.Size(w.store))
+}
 
-	w.WriteMemoryAtLocation(pointer, data)
+// memory returns the memory exported by the module, if there is one
+func (w *WasmtimeInstance) memory() *wasmtime.Memory {
+	export := w.inst.GetExport(w.store, "memory")
+	if export == nil {
+		return nil
+	}
 
-	return pointer, nil
+	return export.Memory()
 }
 
-// WriteMemoryAtLocation writes memory at the given location
-func (w *WasmtimeInstance) WriteMemoryAtLocation(pointer int32, data []byte) {
-	memory := w.inst.GetExport(w.store, "memory").Memory()
+// SetBudget resets the epoch deadline and fuel available to the instance's next invocation
+func (w *WasmtimeInstance) SetBudget(timeout time.Duration, fuel uint64) error {
+	if timeout > 0 {
+		w.store.SetEpochDeadline(epochTicks(timeout))
+	}
+
+	if fuel > 0 {
+		remaining, err := w.store.ConsumeFuel(0)
+		if err != nil {
+			return errors.Wrap(err, "failed to ConsumeFuel")
+		}
+
+		if remaining < fuel {
+			if err := w.store.AddFuel(fuel - remaining); err != nil {
+				return errors.Wrap(err, "failed to AddFuel")
+			}
+		}
+	}
 
-	if memory == nil {
-		// we failed
-		return
+	return nil
+}
+
+// SnapshotGlobals returns the values of the globals exported for snapshots
+func (w *WasmtimeInstance) SnapshotGlobals() ([]interface{}, error) {
+	values := []interface{}{}
+
+	for i := 0; ; i++ {
+		export := w.inst.GetExport(w.store, runtime.SnapshotGlobalName(i))
+		if export == nil || export.Global() == nil {
+			return values, nil
+		}
+
+		values = append(values, export.Global().Get(w.store).Get())
 	}
+}
 
-	scopedMemory := memory.UnsafeData(w.store)[pointer:]
+// RestoreGlobals sets the globals exported for snapshots to the given values
+func (w *WasmtimeInstance) RestoreGlobals(values []interface{}) error {
+	for i, value := range values {
+		export := w.inst.GetExport(w.store, runtime.SnapshotGlobalName(i))
+		if export == nil || export.Global() == nil {
+			return errors.Errorf("global %d not found", i)
+		}
+
+		var val wasmtime.Val
+
+		switch v := value.(type) {
+		case int32:
+			val = wasmtime.ValI32(v)
+		case int64:
+			val = wasmtime.ValI64(v)
+		case float32:
+			val = wasmtime.ValF32(v)
+		case float64:
+			val = wasmtime.ValF64(v)
+		default:
+			return errors.Errorf("global %d has unsupported type %T", i, value)
+		}
+
+		if err := export.Global().Set(w.store, val); err != nil {
+			return errors.Wrapf(err, "failed to Set global %d", i)
+		}
+	}
 
-	copy(scopedMemory, data)
+	return nil
 }
 
-// Deallocate deallocates memory in the instance
-func (w *WasmtimeInstance) Deallocate(pointer int32, length int) {
-	w.Call("deallocate", pointer, length)
+// HostContext returns the context through which the instance's host functions are given their caller
+func (w *WasmtimeInstance) HostContext() *runtime.HostContext {
+	return w.host
 }
 
 // Close closes the instance
@@ -86,4 +162,29 @@ func (w *WasmtimeInstance) Close() {
 	//
 	// See also:
 	// https://github.com/bytecodealliance/wasmtime-go/v5/blob/main/ffi.go
+	//
+	// the store's context can be reused once it is collected, so it must no longer lead to this instance
+	w.hosts.remove(w.store)
+
+	if w.shadows != nil {
+		w.shadows.remove(w.store)
+	}
+}
+
+// budgetError determines if a trap was caused by the instance running out of time or fuel
+func budgetError(err error) error {
+	trap, ok := err.(*wasmtime.Trap)
+	if !ok {
+		return nil
+	}
+
+	if code := trap.Code(); code != nil && *code == wasmtime.Interrupt {
+		return runtime.ErrExecutionTimeout
+	}
+
+	if strings.Contains(trap.Message(), "all fuel consumed") {
+		return runtime.ErrFuelExhausted
+	}
+
+	return nil
 }
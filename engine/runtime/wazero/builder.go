@@ -0,0 +1,250 @@
+package runtimewazero
+
+import (
+	"context"
+	"crypto/rand"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+
+	"github.com/suborbital/appspec/tenant"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// WazeroBuilder is a wazero implementation of the instanceBuilder interface
+type WazeroBuilder struct {
+	ref     *tenant.WasmModuleRef
+	hostFns []runtime.HostFn
+	config  runtime.Config
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+	lock    sync.Mutex
+}
+
+func init() {
+	runtime.RegisterBackend("wazero", newBuilder)
+}
+
+// NewBuilder creates a new WazeroBuilder
+func NewBuilder(ref *tenant.WasmModuleRef, api api.HostAPI) runtime.RuntimeBuilder {
+	return newBuilder(ref, api.HostFunctions(), runtime.Config{})
+}
+
+// NewBuilderWithConfig creates a new WazeroBuilder whose instances are built and run with the given config
+func NewBuilderWithConfig(ref *tenant.WasmModuleRef, api api.HostAPI, config runtime.Config) runtime.RuntimeBuilder {
+	return newBuilder(ref, api.HostFunctions(), config)
+}
+
+func newBuilder(ref *tenant.WasmModuleRef, hostFns []runtime.HostFn, config runtime.Config) runtime.RuntimeBuilder {
+	w := &WazeroBuilder{
+		ref:     ref,
+		hostFns: hostFns,
+		config:  config,
+	}
+
+	return w
+}
+
+// CheckConfig returns an error if the builder's config can't be honoured. wazero does not meter execution, so fail
+// loudly rather than silently running without a fuel budget.
+func (w *WazeroBuilder) CheckConfig() error {
+	if w.config.Fuel > 0 {
+		return runtime.ErrFuelNotSupported
+	}
+
+	return nil
+}
+
+func (w *WazeroBuilder) New() (runtime.RuntimeInstance, error) {
+	if err := w.CheckConfig(); err != nil {
+		return nil, err
+	}
+
+	module, wazeroRuntime, err := w.internals()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to internals")
+	}
+
+	// instances are anonymous so that the module can be instantiated many times, and _start
+	// is called below (rather than by wazero) so that it runs under the instance's budget
+	moduleConfig := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions()
+
+	output := runtime.NewOutputCapture(w.config.Output)
+
+	if output.Stdout != nil {
+		moduleConfig = moduleConfig.WithStdout(output.Stdout)
+	}
+
+	if output.Stderr != nil {
+		moduleConfig = moduleConfig.WithStderr(output.Stderr)
+	}
+
+	host := runtime.NewHostContext()
+
+	// a start function's host calls are given their caller too
+	ctx := contextWithHost(context.Background(), host)
+
+	// the instance's memories are allocated by its limiter, which wazero asks whenever the guest grows them
+	var limiter *memoryLimiter
+	if w.config.Limits.MemoryPages > 0 {
+		limiter = newMemoryLimiter(w.config.Limits.MemoryPages)
+		ctx = experimental.WithMemoryAllocator(ctx, limiter)
+	}
+
+	mod, err := wazeroRuntime.InstantiateModule(ctx, module, w.wasiConfig(moduleConfig, host))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to InstantiateModule")
+	}
+
+	inst := &WazeroInstance{
+		mod:     mod,
+		output:  output,
+		host:    host,
+		base:    contextWithHost(context.Background(), host),
+		limiter: limiter,
+	}
+
+	// _start runs under the same budget as a normal invocation
+	if err := inst.SetBudget(w.config.Timeout, w.config.Fuel); err != nil {
+		return nil, errors.Wrap(err, "failed to SetBudget")
+	}
+
+	if _, err := inst.Call("_start"); err != nil {
+		if errors.Is(err, runtime.ErrExportNotFound) {
+			// that's ok, not all modules will have _start
+		} else {
+			inst.Close()
+			return nil, errors.Wrap(err, "failed to call exported _start")
+		}
+	}
+
+	return inst, nil
+}
+
+// wasiConfig applies the module's WASI configuration to moduleConfig, giving the instance the fake sources in its host
+// context unless it is given the host's
+func (w *WazeroBuilder) wasiConfig(moduleConfig wazero.ModuleConfig, host *runtime.HostContext) wazero.ModuleConfig {
+	config := w.config.WASI
+
+	moduleConfig = moduleConfig.WithArgs(config.Argv(w.ref.Name)...).WithSysNanosleep()
+
+	keys, values := config.Environ()
+	for i := range keys {
+		moduleConfig = moduleConfig.WithEnv(keys[i], values[i])
+	}
+
+	fsConfig := wazero.NewFSConfig()
+
+	for _, p := range config.Preopens {
+		if p.Writable {
+			fsConfig = fsConfig.WithDirMount(p.Host, p.GuestPath())
+		} else {
+			fsConfig = fsConfig.WithReadOnlyDirMount(p.Host, p.GuestPath())
+		}
+	}
+
+	moduleConfig = moduleConfig.WithFSConfig(fsConfig)
+
+	if config.HostClock() {
+		moduleConfig = moduleConfig.WithSysWalltime().WithSysNanotime()
+	} else {
+		resolution := sys.ClockResolution(runtime.FakeClockResolution)
+		moduleConfig = moduleConfig.WithWalltime(host.Clock().Walltime, resolution).WithNanotime(host.Clock().Nanotime, resolution)
+	}
+
+	if config.HostRandom() {
+		moduleConfig = moduleConfig.WithRandSource(rand.Reader)
+	} else {
+		moduleConfig = moduleConfig.WithRandSource(host.Random())
+	}
+
+	return moduleConfig
+}
+
+// Precompile compiles the module without instantiating it, which fills the compile cache
+func (w *WazeroBuilder) Precompile() error {
+	if _, _, err := w.internals(); err != nil {
+		return errors.Wrap(err, "failed to internals")
+	}
+
+	return nil
+}
+
+func (w *WazeroBuilder) internals() (wazero.CompiledModule, wazero.Runtime, error) {
+	// instances are built concurrently, so the builder's internals are guarded while they're set up
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.module == nil {
+		ctx := context.Background()
+
+		config := wazero.NewRuntimeConfig().
+			WithCloseOnContextDone(w.config.Timeout > 0)
+
+		if w.config.CacheDir != "" {
+			// wazero keys its cache by its own version, so it is kept apart from the other runtimes' artifacts
+			cache, err := wazero.NewCompilationCacheWithDir(filepath.Join(w.config.CacheDir, "wazero"))
+			if err != nil {
+				runtime.InternalLogger().Warn(errors.Wrap(err, "failed to NewCompilationCacheWithDir, compiling without cache").Error())
+			} else {
+				config = config.WithCompilationCache(cache)
+			}
+		}
+
+		wazeroRuntime := wazero.NewRuntimeWithConfig(ctx, config)
+
+		// mount WASI, replacing proc_exit so that modules which exit from _start remain usable
+		wasiBuilder := wazeroRuntime.NewHostModuleBuilder(wasi_snapshot_preview1.ModuleName)
+		wasi_snapshot_preview1.NewFunctionExporter().ExportFunctions(wasiBuilder)
+		addProcExit(wasiBuilder)
+
+		if _, err := wasiBuilder.Instantiate(ctx); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to Instantiate WASI")
+		}
+
+		// mount the Runnable API, with a host module for each namespace that its functions are imported from
+		for namespace, fns := range runtime.HostFnsByNamespace(w.hostFns) {
+			hostBuilder := wazeroRuntime.NewHostModuleBuilder(namespace)
+			addHostFns(hostBuilder, fns...)
+
+			if _, err := hostBuilder.Instantiate(ctx); err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to Instantiate host functions for %s", namespace)
+			}
+		}
+
+		// the memory limit is enforced by each instance's memoryLimiter, so the module is only rewritten for the others
+		prepared := w.config
+		prepared.Limits.MemoryPages = 0
+
+		data, err := prepared.Prepare(w.ref.Data)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to config.Prepare")
+		}
+
+		if w.config.Limits.MemoryPages > 0 {
+			if err := checkMemoryLimit(data, w.config.Limits.MemoryPages); err != nil {
+				return nil, nil, errors.Wrap(err, "failed to checkMemoryLimit")
+			}
+		}
+
+		// Compiles the module
+		mod, err := wazeroRuntime.CompileModule(ctx, data)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to CompileModule")
+		}
+
+		w.module = mod
+		w.runtime = wazeroRuntime
+	}
+
+	return w.module, w.runtime, nil
+}
@@ -0,0 +1,135 @@
+package runtimewazero
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero"
+	wapi "github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// hostContextKey is the key of the HostContext in the contexts that instances are called with
+type hostContextKey struct{}
+
+// contextWithHost returns a context that carries an instance's HostContext to its host functions. The host module
+// is shared by every instance, so host functions find their caller in the context of the call.
+func contextWithHost(ctx context.Context, host *runtime.HostContext) context.Context {
+	return context.WithValue(ctx, hostContextKey{}, host)
+}
+
+// hostFromContext returns the HostContext carried by ctx, if there is one
+func hostFromContext(ctx context.Context) *runtime.HostContext {
+	host, _ := ctx.Value(hostContextKey{}).(*runtime.HostContext)
+
+	return host
+}
+
+// addHostFns adds a list of host functions to a host module
+func addHostFns(builder wazero.HostModuleBuilder, fns ...runtime.HostFn) {
+	for i := range fns {
+		// we create a copy inside the loop otherwise things get overwritten
+		fn := fns[i]
+
+		params := valueTypes(fn.Params)
+		returns := valueTypes(fn.Results)
+
+		// this is reused across the normal and Swift variations of the function
+		wazeroFunc := wapi.GoModuleFunc(func(ctx context.Context, _ wapi.Module, stack []uint64) {
+			hostArgs := make([]interface{}, len(fn.Params))
+
+			// the stack can be longer than hostArgs (swift, lame), so use hostArgs to control the loop
+			for i, p := range fn.Params {
+				hostArgs[i] = decodeValue(p, stack[i])
+			}
+
+			result, err := fn.Call(hostFromContext(ctx), hostArgs...)
+			if err != nil {
+				// panicking causes wazero to trap the guest, which returns the error from Call
+				panic(errors.Wrapf(err, "failed to HostFn for %s", fn.Name))
+			}
+
+			results, err := fn.ResultValues(result)
+			if err != nil {
+				panic(errors.Wrap(err, "failed to ResultValues"))
+			}
+
+			for i, r := range results {
+				stack[i] = encodeValue(r)
+			}
+		})
+
+		builder.NewFunctionBuilder().WithGoModuleFunction(wazeroFunc, params, returns).Export(fn.Name)
+
+		// only the functions imported from env have Swift variations
+		if fn.Module != "" {
+			continue
+		}
+
+		// add swift params and mount swift variation
+		swiftParams := append(params, wapi.ValueTypeI32, wapi.ValueTypeI32)
+
+		builder.NewFunctionBuilder().WithGoModuleFunction(wazeroFunc, swiftParams, returns).Export(fmt.Sprintf("%s_swift", fn.Name))
+	}
+}
+
+// valueTypes maps a host function's types to wazero's
+func valueTypes(types []runtime.ValueType) []wapi.ValueType {
+	valueTypes := make([]wapi.ValueType, len(types))
+
+	for i, t := range types {
+		switch t {
+		case runtime.I64:
+			valueTypes[i] = wapi.ValueTypeI64
+		case runtime.F32:
+			valueTypes[i] = wapi.ValueTypeF32
+		case runtime.F64:
+			valueTypes[i] = wapi.ValueTypeF64
+		default:
+			valueTypes[i] = wapi.ValueTypeI32
+		}
+	}
+
+	return valueTypes
+}
+
+// decodeValue decodes a value of the given type from the stack
+func decodeValue(t runtime.ValueType, value uint64) interface{} {
+	switch t {
+	case runtime.I64:
+		return int64(value)
+	case runtime.F32:
+		return wapi.DecodeF32(value)
+	case runtime.F64:
+		return wapi.DecodeF64(value)
+	}
+
+	return wapi.DecodeI32(value)
+}
+
+// encodeValue encodes a value checked by ResultValues for the stack
+func encodeValue(value interface{}) uint64 {
+	switch v := value.(type) {
+	case int64:
+		return wapi.EncodeI64(v)
+	case float32:
+		return wapi.EncodeF32(v)
+	case float64:
+		return wapi.EncodeF64(v)
+	}
+
+	return wapi.EncodeI32(value.(int32))
+}
+
+// addProcExit replaces WASI's proc_exit, which closes the module, with one that only unwinds the guest. Some
+// toolchains (such as Swift's) exit at the end of _start, and the module must remain usable after that.
+func addProcExit(builder wazero.HostModuleBuilder) {
+	procExit := wapi.GoModuleFunc(func(_ context.Context, _ wapi.Module, stack []uint64) {
+		panic(sys.NewExitError(uint32(stack[0])))
+	})
+
+	builder.NewFunctionBuilder().WithGoModuleFunction(procExit, []wapi.ValueType{wapi.ValueTypeI32}, nil).Export("proc_exit")
+}
@@ -0,0 +1,84 @@
+package runtimewazero
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero/experimental"
+
+	"github.com/suborbital/sat/engine/runtime"
+	"github.com/suborbital/sat/engine/wasmbinary"
+)
+
+// memoryLimiter allocates an instance's memories, refusing to grow them beyond the limit. wazero calls it for every
+// attempt to grow, so it knows when the guest was refused by the limit rather than by the module's own maximum.
+type memoryLimiter struct {
+	limit uint64
+	hit   atomic.Bool
+}
+
+func newMemoryLimiter(pages uint32) *memoryLimiter {
+	return &memoryLimiter{limit: uint64(pages) * wasmPageSize}
+}
+
+// Allocate implements experimental.MemoryAllocator
+func (m *memoryLimiter) Allocate(cap, max uint64) experimental.LinearMemory {
+	return &limitedMemory{limiter: m, buf: make([]byte, 0, cap)}
+}
+
+// limitHit returns ErrMemoryLimit if the limiter refused to grow a memory since it was last called
+func (m *memoryLimiter) limitHit() error {
+	if m.hit.Swap(false) {
+		return runtime.ErrMemoryLimit
+	}
+
+	return nil
+}
+
+// limitedMemory is a linear memory allocated by a memoryLimiter
+type limitedMemory struct {
+	limiter *memoryLimiter
+	buf     []byte
+}
+
+// Reallocate implements experimental.LinearMemory, returning nil (which wazero treats as a failure to grow) beyond
+// the limit
+func (l *limitedMemory) Reallocate(size uint64) []byte {
+	if size > l.limiter.limit {
+		l.limiter.hit.Store(true)
+		return nil
+	}
+
+	if size <= uint64(cap(l.buf)) {
+		l.buf = l.buf[:size]
+		return l.buf
+	}
+
+	grown := make([]byte, size, max(size, 2*uint64(cap(l.buf))))
+	copy(grown, l.buf)
+	l.buf = grown
+
+	return l.buf
+}
+
+// Free implements experimental.LinearMemory
+func (l *limitedMemory) Free() {
+	l.buf = nil
+}
+
+// checkMemoryLimit returns an error if one of the module's memories needs more pages than the limit to begin with, as
+// the limiter can only refuse to grow them
+func checkMemoryLimit(module []byte, pages uint32) error {
+	iface, err := wasmbinary.ReadInterface(module)
+	if err != nil {
+		return errors.Wrap(err, "failed to ReadInterface")
+	}
+
+	for _, memory := range iface.Memories {
+		if !memory.Imported && memory.Min > pages {
+			return errors.Wrapf(wasmbinary.ErrExceedsLimit, "minimum of %d is greater than limit of %d", memory.Min, pages)
+		}
+	}
+
+	return nil
+}
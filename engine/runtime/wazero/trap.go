@@ -0,0 +1,113 @@
+package runtimewazero
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// the markers that wazero puts in the text of the errors it returns for traps
+const (
+	wasmErrorPrefix = "wasm error: "
+	stackTraceLine  = "wasm stack trace:"
+	// stackOverflow is the whole text of the error for a stack overflow, which wazero returns without a backtrace
+	stackOverflow = "stack overflow"
+)
+
+// symbolizeTrap converts an error returned by wazero for a trap into a Trap, or returns nil if err isn't a trap.
+// wazero only exposes the backtrace as text, with the source lines from the module's DWARF info already resolved.
+func symbolizeTrap(err error, moduleName string) *runtime.Trap {
+	if err.Error() == stackOverflow {
+		return &runtime.Trap{Kind: runtime.TrapStackOverflow, Message: stackOverflow}
+	}
+
+	message, trace, ok := strings.Cut(err.Error(), stackTraceLine)
+	if !ok {
+		return nil
+	}
+
+	message = strings.TrimPrefix(strings.TrimSpace(message), wasmErrorPrefix)
+
+	t := &runtime.Trap{
+		Kind:    runtime.TrapKindFromMessage(message),
+		Message: message,
+	}
+
+	frames := []runtime.TrapFrame{}
+
+	for _, line := range strings.Split(trace, "\n") {
+		switch {
+		case strings.HasPrefix(line, "\t\t"):
+			// the first source line of a frame is the innermost, the others are the calls it was inlined into
+			if len(frames) > 0 && frames[len(frames)-1].File == "" {
+				parseSourceLine(&frames[len(frames)-1], strings.TrimSpace(line))
+			}
+		case strings.HasPrefix(line, "\t"):
+			frames = append(frames, runtime.TrapFrame{Function: functionName(strings.TrimSpace(line), moduleName)})
+		}
+	}
+
+	for _, f := range frames {
+		t.AddFrame(f)
+	}
+
+	return t
+}
+
+// functionName strips the module name and signature from a frame of wazero's backtrace, such as .run_e(i32,i32,i32)
+func functionName(line, moduleName string) string {
+	// functions with several results are followed by their types in parentheses, as in .fn(i32) (i32,i32)
+	if strings.HasSuffix(line, ")") {
+		if i := strings.LastIndex(line, ") ("); i >= 0 {
+			line = line[:i+1]
+		}
+	}
+
+	if i := strings.LastIndex(line, "("); i >= 0 {
+		line = line[:i]
+	}
+
+	return strings.TrimPrefix(line, moduleName+".")
+}
+
+// parseSourceLine fills in a frame from a source line of wazero's backtrace, such as 0x4198: /src/lib.rs:84:17
+func parseSourceLine(frame *runtime.TrapFrame, line string) {
+	offset, location, ok := strings.Cut(line, ": ")
+	if !ok {
+		return
+	}
+
+	if parsed, err := strconv.ParseUint(strings.TrimPrefix(offset, "0x"), 16, 64); err == nil {
+		frame.Offset = parsed
+	}
+
+	location = strings.TrimSuffix(location, " (inlined)")
+
+	// the file can itself contain colons, so the line and column are taken from the end
+	numbers := []int{}
+	for len(numbers) < 2 {
+		i := strings.LastIndex(location, ":")
+		if i < 0 {
+			break
+		}
+
+		n, err := strconv.Atoi(location[i+1:])
+		if err != nil {
+			break
+		}
+
+		numbers = append([]int{n}, numbers...)
+		location = location[:i]
+	}
+
+	frame.File = location
+
+	if len(numbers) > 0 {
+		frame.Line = numbers[0]
+	}
+
+	if len(numbers) > 1 {
+		frame.Column = numbers[1]
+	}
+}
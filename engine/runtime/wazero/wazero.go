@@ -0,0 +1,210 @@
+package runtimewazero
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	wapi "github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// the size of a Wasm memory page, in bytes
+const wasmPageSize = 65536
+
+// WazeroInstance is a wazero implementation of the runtimeInstance interface
+type WazeroInstance struct {
+	mod wapi.Module
+
+	// output captures the module's stdout and stderr
+	output *runtime.OutputCapture
+
+	// host is the instance's HostContext, which base carries to its host functions
+	host *runtime.HostContext
+	base context.Context
+
+	// ctx carries the deadline of the current invocation, and is cancelled by cancel once it is replaced
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// limiter allocates the instance's memories, and is nil if they aren't limited
+	limiter *memoryLimiter
+}
+
+func (w *WazeroInstance) Call(fn string, args ...interface{}) (interface{}, error) {
+	wasmFunc := w.mod.ExportedFunction(fn)
+	if wasmFunc == nil {
+		return nil, errors.Wrapf(runtime.ErrExportNotFound, "function %s not found", fn)
+	}
+
+	params, err := encodeArgs(args)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encodeArgs for %s", fn)
+	}
+
+	ctx := w.ctx
+	if ctx == nil {
+		ctx = w.base
+	}
+
+	wasmResult, wasmErr := wasmFunc.Call(ctx, params...)
+	if wasmErr != nil {
+		exitErr := &sys.ExitError{}
+		if errors.As(wasmErr, &exitErr) {
+			switch exitErr.ExitCode() {
+			case 0:
+				// the guest exited successfully, which some toolchains do at the end of _start
+				return nil, nil
+			case sys.ExitCodeDeadlineExceeded, sys.ExitCodeContextCanceled:
+				return nil, errors.Wrapf(runtime.ErrExecutionTimeout, "failed to wasmFunc %s", fn)
+			}
+		}
+
+		if trap := symbolizeTrap(wasmErr, w.mod.Name()); trap != nil {
+			return nil, errors.Wrapf(trap, "failed to wasmFunc %s", fn)
+		}
+
+		return nil, errors.Wrap(wasmErr, "failed to wasmFunc")
+	}
+
+	if len(wasmResult) == 0 {
+		return nil, nil
+	}
+
+	return decodeResult(wasmFunc.Definition().ResultTypes()[0], wasmResult[0]), nil
+}
+
+// ReadOutput returns what the module has written to stdout and stderr since it was last called
+func (w *WazeroInstance) ReadOutput() ([]byte, []byte) {
+	return w.output.ReadOutput()
+}
+
+// Memory returns the instance's exported memory
+func (w *WazeroInstance) Memory() []byte {
+	memory := w.mod.ExportedMemory("memory")
+	if memory == nil {
+		return nil
+	}
+
+	// reading the whole of memory returns a view of it rather than a copy
+	data, _ := memory.Read(0, memory.Size())
+
+	return data
+}
+
+// MemoryPages returns the size of the instance's memory in pages
+func (w *WazeroInstance) MemoryPages() uint32 {
+	memory := w.mod.ExportedMemory("memory")
+	if memory == nil {
+		return 0
+	}
+
+	return memory.Size() / wasmPageSize
+}
+
+// LimitHit returns ErrMemoryLimit if the guest was refused growth by the memory limit since LimitHit was last called
+func (w *WazeroInstance) LimitHit() error {
+	if w.limiter == nil {
+		return nil
+	}
+
+	return w.limiter.limitHit()
+}
+
+// SetBudget sets the deadline of the instance's next invocation, after which wazero closes the module
+func (w *WazeroInstance) SetBudget(timeout time.Duration, fuel uint64) error {
+	if w.cancel != nil {
+		w.cancel()
+		w.ctx, w.cancel = nil, nil
+	}
+
+	if timeout > 0 {
+		w.ctx, w.cancel = context.WithTimeout(w.base, timeout)
+	}
+
+	return nil
+}
+
+// SnapshotGlobals returns the values of the globals exported for snapshots
+func (w *WazeroInstance) SnapshotGlobals() ([]interface{}, error) {
+	values := []interface{}{}
+
+	for i := 0; ; i++ {
+		global := w.mod.ExportedGlobal(runtime.SnapshotGlobalName(i))
+		if global == nil {
+			return values, nil
+		}
+
+		values = append(values, global.Get())
+	}
+}
+
+// RestoreGlobals sets the globals exported for snapshots to the given values
+func (w *WazeroInstance) RestoreGlobals(values []interface{}) error {
+	for i, value := range values {
+		global, ok := w.mod.ExportedGlobal(runtime.SnapshotGlobalName(i)).(wapi.MutableGlobal)
+		if !ok {
+			return errors.Errorf("mutable global %d not found", i)
+		}
+
+		global.Set(value.(uint64))
+	}
+
+	return nil
+}
+
+// HostContext returns the context through which the instance's host functions are given their caller
+func (w *WazeroInstance) HostContext() *runtime.HostContext {
+	return w.host
+}
+
+// Close closes the instance
+func (w *WazeroInstance) Close() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+
+	w.mod.Close(context.Background())
+}
+
+// encodeArgs converts Go values into the representation wazero uses for Wasm values
+func encodeArgs(args []interface{}) ([]uint64, error) {
+	params := make([]uint64, len(args))
+
+	for i, arg := range args {
+		switch a := arg.(type) {
+		case int32:
+			params[i] = wapi.EncodeI32(a)
+		case int:
+			params[i] = wapi.EncodeI32(int32(a))
+		case uint32:
+			params[i] = wapi.EncodeU32(a)
+		case int64:
+			params[i] = wapi.EncodeI64(a)
+		case float32:
+			params[i] = wapi.EncodeF32(a)
+		case float64:
+			params[i] = wapi.EncodeF64(a)
+		default:
+			return nil, errors.Errorf("unsupported argument type %T", arg)
+		}
+	}
+
+	return params, nil
+}
+
+// decodeResult converts a Wasm value into the Go type used by the other runtimes
+func decodeResult(valueType wapi.ValueType, result uint64) interface{} {
+	switch valueType {
+	case wapi.ValueTypeI64:
+		return int64(result)
+	case wapi.ValueTypeF32:
+		return wapi.DecodeF32(result)
+	case wapi.ValueTypeF64:
+		return wapi.DecodeF64(result)
+	default:
+		return wapi.DecodeI32(result)
+	}
+}
@@ -0,0 +1,12 @@
+;; a module that doesn't match the sat ABI, used to test ABI validation. It imports a host function that doesn't
+;; exist and another with the wrong signature, gives deallocate the wrong signature and doesn't export run_e.
+(module
+  (import "env" "not_a_host_fn" (func (param i32)))
+  (import "env" "log_msg" (func (param i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (func (export "allocate") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "deallocate") (param $pointer i32)))
@@ -0,0 +1,14 @@
+;; a module that echoes its input back, passing an ident that isn't its own to return_result, used to test that host
+;; functions refuse to act on behalf of an instance other than the one that called them
+(module
+  (import "env" "return_result" (func $return_result (param i32 i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (func (export "allocate") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "deallocate") (param $pointer i32) (param $size i32))
+
+  (func (export "run_e") (param $pointer i32) (param $size i32) (param $ident i32)
+    (call $return_result (local.get $pointer) (local.get $size) (i32.const -1))))
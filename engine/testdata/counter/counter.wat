@@ -0,0 +1,22 @@
+;; a module that counts its invocations in both a private global and its memory, used to test instance pooling.
+;; run_e returns the two counts as bytes.
+(module
+  (import "env" "return_result" (func $return_result (param i32 i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (global $calls (mut i32) (i32.const 0))
+
+  (func (export "allocate") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "deallocate") (param $pointer i32) (param $size i32))
+
+  (func (export "run_e") (param $pointer i32) (param $size i32) (param $ident i32)
+    (global.set $calls (i32.add (global.get $calls) (i32.const 1)))
+    (i32.store8 (i32.const 0) (i32.add (i32.load8_u (i32.const 0)) (i32.const 1)))
+
+    (i32.store8 (i32.const 8) (global.get $calls))
+    (i32.store8 (i32.const 9) (i32.load8_u (i32.const 0)))
+
+    (call $return_result (i32.const 8) (i32.const 2) (local.get $ident))))
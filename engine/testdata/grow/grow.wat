@@ -0,0 +1,26 @@
+;; a module that grows its memory from run_e until growth fails, used to test resource limits. Given "table", it grows
+;; its table instead, and given "once", it grows its memory by 15 pages once and traps whether or not that succeeded.
+(module
+  (memory (export "memory") 1)
+  (table 1 funcref)
+
+  (func (export "allocate") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "deallocate") (param $pointer i32) (param $size i32))
+
+  (func (export "run_e") (param $pointer i32) (param $size i32) (param $ident i32)
+    (if (i32.and (i32.eq (local.get $size) (i32.const 4)) (i32.eq (i32.load (local.get $pointer)) (i32.const 0x65636e6f)))
+      (then
+        (drop (memory.grow (i32.const 15)))
+        unreachable))
+
+    (if (i32.and (i32.eq (local.get $size) (i32.const 5)) (i32.eq (i32.load (local.get $pointer)) (i32.const 0x6c626174)))
+      (then
+        (loop $table
+          (br_if $table (i32.ne (table.grow (ref.null func) (i32.const 1)) (i32.const -1))))
+        unreachable))
+
+    (loop $grow
+      (br_if $grow (i32.ne (memory.grow (i32.const 1)) (i32.const -1))))
+    unreachable))
@@ -0,0 +1,73 @@
+;; a module that calls the host function chosen by the first byte of its input, with the i32 arguments that follow
+;; it (from the fourth byte on) and its ident, used to test that no arguments can crash the host
+(module
+  (import "env" "return_result" (func $return_result (param i32 i32 i32)))
+  (import "env" "return_error" (func $return_error (param i32 i32 i32 i32)))
+  (import "env" "return_abort" (func $return_abort (param i32 i32 i32 i32 i32 i32 i32)))
+  (import "env" "log_msg" (func $log_msg (param i32 i32 i32 i32)))
+  (import "env" "cache_set" (func $cache_set (param i32 i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "cache_get" (func $cache_get (param i32 i32 i32) (result i32)))
+  (import "env" "request_get_field" (func $request_get_field (param i32 i32 i32 i32) (result i32)))
+  (import "env" "request_set_field" (func $request_set_field (param i32 i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "resp_set_header" (func $resp_set_header (param i32 i32 i32 i32 i32)))
+  (import "env" "get_ffi_result" (func $get_ffi_result (param i32 i32) (result i32)))
+  (import "env" "add_ffi_var" (func $add_ffi_var (param i32 i32 i32 i32 i32) (result i32)))
+  (import "env" "get_static_file" (func $get_static_file (param i32 i32 i32) (result i32)))
+  (import "env" "get_secret_value" (func $get_secret_value (param i32 i32 i32) (result i32)))
+
+  (memory (export "memory") 1)
+
+  (func (export "allocate") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "deallocate") (param $pointer i32) (param $size i32))
+
+  ;; arg loads the nth argument from the input
+  (func $arg (param $pointer i32) (param $n i32) (result i32)
+    (i32.load offset=4 (i32.add (local.get $pointer) (i32.shl (local.get $n) (i32.const 2)))))
+
+  (func (export "run_e") (param $pointer i32) (param $size i32) (param $ident i32)
+    (block $done
+      (block $get_secret_value
+        (block $get_static_file
+          (block $add_ffi_var
+            (block $get_ffi_result
+              (block $resp_set_header
+                (block $request_set_field
+                  (block $request_get_field
+                    (block $cache_get
+                      (block $cache_set
+                        (block $log_msg
+                          (block $return_abort
+                            (block $return_error
+                              (block $return_result
+                                (br_table $return_result $return_error $return_abort $log_msg $cache_set $cache_get $request_get_field $request_set_field $resp_set_header $get_ffi_result $add_ffi_var $get_static_file $get_secret_value $done (i32.load8_u (local.get $pointer))))
+                              (call $return_result (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (local.get $ident))
+                              (br $done))
+                            (call $return_error (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (call $arg (local.get $pointer) (i32.const 2)) (local.get $ident))
+                            (br $done))
+                          (call $return_abort (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (call $arg (local.get $pointer) (i32.const 2)) (call $arg (local.get $pointer) (i32.const 3)) (call $arg (local.get $pointer) (i32.const 4)) (call $arg (local.get $pointer) (i32.const 5)) (local.get $ident))
+                          (br $done))
+                        (call $log_msg (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (call $arg (local.get $pointer) (i32.const 2)) (local.get $ident))
+                        (br $done))
+                      (drop (call $cache_set (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (call $arg (local.get $pointer) (i32.const 2)) (call $arg (local.get $pointer) (i32.const 3)) (call $arg (local.get $pointer) (i32.const 4)) (local.get $ident)))
+                      (br $done))
+                    (drop (call $cache_get (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (local.get $ident)))
+                    (br $done))
+                  (drop (call $request_get_field (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (call $arg (local.get $pointer) (i32.const 2)) (local.get $ident)))
+                  (br $done))
+                (drop (call $request_set_field (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (call $arg (local.get $pointer) (i32.const 2)) (call $arg (local.get $pointer) (i32.const 3)) (call $arg (local.get $pointer) (i32.const 4)) (local.get $ident)))
+                (br $done))
+              (call $resp_set_header (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (call $arg (local.get $pointer) (i32.const 2)) (call $arg (local.get $pointer) (i32.const 3)) (local.get $ident))
+              (br $done))
+            ;; a missing cache key leaves an error as the result to be written
+            (drop (call $cache_get (i32.const 0) (i32.const 0) (local.get $ident)))
+            (drop (call $get_ffi_result (call $arg (local.get $pointer) (i32.const 0)) (local.get $ident)))
+            (br $done))
+          (drop (call $add_ffi_var (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (call $arg (local.get $pointer) (i32.const 2)) (call $arg (local.get $pointer) (i32.const 3)) (local.get $ident)))
+          (br $done))
+        (drop (call $get_static_file (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (local.get $ident)))
+        (br $done))
+      (drop (call $get_secret_value (call $arg (local.get $pointer) (i32.const 0)) (call $arg (local.get $pointer) (i32.const 1)) (local.get $ident)))
+      (br $done)))
+)
@@ -0,0 +1,12 @@
+;; a module that never returns from run_e, used to test execution budgets
+(module
+  (memory (export "memory") 1)
+
+  (func (export "allocate") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "deallocate") (param $pointer i32) (param $size i32))
+
+  (func (export "run_e") (param $pointer i32) (param $size i32) (param $ident i32)
+    (loop $forever
+      br $forever)))
@@ -0,0 +1,29 @@
+;; a module that writes to stdout and stderr, used to test output capture.
+;; _start writes a line to stdout, and run_e echoes its input to stdout and writes a fixed line to stderr.
+(module
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (import "env" "return_result" (func $return_result (param i32 i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (data (i32.const 256) "started\n")
+  (data (i32.const 272) "something went wrong\n")
+  (data (i32.const 304) "ok")
+
+  (func $write (param $fd i32) (param $pointer i32) (param $size i32)
+    (i32.store (i32.const 0) (local.get $pointer))
+    (i32.store (i32.const 4) (local.get $size))
+    (drop (call $fd_write (local.get $fd) (i32.const 0) (i32.const 1) (i32.const 8))))
+
+  (func (export "_start")
+    (call $write (i32.const 1) (i32.const 256) (i32.const 8)))
+
+  (func (export "allocate") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "deallocate") (param $pointer i32) (param $size i32))
+
+  (func (export "run_e") (param $pointer i32) (param $size i32) (param $ident i32)
+    (call $write (i32.const 1) (local.get $pointer) (local.get $size))
+    (call $write (i32.const 2) (i32.const 272) (i32.const 21))
+    (call $return_result (i32.const 304) (i32.const 2) (local.get $ident))))
@@ -0,0 +1,60 @@
+;; a module whose _start sets its globals and memory up, used to test preinitialization. Depending on how many
+;; arguments it is given (including its name), _start also logs a message (2), returns a result (3), reads random
+;; bytes (4) or reads the clock (5), of which only logging can be done as the module is preinitialized.
+;; run_e returns a 31 byte result holding the i32, i64 and f64 globals, the byte at 70000, the 8 bytes at 100, the
+;; memory's size in pages and the number of times that _start has run.
+(module
+  (import "wasi_snapshot_preview1" "args_sizes_get" (func $args_sizes_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "random_get" (func $random_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "clock_time_get" (func $clock_time_get (param i32 i64 i32) (result i32)))
+  (import "env" "log_msg" (func $log_msg (param i32 i32 i32 i32)))
+  (import "env" "return_result" (func $return_result (param i32 i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (global $i32 (mut i32) (i32.const 0))
+  (global $i64 (mut i64) (i64.const 0))
+  (global $f64 (mut f64) (f64.const 0))
+  (global $inits (mut i32) (i32.const 0))
+
+  (data (i32.const 100) "hello")
+
+  (func (export "_start")
+    (global.set $inits (i32.add (global.get $inits) (i32.const 1)))
+    (global.set $i32 (i32.const -123456))
+    (global.set $i64 (i64.const 0x1122334455667788))
+    (global.set $f64 (f64.const 1.5))
+
+    ;; change the data segment, and write beyond the memory's initial size
+    (i32.store8 (i32.const 100) (i32.const 0x6a))
+    (drop (memory.grow (i32.const 1)))
+    (i32.store8 (i32.const 70000) (i32.const 7))
+
+    (drop (call $args_sizes_get (i32.const 0) (i32.const 4)))
+    (if (i32.eq (i32.load (i32.const 0)) (i32.const 2))
+      (then (call $log_msg (i32.const 100) (i32.const 5) (i32.const 3) (i32.const 0))))
+    (if (i32.eq (i32.load (i32.const 0)) (i32.const 3))
+      (then (call $return_result (i32.const 100) (i32.const 5) (i32.const 0))))
+    (if (i32.eq (i32.load (i32.const 0)) (i32.const 4))
+      (then (drop (call $random_get (i32.const 8) (i32.const 8)))))
+    (if (i32.eq (i32.load (i32.const 0)) (i32.const 5))
+      (then (drop (call $clock_time_get (i32.const 0) (i64.const 0) (i32.const 8)))))
+
+    (i64.store (i32.const 0) (i64.const 0))
+    (i64.store (i32.const 8) (i64.const 0)))
+
+  (func (export "allocate") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "deallocate") (param $pointer i32) (param $size i32))
+
+  (func (export "run_e") (param $pointer i32) (param $size i32) (param $ident i32)
+    (i32.store (i32.const 4096) (global.get $i32))
+    (i64.store (i32.const 4100) (global.get $i64))
+    (f64.store (i32.const 4108) (global.get $f64))
+    (i32.store8 (i32.const 4116) (i32.load8_u (i32.const 70000)))
+    (i64.store (i32.const 4117) (i64.load (i32.const 100)))
+    (i32.store8 (i32.const 4125) (memory.size))
+    (i32.store8 (i32.const 4126) (global.get $inits))
+
+    (call $return_result (i32.const 4096) (i32.const 31) (local.get $ident))))
@@ -0,0 +1,54 @@
+;; a module that traps in different ways, used to test trap symbolization.
+;; run_e picks the trap from the first byte of its input: u (unreachable), d (divide by zero), m (out of bounds memory),
+;; s (stack overflow) and a (abort, followed by unreachable like the AssemblyScript and Rust SDKs do)
+(module
+  (import "env" "return_result" (func $return_result (param i32 i32 i32)))
+  (import "env" "return_abort" (func $return_abort (param i32 i32 i32 i32 i32 i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (data (i32.const 256) "something broke")
+  (data (i32.const 272) "src/lib.rs")
+  (data (i32.const 288) "ok")
+
+  (func $do_unreachable
+    unreachable)
+
+  (func $do_divide (param $n i32) (result i32)
+    (i32.div_u (i32.const 1) (local.get $n)))
+
+  (func $do_load (result i32)
+    (i32.load (i32.const 0x7ffffff0)))
+
+  (func $do_recurse (param $n i32) (result i32)
+    (i32.add (call $do_recurse (i32.add (local.get $n) (i32.const 1))) (i32.const 1)))
+
+  (func $do_abort (param $ident i32)
+    (call $return_abort (i32.const 256) (i32.const 15) (i32.const 272) (i32.const 10) (i32.const 12) (i32.const 5) (local.get $ident))
+    unreachable)
+
+  (func (export "allocate") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "deallocate") (param $pointer i32) (param $size i32))
+
+  (func $run_e (export "run_e") (param $pointer i32) (param $size i32) (param $ident i32)
+    (local $op i32)
+    (local.set $op (i32.load8_u (local.get $pointer)))
+
+    (if (i32.eq (local.get $op) (i32.const 117)) ;; u
+      (then (call $do_unreachable)))
+
+    (if (i32.eq (local.get $op) (i32.const 100)) ;; d
+      (then (drop (call $do_divide (i32.const 0)))))
+
+    (if (i32.eq (local.get $op) (i32.const 109)) ;; m
+      (then (drop (call $do_load))))
+
+    (if (i32.eq (local.get $op) (i32.const 115)) ;; s
+      (then (drop (call $do_recurse (i32.const 0)))))
+
+    (if (i32.eq (local.get $op) (i32.const 97)) ;; a
+      (then (call $do_abort (local.get $ident))))
+
+    (call $return_result (i32.const 288) (i32.const 2) (local.get $ident))))
@@ -0,0 +1,28 @@
+;; a module that calls a host function with 64-bit and floating point parameters and several results, used to test
+;; typed host function signatures. run_e returns the i64, f32 and i32 results of typed_host_fn, 16 bytes in total.
+(module
+  (import "env" "typed_host_fn" (func $typed_host_fn (param i64 f64) (result i64 f32 i32)))
+  (import "env" "return_result" (func $return_result (param i32 i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (func (export "allocate") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "deallocate") (param $pointer i32) (param $size i32))
+
+  (func (export "run_e") (param $pointer i32) (param $size i32) (param $ident i32)
+    (local $wide i64)
+    (local $float f32)
+    (local $narrow i32)
+
+    (call $typed_host_fn (i64.const 0x100000002) (f64.const 1.5))
+    (local.set $narrow)
+    (local.set $float)
+    (local.set $wide)
+
+    (i64.store (i32.const 2048) (local.get $wide))
+    (f32.store (i32.const 2056) (local.get $float))
+    (i32.store (i32.const 2060) (local.get $narrow))
+
+    (call $return_result (i32.const 2048) (i32.const 16) (local.get $ident))))
@@ -0,0 +1,75 @@
+;; a module that reports what it can see through WASI, used to test the WASI configuration.
+;; run_e returns a 44 byte header holding the environment count and buffer size, the argument count and buffer size,
+;; the errno from inspecting the last preopen, the errno from creating a file within it, the realtime clock, 8 random
+;; bytes and the errno from reading a file within the preopen. The environment and argument buffers follow the header.
+(module
+  (import "wasi_snapshot_preview1" "environ_sizes_get" (func $environ_sizes_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "environ_get" (func $environ_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "args_sizes_get" (func $args_sizes_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "args_get" (func $args_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_prestat_get" (func $fd_prestat_get (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "path_open" (func $path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_read" (func $fd_read (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_close" (func $fd_close (param i32) (result i32)))
+  (import "wasi_snapshot_preview1" "clock_time_get" (func $clock_time_get (param i32 i64 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "random_get" (func $random_get (param i32 i32) (result i32)))
+  (import "env" "return_result" (func $return_result (param i32 i32 i32)))
+
+  (memory (export "memory") 1)
+
+  (data (i32.const 256) "sat-wasi-probe.txt")
+  (data (i32.const 288) "sat-wasi-read.txt")
+
+  (func (export "allocate") (param $size i32) (result i32)
+    i32.const 1024)
+
+  (func (export "deallocate") (param $pointer i32) (param $size i32))
+
+  (func (export "run_e") (param $pointer i32) (param $size i32) (param $ident i32)
+    (local $args i32)
+    (local $fd i32)
+    (local $preopen i32)
+
+    (drop (call $environ_sizes_get (i32.const 4096) (i32.const 4100)))
+    (drop (call $args_sizes_get (i32.const 4104) (i32.const 4108)))
+
+    ;; the environment buffer directly follows the header, and the arguments follow the environment
+    (drop (call $environ_get (i32.const 8192) (i32.const 4140)))
+    (local.set $args (i32.add (i32.const 4140) (i32.load (i32.const 4100))))
+    (drop (call $args_get (i32.const 12288) (local.get $args)))
+
+    ;; find the last preopen, as some runtimes preopen their own directories ahead of the configured ones
+    (local.set $preopen (i32.const -1))
+    (local.set $fd (i32.const 3))
+    (block $done
+      (loop $scan
+        (br_if $done (call $fd_prestat_get (local.get $fd) (i32.const 16384)))
+        (local.set $preopen (local.get $fd))
+        (local.set $fd (i32.add (local.get $fd) (i32.const 1)))
+        (br $scan)))
+
+    (i32.store (i32.const 4112) (call $fd_prestat_get (local.get $preopen) (i32.const 16384)))
+
+    ;; create and truncate the probe file, with the right to write to it
+    (i32.store (i32.const 4116)
+      (call $path_open (local.get $preopen) (i32.const 0) (i32.const 256) (i32.const 18) (i32.const 9) (i64.const 64) (i64.const 0) (i32.const 0) (i32.const 16400)))
+    (if (i32.eqz (i32.load (i32.const 4116)))
+      (then (drop (call $fd_close (i32.load (i32.const 16400))))))
+
+    ;; open the read probe file with only the right to read, and read from it
+    (i32.store (i32.const 4136)
+      (call $path_open (local.get $preopen) (i32.const 0) (i32.const 288) (i32.const 17) (i32.const 0) (i64.const 2) (i64.const 0) (i32.const 0) (i32.const 16400)))
+    (if (i32.eqz (i32.load (i32.const 4136)))
+      (then
+        (i32.store (i32.const 16408) (i32.const 16416))
+        (i32.store (i32.const 16412) (i32.const 8))
+        (i32.store (i32.const 4136) (call $fd_read (i32.load (i32.const 16400)) (i32.const 16408) (i32.const 1) (i32.const 16424)))
+        (drop (call $fd_close (i32.load (i32.const 16400))))))
+
+    (drop (call $clock_time_get (i32.const 0) (i64.const 1) (i32.const 4120)))
+    (drop (call $random_get (i32.const 4128) (i32.const 8)))
+
+    (call $return_result
+      (i32.const 4096)
+      (i32.add (i32.const 44) (i32.add (i32.load (i32.const 4100)) (i32.load (i32.const 4108))))
+      (local.get $ident))))
@@ -0,0 +1,66 @@
+;; a module built against v2 of the host API, as wit-bindgen would lay it out: it logs its input, stores it in the
+;; cache and returns what it reads back from the cache, or returns a run-error if the input is empty
+(module
+  (import "sat:host/log@2.0.0" "log" (func $log (param i32 i32 i32)))
+  (import "sat:host/cache@2.0.0" "set" (func $cache_set (param i32 i32 i32 i32 i32 i32)))
+  (import "sat:host/cache@2.0.0" "get" (func $cache_get (param i32 i32 i32)))
+
+  (memory (export "memory") 1)
+
+  ;; the cache key, and the message of the error returned for an empty input
+  (data (i32.const 64) "wit-echo")
+  (data (i32.const 80) "empty input")
+
+  ;; a bump allocator, reset once the host has read the result of run
+  (global $heap (mut i32) (i32.const 4096))
+
+  (func $cabi_realloc (export "cabi_realloc") (param $old i32) (param $oldSize i32) (param $align i32) (param $size i32) (result i32)
+    (local $pointer i32)
+    ;; align the heap
+    (local.set $pointer
+      (i32.and
+        (i32.add (global.get $heap) (i32.sub (local.get $align) (i32.const 1)))
+        (i32.sub (i32.const 0) (local.get $align))))
+    (global.set $heap (i32.add (local.get $pointer) (local.get $size)))
+    ;; grow the memory to fit
+    (if (i32.gt_u (global.get $heap) (i32.mul (memory.size) (i32.const 65536)))
+      (then
+        (drop (memory.grow
+          (i32.add
+            (i32.div_u (i32.sub (global.get $heap) (i32.mul (memory.size) (i32.const 65536))) (i32.const 65536))
+            (i32.const 1))))))
+    (local.get $pointer))
+
+  ;; run's return area is at 16, and the return areas of cache.set and cache.get are at 32 and 48
+  (func (export "run") (param $pointer i32) (param $size i32) (result i32)
+    (if (i32.eqz (local.get $size))
+      (then
+        (i32.store8 (i32.const 16) (i32.const 1))
+        (i32.store16 (i32.const 20) (i32.const 400))
+        (i32.store (i32.const 24) (i32.const 80))
+        (i32.store (i32.const 28) (i32.const 11))
+        (return (i32.const 16))))
+
+    ;; log.log(info, input)
+    (call $log (i32.const 2) (local.get $pointer) (local.get $size))
+
+    ;; cache.set("wit-echo", input, 0), returning a run-error with the message if it fails
+    (call $cache_set (i32.const 64) (i32.const 8) (local.get $pointer) (local.get $size) (i32.const 0) (i32.const 32))
+    (if (i32.load8_u (i32.const 32))
+      (then
+        (i32.store8 (i32.const 16) (i32.const 1))
+        (i32.store16 (i32.const 20) (i32.const 500))
+        (i64.store (i32.const 24) (i64.load (i32.const 36)))
+        (return (i32.const 16))))
+
+    ;; cache.get("wit-echo"), returning an empty output if it is none
+    (call $cache_get (i32.const 64) (i32.const 8) (i32.const 48))
+    (i32.store8 (i32.const 16) (i32.const 0))
+    (i64.store (i32.const 20) (i64.const 0))
+    (if (i32.load8_u (i32.const 48))
+      (then
+        (i64.store (i32.const 20) (i64.load (i32.const 52)))))
+    (i32.const 16))
+
+  (func (export "cabi_post_run") (param $pointer i32)
+    (global.set $heap (i32.const 4096))))
@@ -0,0 +1,35 @@
+;; a module built against v2 of the host API that traps when its input starts with a t, having marked its state as
+;; broken first. Otherwise it returns "broken" if an earlier call trapped within the same instance, or "ok" if not.
+(module
+  (memory (export "memory") 1)
+
+  (data (i32.const 64) "okbroken")
+
+  (global $heap (mut i32) (i32.const 4096))
+  (global $broken (mut i32) (i32.const 0))
+
+  (func (export "cabi_realloc") (param $old i32) (param $oldSize i32) (param $align i32) (param $size i32) (result i32)
+    (local $pointer i32)
+    (local.set $pointer (global.get $heap))
+    (global.set $heap (i32.add (local.get $pointer) (local.get $size)))
+    (local.get $pointer))
+
+  ;; run's return area is at 16
+  (func (export "run") (param $pointer i32) (param $size i32) (result i32)
+    (if (i32.and (i32.ne (local.get $size) (i32.const 0)) (i32.eq (i32.load8_u (local.get $pointer)) (i32.const 0x74)))
+      (then
+        (global.set $broken (i32.const 1))
+        unreachable))
+
+    (i32.store8 (i32.const 16) (i32.const 0))
+    (if (global.get $broken)
+      (then
+        (i32.store (i32.const 20) (i32.const 66))
+        (i32.store (i32.const 24) (i32.const 6)))
+      (else
+        (i32.store (i32.const 20) (i32.const 64))
+        (i32.store (i32.const 24) (i32.const 2))))
+    (i32.const 16))
+
+  (func (export "cabi_post_run") (param $pointer i32)
+    (global.set $heap (i32.const 4096))))
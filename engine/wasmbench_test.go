@@ -2,15 +2,26 @@ package engine
 
 import (
 	"fmt"
+	"os"
+	goruntime "runtime"
 	"testing"
 
 	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/tenant"
+	"github.com/suborbital/e2core/scheduler"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine/runtime"
 )
 
 func BenchmarkRunnable(b *testing.B) {
 	e := New()
 
-	doWasm, _ := e.RegisterFromFile("wasm", "./testdata/hello-echo/hello-echo.wasm")
+	doWasm, err := e.RegisterFromFile("wasm", "./testdata/hello-echo/hello-echo.wasm")
+	if err != nil {
+		b.Fatal(errors.Wrap(err, "failed to RegisterFromFile"))
+	}
 
 	for n := 0; n < b.N; n++ {
 		res, err := doWasm("my name is joe").Then()
@@ -27,7 +38,10 @@ func BenchmarkRunnable(b *testing.B) {
 func BenchmarkSwiftRunnable(b *testing.B) {
 	e := New()
 
-	doWasm, _ := e.RegisterFromFile("wasm", "./testdata/hello-swift/hello-swift.wasm")
+	doWasm, err := e.RegisterFromFile("wasm", "./testdata/hello-swift/hello-swift.wasm")
+	if err != nil {
+		b.Fatal(errors.Wrap(err, "failed to RegisterFromFile"))
+	}
 
 	for n := 0; n < b.N; n++ {
 		res, err := doWasm("my name is joe").Then()
@@ -40,3 +54,115 @@ func BenchmarkSwiftRunnable(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkHostCall measures invocations of a module that returns its result through a host function. Instances are
+// reused so that the invocations aren't dominated by building them.
+func BenchmarkHostCall(b *testing.B) {
+	for _, name := range runtime.Backends() {
+		b.Run(name, func(b *testing.B) {
+			doWasm, err := registerHostCall(name, runtime.PoolReuse)
+			if err != nil {
+				b.Fatal(errors.Wrap(err, "failed to registerHostCall"))
+			}
+
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				if _, err := doWasm("my name is joe").Then(); err != nil {
+					b.Error(errors.Wrap(err, "failed to Then"))
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkHostCallParallel measures concurrent invocations of the host call benchmark
+func BenchmarkHostCallParallel(b *testing.B) {
+	for _, name := range runtime.Backends() {
+		b.Run(name, func(b *testing.B) {
+			doWasm, err := registerHostCall(name, runtime.PoolReuse, scheduler.PoolSize(goruntime.NumCPU()))
+			if err != nil {
+				b.Fatal(errors.Wrap(err, "failed to registerHostCall"))
+			}
+
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := doWasm("my name is joe").Then(); err != nil {
+						b.Error(errors.Wrap(err, "failed to Then"))
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkHostCallFresh measures concurrent invocations of the host call benchmark in the default pool mode, in which
+// an instance is built, and given an ident, for every invocation
+func BenchmarkHostCallFresh(b *testing.B) {
+	for _, name := range runtime.Backends() {
+		b.Run(name, func(b *testing.B) {
+			doWasm, err := registerHostCall(name, runtime.PoolFresh, scheduler.PoolSize(goruntime.NumCPU()))
+			if err != nil {
+				b.Fatal(errors.Wrap(err, "failed to registerHostCall"))
+			}
+
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := doWasm("my name is joe").Then(); err != nil {
+						b.Error(errors.Wrap(err, "failed to Then"))
+					}
+				}
+			})
+		})
+	}
+}
+
+// registerHostCall registers hello-echo, which returns its result through a host function, on the named runtime with
+// a pool in the given mode
+func registerHostCall(name string, mode runtime.PoolMode, opts ...scheduler.Option) (scheduler.JobFunc, error) {
+	data, err := os.ReadFile("./testdata/hello-echo/hello-echo.wasm")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ReadFile")
+	}
+
+	config := runtime.Config{Pool: runtime.Pool{Mode: mode}}
+
+	return New(UseRuntime(name)).RegisterWithConfig("wasm", tenant.NewWasmModuleRef("hello-echo", "", data), config, opts...)
+}
+
+// BenchmarkUseInstance measures the overhead of taking an instance from an environment's pool for an invocation
+func BenchmarkUseInstance(b *testing.B) {
+	data, err := os.ReadFile("./testdata/caller/caller.wasm")
+	if err != nil {
+		b.Fatal(errors.Wrap(err, "failed to ReadFile"))
+	}
+
+	config := runtime.Config{Pool: runtime.Pool{Mode: runtime.PoolReuse}}
+
+	builderFunc, err := runtime.Backend(runtime.DefaultBackend())
+	if err != nil {
+		b.Fatal(errors.Wrap(err, "failed to Backend"))
+	}
+
+	env := runtime.NewEnvironment(builderFunc(tenant.NewWasmModuleRef("caller", "", data), api.New().HostFunctions(), config), config)
+
+	for i := 0; i < goruntime.NumCPU(); i++ {
+		if err := env.AddInstance(); err != nil {
+			b.Fatal(errors.Wrap(err, "failed to AddInstance"))
+		}
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := env.UseInstance(nil, func(*runtime.WasmInstance, int32) {}); err != nil {
+				b.Error(errors.Wrap(err, "failed to UseInstance"))
+			}
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package wasmbinary
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// ProducersSection is the name of the custom section that records the toolchain that built a module
+const ProducersSection = "producers"
+
+// CustomSection is a named section holding metadata that doesn't affect how the module runs
+type CustomSection struct {
+	Name string
+	Data []byte
+}
+
+// CustomSections returns the custom sections of a module, in order
+func CustomSections(module []byte) ([]CustomSection, error) {
+	sections, err := Sections(module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to Sections")
+	}
+
+	customs := []CustomSection{}
+
+	for _, s := range sections {
+		if s.ID != SectionCustom {
+			continue
+		}
+
+		r := newReader(s.Data)
+
+		name, err := r.name()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read custom section name")
+		}
+
+		customs = append(customs, CustomSection{Name: name, Data: s.Data[r.pos:]})
+	}
+
+	return customs, nil
+}
+
+// Producer is a tool listed in the producers section
+type Producer struct {
+	Name    string
+	Version string
+}
+
+// Producers maps each field of the producers section (language, processed-by or sdk) to the tools listed in it
+type Producers map[string][]Producer
+
+// ReadProducers reads the contents of a producers section
+func ReadProducers(section []byte) (Producers, error) {
+	r := newReader(section)
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read field count")
+	}
+
+	producers := Producers{}
+
+	for i := uint32(0); i < count; i++ {
+		field, err := r.name()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read field name")
+		}
+
+		values, err := r.u32()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s count", field)
+		}
+
+		for j := uint32(0); j < values; j++ {
+			p := Producer{}
+
+			if p.Name, err = r.name(); err != nil {
+				return nil, errors.Wrapf(err, "failed to read %s name", field)
+			}
+
+			if p.Version, err = r.name(); err != nil {
+				return nil, errors.Wrapf(err, "failed to read %s version", field)
+			}
+
+			producers[field] = append(producers[field], p)
+		}
+	}
+
+	return producers, nil
+}
+
+// NameSection is the name of the custom section that holds the names of a module's functions
+const NameSection = "name"
+
+// nameSubsectionFunctions is the ID of the name section's function names subsection
+const nameSubsectionFunctions byte = 1
+
+// ReadFunctionNames reads the function names in a name section, indexed like the module's functions (imported
+// functions first). Functions without a name are left out.
+func ReadFunctionNames(section []byte) (map[uint32]string, error) {
+	r := newReader(section)
+	names := map[uint32]string{}
+
+	for !r.done() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read subsection id")
+		}
+
+		data, err := r.bytes()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read subsection %d", id)
+		}
+
+		if id != nameSubsectionFunctions {
+			continue
+		}
+
+		sub := newReader(data)
+
+		count, err := sub.u32()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read function name count")
+		}
+
+		for i := uint32(0); i < count; i++ {
+			index, err := sub.u32()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read function index")
+			}
+
+			if names[index], err = sub.name(); err != nil {
+				return nil, errors.Wrapf(err, "failed to read name of function %d", index)
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// TrailingCustomSection returns the contents of the named custom section if it is the last section of the module,
+// along with the module up to the start of that section. ok is false if the module ends with any other section.
+func TrailingCustomSection(module []byte, name string) (rest, data []byte, ok bool, err error) {
+	if !bytes.HasPrefix(module, header) {
+		return nil, nil, false, ErrNotWasm
+	}
+
+	r := newReader(module[len(header):])
+	start := 0
+
+	var last []byte
+
+	for !r.done() {
+		start = r.pos
+
+		id, err := r.byte()
+		if err != nil {
+			return nil, nil, false, errors.Wrap(err, "failed to read section id")
+		}
+
+		if last, err = r.bytes(); err != nil {
+			return nil, nil, false, errors.Wrapf(err, "failed to read section %d", id)
+		}
+
+		if id != SectionCustom {
+			last = nil
+		}
+	}
+
+	if last == nil {
+		return nil, nil, false, nil
+	}
+
+	sr := newReader(last)
+
+	sectionName, err := sr.name()
+	if err != nil {
+		return nil, nil, false, errors.Wrap(err, "failed to read custom section name")
+	}
+
+	if sectionName != name {
+		return nil, nil, false, nil
+	}
+
+	return module[:len(header)+start], last[sr.pos:], true, nil
+}
+
+// AppendCustomSection returns a copy of the module with a custom section added to its end
+func AppendCustomSection(module []byte, name string, data []byte) []byte {
+	contents := &writer{}
+	contents.name(name)
+	contents.raw(data)
+
+	w := &writer{}
+	w.raw(module)
+	w.byte(SectionCustom)
+	w.bytes(contents.buf.Bytes())
+
+	return w.buf.Bytes()
+}
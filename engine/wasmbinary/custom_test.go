@@ -0,0 +1,130 @@
+package wasmbinary
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestReadProducers(t *testing.T) {
+	producers := &writer{}
+	producers.u32(2)
+	producers.name("language")
+	producers.u32(1)
+	producers.name("Rust")
+	producers.name("")
+	producers.name("processed-by")
+	producers.u32(2)
+	producers.name("rustc")
+	producers.name("1.60.0")
+	producers.name("walrus")
+	producers.name("0.19.0")
+
+	custom := &writer{}
+	custom.name(ProducersSection)
+	custom.raw(producers.buf.Bytes())
+
+	module := Encode([]Section{
+		{ID: SectionCustom, Data: custom.buf.Bytes()},
+	})
+
+	customs, err := CustomSections(module)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to CustomSections"))
+	}
+
+	if len(customs) != 1 || customs[0].Name != ProducersSection {
+		t.Fatalf("expected the producers section, got %+v", customs)
+	}
+
+	got, err := ReadProducers(customs[0].Data)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadProducers"))
+	}
+
+	expected := Producers{
+		"language":     {{Name: "Rust"}},
+		"processed-by": {{Name: "rustc", Version: "1.60.0"}, {Name: "walrus", Version: "0.19.0"}},
+	}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestReadFunctionNames(t *testing.T) {
+	functions := &writer{}
+	functions.u32(2)
+	functions.u32(0)
+	functions.name("log_msg")
+	functions.u32(3)
+	functions.name("run_e")
+
+	locals := &writer{}
+	locals.u32(0)
+
+	names := &writer{}
+	names.name(NameSection)
+	// a module name subsection comes first, and the local names subsection after the function names
+	names.byte(0)
+	names.bytes([]byte{4, 't', 'e', 's', 't'})
+	names.byte(nameSubsectionFunctions)
+	names.bytes(functions.buf.Bytes())
+	names.byte(2)
+	names.bytes(locals.buf.Bytes())
+
+	module := Encode([]Section{
+		{ID: SectionCustom, Data: names.buf.Bytes()},
+	})
+
+	customs, err := CustomSections(module)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to CustomSections"))
+	}
+
+	got, err := ReadFunctionNames(customs[0].Data)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadFunctionNames"))
+	}
+
+	expected := map[uint32]string{0: "log_msg", 3: "run_e"}
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestTrailingCustomSection(t *testing.T) {
+	module := Encode([]Section{
+		{ID: SectionType, Data: []byte{0}},
+	})
+
+	signed := AppendCustomSection(module, "signature", []byte{1, 2, 3})
+
+	rest, data, ok, err := TrailingCustomSection(signed, "signature")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to TrailingCustomSection"))
+	}
+
+	if !ok || !reflect.DeepEqual(rest, module) || !reflect.DeepEqual(data, []byte{1, 2, 3}) {
+		t.Errorf("expected the section to be split from the module, got %v %v %v", rest, data, ok)
+	}
+
+	notLast := Encode([]Section{
+		{ID: SectionType, Data: []byte{0}},
+		{ID: SectionCustom, Data: append([]byte{byte(len("signature"))}, "signature"...)},
+		{ID: SectionType, Data: []byte{0}},
+	})
+
+	// the section is only found when it is last, and has the name asked for
+	for _, m := range [][]byte{module, AppendCustomSection(signed, "other", nil), notLast} {
+		if _, _, ok, err := TrailingCustomSection(m, "signature"); err != nil || ok {
+			t.Errorf("expected no trailing signature section in %v, got %v %v", m, ok, err)
+		}
+	}
+
+	if _, _, _, err := TrailingCustomSection([]byte("not a module"), "signature"); err == nil {
+		t.Error("expected an error for a module that isn't Wasm")
+	}
+}
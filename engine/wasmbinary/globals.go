@@ -0,0 +1,252 @@
+package wasmbinary
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// value types as defined by the Wasm binary format
+const (
+	ValueI32 byte = 0x7f
+	ValueI64 byte = 0x7e
+	ValueF32 byte = 0x7d
+	ValueF64 byte = 0x7c
+)
+
+// external kinds as defined by the Wasm binary format
+const (
+	KindFunc   byte = 0x00
+	KindTable  byte = 0x01
+	KindMemory byte = 0x02
+	KindGlobal byte = 0x03
+	KindTag    byte = 0x04
+)
+
+// ExportGlobals rewrites the module so that each of the mutable numeric globals that it defines is exported,
+// named with the given prefix followed by a counter starting at zero. This allows the host to capture and
+// restore globals (such as the stack pointer) that the module would otherwise keep to itself.
+func ExportGlobals(module []byte, prefix string) ([]byte, error) {
+	sections, err := Sections(module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to Sections")
+	}
+
+	// globals are indexed after the ones that are imported
+	var index uint32
+	var mutable []uint32
+
+	exportAt := -1
+
+	for i, s := range sections {
+		switch s.ID {
+		case SectionImport:
+			count, err := importedGlobals(s.Data)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read imports")
+			}
+
+			index += count
+		case SectionGlobal:
+			if mutable, err = mutableGlobals(s.Data, index); err != nil {
+				return nil, errors.Wrap(err, "failed to read globals")
+			}
+		case SectionExport:
+			exportAt = i
+		}
+	}
+
+	if len(mutable) == 0 {
+		return module, nil
+	}
+
+	w := &writer{}
+	var existing []byte
+
+	if exportAt >= 0 {
+		r := newReader(sections[exportAt].Data)
+
+		count, err := r.u32()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read exports")
+		}
+
+		w.u32(count + uint32(len(mutable)))
+		existing = sections[exportAt].Data[r.pos:]
+	} else {
+		w.u32(uint32(len(mutable)))
+	}
+
+	w.raw(existing)
+
+	for i, globalIndex := range mutable {
+		w.name(fmt.Sprintf("%s%d", prefix, i))
+		w.byte(KindGlobal)
+		w.u32(globalIndex)
+	}
+
+	exports := Section{ID: SectionExport, Data: w.buf.Bytes()}
+
+	if exportAt >= 0 {
+		sections[exportAt] = exports
+	} else {
+		sections = insertSection(sections, exports)
+	}
+
+	return Encode(sections), nil
+}
+
+// importedGlobals counts the globals in an import section
+func importedGlobals(section []byte) (uint32, error) {
+	r := newReader(section)
+
+	count, err := r.u32()
+	if err != nil {
+		return 0, err
+	}
+
+	var globals uint32
+
+	for i := uint32(0); i < count; i++ {
+		if _, err := r.name(); err != nil {
+			return 0, err
+		}
+
+		if _, err := r.name(); err != nil {
+			return 0, err
+		}
+
+		kind, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch kind {
+		case KindFunc:
+			_, err = r.u32()
+		case KindTable:
+			if _, err = r.byte(); err == nil {
+				_, err = readLimits(r)
+			}
+		case KindMemory:
+			_, err = readLimits(r)
+		case KindGlobal:
+			globals++
+			_, err = r.raw(2)
+		case KindTag:
+			if _, err = r.byte(); err == nil {
+				_, err = r.u32()
+			}
+		default:
+			err = fmt.Errorf("unknown import kind %d", kind)
+		}
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return globals, nil
+}
+
+// mutableGlobals returns the indices of the mutable numeric globals in a global section
+func mutableGlobals(section []byte, first uint32) ([]uint32, error) {
+	r := newReader(section)
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	mutable := []uint32{}
+
+	for i := uint32(0); i < count; i++ {
+		valType, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		mut, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := skipConstExpr(r); err != nil {
+			return nil, err
+		}
+
+		switch valType {
+		case ValueI32, ValueI64, ValueF32, ValueF64:
+			if mut == 0x01 {
+				mutable = append(mutable, first+i)
+			}
+		}
+	}
+
+	return mutable, nil
+}
+
+// skipConstExpr reads past a constant expression, such as a global's initializer
+func skipConstExpr(r *reader) error {
+	for {
+		op, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case 0x0b: // end
+			return nil
+		case 0x41, 0x42: // i32.const, i64.const
+			err = r.skipLEB()
+		case 0x43: // f32.const
+			_, err = r.raw(4)
+		case 0x44: // f64.const
+			_, err = r.raw(8)
+		case 0x23, 0xd2: // global.get, ref.func
+			_, err = r.u32()
+		case 0xd0: // ref.null
+			_, err = r.byte()
+		case 0xfd: // v128.const
+			if _, err = r.u32(); err == nil {
+				_, err = r.raw(16)
+			}
+		default:
+			// the extended constant arithmetic instructions have no immediates
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// insertSection adds a section in its place according to the order required by the Wasm binary format
+func insertSection(sections []Section, s Section) []Section {
+	at := len(sections)
+
+	for i, existing := range sections {
+		if existing.ID != SectionCustom && order(existing.ID) > order(s.ID) {
+			at = i
+			break
+		}
+	}
+
+	sections = append(sections, Section{})
+	copy(sections[at+1:], sections[at:])
+	sections[at] = s
+
+	return sections
+}
+
+// order returns a section's position in a module, which differs from its ID for sections added by later proposals
+func order(id byte) int {
+	switch id {
+	case SectionTag:
+		return int(SectionGlobal)*2 - 1
+	case SectionDataCount:
+		return int(SectionCode)*2 - 1
+	default:
+		return int(id) * 2
+	}
+}
@@ -0,0 +1,123 @@
+package wasmbinary
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// globalsModule builds a module that imports one global and defines a mutable i32, an immutable i64 and a mutable f64
+func globalsModule(withExports bool) []byte {
+	imports := &writer{}
+	imports.u32(1)
+	imports.name("env")
+	imports.name("imported")
+	imports.byte(KindGlobal)
+	imports.raw([]byte{ValueI32, 0x00})
+
+	globals := &writer{}
+	globals.u32(3)
+	globals.raw([]byte{ValueI32, 0x01, 0x41, 0x80, 0x80, 0x04, 0x0b})
+	globals.raw([]byte{ValueI64, 0x00, 0x42, 0x01, 0x0b})
+	globals.raw([]byte{ValueF64, 0x01, 0x44, 0, 0, 0, 0, 0, 0, 0, 0, 0x0b})
+
+	sections := []Section{
+		{ID: SectionImport, Data: imports.buf.Bytes()},
+		{ID: SectionGlobal, Data: globals.buf.Bytes()},
+		{ID: SectionCode, Data: []byte{0x00}},
+	}
+
+	if withExports {
+		exports := &writer{}
+		exports.u32(1)
+		exports.name("memory")
+		exports.byte(KindMemory)
+		exports.u32(0)
+
+		sections = insertSection(sections, Section{ID: SectionExport, Data: exports.buf.Bytes()})
+	}
+
+	return Encode(sections)
+}
+
+type export struct {
+	name  string
+	kind  byte
+	index uint32
+}
+
+func readExports(t *testing.T, module []byte) ([]export, []byte) {
+	sections, err := Sections(module)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Sections"))
+	}
+
+	exports := []export{}
+	order := []byte{}
+
+	for _, s := range sections {
+		order = append(order, s.ID)
+
+		if s.ID != SectionExport {
+			continue
+		}
+
+		r := newReader(s.Data)
+		count, _ := r.u32()
+
+		for i := uint32(0); i < count; i++ {
+			e := export{}
+			e.name, _ = r.name()
+			e.kind, _ = r.byte()
+			e.index, _ = r.u32()
+
+			exports = append(exports, e)
+		}
+	}
+
+	return exports, order
+}
+
+func TestExportGlobals(t *testing.T) {
+	for _, withExports := range []bool{false, true} {
+		exported, err := ExportGlobals(globalsModule(withExports), "g")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "failed to ExportGlobals"))
+		}
+
+		exports, order := readExports(t, exported)
+
+		want := []export{{"g0", KindGlobal, 1}, {"g1", KindGlobal, 3}}
+		if withExports {
+			want = append([]export{{"memory", KindMemory, 0}}, want...)
+		}
+
+		if len(exports) != len(want) {
+			t.Fatalf("expected %v, got %v", want, exports)
+		}
+
+		for i := range want {
+			if exports[i] != want[i] {
+				t.Errorf("expected %v, got %v", want[i], exports[i])
+			}
+		}
+
+		wantOrder := []byte{SectionImport, SectionGlobal, SectionExport, SectionCode}
+		if string(order) != string(wantOrder) {
+			t.Errorf("expected section order %v, got %v", wantOrder, order)
+		}
+	}
+}
+
+func TestExportGlobalsNone(t *testing.T) {
+	module := Encode([]Section{{ID: SectionCode, Data: []byte{0x00}}})
+
+	exported, err := ExportGlobals(module, "g")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ExportGlobals"))
+	}
+
+	if string(exported) != string(module) {
+		t.Error("expected module to be unchanged")
+	}
+}
@@ -0,0 +1,484 @@
+package wasmbinary
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// the bits of the value returned by the export added by SignalLimits, for the limits that the module was refused by
+const (
+	LimitHitMemory int32 = 1 << 0
+	LimitHitTable  int32 = 1 << 1
+)
+
+// growSite is a memory.grow or table.grow instruction in a function body, at body[start:end]
+type growSite struct {
+	start, end int
+	table      bool
+	index      uint32
+}
+
+// growthModule holds what SignalLimits needs to know of a module to add functions and a global to it
+type growthModule struct {
+	types, funcs, globals uint32
+	// tables are the reference types of the module's tables, imported tables first
+	tables []byte
+}
+
+// SignalLimits rewrites the module so that it records when it is refused growth by one of the given limits (which
+// ApplyLimits sets), since a guest that is refused usually traps without saying why. Each memory.grow and table.grow
+// is replaced by a call to a function that grows the memory or table, and if it is refused beyond the limit, sets the
+// memory or table bit of a global. The exported function named export returns the global and clears it.
+//
+// It is only needed for runtimes that have no limiter of their own to say when they refuse growth. A module that never
+// grows a limited memory or table is returned as it is, without the export.
+func SignalLimits(module []byte, maxMemoryPages, maxTableElements uint32, export string) ([]byte, error) {
+	if maxMemoryPages == 0 && maxTableElements == 0 {
+		return module, nil
+	}
+
+	sections, err := Sections(module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to Sections")
+	}
+
+	m, err := readGrowthModule(sections)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to readGrowthModule")
+	}
+
+	codeAt := -1
+	var bodies [][]byte
+	var sites [][]growSite
+
+	memoryGrown := false
+	tablesGrown := map[uint32]bool{}
+
+	for i, s := range sections {
+		if s.ID != SectionCode {
+			continue
+		}
+
+		codeAt = i
+
+		if bodies, err = readBodies(s.Data); err != nil {
+			return nil, errors.Wrap(err, "failed to readBodies")
+		}
+
+		sites = make([][]growSite, len(bodies))
+
+		for j, body := range bodies {
+			found, err := growSites(body)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read function body %d", j)
+			}
+
+			for _, site := range found {
+				if site.table && maxTableElements > 0 && int(site.index) < len(m.tables) {
+					tablesGrown[site.index] = true
+					sites[j] = append(sites[j], site)
+				} else if !site.table && maxMemoryPages > 0 {
+					memoryGrown = true
+					sites[j] = append(sites[j], site)
+				}
+			}
+		}
+	}
+
+	if !memoryGrown && len(tablesGrown) == 0 {
+		return module, nil
+	}
+
+	flag := m.globals
+	next := m.funcs
+
+	// the guards are added after the module's functions, so that none of its own indices change
+	types := &writer{}
+	funcs := &writer{}
+	code := &writer{}
+	added := uint32(0)
+
+	addFunc := func(params []byte, results []byte, body []byte) uint32 {
+		types.byte(funcTypeForm)
+		types.bytes(params)
+		types.bytes(results)
+
+		funcs.u32(m.types + added)
+		code.bytes(body)
+
+		added++
+
+		return next + added - 1
+	}
+
+	memoryGuard := uint32(0)
+	if memoryGrown {
+		memoryGuard = addFunc([]byte{ValueI32}, []byte{ValueI32}, growGuard(nil, maxMemoryPages, flag, LimitHitMemory))
+	}
+
+	tableIndices := make([]uint32, 0, len(tablesGrown))
+	for index := range tablesGrown {
+		tableIndices = append(tableIndices, index)
+	}
+
+	sort.Slice(tableIndices, func(i, j int) bool { return tableIndices[i] < tableIndices[j] })
+
+	tableGuards := map[uint32]uint32{}
+	for _, index := range tableIndices {
+		refType := m.tables[index]
+		tableGuards[index] = addFunc([]byte{refType, ValueI32}, []byte{ValueI32}, growGuard(&index, maxTableElements, flag, LimitHitTable))
+	}
+
+	// the export returns the flag and clears it
+	readFlag := &writer{}
+	readFlag.byte(0x00)
+	readFlag.byte(0x23)
+	readFlag.u32(flag)
+	readFlag.raw([]byte{0x41, 0x00, 0x24})
+	readFlag.u32(flag)
+	readFlag.byte(0x0b)
+
+	read := addFunc(nil, []byte{ValueI32}, readFlag.buf.Bytes())
+
+	rewritten := &writer{}
+	rewritten.u32(uint32(len(bodies)) + added)
+
+	for j, body := range bodies {
+		rewritten.bytes(rewriteSites(body, sites[j], memoryGuard, tableGuards))
+	}
+
+	rewritten.raw(code.buf.Bytes())
+	sections[codeAt].Data = rewritten.buf.Bytes()
+
+	global := &writer{}
+	global.raw([]byte{ValueI32, 0x01, 0x41, 0x00, 0x0b})
+
+	exported := &writer{}
+	exported.name(export)
+	exported.byte(KindFunc)
+	exported.u32(read)
+
+	for _, add := range []struct {
+		id    byte
+		count uint32
+		data  []byte
+	}{
+		{id: SectionType, count: added, data: types.buf.Bytes()},
+		{id: SectionFunction, count: added, data: funcs.buf.Bytes()},
+		{id: SectionGlobal, count: 1, data: global.buf.Bytes()},
+		{id: SectionExport, count: 1, data: exported.buf.Bytes()},
+	} {
+		if sections, err = appendEntries(sections, add.id, add.count, add.data); err != nil {
+			return nil, errors.Wrapf(err, "failed to add to section %d", add.id)
+		}
+	}
+
+	return Encode(sections), nil
+}
+
+// readGrowthModule counts the module's types, functions and globals, and reads the types of its tables
+func readGrowthModule(sections []Section) (*growthModule, error) {
+	m := &growthModule{}
+
+	for _, s := range sections {
+		r := newReader(s.Data)
+
+		switch s.ID {
+		case SectionType:
+			count, err := r.u32()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read types")
+			}
+
+			m.types = count
+		case SectionImport:
+			if err := m.readImports(r); err != nil {
+				return nil, errors.Wrap(err, "failed to read imports")
+			}
+		case SectionFunction:
+			count, err := r.u32()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read functions")
+			}
+
+			m.funcs += count
+		case SectionGlobal:
+			count, err := r.u32()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read globals")
+			}
+
+			m.globals += count
+		case SectionTable:
+			count, err := r.u32()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read tables")
+			}
+
+			for i := uint32(0); i < count; i++ {
+				refType, err := r.byte()
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to read table")
+				}
+
+				if _, err := readLimits(r); err != nil {
+					return nil, errors.Wrap(err, "failed to read table")
+				}
+
+				m.tables = append(m.tables, refType)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// readImports counts the imported functions and globals, and reads the types of the imported tables
+func (m *growthModule) readImports(r *reader) error {
+	count, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < count; i++ {
+		if _, err := r.name(); err != nil {
+			return err
+		}
+
+		if _, err := r.name(); err != nil {
+			return err
+		}
+
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case KindFunc:
+			m.funcs++
+			_, err = r.u32()
+		case KindTable:
+			var refType byte
+			if refType, err = r.byte(); err == nil {
+				m.tables = append(m.tables, refType)
+				_, err = readLimits(r)
+			}
+		case KindMemory:
+			_, err = readLimits(r)
+		case KindGlobal:
+			m.globals++
+			_, err = r.raw(2)
+		case KindTag:
+			if _, err = r.byte(); err == nil {
+				_, err = r.u32()
+			}
+		default:
+			err = fmt.Errorf("unknown import kind %d", kind)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readBodies splits a code section into its function bodies
+func readBodies(section []byte) ([][]byte, error) {
+	r := newReader(section)
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	bodies := make([][]byte, count)
+
+	for i := range bodies {
+		if bodies[i], err = r.bytes(); err != nil {
+			return nil, err
+		}
+	}
+
+	return bodies, nil
+}
+
+// growSites finds the memory.grow instructions of the first memory, and the table.grow instructions, in a body
+func growSites(body []byte) ([]growSite, error) {
+	r := newReader(body)
+
+	if err := readBodyLocals(r); err != nil {
+		return nil, err
+	}
+
+	var sites []growSite
+
+	for !r.done() {
+		start := r.pos
+
+		op, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opMemoryGrow:
+			index, err := r.u32()
+			if err != nil {
+				return nil, err
+			}
+
+			if index == 0 {
+				sites = append(sites, growSite{start: start, end: r.pos})
+			}
+		case opPrefixMisc:
+			sub, err := newReader(body[r.pos:]).u32()
+			if err != nil {
+				return nil, err
+			}
+
+			if sub != miscTableGrow {
+				err = skipMisc(r)
+			} else if _, err = r.u32(); err == nil {
+				var index uint32
+				if index, err = r.u32(); err == nil {
+					sites = append(sites, growSite{start: start, end: r.pos, table: true, index: index})
+				}
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		default:
+			if err := skipImmediates(r, op); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return sites, nil
+}
+
+// rewriteSites replaces the grow instructions in a body with calls to their guards
+func rewriteSites(body []byte, sites []growSite, memoryGuard uint32, tableGuards map[uint32]uint32) []byte {
+	if len(sites) == 0 {
+		return body
+	}
+
+	w := &writer{}
+	last := 0
+
+	for _, site := range sites {
+		w.raw(body[last:site.start])
+
+		guard := memoryGuard
+		if site.table {
+			guard = tableGuards[site.index]
+		}
+
+		w.byte(0x10)
+		w.u32(guard)
+
+		last = site.end
+	}
+
+	w.raw(body[last:])
+
+	return w.buf.Bytes()
+}
+
+// growGuard is the body of a function that grows the memory, or the table when index isn't nil, and sets bit in the
+// flag global if it is refused because the size asked for is beyond limit. Its arguments are those of the grow
+// instruction it replaces, the last being the number of pages or elements to grow by.
+func growGuard(index *uint32, limit uint32, flag uint32, bit int32) []byte {
+	delta, result := uint32(0), uint32(1)
+	if index != nil {
+		delta, result = 1, 2
+	}
+
+	w := &writer{}
+
+	// one local holds the result of growing
+	w.raw([]byte{0x01, 0x01, ValueI32})
+
+	for i := uint32(0); i <= delta; i++ {
+		w.byte(0x20) // local.get
+		w.u32(i)
+	}
+
+	if index == nil {
+		w.raw([]byte{opMemoryGrow, 0x00})
+	} else {
+		w.byte(opPrefixMisc)
+		w.u32(miscTableGrow)
+		w.u32(*index)
+	}
+
+	w.byte(0x22) // local.tee
+	w.u32(result)
+	w.raw([]byte{0x41, 0x7f, 0x46, 0x04, 0x40}) // i32.const -1, i32.eq, if
+
+	if index == nil {
+		w.raw([]byte{0x3f, 0x00}) // memory.size
+	} else {
+		w.byte(opPrefixMisc)
+		w.u32(miscTableSize)
+		w.u32(*index)
+	}
+
+	w.byte(0xad) // i64.extend_i32_u
+	w.byte(0x20)
+	w.u32(delta)
+	w.raw([]byte{0xad, 0x7c, 0x42}) // i64.extend_i32_u, i64.add, i64.const
+	w.s64(int64(limit))
+	w.raw([]byte{0x56, 0x04, 0x40}) // i64.gt_u, if
+
+	w.byte(0x23) // global.get
+	w.u32(flag)
+	w.byte(0x41) // i32.const
+	w.s64(int64(bit))
+	w.byte(0x72) // i32.or
+	w.byte(0x24) // global.set
+	w.u32(flag)
+
+	w.raw([]byte{0x0b, 0x0b, 0x20}) // end, end, local.get
+	w.u32(result)
+	w.byte(0x0b)
+
+	return w.buf.Bytes()
+}
+
+// appendEntries adds count entries to the vector that makes up a section, adding the section if the module has none
+func appendEntries(sections []Section, id byte, count uint32, data []byte) ([]Section, error) {
+	w := &writer{}
+
+	for i, s := range sections {
+		if s.ID != id {
+			continue
+		}
+
+		r := newReader(s.Data)
+
+		existing, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+
+		w.u32(existing + count)
+		w.raw(s.Data[r.pos:])
+		w.raw(data)
+
+		sections[i].Data = w.buf.Bytes()
+
+		return sections, nil
+	}
+
+	w.u32(count)
+	w.raw(data)
+
+	return insertSection(sections, Section{ID: id, Data: w.buf.Bytes()}), nil
+}
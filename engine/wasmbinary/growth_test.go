@@ -0,0 +1,101 @@
+package wasmbinary
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// growthTestModule builds a module with an imported function, a memory, and one function with the given body
+func growthTestModule(body []byte) []byte {
+	imports := &writer{}
+	imports.u32(1)
+	imports.name("env")
+	imports.name("f")
+	imports.byte(KindFunc)
+	imports.u32(0)
+
+	memories := &writer{}
+	memories.u32(1)
+	limits{min: 1}.write(memories)
+
+	code := &writer{}
+	code.u32(1)
+	code.bytes(body)
+
+	return Encode([]Section{
+		{ID: SectionType, Data: []byte{0x01, funcTypeForm, 0x00, 0x00}},
+		{ID: SectionImport, Data: imports.buf.Bytes()},
+		{ID: SectionFunction, Data: []byte{0x01, 0x00}},
+		{ID: SectionMemory, Data: memories.buf.Bytes()},
+		{ID: SectionCode, Data: code.buf.Bytes()},
+	})
+}
+
+func TestSignalLimits(t *testing.T) {
+	// i32.const 1, memory.grow, drop, i64.const 5, drop, end
+	mod := growthTestModule([]byte{0x00, 0x41, 0x01, 0x40, 0x00, 0x1a, 0x42, 0x05, 0x1a, 0x0b})
+
+	signalled, err := SignalLimits(mod, 16, 0, "hit")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to SignalLimits"))
+	}
+
+	exports, _ := readExports(t, signalled)
+
+	// the imported function and the module's own keep their indices, followed by the guard and the export
+	if expected := []export{{name: "hit", kind: KindFunc, index: 3}}; !reflect.DeepEqual(exports, expected) {
+		t.Errorf("expected exports %v, got %v", expected, exports)
+	}
+
+	sections, err := Sections(signalled)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Sections"))
+	}
+
+	for _, s := range sections {
+		switch s.ID {
+		case SectionFunction:
+			if !bytes.Equal(s.Data, []byte{0x03, 0x00, 0x01, 0x02}) {
+				t.Errorf("expected the guard and export to be added with their own types, got %x", s.Data)
+			}
+		case SectionGlobal:
+			if !bytes.Equal(s.Data, []byte{0x01, ValueI32, 0x01, 0x41, 0x00, 0x0b}) {
+				t.Errorf("expected a mutable i32 flag, got %x", s.Data)
+			}
+		case SectionCode:
+			bodies, err := readBodies(s.Data)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to readBodies"))
+			}
+
+			// memory.grow is replaced by a call to the guard
+			if expected := []byte{0x00, 0x41, 0x01, 0x10, 0x02, 0x1a, 0x42, 0x05, 0x1a, 0x0b}; len(bodies) != 3 || !bytes.Equal(bodies[0], expected) {
+				t.Errorf("expected the module's function to call the guard, got %x", bodies)
+			}
+		}
+	}
+}
+
+func TestSignalLimitsNoGrowth(t *testing.T) {
+	mod := growthTestModule([]byte{0x00, 0x3f, 0x00, 0x1a, 0x0b})
+
+	signalled, err := SignalLimits(mod, 16, 8, "hit")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to SignalLimits"))
+	}
+
+	if !bytes.Equal(signalled, mod) {
+		t.Error("expected a module that never grows to be unchanged")
+	}
+}
+
+func TestSignalLimitsUnknownInstruction(t *testing.T) {
+	mod := growthTestModule([]byte{0x00, 0xff, 0x0b})
+
+	if _, err := SignalLimits(mod, 16, 0, "hit"); !errors.Is(err, ErrUnknownInstruction) {
+		t.Errorf("expected ErrUnknownInstruction, got %v", err)
+	}
+}
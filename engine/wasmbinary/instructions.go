@@ -0,0 +1,225 @@
+package wasmbinary
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownInstruction is returned for a function body that uses an instruction that can't be decoded
+var ErrUnknownInstruction = errors.New("unknown instruction")
+
+// opcodes of the instructions that are rewritten, and of the prefixes of multi-byte instructions
+const (
+	opMemoryGrow   byte = 0x40
+	opPrefixMisc   byte = 0xfc
+	opPrefixSIMD   byte = 0xfd
+	opPrefixAtomic byte = 0xfe
+
+	miscTableGrow uint32 = 15
+	miscTableSize uint32 = 16
+)
+
+// readBodyLocals reads past the local declarations at the start of a function body
+func readBodyLocals(r *reader) error {
+	count, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < count; i++ {
+		if _, err := r.u32(); err != nil {
+			return err
+		}
+
+		if err := skipValueType(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// skipValueType reads past a value type, including the typed references of the function references proposal
+func skipValueType(r *reader) error {
+	b, err := r.byte()
+	if err != nil {
+		return err
+	}
+
+	if b == 0x63 || b == 0x64 {
+		return r.skipLEB()
+	}
+
+	return nil
+}
+
+// skipBlockType reads past the type of a block, which is empty, a value type or the index of a function type
+func skipBlockType(r *reader) error {
+	if r.done() {
+		return ErrUnexpectedEOF
+	}
+
+	switch b := r.data[r.pos]; {
+	case b == 0x40 || (b >= 0x6f && b <= 0x7f):
+		r.pos++
+		return nil
+	case b == 0x63 || b == 0x64:
+		return skipValueType(r)
+	default:
+		return r.skipLEB()
+	}
+}
+
+// skipMemArg reads past the alignment and offset of a memory access, and the memory's index if it has one
+func skipMemArg(r *reader) error {
+	align, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	if align&0x40 != 0 {
+		if _, err := r.u32(); err != nil {
+			return err
+		}
+	}
+
+	_, err = r.u32()
+
+	return err
+}
+
+// skipImmediates reads past the immediates of the instruction whose opcode has just been read. Prefixed instructions
+// are read in full, since their immediates depend on the opcode that follows the prefix.
+func skipImmediates(r *reader, op byte) error {
+	var err error
+
+	switch {
+	case op == 0x02 || op == 0x03 || op == 0x04 || op == 0x06: // block, loop, if, try
+		err = skipBlockType(r)
+	case op == 0x07 || op == 0x08 || op == 0x09 || op == 0x18: // catch, throw, rethrow, delegate
+		_, err = r.u32()
+	case op == 0x0c || op == 0x0d || op == 0x10 || op == 0x12 || op == 0x14 || op == 0x15 || op == 0xd5 || op == 0xd6:
+		// br, br_if, call, return_call, call_ref, return_call_ref, br_on_null, br_on_non_null
+		_, err = r.u32()
+	case op == 0x0e: // br_table
+		var count uint32
+		if count, err = r.u32(); err == nil {
+			for i := uint32(0); i <= count && err == nil; i++ {
+				_, err = r.u32()
+			}
+		}
+	case op == 0x11 || op == 0x13: // call_indirect, return_call_indirect
+		if _, err = r.u32(); err == nil {
+			_, err = r.u32()
+		}
+	case op == 0x1c: // select with types
+		var count uint32
+		if count, err = r.u32(); err == nil {
+			for i := uint32(0); i < count && err == nil; i++ {
+				err = skipValueType(r)
+			}
+		}
+	case op >= 0x20 && op <= 0x26: // local, global and table get and set
+		_, err = r.u32()
+	case op >= 0x28 && op <= 0x3e: // loads and stores
+		err = skipMemArg(r)
+	case op == 0x3f || op == opMemoryGrow: // memory.size, memory.grow
+		_, err = r.u32()
+	case op == 0x41 || op == 0x42: // i32.const, i64.const
+		err = r.skipLEB()
+	case op == 0x43: // f32.const
+		_, err = r.raw(4)
+	case op == 0x44: // f64.const
+		_, err = r.raw(8)
+	case op == 0xd0: // ref.null
+		err = skipValueType(r)
+	case op == 0xd2: // ref.func
+		_, err = r.u32()
+	case op == opPrefixMisc:
+		err = skipMisc(r)
+	case op == opPrefixSIMD:
+		err = skipSIMD(r)
+	case op == opPrefixAtomic:
+		err = skipAtomic(r)
+	case op <= 0x01 || op == 0x05 || op == 0x0b || op == 0x0f || op == 0x19 || op == 0x1a || op == 0x1b ||
+		(op >= 0x45 && op <= 0xc4) || op == 0xd1 || op == 0xd3 || op == 0xd4:
+		// control, parametric, numeric and reference instructions without immediates
+	default:
+		return errors.Wrapf(ErrUnknownInstruction, "opcode %#x", op)
+	}
+
+	return err
+}
+
+// skipMisc reads past an instruction with the 0xfc prefix, which are the saturating truncations and the bulk memory
+// and table instructions
+func skipMisc(r *reader) error {
+	sub, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	immediates := 0
+
+	switch {
+	case sub <= 7:
+	case sub == 8 || sub == 10 || sub == 12 || sub == 14: // memory.init, memory.copy, table.init, table.copy
+		immediates = 2
+	case sub <= 17:
+		immediates = 1
+	default:
+		return errors.Wrapf(ErrUnknownInstruction, "opcode 0xfc %d", sub)
+	}
+
+	for i := 0; i < immediates; i++ {
+		if _, err := r.u32(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// skipSIMD reads past an instruction with the 0xfd prefix
+func skipSIMD(r *reader) error {
+	sub, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case sub <= 11 || sub == 92 || sub == 93: // loads and stores
+		return skipMemArg(r)
+	case sub == 12 || sub == 13: // v128.const, i8x16.shuffle
+		_, err = r.raw(16)
+	case sub >= 21 && sub <= 34: // lane extracts and replaces
+		_, err = r.raw(1)
+	case sub >= 84 && sub <= 91: // lane loads and stores
+		if err = skipMemArg(r); err == nil {
+			_, err = r.raw(1)
+		}
+	case sub <= 0x113:
+	default:
+		return errors.Wrapf(ErrUnknownInstruction, "opcode 0xfd %d", sub)
+	}
+
+	return err
+}
+
+// skipAtomic reads past an instruction with the 0xfe prefix
+func skipAtomic(r *reader) error {
+	sub, err := r.u32()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case sub == 3: // atomic.fence
+		_, err = r.raw(1)
+	case sub <= 0x4e:
+		err = skipMemArg(r)
+	default:
+		return errors.Wrapf(ErrUnknownInstruction, "opcode 0xfe %d", sub)
+	}
+
+	return err
+}
@@ -0,0 +1,369 @@
+package wasmbinary
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// funcTypeForm precedes each function type in the type section
+const funcTypeForm byte = 0x60
+
+// FuncType is the signature of a function
+type FuncType struct {
+	Params  []byte
+	Results []byte
+}
+
+// Equal returns true if the signatures are identical
+func (f FuncType) Equal(other FuncType) bool {
+	return string(f.Params) == string(other.Params) && string(f.Results) == string(other.Results)
+}
+
+// String returns the signature in the Wasm text format, such as (param i32 i32) (result i32)
+func (f FuncType) String() string {
+	parts := []string{}
+
+	if len(f.Params) > 0 {
+		parts = append(parts, "(param "+valueTypeNames(f.Params)+")")
+	}
+
+	if len(f.Results) > 0 {
+		parts = append(parts, "(result "+valueTypeNames(f.Results)+")")
+	}
+
+	if len(parts) == 0 {
+		return "(func)"
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Import is a symbol that the module imports from the host
+type Import struct {
+	Module string
+	Name   string
+	Kind   byte
+	// Type is the signature of an imported function, and is nil for other kinds
+	Type *FuncType
+}
+
+// Export is a symbol that the module exports to the host
+type Export struct {
+	Name string
+	Kind byte
+	// Type is the signature of an exported function, and is nil for other kinds
+	Type *FuncType
+}
+
+// Memory is a memory that the module imports or defines, sized in 64KiB pages
+type Memory struct {
+	Min uint32
+	// Max is only meaningful if HasMax is true, otherwise the memory can grow without bound
+	Max      uint32
+	HasMax   bool
+	Imported bool
+}
+
+// Interface is the set of symbols that a module imports and exports, and the memories it needs
+type Interface struct {
+	Imports  []Import
+	Exports  []Export
+	Memories []Memory
+}
+
+// Export returns the module's export with the given name, if it exists
+func (i *Interface) Export(name string) (Export, bool) {
+	for _, e := range i.Exports {
+		if e.Name == name {
+			return e, true
+		}
+	}
+
+	return Export{}, false
+}
+
+// KindName returns the name of an import or export kind in the Wasm text format
+func KindName(kind byte) string {
+	switch kind {
+	case KindFunc:
+		return "func"
+	case KindTable:
+		return "table"
+	case KindMemory:
+		return "memory"
+	case KindGlobal:
+		return "global"
+	case KindTag:
+		return "tag"
+	}
+
+	return fmt.Sprintf("%#x", kind)
+}
+
+// ReadInterface reads the imports, exports and memories of a module, along with the signatures of any functions
+// among them
+func ReadInterface(module []byte) (*Interface, error) {
+	sections, err := Sections(module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to Sections")
+	}
+
+	var types []FuncType
+	var funcs []uint32 // the type of each function, imported functions first
+
+	iface := &Interface{}
+
+	for _, s := range sections {
+		switch s.ID {
+		case SectionType:
+			if types, err = readTypeSection(s.Data); err != nil {
+				return nil, errors.Wrap(err, "failed to read types")
+			}
+		case SectionImport:
+			var imported []uint32
+			var memories []Memory
+			if iface.Imports, imported, memories, err = readImportSection(s.Data, types); err != nil {
+				return nil, errors.Wrap(err, "failed to read imports")
+			}
+
+			funcs = append(funcs, imported...)
+			iface.Memories = append(iface.Memories, memories...)
+		case SectionFunction:
+			defined, err := readFunctionSection(s.Data)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read functions")
+			}
+
+			funcs = append(funcs, defined...)
+		case SectionMemory:
+			memories, err := readMemorySection(s.Data)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read memories")
+			}
+
+			iface.Memories = append(iface.Memories, memories...)
+		case SectionExport:
+			// the type and function sections always precede the export section
+			if iface.Exports, err = readExportSection(s.Data, types, funcs); err != nil {
+				return nil, errors.Wrap(err, "failed to read exports")
+			}
+		}
+	}
+
+	return iface, nil
+}
+
+// readTypeSection reads the function types in a type section
+func readTypeSection(section []byte) ([]FuncType, error) {
+	r := newReader(section)
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	types := make([]FuncType, count)
+
+	for i := range types {
+		form, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		if form != funcTypeForm {
+			return nil, fmt.Errorf("unknown type form %#x", form)
+		}
+
+		if types[i].Params, err = r.bytes(); err != nil {
+			return nil, err
+		}
+
+		if types[i].Results, err = r.bytes(); err != nil {
+			return nil, err
+		}
+	}
+
+	return types, nil
+}
+
+// readImportSection reads the imports in an import section, the types of the imported functions in order, and the
+// imported memories
+func readImportSection(section []byte, types []FuncType) ([]Import, []uint32, []Memory, error) {
+	r := newReader(section)
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	imports := make([]Import, count)
+	funcs := []uint32{}
+	memories := []Memory{}
+
+	for i := range imports {
+		imp := &imports[i]
+
+		if imp.Module, err = r.name(); err != nil {
+			return nil, nil, nil, err
+		}
+
+		if imp.Name, err = r.name(); err != nil {
+			return nil, nil, nil, err
+		}
+
+		if imp.Kind, err = r.byte(); err != nil {
+			return nil, nil, nil, err
+		}
+
+		switch imp.Kind {
+		case KindFunc:
+			var typeIndex uint32
+			if typeIndex, err = r.u32(); err == nil {
+				imp.Type, err = funcType(types, typeIndex)
+				funcs = append(funcs, typeIndex)
+			}
+		case KindTable:
+			if _, err = r.byte(); err == nil {
+				_, err = readLimits(r)
+			}
+		case KindMemory:
+			var l limits
+			if l, err = readLimits(r); err == nil {
+				memory := l.memory()
+				memory.Imported = true
+				memories = append(memories, memory)
+			}
+		case KindGlobal:
+			_, err = r.raw(2)
+		case KindTag:
+			if _, err = r.byte(); err == nil {
+				_, err = r.u32()
+			}
+		default:
+			err = fmt.Errorf("unknown import kind %d", imp.Kind)
+		}
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return imports, funcs, memories, nil
+}
+
+// readFunctionSection reads the type indices in a function section
+func readFunctionSection(section []byte) ([]uint32, error) {
+	r := newReader(section)
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := make([]uint32, count)
+
+	for i := range funcs {
+		if funcs[i], err = r.u32(); err != nil {
+			return nil, err
+		}
+	}
+
+	return funcs, nil
+}
+
+// readMemorySection reads the memories defined in a memory section
+func readMemorySection(section []byte) ([]Memory, error) {
+	r := newReader(section)
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	memories := make([]Memory, count)
+
+	for i := range memories {
+		l, err := readLimits(r)
+		if err != nil {
+			return nil, err
+		}
+
+		memories[i] = l.memory()
+	}
+
+	return memories, nil
+}
+
+// readExportSection reads the exports in an export section, given the type of every function in the module
+func readExportSection(section []byte, types []FuncType, funcs []uint32) ([]Export, error) {
+	r := newReader(section)
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	exports := make([]Export, count)
+
+	for i := range exports {
+		exp := &exports[i]
+
+		if exp.Name, err = r.name(); err != nil {
+			return nil, err
+		}
+
+		if exp.Kind, err = r.byte(); err != nil {
+			return nil, err
+		}
+
+		index, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+
+		if exp.Kind == KindFunc {
+			if index >= uint32(len(funcs)) {
+				return nil, fmt.Errorf("export %s refers to unknown function %d", exp.Name, index)
+			}
+
+			if exp.Type, err = funcType(types, funcs[index]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return exports, nil
+}
+
+// funcType returns the function type at the given index
+func funcType(types []FuncType, index uint32) (*FuncType, error) {
+	if index >= uint32(len(types)) {
+		return nil, fmt.Errorf("unknown function type %d", index)
+	}
+
+	return &types[index], nil
+}
+
+// valueTypeNames returns the names of value types in the Wasm text format, separated by spaces
+func valueTypeNames(types []byte) string {
+	names := make([]string, len(types))
+
+	for i, t := range types {
+		switch t {
+		case ValueI32:
+			names[i] = "i32"
+		case ValueI64:
+			names[i] = "i64"
+		case ValueF32:
+			names[i] = "f32"
+		case ValueF64:
+			names[i] = "f64"
+		default:
+			names[i] = fmt.Sprintf("%#x", t)
+		}
+	}
+
+	return strings.Join(names, " ")
+}
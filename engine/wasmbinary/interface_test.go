@@ -0,0 +1,98 @@
+package wasmbinary
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// interfaceModule builds a module that imports one function and a memory, and exports a defined function and a global
+func interfaceModule() []byte {
+	types := &writer{}
+	types.u32(2)
+	types.raw([]byte{funcTypeForm, 0x02, ValueI32, ValueI64, 0x01, ValueF64})
+	types.raw([]byte{funcTypeForm, 0x00, 0x02, ValueI32, ValueF32})
+
+	imports := &writer{}
+	imports.u32(2)
+	imports.name("env")
+	imports.name("host")
+	imports.byte(KindFunc)
+	imports.u32(0)
+	imports.name("env")
+	imports.name("memory")
+	imports.byte(KindMemory)
+	imports.raw([]byte{0x00, 0x01})
+
+	funcs := &writer{}
+	funcs.u32(1)
+	funcs.u32(1)
+
+	exports := &writer{}
+	exports.u32(2)
+	exports.name("guest")
+	exports.byte(KindFunc)
+	exports.u32(1)
+	exports.name("counter")
+	exports.byte(KindGlobal)
+	exports.u32(0)
+
+	return Encode([]Section{
+		{ID: SectionType, Data: types.buf.Bytes()},
+		{ID: SectionImport, Data: imports.buf.Bytes()},
+		{ID: SectionFunction, Data: funcs.buf.Bytes()},
+		{ID: SectionExport, Data: exports.buf.Bytes()},
+	})
+}
+
+func TestReadInterface(t *testing.T) {
+	iface, err := ReadInterface(interfaceModule())
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadInterface"))
+	}
+
+	if len(iface.Imports) != 2 {
+		t.Fatalf("expected 2 imports, got %d", len(iface.Imports))
+	}
+
+	host := iface.Imports[0]
+	if host.Module != "env" || host.Name != "host" || host.Type == nil || host.Type.String() != "(param i32 i64) (result f64)" {
+		t.Errorf("unexpected function import %+v", host)
+	}
+
+	if memory := iface.Imports[1]; memory.Kind != KindMemory || memory.Type != nil {
+		t.Errorf("unexpected memory import %+v", memory)
+	}
+
+	if len(iface.Memories) != 1 || iface.Memories[0] != (Memory{Min: 1, Imported: true}) {
+		t.Errorf("expected the imported memory with a minimum of 1 page, got %+v", iface.Memories)
+	}
+
+	guest, ok := iface.Export("guest")
+	if !ok || guest.Type == nil || guest.Type.String() != "(result i32 f32)" {
+		t.Errorf("unexpected function export %+v", guest)
+	}
+
+	if counter, ok := iface.Export("counter"); !ok || counter.Kind != KindGlobal || counter.Type != nil {
+		t.Errorf("unexpected global export %+v", counter)
+	}
+
+	if _, ok := iface.Export("missing"); ok {
+		t.Error("expected no export named missing")
+	}
+}
+
+func TestReadInterfaceNotWasm(t *testing.T) {
+	if _, err := ReadInterface([]byte("not wasm")); !errors.Is(err, ErrNotWasm) {
+		t.Errorf("expected ErrNotWasm, got %v", err)
+	}
+}
+
+func TestReadInterfaceComponent(t *testing.T) {
+	// an empty component, which has the Wasm magic but the component version and layer
+	component := []byte{0x00, 0x61, 0x73, 0x6d, 0x0d, 0x00, 0x01, 0x00}
+
+	if _, err := ReadInterface(component); !errors.Is(err, ErrComponent) {
+		t.Errorf("expected ErrComponent, got %v", err)
+	}
+}
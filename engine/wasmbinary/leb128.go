@@ -0,0 +1,163 @@
+package wasmbinary
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// reader decodes the primitive values used by the Wasm binary format
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func newReader(data []byte) *reader {
+	return &reader{data: data}
+}
+
+func (r *reader) done() bool {
+	return r.pos >= len(r.data)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.done() {
+		return 0, ErrUnexpectedEOF
+	}
+
+	b := r.data[r.pos]
+	r.pos++
+
+	return b, nil
+}
+
+// u32 reads an unsigned LEB128-encoded integer
+func (r *reader) u32() (uint32, error) {
+	var result uint32
+	var shift uint
+
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+
+		result |= uint32(b&0x7f) << shift
+
+		if b&0x80 == 0 {
+			return result, nil
+		}
+
+		shift += 7
+		if shift >= 35 {
+			return 0, errors.New("u32 is too long")
+		}
+	}
+}
+
+// skipLEB reads past a LEB128-encoded integer of any size or signedness
+func (r *reader) skipLEB() error {
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return err
+		}
+
+		if b&0x80 == 0 {
+			return nil
+		}
+	}
+}
+
+// raw reads n bytes
+func (r *reader) raw(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, ErrUnexpectedEOF
+	}
+
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+
+	return b, nil
+}
+
+// bytes reads a length-prefixed vector of bytes
+func (r *reader) bytes() ([]byte, error) {
+	size, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.raw(int(size))
+}
+
+// name reads a length-prefixed UTF-8 string
+func (r *reader) name() (string, error) {
+	b, err := r.bytes()
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// writer encodes the primitive values used by the Wasm binary format
+type writer struct {
+	buf bytes.Buffer
+}
+
+func (w *writer) byte(b byte) {
+	w.buf.WriteByte(b)
+}
+
+func (w *writer) raw(b []byte) {
+	w.buf.Write(b)
+}
+
+// u32 writes an unsigned LEB128-encoded integer
+func (w *writer) u32(val uint32) {
+	for {
+		b := byte(val & 0x7f)
+		val >>= 7
+
+		if val != 0 {
+			b |= 0x80
+		}
+
+		w.buf.WriteByte(b)
+
+		if val == 0 {
+			return
+		}
+	}
+}
+
+// s64 writes a signed LEB128-encoded integer, as used for the constants of i32 and i64 instructions
+func (w *writer) s64(val int64) {
+	for {
+		b := byte(val & 0x7f)
+		val >>= 7
+
+		done := (val == 0 && b&0x40 == 0) || (val == -1 && b&0x40 != 0)
+		if !done {
+			b |= 0x80
+		}
+
+		w.buf.WriteByte(b)
+
+		if done {
+			return
+		}
+	}
+}
+
+// bytes writes a length-prefixed vector of bytes
+func (w *writer) bytes(b []byte) {
+	w.u32(uint32(len(b)))
+	w.buf.Write(b)
+}
+
+// name writes a length-prefixed UTF-8 string
+func (w *writer) name(s string) {
+	w.bytes([]byte(s))
+}
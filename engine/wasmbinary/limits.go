@@ -0,0 +1,170 @@
+package wasmbinary
+
+import (
+	"github.com/pkg/errors"
+)
+
+// flags used by the limits encoding of memory and table types
+const (
+	limitsHasMax byte = 0x01
+	limitsMem64  byte = 0x04
+)
+
+var ErrExceedsLimit = errors.New("module's minimum size exceeds the configured limit")
+
+// limits is the encoding of the size bounds of a memory or table
+type limits struct {
+	flags byte
+	min   uint32
+	max   uint32
+}
+
+func readLimits(r *reader) (limits, error) {
+	l := limits{}
+
+	flags, err := r.byte()
+	if err != nil {
+		return l, err
+	}
+
+	if flags&limitsMem64 != 0 {
+		return l, errors.New("64-bit memories are not supported")
+	}
+
+	l.flags = flags
+
+	if l.min, err = r.u32(); err != nil {
+		return l, err
+	}
+
+	if flags&limitsHasMax != 0 {
+		if l.max, err = r.u32(); err != nil {
+			return l, err
+		}
+	}
+
+	return l, nil
+}
+
+// memory returns the limits as the size of a memory
+func (l limits) memory() Memory {
+	return Memory{Min: l.min, Max: l.max, HasMax: l.flags&limitsHasMax != 0}
+}
+
+func (l limits) write(w *writer) {
+	w.byte(l.flags)
+	w.u32(l.min)
+
+	if l.flags&limitsHasMax != 0 {
+		w.u32(l.max)
+	}
+}
+
+// capped returns the limits with their maximum lowered to ceiling
+func (l limits) capped(ceiling uint32) (limits, error) {
+	if l.min > ceiling {
+		return l, errors.Wrapf(ErrExceedsLimit, "minimum of %d is greater than limit of %d", l.min, ceiling)
+	}
+
+	if l.flags&limitsHasMax == 0 || l.max > ceiling {
+		l.flags |= limitsHasMax
+		l.max = ceiling
+	}
+
+	return l, nil
+}
+
+// ApplyLimits rewrites the module's memories and tables so that their maximum size is no greater than the given
+// number of memory pages and table elements, causing any attempt to grow beyond them to fail within the guest.
+// A limit of zero leaves the corresponding maximum unchanged.
+func ApplyLimits(module []byte, maxMemoryPages, maxTableElements uint32) ([]byte, error) {
+	if maxMemoryPages == 0 && maxTableElements == 0 {
+		return module, nil
+	}
+
+	sections, err := Sections(module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to Sections")
+	}
+
+	for i, s := range sections {
+		switch {
+		case s.ID == SectionMemory && maxMemoryPages > 0:
+			data, err := capMemories(s.Data, maxMemoryPages)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to cap memory")
+			}
+
+			sections[i].Data = data
+		case s.ID == SectionTable && maxTableElements > 0:
+			data, err := capTables(s.Data, maxTableElements)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to cap table")
+			}
+
+			sections[i].Data = data
+		}
+	}
+
+	return Encode(sections), nil
+}
+
+func capMemories(section []byte, ceiling uint32) ([]byte, error) {
+	r := newReader(section)
+	w := &writer{}
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	w.u32(count)
+
+	for i := uint32(0); i < count; i++ {
+		l, err := readLimits(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if l, err = l.capped(ceiling); err != nil {
+			return nil, err
+		}
+
+		l.write(w)
+	}
+
+	return w.buf.Bytes(), nil
+}
+
+func capTables(section []byte, ceiling uint32) ([]byte, error) {
+	r := newReader(section)
+	w := &writer{}
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	w.u32(count)
+
+	for i := uint32(0); i < count; i++ {
+		refType, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := readLimits(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if l, err = l.capped(ceiling); err != nil {
+			return nil, err
+		}
+
+		w.byte(refType)
+		l.write(w)
+	}
+
+	return w.buf.Bytes(), nil
+}
@@ -0,0 +1,102 @@
+package wasmbinary
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// module builds a module with the given memory limits and a funcref table with the given limits
+func module(mem, table limits) []byte {
+	memSection := &writer{}
+	memSection.u32(1)
+	mem.write(memSection)
+
+	tableSection := &writer{}
+	tableSection.u32(1)
+	tableSection.byte(0x70)
+	table.write(tableSection)
+
+	return Encode([]Section{
+		{ID: SectionTable, Data: tableSection.buf.Bytes()},
+		{ID: SectionMemory, Data: memSection.buf.Bytes()},
+	})
+}
+
+func TestApplyLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		mem, table limits
+		memPages   uint32
+		tableElems uint32
+		wantMem    limits
+		wantTable  limits
+	}{
+		{
+			name:      "unbounded gets a maximum",
+			mem:       limits{min: 1},
+			table:     limits{min: 2},
+			memPages:  16,
+			wantMem:   limits{flags: limitsHasMax, min: 1, max: 16},
+			wantTable: limits{min: 2},
+		},
+		{
+			name:       "larger maximum is lowered",
+			mem:        limits{flags: limitsHasMax, min: 1, max: 100},
+			table:      limits{flags: limitsHasMax, min: 2, max: 100},
+			memPages:   16,
+			tableElems: 10,
+			wantMem:    limits{flags: limitsHasMax, min: 1, max: 16},
+			wantTable:  limits{flags: limitsHasMax, min: 2, max: 10},
+		},
+		{
+			name:       "smaller maximum is kept",
+			mem:        limits{flags: limitsHasMax, min: 1, max: 4},
+			table:      limits{flags: limitsHasMax, min: 2, max: 4},
+			memPages:   16,
+			tableElems: 10,
+			wantMem:    limits{flags: limitsHasMax, min: 1, max: 4},
+			wantTable:  limits{flags: limitsHasMax, min: 2, max: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limited, err := ApplyLimits(module(tt.mem, tt.table), tt.memPages, tt.tableElems)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to ApplyLimits"))
+			}
+
+			if want := module(tt.wantMem, tt.wantTable); !bytes.Equal(limited, want) {
+				t.Errorf("expected %x, got %x", want, limited)
+			}
+		})
+	}
+}
+
+func TestApplyLimitsExceeded(t *testing.T) {
+	_, err := ApplyLimits(module(limits{min: 32}, limits{}), 16, 0)
+	if !errors.Is(err, ErrExceedsLimit) {
+		t.Errorf("expected ErrExceedsLimit, got %v", err)
+	}
+}
+
+func TestApplyLimitsNone(t *testing.T) {
+	mod := module(limits{min: 1}, limits{min: 1})
+
+	limited, err := ApplyLimits(mod, 0, 0)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ApplyLimits"))
+	}
+
+	if !bytes.Equal(limited, mod) {
+		t.Error("expected module to be unchanged")
+	}
+}
+
+func TestApplyLimitsNotWasm(t *testing.T) {
+	if _, err := ApplyLimits([]byte("not a module"), 16, 0); err == nil {
+		t.Error("expected error, got none")
+	}
+}
@@ -0,0 +1,367 @@
+package wasmbinary
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// data segment flags as defined by the Wasm binary format
+const (
+	segmentActive         uint32 = 0
+	segmentPassive        uint32 = 1
+	segmentActiveExplicit uint32 = 2
+)
+
+// snapshotSegmentGap is the shortest run of zeros that splits a snapshot's memory into separate data segments, as a
+// segment costs more to encode than a few zeros
+const snapshotSegmentGap = 16
+
+// maxDataSegments is the most data segments that runtimes accept in a module
+const maxDataSegments = 100000
+
+// Snapshot is the state of an instance that Preinitialize rewrites its module to start in
+type Snapshot struct {
+	// MemoryPages is the size of the instance's memory, in 64KiB pages
+	MemoryPages uint32
+	// Memory is the contents of the instance's memory
+	Memory []byte
+	// Globals are the bits of the values of the mutable numeric globals that the module defines, in the order that
+	// ExportGlobals exports them
+	Globals []uint64
+}
+
+// Preinitialize rewrites the module so that its instances start in the state captured by the snapshot, which was
+// taken once the module's initialization had run. Its memory is sized and filled from the snapshot in place of its
+// active data segments, its mutable numeric globals start with the snapshot's values, and its start function and the
+// named initialization exports are removed so that they don't run again. Tables, and globals of other types, aren't
+// captured, so modules that change them as they initialize can't be preinitialized.
+func Preinitialize(module []byte, snapshot Snapshot, initExports ...string) ([]byte, error) {
+	iface, err := ReadInterface(module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ReadInterface")
+	}
+
+	if len(iface.Memories) > 1 {
+		return nil, errors.New("modules with more than one memory can't be preinitialized")
+	}
+
+	for _, m := range iface.Memories {
+		if m.Imported {
+			return nil, errors.New("modules that import their memory can't be preinitialized")
+		}
+	}
+
+	sections, err := Sections(module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to Sections")
+	}
+
+	rewritten := make([]Section, 0, len(sections)+1)
+	hasData := false
+	segments := 0
+
+	for _, s := range sections {
+		switch s.ID {
+		case SectionStart:
+			// the start function ran as the module was initialized
+			continue
+		case SectionMemory:
+			if s.Data, err = resizeMemory(s.Data, snapshot.MemoryPages); err != nil {
+				return nil, errors.Wrap(err, "failed to resize memory")
+			}
+		case SectionGlobal:
+			if s.Data, err = initGlobals(s.Data, snapshot.Globals); err != nil {
+				return nil, errors.Wrap(err, "failed to initialize globals")
+			}
+		case SectionExport:
+			if s.Data, err = removeExports(s.Data, initExports); err != nil {
+				return nil, errors.Wrap(err, "failed to remove exports")
+			}
+		case SectionData:
+			hasData = true
+
+			if s.Data, segments, err = snapshotData(s.Data, snapshot.Memory); err != nil {
+				return nil, errors.Wrap(err, "failed to replace data")
+			}
+		}
+
+		rewritten = append(rewritten, s)
+	}
+
+	if !hasData && len(iface.Memories) > 0 {
+		data, count, err := snapshotData([]byte{0x00}, snapshot.Memory)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to add data")
+		}
+
+		segments = count
+		rewritten = insertSection(rewritten, Section{ID: SectionData, Data: data})
+	}
+
+	// the data count section must agree with the data section, and memory.init or data.drop can require one
+	for i, s := range rewritten {
+		if s.ID == SectionDataCount {
+			w := &writer{}
+			w.u32(uint32(segments))
+			rewritten[i].Data = w.buf.Bytes()
+		}
+	}
+
+	return Encode(rewritten), nil
+}
+
+// resizeMemory sets the minimum size of the memory defined in a memory section to the snapshot's size
+func resizeMemory(section []byte, pages uint32) ([]byte, error) {
+	r := newReader(section)
+	w := &writer{}
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	w.u32(count)
+
+	for i := uint32(0); i < count; i++ {
+		l, err := readLimits(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if l.flags&limitsHasMax != 0 && pages > l.max {
+			return nil, fmt.Errorf("snapshot of %d pages is larger than the memory's maximum of %d", pages, l.max)
+		}
+
+		l.min = pages
+		l.write(w)
+	}
+
+	return w.buf.Bytes(), nil
+}
+
+// initGlobals rewrites the initializers of the mutable numeric globals in a global section to the snapshot's values
+func initGlobals(section []byte, values []uint64) ([]byte, error) {
+	r := newReader(section)
+	w := &writer{}
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	w.u32(count)
+
+	next := 0
+
+	for i := uint32(0); i < count; i++ {
+		start := r.pos
+
+		valType, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		mut, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		exprStart := r.pos
+
+		if err := skipConstExpr(r); err != nil {
+			return nil, err
+		}
+
+		numeric := valType == ValueI32 || valType == ValueI64 || valType == ValueF32 || valType == ValueF64
+		if mut != 0x01 || !numeric {
+			w.raw(r.data[start:r.pos])
+			continue
+		}
+
+		if next >= len(values) {
+			return nil, fmt.Errorf("snapshot has %d globals, but the module has more", len(values))
+		}
+
+		w.raw(r.data[start:exprStart])
+		writeConst(w, valType, values[next])
+
+		next++
+	}
+
+	if next != len(values) {
+		return nil, fmt.Errorf("snapshot has %d globals, but the module has %d", len(values), next)
+	}
+
+	return w.buf.Bytes(), nil
+}
+
+// writeConst writes a constant expression for a value of the given type, from its bits
+func writeConst(w *writer, valType byte, bits uint64) {
+	switch valType {
+	case ValueI32:
+		w.byte(0x41)
+		w.s64(int64(int32(uint32(bits))))
+	case ValueI64:
+		w.byte(0x42)
+		w.s64(int64(bits))
+	case ValueF32:
+		w.byte(0x43)
+		w.raw(binary.LittleEndian.AppendUint32(nil, uint32(bits)))
+	case ValueF64:
+		w.byte(0x44)
+		w.raw(binary.LittleEndian.AppendUint64(nil, bits))
+	}
+
+	w.byte(0x0b) // end
+}
+
+// removeExports removes the functions with the given names from an export section
+func removeExports(section []byte, names []string) ([]byte, error) {
+	r := newReader(section)
+	kept := &writer{}
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	var keptCount uint32
+
+	for i := uint32(0); i < count; i++ {
+		start := r.pos
+
+		name, err := r.name()
+		if err != nil {
+			return nil, err
+		}
+
+		kind, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := r.u32(); err != nil {
+			return nil, err
+		}
+
+		if kind == KindFunc && contains(names, name) {
+			continue
+		}
+
+		kept.raw(r.data[start:r.pos])
+		keptCount++
+	}
+
+	w := &writer{}
+	w.u32(keptCount)
+	w.raw(kept.buf.Bytes())
+
+	return w.buf.Bytes(), nil
+}
+
+// snapshotData rewrites a data section so that the snapshot's memory is written in place of its active segments,
+// returning the section and its number of segments. The active segments are emptied rather than removed, and
+// passive segments are kept as they are, so that memory.init and data.drop still refer to the same segments.
+func snapshotData(section []byte, memory []byte) ([]byte, int, error) {
+	r := newReader(section)
+	segments := &writer{}
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := uint32(0); i < count; i++ {
+		start := r.pos
+
+		flags, err := r.u32()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		switch flags {
+		case segmentActive, segmentActiveExplicit:
+			if flags == segmentActiveExplicit {
+				if _, err := r.u32(); err != nil {
+					return nil, 0, err
+				}
+			}
+
+			if err := skipConstExpr(r); err != nil {
+				return nil, 0, err
+			}
+
+			if _, err := r.bytes(); err != nil {
+				return nil, 0, err
+			}
+
+			writeDataSegment(segments, 0, nil)
+		case segmentPassive:
+			if _, err := r.bytes(); err != nil {
+				return nil, 0, err
+			}
+
+			segments.raw(r.data[start:r.pos])
+		default:
+			return nil, 0, fmt.Errorf("unknown data segment flags %d", flags)
+		}
+	}
+
+	runs := nonZeroRuns(memory, snapshotSegmentGap)
+	for gap := snapshotSegmentGap * 2; int(count)+len(runs) > maxDataSegments; gap *= 2 {
+		runs = nonZeroRuns(memory, gap)
+	}
+
+	for _, run := range runs {
+		writeDataSegment(segments, run[0], memory[run[0]:run[1]])
+	}
+
+	total := int(count) + len(runs)
+
+	w := &writer{}
+	w.u32(uint32(total))
+	w.raw(segments.buf.Bytes())
+
+	return w.buf.Bytes(), total, nil
+}
+
+// writeDataSegment writes an active segment that fills memory from offset with data
+func writeDataSegment(w *writer, offset int, data []byte) {
+	w.u32(segmentActive)
+	w.byte(0x41) // i32.const
+	w.s64(int64(int32(uint32(offset))))
+	w.byte(0x0b) // end
+	w.bytes(data)
+}
+
+// nonZeroRuns returns the start and end of each run of memory that isn't zero, joining runs that are separated by
+// fewer than gap zeros
+func nonZeroRuns(memory []byte, gap int) [][2]int {
+	runs := [][2]int{}
+
+	for i := 0; i < len(memory); i++ {
+		if memory[i] == 0 {
+			continue
+		}
+
+		if last := len(runs) - 1; last >= 0 && i-runs[last][1] < gap {
+			runs[last][1] = i + 1
+		} else {
+			runs = append(runs, [2]int{i, i + 1})
+		}
+	}
+
+	return runs
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
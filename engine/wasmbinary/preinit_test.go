@@ -0,0 +1,179 @@
+package wasmbinary
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// preinitModule builds a module with two empty functions, a memory of at most 4 pages, a mutable i32, an immutable i64
+// and a mutable f64 global, an active and a passive data segment, and a start function that is also exported as _start
+func preinitModule() []byte {
+	memories := &writer{}
+	memories.u32(1)
+	limits{flags: limitsHasMax, min: 1, max: 4}.write(memories)
+
+	globals := &writer{}
+	globals.u32(3)
+	globals.raw([]byte{ValueI32, 0x01, 0x41, 0x00, 0x0b})
+	globals.raw([]byte{ValueI64, 0x00, 0x42, 0x01, 0x0b})
+	globals.raw([]byte{ValueF64, 0x01, 0x44, 0, 0, 0, 0, 0, 0, 0, 0, 0x0b})
+
+	exports := &writer{}
+	exports.u32(3)
+	exports.name("_start")
+	exports.byte(KindFunc)
+	exports.u32(0)
+	exports.name("memory")
+	exports.byte(KindMemory)
+	exports.u32(0)
+	exports.name("run")
+	exports.byte(KindFunc)
+	exports.u32(1)
+
+	start := &writer{}
+	start.u32(0)
+
+	dataCount := &writer{}
+	dataCount.u32(2)
+
+	data := &writer{}
+	data.u32(2)
+	writeDataSegment(data, 0, []byte("xyz"))
+	data.u32(segmentPassive)
+	data.bytes([]byte("p"))
+
+	return Encode([]Section{
+		{ID: SectionType, Data: []byte{0x01, 0x60, 0x00, 0x00}},
+		{ID: SectionFunction, Data: []byte{0x02, 0x00, 0x00}},
+		{ID: SectionMemory, Data: memories.buf.Bytes()},
+		{ID: SectionGlobal, Data: globals.buf.Bytes()},
+		{ID: SectionExport, Data: exports.buf.Bytes()},
+		{ID: SectionStart, Data: start.buf.Bytes()},
+		{ID: SectionDataCount, Data: dataCount.buf.Bytes()},
+		{ID: SectionCode, Data: []byte{0x02, 0x02, 0x00, 0x0b, 0x02, 0x00, 0x0b}},
+		{ID: SectionData, Data: data.buf.Bytes()},
+	})
+}
+
+func TestPreinitialize(t *testing.T) {
+	memory := make([]byte, 2*65536)
+	copy(memory[10:], "abc")
+	memory[100000] = 7
+
+	snapshot := Snapshot{
+		MemoryPages: 2,
+		Memory:      memory,
+		Globals:     []uint64{uint64(uint32(0xfffe1dc0)), math.Float64bits(1.5)},
+	}
+
+	preinitialized, err := Preinitialize(preinitModule(), snapshot, "_start")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Preinitialize"))
+	}
+
+	exports, order := readExports(t, preinitialized)
+
+	if !bytes.Equal(order, []byte{SectionType, SectionFunction, SectionMemory, SectionGlobal, SectionExport, SectionDataCount, SectionCode, SectionData}) {
+		t.Errorf("expected the start section to be removed, got sections %v", order)
+	}
+
+	expectedExports := []export{{name: "memory", kind: KindMemory, index: 0}, {name: "run", kind: KindFunc, index: 1}}
+	if !reflect.DeepEqual(exports, expectedExports) {
+		t.Errorf("expected exports %v, got %v", expectedExports, exports)
+	}
+
+	sections, err := Sections(preinitialized)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Sections"))
+	}
+
+	expectedGlobals := &writer{}
+	expectedGlobals.u32(3)
+	expectedGlobals.raw([]byte{ValueI32, 0x01, 0x41, 0xc0, 0xbb, 0x78, 0x0b})
+	expectedGlobals.raw([]byte{ValueI64, 0x00, 0x42, 0x01, 0x0b})
+	expectedGlobals.raw([]byte{ValueF64, 0x01, 0x44, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f, 0x0b})
+
+	// the active segment is emptied rather than removed, so that the passive segment keeps its index
+	expectedData := &writer{}
+	expectedData.u32(4)
+	writeDataSegment(expectedData, 0, nil)
+	expectedData.u32(segmentPassive)
+	expectedData.bytes([]byte("p"))
+	writeDataSegment(expectedData, 10, []byte("abc"))
+	writeDataSegment(expectedData, 100000, []byte{7})
+
+	for _, s := range sections {
+		switch s.ID {
+		case SectionMemory:
+			r := newReader(s.Data)
+			r.u32()
+
+			mem, _ := readLimits(r)
+			if mem != (limits{flags: limitsHasMax, min: 2, max: 4}) {
+				t.Errorf("expected the memory to start at 2 pages, got %+v", mem)
+			}
+		case SectionGlobal:
+			if !bytes.Equal(s.Data, expectedGlobals.buf.Bytes()) {
+				t.Errorf("expected globals %x, got %x", expectedGlobals.buf.Bytes(), s.Data)
+			}
+		case SectionDataCount:
+			if count, _ := newReader(s.Data).u32(); count != 4 {
+				t.Errorf("expected a data count of 4, got %d", count)
+			}
+		case SectionData:
+			if !bytes.Equal(s.Data, expectedData.buf.Bytes()) {
+				t.Errorf("expected data %x, got %x", expectedData.buf.Bytes(), s.Data)
+			}
+		}
+	}
+}
+
+func TestPreinitializeNoData(t *testing.T) {
+	memories := &writer{}
+	memories.u32(1)
+	limits{min: 1}.write(memories)
+
+	module := Encode([]Section{
+		{ID: SectionMemory, Data: memories.buf.Bytes()},
+		{ID: SectionCode, Data: []byte{0x00}},
+	})
+
+	memory := make([]byte, 65536)
+	memory[5] = 1
+
+	preinitialized, err := Preinitialize(module, Snapshot{MemoryPages: 1, Memory: memory})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Preinitialize"))
+	}
+
+	sections, err := Sections(preinitialized)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Sections"))
+	}
+
+	expectedData := &writer{}
+	expectedData.u32(1)
+	writeDataSegment(expectedData, 5, []byte{1})
+
+	if len(sections) != 3 || sections[2].ID != SectionData || !bytes.Equal(sections[2].Data, expectedData.buf.Bytes()) {
+		t.Errorf("expected a data section to be added after the code section, got %+v", sections)
+	}
+}
+
+func TestPreinitializeTooLarge(t *testing.T) {
+	snapshot := Snapshot{MemoryPages: 5, Memory: make([]byte, 5*65536), Globals: []uint64{0, 0}}
+
+	if _, err := Preinitialize(preinitModule(), snapshot); err == nil {
+		t.Error("expected a snapshot larger than the memory's maximum to be refused")
+	}
+}
+
+func TestPreinitializeNotWasm(t *testing.T) {
+	if _, err := Preinitialize([]byte("not wasm"), Snapshot{}); err == nil {
+		t.Error("expected an error for a module that isn't Wasm")
+	}
+}
@@ -0,0 +1,88 @@
+// Package wasmbinary reads and rewrites the sections of a Wasm module's binary encoding, without needing a runtime.
+package wasmbinary
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// section IDs as defined by the Wasm binary format
+const (
+	SectionCustom    byte = 0
+	SectionType      byte = 1
+	SectionImport    byte = 2
+	SectionFunction  byte = 3
+	SectionTable     byte = 4
+	SectionMemory    byte = 5
+	SectionGlobal    byte = 6
+	SectionExport    byte = 7
+	SectionStart     byte = 8
+	SectionElement   byte = 9
+	SectionCode      byte = 10
+	SectionData      byte = 11
+	SectionDataCount byte = 12
+	SectionTag       byte = 13
+)
+
+var (
+	ErrNotWasm       = errors.New("data is not a Wasm module")
+	ErrComponent     = errors.New("data is a Wasm component rather than a core module")
+	ErrUnexpectedEOF = errors.New("unexpected end of Wasm module")
+)
+
+var header = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+// componentHeader begins the binaries of components, whose version is followed by the layer that marks them as such
+var componentHeader = []byte{0x00, 0x61, 0x73, 0x6d, 0x0d, 0x00, 0x01, 0x00}
+
+// Section is a single section of a Wasm module
+type Section struct {
+	ID   byte
+	Data []byte
+	// Offset is the position of Data within the module that the section was read from
+	Offset int
+}
+
+// Sections splits a Wasm module into its sections
+func Sections(module []byte) ([]Section, error) {
+	if bytes.HasPrefix(module, componentHeader) {
+		return nil, ErrComponent
+	}
+
+	if !bytes.HasPrefix(module, header) {
+		return nil, ErrNotWasm
+	}
+
+	r := newReader(module[len(header):])
+	sections := []Section{}
+
+	for !r.done() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read section id")
+		}
+
+		data, err := r.bytes()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read section %d", id)
+		}
+
+		sections = append(sections, Section{ID: id, Data: data, Offset: len(header) + r.pos - len(data)})
+	}
+
+	return sections, nil
+}
+
+// Encode assembles sections back into a Wasm module
+func Encode(sections []Section) []byte {
+	w := &writer{}
+	w.raw(header)
+
+	for _, s := range sections {
+		w.byte(s.ID)
+		w.bytes(s.Data)
+	}
+
+	return w.buf.Bytes()
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/pkg/errors"
 
@@ -25,11 +27,21 @@ var (
 
 // wasmRunner represents a wasm-based runnable
 type wasmRunner struct {
-	env *runtime.WasmEnvironment
+	// name is the name that the runner was registered with
+	name   string
+	env    *runtime.WasmEnvironment
+	config runtime.Config
+
+	// logger logs the output captured from the runner's instances, and is nil if the host API can't log it
+	logger api.OutputLogger
+	// traps logs the traps raised by the runner's instances, and is nil if the host API can't log them
+	traps api.TrapLogger
+	// recorder records or replays the runner's invocations, and is nil if they aren't
+	recorder api.Recorder
 }
 
 // newRunnerFromFile returns a new *wasmRunner
-func newRunnerFromFile(filepath string, api api.HostAPI) (*wasmRunner, error) {
+func newRunnerFromFile(filepath string, api api.HostAPI, backend string, config runtime.Config) (*wasmRunner, error) {
 	file, err := os.Open(filepath)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to Open")
@@ -42,26 +54,107 @@ func newRunnerFromFile(filepath string, api api.HostAPI) (*wasmRunner, error) {
 
 	ref := tenant.NewWasmModuleRef("", "", data)
 
-	runner := newRunnerFromRef(ref, api)
+	runner, err := newRunnerFromRef(ref, api, backend, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to newRunnerFromRef")
+	}
 
 	return runner, nil
 }
 
-// newRunnerFromRef creates a wasmRunner from a moduleRef
-func newRunnerFromRef(ref *tenant.WasmModuleRef, api api.HostAPI) *wasmRunner {
-	builder := runtimeBuilder(ref, api)
+// newRunnerFromRef creates a wasmRunner from a moduleRef, returning an error if the runtime is unavailable, the
+// module doesn't match the ABI or the runtime can't honour the config
+func newRunnerFromRef(ref *tenant.WasmModuleRef, api api.HostAPI, backend string, config runtime.Config) (*wasmRunner, error) {
+	builder, err := newModuleBuilder(ref, api, backend, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to newModuleBuilder")
+	}
 
-	environment := runtime.NewEnvironment(builder)
+	environment := runtime.NewEnvironment(builder, config)
 
 	r := &wasmRunner{
-		env: environment,
+		env:    environment,
+		config: config,
+		logger: outputLogger(api),
+		traps:  trapLogger(api),
+	}
+
+	return r, nil
+}
+
+// moduleBuilder builds the instances of one version of a module
+type moduleBuilder struct {
+	runtime.RuntimeBuilder
+	// abi is the version of the host API that the module was built against, which decides how it is run
+	abi runtime.ABIVersion
+	// identifierLookup is true if the host API has functions that may look instances up by their ident
+	identifierLookup bool
+}
+
+// UsesIdentifierLookup returns true if the module's instances must be registered for InstanceForIdentifier
+func (b *moduleBuilder) UsesIdentifierLookup() bool {
+	return b.identifierLookup
+}
+
+// newModuleBuilder creates the builder for a module's instances, returning an error if the module doesn't match the
+// ABI or the runtime can't honour the config
+func newModuleBuilder(ref *tenant.WasmModuleRef, api api.HostAPI, backend string, config runtime.Config) (*moduleBuilder, error) {
+	builderFunc, err := runtime.Backend(backend)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to runtime.Backend")
+	}
+
+	// a module that doesn't match the ABI would otherwise only be reported when it is first called
+	abi, err := checkABI(ref, api.HostFunctions())
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Deterministic != nil {
+		if err := config.Deterministic.Validate(config.WASI, config.Pool); err != nil {
+			return nil, errors.Wrap(err, "failed to Deterministic.Validate")
+		}
+	}
+
+	builder := builderFunc(ref, api.HostFunctions(), config)
+
+	// a configuration that the runtime can't honour would otherwise only be reported when instances are created
+	if checker, ok := builder.(runtime.ConfigChecker); ok {
+		if err := checker.CheckConfig(); err != nil {
+			return nil, errors.Wrap(err, "failed to CheckConfig")
+		}
+	}
+
+	// the module's initialization runs once here, rather than in every instance that the builder builds
+	if config.Preinit {
+		preinitialized, err := runtime.Preinitialize(ref, api.HostFunctions(), backend, config)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to Preinitialize")
+		}
+
+		builder = builderFunc(preinitialized, api.HostFunctions(), config)
 	}
 
-	return r
+	mb := &moduleBuilder{
+		RuntimeBuilder:   builder,
+		abi:              abi,
+		identifierLookup: runtime.NeedsIdentifierLookup(api.HostFunctions()),
+	}
+
+	return mb, nil
+}
+
+// abiOf returns the version of the host API that an instance's module was built against
+func abiOf(instance *runtime.WasmInstance) runtime.ABIVersion {
+	if builder, ok := instance.Builder().(*moduleBuilder); ok {
+		return builder.abi
+	}
+
+	return runtime.ABIv1
 }
 
 // Run runs a wasmRunner
-func (w *wasmRunner) Run(job scheduler.Job, ctx *scheduler.Ctx) (interface{}, error) {
+func (w *wasmRunner) Run(job scheduler.Job, ctx *scheduler.Ctx) (result interface{}, err error) {
 	var jobBytes []byte
 	var req *request.CoordinatedRequest
 
@@ -82,6 +175,15 @@ func (w *wasmRunner) Run(job scheduler.Job, ctx *scheduler.Ctx) (interface{}, er
 		jobBytes = bytes
 	}
 
+	// the invocation is recorded before a sequence changes the request's state, so that replaying it does the same
+	if w.recorder != nil {
+		ctx.Context = w.recorder.Begin(ctx.Context, w.name, req, jobBytes)
+
+		defer func() {
+			w.recorder.End(ctx.Context, resultOutput(result), err)
+		}()
+	}
+
 	if req != nil {
 		if req.SequenceJSON != nil && len(req.SequenceJSON) > 0 {
 			seq, err := sequence.FromJSON(req.SequenceJSON, req, nil)
@@ -113,30 +215,97 @@ func (w *wasmRunner) Run(job scheduler.Job, ctx *scheduler.Ctx) (interface{}, er
 		jobBytes = req.Body
 	}
 
+	// a module that runs deterministically sees the same clock and random numbers whenever it is given the same
+	// request (or input), and can't call the host functions whose results could change without stubbing them
+	deterministic := w.config.Deterministic
+
+	invocationID := string(jobBytes)
+	if req != nil {
+		invocationID = req.ID
+	}
+
+	if deterministic != nil {
+		ctx.Context = api.ContextWithDeterminism(ctx.Context, *deterministic)
+	}
+
 	var output []byte
+	var stdout, stderr []byte
 	var runErr error
 	var callErr error
 
+	limited := w.config.Limits.MemoryPages > 0 || w.config.Limits.TableElements > 0
+
 	if err := w.env.UseInstance(ctx, func(instance *runtime.WasmInstance, ident int32) {
-		inPointer, writeErr := instance.WriteMemory(jobBytes)
-		if writeErr != nil {
-			runErr = errors.Wrap(writeErr, "failed to instance.writeMemory")
-			return
+		var inPointer int32
+
+		// the module can be reloaded with a version built against the other ABI, so each instance is run by its own
+		abi := abiOf(instance)
+
+		if deterministic != nil {
+			instance.ResetWASI(*deterministic, invocationID)
 		}
 
-		// execute the Runnable's Run function, passing the input data and ident
-		// set runErr but don't return because the ExecutionResult error should also be grabbed
-		_, callErr = instance.Call("run_e", inPointer, int32(len(jobBytes)), ident)
+		if abi == runtime.ABIv2 {
+			// ABIv2 modules return their result from run rather than calling a host function with it
+			callErr = instance.RunCanonical(jobBytes)
+		} else {
+			var writeErr error
+			inPointer, writeErr = instance.WriteMemory(jobBytes)
+			if writeErr != nil {
+				runErr = errors.Wrap(writeErr, "failed to instance.writeMemory")
+				return
+			}
+
+			// execute the Runnable's Run function, passing the input data and ident
+			// set runErr but don't return because the ExecutionResult error should also be grabbed
+			_, callErr = instance.Call("run_e", inPointer, int32(len(jobBytes)), ident)
+		}
+
+		// a guest that is refused growth by a limit usually traps, so the module records when it is, and reading the
+		// record also clears it for an instance that is reused
+		if limited {
+			if hit := instance.LimitHit(); hit != nil && callErr != nil {
+				callErr = errors.Wrap(hit, callErr.Error())
+			}
+		}
 
 		// get the results from the instance
 		output, runErr = instance.ExecutionResult()
 
-		// deallocate the memory used for the input
-		instance.Deallocate(inPointer, len(jobBytes))
+		// traps are logged while the instance is still running this invocation, so that they carry the request's ID
+		if trap := invocationTrap(runErr, callErr); trap != nil {
+			w.logTrap(instance, trap)
+		}
+
+		// deallocate the memory used for the input, which ABIv2 modules own once it has been passed to run
+		if abi != runtime.ABIv2 {
+			instance.Deallocate(inPointer, len(jobBytes))
+		}
+
+		// the output has to be logged while the instance is still running this invocation
+		stdout, stderr = instance.ReadOutput()
+		w.logOutput(instance, stdout, stderr)
 	}); err != nil {
+		if errors.Is(err, runtime.ErrExecutionTimeout) {
+			return nil, budgetRunErr(err)
+		}
+
+		if errors.Is(err, runtime.ErrPoolUnavailable) {
+			return nil, scheduler.RunErr{Code: http.StatusServiceUnavailable, Message: runtime.ErrPoolUnavailable.Error()}
+		}
+
+		if errors.Is(err, runtime.ErrInstanceLimit) {
+			return nil, w.limitRunErr(runtime.ErrInstanceLimit)
+		}
+
 		return nil, errors.Wrap(err, "failed to useInstance")
 	}
 
+	// the module was stopped when it broke its determinism, so whatever else went wrong followed from that
+	if violation := api.DeterminismViolation(ctx.Context); violation != nil {
+		return nil, scheduler.RunErr{Code: http.StatusForbidden, Message: violation.Error()}
+	}
+
 	if runErr != nil {
 		// we do not wrap the error here as we want to
 		// propogate its exact type to the caller (specifically scheduler.RunErr)
@@ -147,10 +316,23 @@ func (w *wasmRunner) Run(job scheduler.Job, ctx *scheduler.Ctx) (interface{}, er
 		// if the runnable didn't return an explicit runErr, still check to see if there was an
 		// error executing the module in the first place. It's posslble for both to be non-nil
 		// in which case returning the runErr takes precedence, which is why it's checked first.
+		if errors.Is(callErr, runtime.ErrExecutionTimeout) || errors.Is(callErr, runtime.ErrFuelExhausted) {
+			return nil, budgetRunErr(callErr)
+		}
+
+		var limitErr runtime.LimitError
+		if errors.As(callErr, &limitErr) {
+			return nil, w.limitRunErr(limitErr)
+		}
+
 		return nil, errors.Wrap(callErr, "wasm execution error")
 	}
 
 	if req != nil {
+		if w.config.Output.DebugHeader {
+			setOutputHeaders(req, stdout, stderr)
+		}
+
 		resp := &request.CoordinatedResponse{
 			Output:      output,
 			RespHeaders: req.RespHeaders,
@@ -162,6 +344,31 @@ func (w *wasmRunner) Run(job scheduler.Job, ctx *scheduler.Ctx) (interface{}, er
 	return output, nil
 }
 
+// PreWarm builds instances for the runner's workers ahead of time
+func (w *wasmRunner) PreWarm(count int) error {
+	return w.env.PreWarm(count)
+}
+
+// Reload replaces the runner's module with a new version, which the runner's instances are rebuilt with. The old
+// version keeps serving if the new one fails to build.
+func (w *wasmRunner) Reload(ref *tenant.WasmModuleRef, api api.HostAPI, backend string) error {
+	builder, err := newModuleBuilder(ref, api, backend, w.config)
+	if err != nil {
+		return errors.Wrap(err, "failed to newModuleBuilder")
+	}
+
+	if err := w.env.Swap(builder); err != nil {
+		return errors.Wrap(err, "failed to Swap")
+	}
+
+	return nil
+}
+
+// Health returns the health of the runner's pool of instances
+func (w *wasmRunner) Health() runtime.PoolHealth {
+	return w.env.Health()
+}
+
 // OnChange runs when a worker starts using this Runnable
 func (w *wasmRunner) OnChange(evt scheduler.ChangeEvent) error {
 	switch evt {
@@ -178,6 +385,163 @@ func (w *wasmRunner) OnChange(evt scheduler.ChangeEvent) error {
 	return nil
 }
 
+// checkABI returns the version of the host API that the module was built against, or an ABIError if the module
+// doesn't match the ABI provided by the host functions
+func checkABI(ref *tenant.WasmModuleRef, hostFns []runtime.HostFn) (runtime.ABIVersion, error) {
+	if len(ref.Data) == 0 {
+		// there is nothing to inspect until the module has been loaded
+		return runtime.ABIv1, nil
+	}
+
+	report, err := runtime.CheckABI(ref.Data, hostFns)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to CheckABI")
+	}
+
+	if report.LegacyInit {
+		runtime.InternalLogger().Warn(fmt.Sprintf("module %s exports the deprecated init function, which only the wasmer runtime calls", ref.Name))
+	}
+
+	return report.Version, report.Err()
+}
+
+// logOutput logs an invocation's captured output at the configured levels
+func (w *wasmRunner) logOutput(instance *runtime.WasmInstance, stdout, stderr []byte) {
+	if w.logger == nil {
+		return
+	}
+
+	w.logger.LogOutput(instance, w.config.Output.Stdout(), stdout)
+	w.logger.LogOutput(instance, w.config.Output.Stderr(), stderr)
+}
+
+// logTrap logs a trap raised by an invocation, falling back to the internal logger if the host API can't log it
+func (w *wasmRunner) logTrap(instance *runtime.WasmInstance, trap *runtime.Trap) {
+	if w.traps == nil {
+		runtime.InternalLogger().ErrorString(trap.Error())
+		return
+	}
+
+	w.traps.LogTrap(instance, trap)
+}
+
+// invocationTrap returns the trap that ended an invocation, if there was one. A guest that aborts usually traps
+// straight afterwards, so the abort is given that trap's backtrace.
+func invocationTrap(runErr, callErr error) *runtime.Trap {
+	var runTrap, callTrap *runtime.Trap
+	errors.As(runErr, &runTrap)
+	errors.As(callErr, &callTrap)
+
+	if runTrap == nil {
+		return callTrap
+	}
+
+	if runTrap.Kind == runtime.TrapAbort && callTrap != nil {
+		runTrap.Frames = append(runTrap.Frames, callTrap.Frames...)
+	}
+
+	return runTrap
+}
+
+// trapLogger returns the host API's TrapLogger, if it has one
+func trapLogger(hostAPI api.HostAPI) api.TrapLogger {
+	logger, ok := hostAPI.(api.TrapLogger)
+	if !ok {
+		return nil
+	}
+
+	return logger
+}
+
+// outputLogger returns the host API's OutputLogger, if it has one
+func outputLogger(hostAPI api.HostAPI) api.OutputLogger {
+	logger, ok := hostAPI.(api.OutputLogger)
+	if !ok {
+		return nil
+	}
+
+	return logger
+}
+
+// setOutputHeaders returns an invocation's captured output to the caller, quoted so that it is safe to use as a header
+func setOutputHeaders(req *request.CoordinatedRequest, stdout, stderr []byte) {
+	if req.RespHeaders == nil {
+		req.RespHeaders = map[string]string{}
+	}
+
+	if len(stdout) > 0 {
+		req.RespHeaders["X-Sat-Stdout"] = strconv.QuoteToASCII(string(stdout))
+	}
+
+	if len(stderr) > 0 {
+		req.RespHeaders["X-Sat-Stderr"] = strconv.QuoteToASCII(string(stderr))
+	}
+}
+
+// resultOutput returns the output of a run's result
+func resultOutput(result interface{}) []byte {
+	switch r := result.(type) {
+	case *request.CoordinatedResponse:
+		return r.Output
+	case []byte:
+		return r
+	}
+
+	return nil
+}
+
+// budgetRunErr converts an exceeded execution budget into a RunErr so that callers receive a 504
+func budgetRunErr(err error) scheduler.RunErr {
+	msg := runtime.ErrExecutionTimeout.Error()
+	if errors.Is(err, runtime.ErrFuelExhausted) {
+		msg = runtime.ErrFuelExhausted.Error()
+	}
+
+	return scheduler.RunErr{Code: http.StatusGatewayTimeout, Message: msg}
+}
+
+// limitError is returned for an invocation that was stopped by one of the module's resource limits. It is reported to
+// the caller as a RunErr, and unwraps to the runtime.LimitError so that it can be told apart from the module's errors.
+type limitError struct {
+	scheduler.RunErr
+	limit runtime.LimitError
+}
+
+// limitRunErr explains which limit stopped the invocation
+func (w *wasmRunner) limitRunErr(limit runtime.LimitError) limitError {
+	var msg string
+	code := http.StatusInternalServerError
+
+	switch limit {
+	case runtime.ErrMemoryLimit:
+		msg = fmt.Sprintf("the function needed more than the %d pages of memory that it is limited to", w.config.Limits.MemoryPages)
+	case runtime.ErrTableLimit:
+		msg = fmt.Sprintf("the function needed more than the %d table elements that it is limited to", w.config.Limits.TableElements)
+	case runtime.ErrInstanceLimit:
+		code = http.StatusServiceUnavailable
+		msg = fmt.Sprintf("all %d of the function's instances stayed busy for longer than its timeout", w.config.Limits.Instances)
+	}
+
+	return limitError{
+		RunErr: scheduler.RunErr{Code: code, Message: fmt.Sprintf("%s: %s", limit.Error(), msg)},
+		limit:  limit,
+	}
+}
+
+func (l limitError) Unwrap() error {
+	return l.limit
+}
+
+// As allows the error to be handled as the RunErr that it is reported as
+func (l limitError) As(target interface{}) bool {
+	runErr, ok := target.(*scheduler.RunErr)
+	if ok {
+		*runErr = l.RunErr
+	}
+
+	return ok
+}
+
 func interfaceToBytes(data interface{}) ([]byte, error) {
 	// if data is []byte or string, return it as-is
 	if b, ok := data.([]byte); ok {
@@ -0,0 +1,74 @@
+package wasmtest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestCheckABI(t *testing.T) {
+	modules := []string{
+		"../testdata/as-echo/as-echo.wasm",
+		"../testdata/grain-echo/grain-echo.wasm",
+		"../testdata/hello-echo/hello-echo.wasm",
+		"../testdata/tinygo-hello-echo/tinygo-hello-echo.wasm",
+		"../testdata/wasi/wasi.wasm",
+	}
+
+	e := engine.New()
+
+	for _, filename := range modules {
+		ref, err := refFromFile("module", filename)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+		}
+
+		if err := e.CheckABI(ref); err != nil {
+			t.Errorf("expected %s to match the ABI, got %s", filename, err)
+		}
+	}
+}
+
+func TestCheckABIMismatch(t *testing.T) {
+	ref, err := refFromFile("bad-abi", "../testdata/bad-abi/bad-abi.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+	}
+
+	e := engine.New()
+
+	err = e.CheckABI(ref)
+	if !errors.Is(err, runtime.ErrABIMismatch) {
+		t.Fatalf("expected ErrABIMismatch, got %v", err)
+	}
+
+	abiErr := &runtime.ABIError{}
+	if !errors.As(err, &abiErr) {
+		t.Fatalf("expected an ABIError, got %T", err)
+	}
+
+	expected := []string{
+		"unknown import env.not_a_host_fn, sat provides no such host function",
+		"import env.log_msg has signature (param i32 i32), expected (param i32 i32 i32 i32)",
+		"export deallocate has signature (param i32), expected (param i32 i32)",
+		"missing export run_e, expected (param i32 i32 i32)",
+	}
+
+	if !reflect.DeepEqual(abiErr.Report.Problems, expected) {
+		t.Errorf("expected problems %q, got %q", expected, abiErr.Report.Problems)
+	}
+
+	// registering the module reports the same problems rather than failing to instantiate it
+	if _, err := e.RegisterWithConfig("bad-abi", ref, runtime.Config{}); !errors.Is(err, runtime.ErrABIMismatch) {
+		t.Errorf("expected ErrABIMismatch from RegisterWithConfig, got %v", err)
+	}
+
+	// Register can't return the error, so the module's jobs fail with it
+	if _, err := e.Register("bad-abi-job", ref)(nil).Then(); !errors.Is(err, runtime.ErrABIMismatch) {
+		t.Errorf("expected ErrABIMismatch from the job, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package wasmtest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestEngineUnavailableRuntime(t *testing.T) {
+	e := engine.New(engine.UseRuntime("not-a-runtime"))
+
+	_, err := e.RegisterFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+	if err == nil {
+		t.Fatal("expected error, got none")
+	}
+
+	if !strings.Contains(err.Error(), runtime.ErrBackendNotAvailable.Error()) || !strings.Contains(err.Error(), "not-a-runtime") {
+		t.Errorf("expected error to name the unavailable runtime, got %s", err)
+	}
+}
+
+func TestEngineRuntimes(t *testing.T) {
+	// every runtime that was compiled in should be able to run a module side-by-side in the same process
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			e := engine.New(engine.UseRuntime(name))
+
+			if e.Runtime() != name {
+				t.Errorf("expected runtime %s, got %s", name, e.Runtime())
+			}
+
+			doWasm, err := e.RegisterFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to RegisterFromFile"))
+			}
+
+			res, err := doWasm("world").Then()
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Then"))
+			}
+
+			if string(res.([]byte)) != "hello world" {
+				t.Errorf("expected 'hello world', got %s", string(res.([]byte)))
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package wasmtest
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/tenant"
+	"github.com/suborbital/e2core/scheduler"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestWasmRunnerTimeout(t *testing.T) {
+	e := engine.New()
+
+	ref, err := refFromFile("loop", "../testdata/loop/loop.wasm")
+	if err != nil {
+		t.Error(errors.Wrap(err, "failed to refFromFile"))
+		return
+	}
+
+	doWasm, err := e.RegisterWithConfig("loop", ref, runtime.Config{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Error(errors.Wrap(err, "failed to RegisterWithConfig"))
+		return
+	}
+
+	start := time.Now()
+
+	_, err = doWasm("forever").Then()
+	if err == nil {
+		t.Error("expected error, got none")
+		return
+	}
+
+	runErr := scheduler.RunErr{}
+	if !errors.As(err, &runErr) {
+		t.Errorf("expected RunErr, got %s", err)
+		return
+	}
+
+	if runErr.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected code %d, got %d", http.StatusGatewayTimeout, runErr.Code)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected execution to be interrupted after 100ms, took %s", elapsed)
+	}
+}
+
+func TestWasmRunnerTimeoutBackends(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			ref, err := refFromFile("loop", "../testdata/loop/loop.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			// a runtime either interrupts the guest at its deadline, or refuses the timeout rather than abandoning it
+			doWasm, err := engine.New(engine.UseRuntime(name)).RegisterWithConfig("loop", ref, runtime.Config{Timeout: 100 * time.Millisecond})
+			if errors.Is(err, runtime.ErrTimeoutNotSupported) {
+				return
+			} else if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to RegisterWithConfig"))
+			}
+
+			start := time.Now()
+
+			_, err = doWasm("forever").Then()
+
+			runErr := scheduler.RunErr{}
+			if !errors.As(err, &runErr) || runErr.Code != http.StatusGatewayTimeout {
+				t.Fatalf("expected a RunErr with code %d, got %v", http.StatusGatewayTimeout, err)
+			}
+
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected execution to be interrupted after 100ms, took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestEnvironmentTimeoutWaitsForCall(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			builderFunc, err := runtime.Backend(name)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to runtime.Backend"))
+			}
+
+			ref, err := refFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			config := runtime.Config{Timeout: 20 * time.Millisecond}
+			env := runtime.NewEnvironment(builderFunc(ref, api.New().HostFunctions(), config), config)
+
+			if err := env.AddInstance(); err != nil {
+				t.Fatal(errors.Wrap(err, "failed to AddInstance"))
+			}
+
+			// a call blocked past its deadline (as it would be in a host function) still writes to the caller's state
+			returned := false
+
+			if err := env.UseInstance(nil, func(*runtime.WasmInstance, int32) {
+				time.Sleep(50 * time.Millisecond)
+				returned = true
+			}); !errors.Is(err, runtime.ErrExecutionTimeout) {
+				t.Fatalf("expected ErrExecutionTimeout, got %v", err)
+			}
+
+			if !returned {
+				t.Error("expected UseInstance to wait for the call to return")
+			}
+		})
+	}
+}
+
+func refFromFile(name, filename string) (*tenant.WasmModuleRef, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ReadFile")
+	}
+
+	return tenant.NewWasmModuleRef(name, "", data), nil
+}
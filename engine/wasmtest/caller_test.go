@@ -0,0 +1,127 @@
+package wasmtest
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/e2core/scheduler"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestWasmRunnerRejectsForeignIdent(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			ref, err := refFromFile("caller", "../testdata/caller/caller.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			doWasm, err := engine.New(engine.UseRuntime(name)).RegisterWithConfig("caller", ref, runtime.Config{})
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Register"))
+			}
+
+			// the module passes an ident that isn't its own to return_result, which must not act on another instance
+			res, err := doWasm("my name is joe").Then()
+			if err == nil && len(res.([]byte)) != 0 {
+				t.Errorf("expected the result to be refused, got %q", res)
+			}
+		})
+	}
+}
+
+func TestWasmRunnerCallerHostFn(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+
+			typedFn := runtime.NewTypedHostFn(
+				"typed_host_fn",
+				[]runtime.ValueType{runtime.I64, runtime.F64},
+				[]runtime.ValueType{runtime.I64, runtime.F32, runtime.I32},
+				func(args ...interface{}) (interface{}, error) {
+					return nil, errors.New("expected CallerFn to be called instead")
+				},
+			)
+
+			typedFn.CallerFn = func(caller *runtime.WasmInstance, args ...interface{}) (interface{}, error) {
+				calls++
+
+				// the caller is the instance running the invocation
+				if caller == nil || caller.Ctx() == nil {
+					return nil, errors.New("expected the instance running the invocation")
+				}
+
+				return []interface{}{int64(0), float32(0), int32(0)}, nil
+			}
+
+			ref, err := refFromFile("typed", "../testdata/typed/typed.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			e := engine.NewWithAPI(&typedAPI{HostAPI: api.New(), typedFn: typedFn}, engine.UseRuntime(name))
+			doWasm, err := e.RegisterWithConfig("typed", ref, runtime.Config{})
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Register"))
+			}
+
+			for i := 0; i < 2; i++ {
+				if _, err := doWasm(nil).Then(); err != nil {
+					t.Fatal(errors.Wrap(err, "failed to Then"))
+				}
+			}
+
+			if calls != 2 {
+				t.Errorf("expected 2 calls, got %d", calls)
+			}
+		})
+	}
+}
+
+func TestEnvironmentIdentifierLookup(t *testing.T) {
+	builderFunc, err := runtime.Backend(runtime.DefaultBackend())
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to runtime.Backend"))
+	}
+
+	ref, err := refFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+	}
+
+	// only the instances of builders with host functions that may look them up are registered with the instance mapper
+	for _, lookup := range []bool{false, true} {
+		config := runtime.Config{}
+		env := runtime.NewEnvironment(&lookupBuilder{RuntimeBuilder: builderFunc(ref, api.New().HostFunctions(), config), lookup: lookup}, config)
+
+		if err := env.AddInstance(); err != nil {
+			t.Fatal(errors.Wrap(err, "failed to AddInstance"))
+		}
+
+		if err := env.UseInstance(&scheduler.Ctx{}, func(inst *runtime.WasmInstance, ident int32) {
+			found, err := runtime.InstanceForIdentifier(ident, false)
+			if lookup && (err != nil || found != inst) {
+				t.Errorf("expected the instance to be found by its ident, got %v", err)
+			} else if !lookup && err == nil {
+				t.Error("expected an instance that wasn't registered not to be found by its ident")
+			}
+		}); err != nil {
+			t.Fatal(errors.Wrap(err, "failed to UseInstance"))
+		}
+	}
+}
+
+// lookupBuilder builds instances with a RuntimeBuilder, and says whether they may be looked up by their ident
+type lookupBuilder struct {
+	runtime.RuntimeBuilder
+	lookup bool
+}
+
+func (l *lookupBuilder) UsesIdentifierLookup() bool {
+	return l.lookup
+}
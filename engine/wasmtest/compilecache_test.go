@@ -0,0 +1,84 @@
+package wasmtest
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestWasmRunnerCompileCache(t *testing.T) {
+	dir := t.TempDir()
+	config := runtime.Config{CacheDir: dir}
+
+	ref, err := refFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+	}
+
+	if err := engine.New().Precompile(ref, config); err != nil {
+		if errors.Is(err, runtime.ErrNoPrecompile) {
+			t.Skip("runtime does not support ahead-of-time compilation")
+		}
+
+		t.Fatal(errors.Wrap(err, "failed to Precompile"))
+	}
+
+	artifacts, err := cachedArtifacts(dir)
+	if err != nil || len(artifacts) != 1 {
+		t.Fatalf("expected one cached artifact, got %v", artifacts)
+	}
+
+	info, err := os.Stat(artifacts[0])
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Stat"))
+	}
+
+	// a second engine should run the module using the cached artifact
+	doWasm, err := engine.New().RegisterWithConfig("hello-echo", ref, config)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to RegisterWithConfig"))
+	}
+
+	res, err := doWasm([]byte("cache")).Then()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Then"))
+	}
+
+	if string(res.([]byte)) != "hello cache" {
+		t.Errorf("expected 'hello cache', got %s", string(res.([]byte)))
+	}
+
+	after, err := os.Stat(artifacts[0])
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Stat"))
+	}
+
+	if !after.ModTime().Equal(info.ModTime()) {
+		t.Error("expected cached artifact to be reused rather than rewritten")
+	}
+}
+
+// cachedArtifacts lists the files in the cache dir, whose layout beneath it depends on the runtime
+func cachedArtifacts(dir string) ([]string, error) {
+	artifacts := []string{}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			artifacts = append(artifacts, path)
+		}
+
+		return nil
+	})
+
+	return artifacts, err
+}
@@ -0,0 +1,151 @@
+package wasmtest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/e2core/scheduler"
+
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestWasmRunnerDeterministicWASI(t *testing.T) {
+	start := time.Date(2030, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			ref, err := refFromFile("wasi", "../testdata/wasi/wasi.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			// the instance is reused, so nothing but the reset keeps its clock and random numbers the same each time
+			config := runtime.Config{
+				Pool:          runtime.Pool{Mode: runtime.PoolSnapshot},
+				Deterministic: &runtime.Deterministic{Clock: runtime.ClockFixed, Time: start},
+			}
+
+			doWasm, err := engine.New(engine.UseRuntime(name)).RegisterWithConfig("wasi", ref, config)
+			if err != nil && strings.Contains(err.Error(), runtime.ErrWASISourceNotSupported.Error()) {
+				t.Skip("runtime cannot withhold the host's clock and random sources")
+			} else if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to RegisterWithConfig"))
+			}
+
+			probe := func(input string) wasiProbe {
+				t.Helper()
+
+				res, err := doWasm(input).Then()
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "failed to Then"))
+				}
+
+				probe, err := decodeWASIProbe(res.([]byte))
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "failed to decodeWASIProbe"))
+				}
+
+				return probe
+			}
+
+			first, again, other := probe("first"), probe("first"), probe("other")
+
+			if !first.clock.Equal(start) || !again.clock.Equal(start) {
+				t.Errorf("expected the clock to be fixed at %s, got %s and %s", start, first.clock, again.clock)
+			}
+
+			if !bytes.Equal(first.random, again.random) {
+				t.Errorf("expected the same random bytes for the same input, got %v and %v", first.random, again.random)
+			}
+
+			if bytes.Equal(first.random, other.random) {
+				t.Errorf("expected different random bytes for different inputs, got %v for both", first.random)
+			}
+		})
+	}
+}
+
+func TestWasmRunnerDeterministicHostFns(t *testing.T) {
+	var requests atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("hello from the server"))
+	}))
+	defer server.Close()
+
+	e := engine.New()
+
+	register := func(name, file string, deterministic *runtime.Deterministic) {
+		t.Helper()
+
+		ref, err := refFromFile(name, file)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+		}
+
+		if _, err := e.RegisterWithConfig(name, ref, runtime.Config{Deterministic: deterministic}); err != nil {
+			t.Fatal(errors.Wrap(err, "failed to RegisterWithConfig"))
+		}
+	}
+
+	register("fetch", "../testdata/fetch/fetch.wasm", &runtime.Deterministic{})
+	register("tinygo-cache", "../testdata/tinygo-cache/tinygo-cache.wasm", &runtime.Deterministic{})
+
+	register("fetch-stubbed", "../testdata/fetch/fetch.wasm", &runtime.Deterministic{
+		Stubs: map[string]map[string]string{
+			"fetch_url": {server.URL: "stubbed server", "https://postman-echo.com/post": "stubbed echo"},
+		},
+	})
+
+	register("tinygo-cache-stubbed", "../testdata/tinygo-cache/tinygo-cache.wasm", &runtime.Deterministic{
+		Stubs: map[string]map[string]string{
+			"cache_get": {"name": "stubbed"},
+		},
+	})
+
+	forbidden := func(module, input, call string) {
+		t.Helper()
+
+		_, err := e.Do(scheduler.NewJob(module, input)).Then()
+
+		runErr := scheduler.RunErr{}
+		if !errors.As(err, &runErr) || runErr.Code != http.StatusForbidden || !strings.HasPrefix(runErr.Message, call) {
+			t.Errorf("expected %s to be forbidden, got %v", call, err)
+		}
+	}
+
+	forbidden("fetch", server.URL, `fetch_url("GET", "`+server.URL+`")`)
+	forbidden("tinygo-cache", "very important", `cache_get("name")`)
+
+	if requests.Load() != 0 {
+		t.Error("expected the forbidden request not to be made")
+	}
+
+	res, err := e.Do(scheduler.NewJob("fetch-stubbed", server.URL)).Then()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Do fetch-stubbed"))
+	}
+
+	if string(res.([]byte)) != "stubbed echo" || requests.Load() != 0 {
+		t.Errorf("expected the requests to be answered by their stubs, got %q after %d requests", res, requests.Load())
+	}
+
+	// cache_set always gives the same result, so only cache_get needs to be stubbed
+	res, err = e.Do(scheduler.NewJob("tinygo-cache-stubbed", "very important")).Then()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Do tinygo-cache-stubbed"))
+	}
+
+	if string(res.([]byte)) != "stubbed" {
+		t.Errorf("expected the cached value to be stubbed, got %q", res)
+	}
+}
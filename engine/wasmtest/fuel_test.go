@@ -0,0 +1,76 @@
+package wasmtest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/e2core/scheduler"
+
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestWasmRunnerFuel(t *testing.T) {
+	// fuel metering is supported by Wasmtime
+	if _, err := runtime.Backend("wasmtime"); err != nil {
+		t.Skip(err)
+	}
+
+	e := engine.New(engine.UseRuntime("wasmtime"))
+
+	ref, err := refFromFile("loop", "../testdata/loop/loop.wasm")
+	if err != nil {
+		t.Error(errors.Wrap(err, "failed to refFromFile"))
+		return
+	}
+
+	doWasm, err := e.RegisterWithConfig("loop", ref, runtime.Config{Fuel: 100000})
+	if err != nil {
+		t.Error(errors.Wrap(err, "failed to RegisterWithConfig"))
+		return
+	}
+
+	_, err = doWasm("forever").Then()
+	if err == nil {
+		t.Error("expected error, got none")
+		return
+	}
+
+	runErr := scheduler.RunErr{}
+	if !errors.As(err, &runErr) {
+		t.Errorf("expected RunErr, got %s", err)
+		return
+	}
+
+	if runErr.Code != http.StatusGatewayTimeout || runErr.Message != runtime.ErrFuelExhausted.Error() {
+		t.Errorf("expected fuel exhaustion RunErr, got %d: %s", runErr.Code, runErr.Message)
+	}
+}
+
+func TestWasmRunnerFuelBackends(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			ref, err := refFromFile("loop", "../testdata/loop/loop.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			// a runtime either meters the guest, or refuses the fuel rather than running it without a budget
+			doWasm, err := engine.New(engine.UseRuntime(name)).RegisterWithConfig("loop", ref, runtime.Config{Fuel: 100000})
+			if errors.Is(err, runtime.ErrFuelNotSupported) {
+				return
+			} else if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to RegisterWithConfig"))
+			}
+
+			_, err = doWasm("forever").Then()
+
+			runErr := scheduler.RunErr{}
+			if !errors.As(err, &runErr) || runErr.Code != http.StatusGatewayTimeout || runErr.Message != runtime.ErrFuelExhausted.Error() {
+				t.Fatalf("expected fuel exhaustion RunErr, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package wasmtest
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// typedAPI adds a host function with a typed signature to the default API
+type typedAPI struct {
+	api.HostAPI
+	typedFn runtime.HostFn
+}
+
+func (t *typedAPI) HostFunctions() []runtime.HostFn {
+	return append(t.HostAPI.HostFunctions(), t.typedFn)
+}
+
+func TestWasmRunnerTypedHostFn(t *testing.T) {
+	var gotWide int64
+	var gotFloat float64
+
+	typedFn := runtime.NewTypedHostFn(
+		"typed_host_fn",
+		[]runtime.ValueType{runtime.I64, runtime.F64},
+		[]runtime.ValueType{runtime.I64, runtime.F32, runtime.I32},
+		func(args ...interface{}) (interface{}, error) {
+			gotWide = args[0].(int64)
+			gotFloat = args[1].(float64)
+
+			return []interface{}{gotWide + 1, float32(gotFloat * 2), int32(gotWide >> 32)}, nil
+		},
+	)
+
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			ref, err := refFromFile("typed", "../testdata/typed/typed.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			e := engine.NewWithAPI(&typedAPI{HostAPI: api.New(), typedFn: typedFn}, engine.UseRuntime(name))
+
+			doWasm, err := e.RegisterWithConfig("typed", ref, runtime.Config{})
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Register"))
+			}
+
+			res, err := doWasm(nil).Then()
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Then"))
+			}
+
+			if gotWide != 0x100000002 || gotFloat != 1.5 {
+				t.Errorf("expected the host function to receive 0x100000002 and 1.5, got %#x and %v", gotWide, gotFloat)
+			}
+
+			out := res.([]byte)
+			if len(out) != 16 {
+				t.Fatalf("expected 16 bytes, got %d", len(out))
+			}
+
+			if wide := binary.LittleEndian.Uint64(out); wide != 0x100000003 {
+				t.Errorf("expected i64 result 0x100000003, got %#x", wide)
+			}
+
+			if float := math.Float32frombits(binary.LittleEndian.Uint32(out[8:])); float != 3 {
+				t.Errorf("expected f32 result 3, got %v", float)
+			}
+
+			if narrow := binary.LittleEndian.Uint32(out[12:]); narrow != 1 {
+				t.Errorf("expected i32 result 1, got %d", narrow)
+			}
+		})
+	}
+}
+
+func TestWasmRunnerTypedHostFnBadResult(t *testing.T) {
+	typedFn := runtime.NewTypedHostFn(
+		"typed_host_fn",
+		[]runtime.ValueType{runtime.I64, runtime.F64},
+		[]runtime.ValueType{runtime.I64, runtime.F32, runtime.I32},
+		func(args ...interface{}) (interface{}, error) {
+			// an i32 where an i64 is expected must trap rather than be silently converted
+			return []interface{}{int32(1), float32(2), int32(3)}, nil
+		},
+	)
+
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			if name == "wasmer" {
+				t.Skip("wasmer-go can't raise traps from host functions safely, so the guest receives zero values")
+			}
+
+			ref, err := refFromFile("typed", "../testdata/typed/typed.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			e := engine.NewWithAPI(&typedAPI{HostAPI: api.New(), typedFn: typedFn}, engine.UseRuntime(name))
+
+			doWasm, err := e.RegisterWithConfig("typed", ref, runtime.Config{})
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Register"))
+			}
+
+			if _, err := doWasm(nil).Then(); err == nil {
+				t.Error("expected the call to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package wasmtest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/e2core/scheduler"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestWasmRunnerMemoryLimit(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			err := runGrow(name, "grow", runtime.Limits{MemoryPages: 16})
+			if !errors.Is(err, runtime.ErrMemoryLimit) {
+				t.Fatalf("expected ErrMemoryLimit, got %v", err)
+			}
+
+			runErr := scheduler.RunErr{}
+			if !errors.As(err, &runErr) {
+				t.Fatalf("expected RunErr, got %s", err)
+			}
+
+			if runErr.Code != http.StatusInternalServerError {
+				t.Errorf("expected code %d, got %d", http.StatusInternalServerError, runErr.Code)
+			}
+
+			expected := "memory limit exceeded: the function needed more than the 16 pages of memory that it is limited to"
+			if runErr.Message != expected {
+				t.Errorf("expected message %q, got %q", expected, runErr.Message)
+			}
+		})
+	}
+}
+
+func TestWasmRunnerMemoryLimitNotHit(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			// the module grows to exactly its limit and traps, which it would have done without the limit
+			err := runGrow(name, "once", runtime.Limits{MemoryPages: 16})
+			if err == nil {
+				t.Fatal("expected error, got none")
+			}
+
+			if errors.As(err, &runtime.LimitError{}) {
+				t.Errorf("expected the trap not to be blamed on a limit, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWasmRunnerTableLimit(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			err := runGrow(name, "table", runtime.Limits{MemoryPages: 16, TableElements: 8})
+			if !errors.Is(err, runtime.ErrTableLimit) {
+				t.Fatalf("expected ErrTableLimit, got %v", err)
+			}
+
+			runErr := scheduler.RunErr{}
+			if !errors.As(err, &runErr) || runErr.Code != http.StatusInternalServerError {
+				t.Errorf("expected a RunErr with code %d, got %v", http.StatusInternalServerError, err)
+			}
+		})
+	}
+}
+
+// runGrow runs the grow test module with the given limits
+func runGrow(name, input string, limits runtime.Limits) error {
+	ref, err := refFromFile("grow", "../testdata/grow/grow.wasm")
+	if err != nil {
+		return errors.Wrap(err, "failed to refFromFile")
+	}
+
+	doWasm, err := engine.New(engine.UseRuntime(name)).RegisterWithConfig("grow", ref, runtime.Config{Limits: limits})
+	if err != nil {
+		return errors.Wrap(err, "failed to RegisterWithConfig")
+	}
+
+	_, err = doWasm(input).Then()
+
+	return err
+}
+
+func TestWasmRunnerInstanceLimit(t *testing.T) {
+	e := engine.New()
+
+	ref, err := refFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Error(errors.Wrap(err, "failed to refFromFile"))
+		return
+	}
+
+	doWasm, err := e.RegisterWithConfig("hello-echo", ref, runtime.Config{Limits: runtime.Limits{Instances: 1}})
+	if err != nil {
+		t.Error(errors.Wrap(err, "failed to RegisterWithConfig"))
+		return
+	}
+
+	grp := scheduler.NewGroup()
+	for i := 0; i < 10; i++ {
+		grp.Add(doWasm([]byte("world")))
+	}
+
+	if err := grp.Wait(); err != nil {
+		t.Error(errors.Wrap(err, "failed to grp.Wait"))
+	}
+}
+
+func TestEnvironmentInstanceLimitTimeout(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			builderFunc, err := runtime.Backend(name)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to runtime.Backend"))
+			}
+
+			ref, err := refFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			config := runtime.Config{Timeout: 50 * time.Millisecond, Limits: runtime.Limits{Instances: 1}, Pool: runtime.Pool{Mode: runtime.PoolReuse}}
+			env := runtime.NewEnvironment(builderFunc(ref, api.New().HostFunctions(), config), config)
+
+			// the wait for an instance is bounded by the timeout, which some runtimes refuse
+			if err := env.AddInstance(); errors.Is(err, runtime.ErrTimeoutNotSupported) {
+				return
+			} else if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to AddInstance"))
+			}
+
+			// the only instance is kept busy beyond the timeout
+			release := make(chan struct{})
+			defer close(release)
+
+			go env.UseInstance(nil, func(*runtime.WasmInstance, int32) { <-release })
+
+			time.Sleep(10 * time.Millisecond)
+
+			if err := env.UseInstance(nil, func(*runtime.WasmInstance, int32) {}); !errors.Is(err, runtime.ErrInstanceLimit) {
+				t.Errorf("expected ErrInstanceLimit, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package wasmtest
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/capabilities"
+	"github.com/suborbital/appspec/request"
+	"github.com/suborbital/e2core/scheduler"
+	"github.com/suborbital/vektor/vlog"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// hostCallOps are the host functions called by the hostcall module, in the order of the ops that call them
+var hostCallOps = []string{
+	"return_result", "return_error", "return_abort", "log_msg", "cache_set", "cache_get", "request_get_field",
+	"request_set_field", "resp_set_header", "get_ffi_result", "add_ffi_var", "get_static_file", "get_secret_value",
+}
+
+func TestWasmRunnerMemoryOutOfBounds(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			if name == "wasmer" {
+				t.Skip("wasmer-go can't raise traps from host functions safely, so the guest receives zero values")
+			}
+
+			doWasm, err := hostCallRunner(name)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to hostCallRunner"))
+			}
+
+			for op, fn := range hostCallOps {
+				// every pointer and size is beyond the module's single page of memory
+				_, err := doWasm(hostCallRequest(uint8(op), -100, -100, -100, -100, -100, -100)).Then()
+
+				trap := &runtime.Trap{}
+				if !errors.As(err, &trap) || trap.Kind != runtime.TrapOutOfBounds {
+					t.Errorf("expected %s to trap out of bounds, got %v", fn, err)
+				}
+			}
+
+			// a pointer at the very end of memory is fine as long as nothing is read past it
+			res, err := doWasm(hostCallRequest(0, math.MaxUint16+1, 0)).Then()
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Then"))
+			}
+
+			if output := res.(*request.CoordinatedResponse).Output; len(output) != 0 {
+				t.Errorf("expected an empty result, got %q", output)
+			}
+		})
+	}
+}
+
+func FuzzHostCall(f *testing.F) {
+	// the module's input is written at 1024, so the first seed returns it as the result
+	f.Add(uint8(0), int32(1024), int32(28), int32(0), int32(0), int32(0), int32(0))
+	f.Add(uint8(0), int32(math.MaxUint16), int32(2), int32(0), int32(0), int32(0), int32(0))
+	f.Add(uint8(1), int32(500), int32(1024), int32(-1), int32(0), int32(0), int32(0))
+	f.Add(uint8(2), int32(1028), int32(4), int32(math.MaxInt32), int32(1), int32(12), int32(5))
+	f.Add(uint8(4), int32(1024), int32(4), int32(1028), int32(math.MinInt32), int32(0), int32(0))
+	f.Add(uint8(7), int32(0), int32(1024), int32(4), int32(65530), int32(7), int32(0))
+	f.Add(uint8(9), int32(65530), int32(0), int32(0), int32(0), int32(0), int32(0))
+	f.Add(uint8(10), int32(1024), int32(4), int32(-4), int32(4), int32(0), int32(0))
+
+	runners := map[string]scheduler.JobFunc{}
+	for _, name := range runtime.Backends() {
+		doWasm, err := hostCallRunner(name)
+		if err != nil {
+			f.Fatal(errors.Wrap(err, "failed to hostCallRunner"))
+		}
+
+		runners[name] = doWasm
+	}
+
+	f.Fuzz(func(t *testing.T, op uint8, a0, a1, a2, a3, a4, a5 int32) {
+		for name, doWasm := range runners {
+			// whatever the guest passes, a host function either works or traps the guest; any panic would
+			// take the whole test binary down with it
+			_, err := doWasm(hostCallRequest(op, a0, a1, a2, a3, a4, a5)).Then()
+
+			trap := &runtime.Trap{}
+			if errors.As(err, &trap) && trap.Kind != runtime.TrapOutOfBounds && trap.Kind != runtime.TrapAbort {
+				t.Errorf("%s: expected an out of bounds trap, got %s", name, trap)
+			}
+		}
+	})
+}
+
+// hostCallRunner registers the hostcall module on the named runtime, with logging turned off
+func hostCallRunner(name string) (scheduler.JobFunc, error) {
+	logger := vlog.Default(vlog.Level(vlog.LogLevelNull))
+
+	hostAPI, err := api.NewWithConfig(capabilities.DefaultConfigWithLogger(logger))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to api.NewWithConfig")
+	}
+
+	ref, err := refFromFile("hostcall", "../testdata/hostcall/hostcall.wasm")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to refFromFile")
+	}
+
+	return engine.NewWithAPI(hostAPI, engine.UseRuntime(name)).RegisterWithConfig("hostcall", ref, runtime.Config{})
+}
+
+// hostCallRequest creates a request that makes the hostcall module call the op's host function with args
+func hostCallRequest(op uint8, args ...int32) *request.CoordinatedRequest {
+	body := make([]byte, 4, 4+4*len(args))
+	body[0] = op
+
+	for _, a := range args {
+		body = binary.LittleEndian.AppendUint32(body, uint32(a))
+	}
+
+	return &request.CoordinatedRequest{
+		Method:      "POST",
+		URL:         "/hostcall",
+		ID:          uuid.New().String(),
+		Body:        body,
+		Headers:     map[string]string{},
+		RespHeaders: map[string]string{},
+		Params:      map[string]string{},
+		State:       map[string][]byte{},
+	}
+}
@@ -0,0 +1,222 @@
+package wasmtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/capabilities"
+	"github.com/suborbital/appspec/request"
+	"github.com/suborbital/vektor/vlog"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// outputLog is a structured log line written by the logger capability
+type outputLog struct {
+	Message string `json:"log_message"`
+	Level   int    `json:"level"`
+	Scope   struct {
+		RequestID  string `json:"request_id"`
+		Identifier int32  `json:"ident"`
+	} `json:"scope"`
+}
+
+func TestWasmRunnerOutputLogged(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			config := runtime.Config{
+				Output: runtime.Output{StdoutLevel: runtime.LogLevelDebug, DebugHeader: true},
+			}
+
+			req := &request.CoordinatedRequest{
+				Method: "POST",
+				URL:    "/output",
+				ID:     uuid.New().String(),
+				Body:   []byte("hello\nworld\n"),
+			}
+
+			resp, logs, err := runOutput(name, config, req)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to runOutput"))
+			}
+
+			if string(resp.Output) != "ok" {
+				t.Errorf("expected 'ok', got %q", string(resp.Output))
+			}
+
+			// vlog's default producer prefixes each message with its level
+			expected := []outputLog{
+				{Message: "(D) hello", Level: 4},
+				{Message: "(D) world", Level: 4},
+				{Message: "(W) something went wrong", Level: 2},
+			}
+
+			if len(logs) != len(expected) {
+				t.Fatalf("expected %d log lines, got %+v", len(expected), logs)
+			}
+
+			for i, log := range logs {
+				if log.Message != expected[i].Message || log.Level != expected[i].Level {
+					t.Errorf("expected %q at level %d, got %q at level %d", expected[i].Message, expected[i].Level, log.Message, log.Level)
+				}
+
+				if log.Scope.RequestID != req.ID {
+					t.Errorf("expected request ID %s, got %q", req.ID, log.Scope.RequestID)
+				}
+			}
+
+			if resp.RespHeaders["X-Sat-Stdout"] != strconv.QuoteToASCII("hello\nworld\n") {
+				t.Errorf("unexpected X-Sat-Stdout header %s", resp.RespHeaders["X-Sat-Stdout"])
+			}
+
+			if resp.RespHeaders["X-Sat-Stderr"] != strconv.QuoteToASCII("something went wrong\n") {
+				t.Errorf("unexpected X-Sat-Stderr header %s", resp.RespHeaders["X-Sat-Stderr"])
+			}
+		})
+	}
+}
+
+func TestWasmRunnerOutputCapped(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			config := runtime.Config{
+				Output: runtime.Output{StderrLevel: runtime.LogLevelNone, MaxBytes: 4, DebugHeader: true},
+			}
+
+			req := &request.CoordinatedRequest{
+				Method: "POST",
+				URL:    "/output",
+				ID:     uuid.New().String(),
+				Body:   []byte("hello world"),
+			}
+
+			resp, logs, err := runOutput(name, config, req)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to runOutput"))
+			}
+
+			if len(logs) != 2 || logs[0].Message != "(I) hell" || logs[0].Level != 3 {
+				t.Fatalf("expected the capped stdout at info level, got %+v", logs)
+			}
+
+			if resp.RespHeaders["X-Sat-Stdout"] != strconv.QuoteToASCII("hell\n[7 bytes of output dropped]") {
+				t.Errorf("unexpected X-Sat-Stdout header %s", resp.RespHeaders["X-Sat-Stdout"])
+			}
+
+			if _, ok := resp.RespHeaders["X-Sat-Stderr"]; ok {
+				t.Error("expected stderr not to be captured")
+			}
+		})
+	}
+}
+
+// runOutput runs the output test module on the named runtime and returns the response and what was logged
+func runOutput(name string, config runtime.Config, req *request.CoordinatedRequest) (*request.CoordinatedResponse, []outputLog, error) {
+	buf := &bytes.Buffer{}
+	logger := vlog.Default(vlog.WithWriter(buf), vlog.Level(vlog.LogLevelDebug))
+
+	hostAPI, err := api.NewWithConfig(capabilities.DefaultConfigWithLogger(logger))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to api.NewWithConfig")
+	}
+
+	ref, err := refFromFile("output", "../testdata/output/output.wasm")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to refFromFile")
+	}
+
+	doWasm, err := engine.NewWithAPI(hostAPI, engine.UseRuntime(name)).RegisterWithConfig("output", ref, config)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to RegisterWithConfig")
+	}
+
+	res, err := doWasm(req).Then()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to Then")
+	}
+
+	logs := []outputLog{}
+
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		log := outputLog{}
+		if err := json.Unmarshal(scanner.Bytes(), &log); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to Unmarshal log")
+		}
+
+		logs = append(logs, log)
+	}
+
+	return res.(*request.CoordinatedResponse), logs, nil
+}
+
+func TestWasmRunnerOutputDescriptors(t *testing.T) {
+	if _, err := os.ReadDir("/proc/self/fd"); err != nil {
+		t.Skip("open descriptors can't be counted on this platform")
+	}
+
+	for _, name := range runtime.Backends() {
+		for _, mode := range []runtime.PoolMode{runtime.PoolFresh, runtime.PoolReuse} {
+			t.Run(name+"/"+string(mode), func(t *testing.T) {
+				config := runtime.Config{
+					Output: runtime.Output{StdoutLevel: runtime.LogLevelDebug},
+					Pool:   runtime.Pool{Mode: mode},
+				}
+
+				ref, err := refFromFile("output", "../testdata/output/output.wasm")
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+				}
+
+				hostAPI, err := api.NewWithConfig(capabilities.DefaultConfigWithLogger(vlog.Default(vlog.WithWriter(&bytes.Buffer{}))))
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "failed to api.NewWithConfig"))
+				}
+
+				doWasm, err := engine.NewWithAPI(hostAPI, engine.UseRuntime(name)).RegisterWithConfig("output", ref, config)
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "failed to RegisterWithConfig"))
+				}
+
+				run := func(count int) {
+					for i := 0; i < count; i++ {
+						req := &request.CoordinatedRequest{Method: "POST", URL: "/output", ID: uuid.New().String(), Body: []byte("hello\n")}
+
+						if _, err := doWasm(req).Then(); err != nil {
+							t.Fatal(errors.Wrap(err, "failed to Then"))
+						}
+					}
+				}
+
+				// the first calls open whatever the engine keeps open for its lifetime
+				run(10)
+				before := openDescriptors(t)
+
+				run(200)
+
+				if after := openDescriptors(t); after > before {
+					t.Errorf("expected the open descriptors to stay at %d, got %d", before, after)
+				}
+			})
+		}
+	}
+}
+
+// openDescriptors counts the process's open file descriptors
+func openDescriptors(t *testing.T) int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadDir"))
+	}
+
+	return len(fds)
+}
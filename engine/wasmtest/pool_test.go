@@ -0,0 +1,285 @@
+package wasmtest
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestWasmRunnerPool(t *testing.T) {
+	tests := []struct {
+		name string
+		pool runtime.Pool
+		want [][]byte
+	}{
+		{
+			name: "fresh",
+			pool: runtime.Pool{Mode: runtime.PoolFresh},
+			want: [][]byte{{1, 1}, {1, 1}, {1, 1}},
+		},
+		{
+			name: "snapshot",
+			pool: runtime.Pool{Mode: runtime.PoolSnapshot},
+			want: [][]byte{{1, 1}, {1, 1}, {1, 1}},
+		},
+		{
+			name: "reuse",
+			pool: runtime.Pool{Mode: runtime.PoolReuse},
+			want: [][]byte{{1, 1}, {2, 2}, {3, 3}},
+		},
+		{
+			name: "reuse with max uses",
+			pool: runtime.Pool{Mode: runtime.PoolReuse, MaxUses: 2},
+			want: [][]byte{{1, 1}, {2, 2}, {1, 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := engine.New()
+
+			ref, err := refFromFile("counter", "../testdata/counter/counter.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			// a single instance makes it possible to observe whether it is being reused
+			config := runtime.Config{Pool: tt.pool, Limits: runtime.Limits{Instances: 1}}
+
+			doWasm, err := e.RegisterWithConfig("counter", ref, config)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to RegisterWithConfig"))
+			}
+
+			for i, want := range tt.want {
+				res, err := doWasm(nil).Then()
+				if err != nil {
+					t.Fatal(errors.Wrapf(err, "failed to Then for call %d", i))
+				}
+
+				if !bytes.Equal(res.([]byte), want) {
+					t.Errorf("call %d: expected %v, got %v", i, want, res)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvironmentReplaceRetry(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			builderFunc, err := runtime.Backend(name)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to runtime.Backend"))
+			}
+
+			ref, err := refFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			// the instance is replaced after every use, and the first two attempts to replace it fail
+			config := runtime.Config{Pool: runtime.Pool{Mode: runtime.PoolFresh}}
+			builder := &flakyBuilder{RuntimeBuilder: builderFunc(ref, api.New().HostFunctions(), config), succeed: 1, fail: 2}
+
+			env := runtime.NewEnvironment(builder, config)
+
+			if err := env.AddInstance(); err != nil {
+				t.Fatal(errors.Wrap(err, "failed to AddInstance"))
+			}
+
+			if health := env.Health(); health.State != runtime.PoolHealthy || health.Instances != 1 {
+				t.Fatalf("expected a healthy pool with one instance, got %+v", health)
+			}
+
+			if err := env.UseInstance(nil, func(*runtime.WasmInstance, int32) {}); err != nil {
+				t.Fatal(errors.Wrap(err, "failed to UseInstance"))
+			}
+
+			// the used instance is gone and its replacement is failing, so the pool can't serve anything
+			health := waitForPoolState(env, runtime.PoolUnavailable)
+			if health.Ready() || health.Retrying != 1 || health.LastError == "" {
+				t.Fatalf("expected an unavailable pool retrying one instance, got %+v", health)
+			}
+
+			if err := env.UseInstance(nil, func(*runtime.WasmInstance, int32) {}); !errors.Is(err, runtime.ErrPoolUnavailable) {
+				t.Fatalf("expected ErrPoolUnavailable, got %v", err)
+			}
+
+			// the third attempt succeeds after backing off
+			health = waitForPoolState(env, runtime.PoolHealthy)
+			if !health.Ready() || health.Instances != 1 || health.Retrying != 0 {
+				t.Fatalf("expected the pool to recover, got %+v", health)
+			}
+
+			if err := env.UseInstance(nil, func(*runtime.WasmInstance, int32) {}); err != nil {
+				t.Fatal(errors.Wrap(err, "failed to UseInstance after recovering"))
+			}
+		})
+	}
+}
+
+func TestEnvironmentRemoveRetryingInstance(t *testing.T) {
+	builderFunc, err := runtime.Backend(runtime.DefaultBackend())
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to runtime.Backend"))
+	}
+
+	ref, err := refFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+	}
+
+	// every replacement fails, so the instance can only be removed by giving up on its replacement
+	config := runtime.Config{Pool: runtime.Pool{Mode: runtime.PoolFresh}}
+	builder := &flakyBuilder{RuntimeBuilder: builderFunc(ref, api.New().HostFunctions(), config), succeed: 1, fail: 1000}
+
+	env := runtime.NewEnvironment(builder, config)
+
+	if err := env.AddInstance(); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to AddInstance"))
+	}
+
+	if err := env.UseInstance(nil, func(*runtime.WasmInstance, int32) {}); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to UseInstance"))
+	}
+
+	waitForPoolState(env, runtime.PoolUnavailable)
+
+	done := make(chan error)
+	go func() {
+		done <- env.RemoveInstance()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "failed to RemoveInstance"))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("RemoveInstance blocked waiting for an instance that can't be built")
+	}
+
+	if health := env.Health(); health.State != runtime.PoolHealthy || health.Instances != 0 || health.Retrying != 0 {
+		t.Errorf("expected an empty pool with nothing to retry, got %+v", health)
+	}
+}
+
+func TestEnvironmentPreWarm(t *testing.T) {
+	builderFunc, err := runtime.Backend(runtime.DefaultBackend())
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to runtime.Backend"))
+	}
+
+	ref, err := refFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+	}
+
+	config := runtime.Config{Limits: runtime.Limits{Instances: 3}}
+	env := runtime.NewEnvironment(builderFunc(ref, api.New().HostFunctions(), config), config)
+
+	// the instance limit caps what is built ahead of time
+	if err := env.PreWarm(4); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to PreWarm"))
+	}
+
+	if health := env.Health(); health.Instances != 3 {
+		t.Fatalf("expected 3 pre-warmed instances, got %+v", health)
+	}
+
+	// workers adopt the pre-warmed instances rather than building their own
+	for i := 0; i < 3; i++ {
+		if err := env.AddInstance(); err != nil {
+			t.Fatal(errors.Wrap(err, "failed to AddInstance"))
+		}
+	}
+
+	if health := env.Health(); health.Instances != 3 || health.State != runtime.PoolHealthy {
+		t.Errorf("expected the workers to adopt the 3 instances, got %+v", health)
+	}
+
+	if err := env.UseInstance(nil, func(*runtime.WasmInstance, int32) {}); err != nil {
+		t.Error(errors.Wrap(err, "failed to UseInstance"))
+	}
+}
+
+func TestEnvironmentConcurrentFirstBuild(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			builderFunc, err := runtime.Backend(name)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to runtime.Backend"))
+			}
+
+			ref, err := refFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			config := runtime.Config{}
+			env := runtime.NewEnvironment(builderFunc(ref, api.New().HostFunctions(), config), config)
+
+			// workers that weren't pre-warmed build their instances at once, which is the builder's first build
+			errs := make(chan error, 8)
+			for i := 0; i < 8; i++ {
+				go func() {
+					errs <- env.AddInstance()
+				}()
+			}
+
+			for i := 0; i < 8; i++ {
+				if err := <-errs; err != nil {
+					t.Fatal(errors.Wrap(err, "failed to AddInstance"))
+				}
+			}
+
+			if health := env.Health(); health.Instances != 8 || health.State != runtime.PoolHealthy {
+				t.Errorf("expected 8 instances, got %+v", health)
+			}
+		})
+	}
+}
+
+// flakyBuilder builds instances with a RuntimeBuilder, except that it fails a number of times after the first successes
+type flakyBuilder struct {
+	runtime.RuntimeBuilder
+	succeed int
+	fail    int
+	lock    sync.Mutex
+}
+
+func (f *flakyBuilder) New() (runtime.RuntimeInstance, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.succeed > 0 {
+		f.succeed--
+	} else if f.fail > 0 {
+		f.fail--
+		return nil, errors.New("out of memory")
+	}
+
+	return f.RuntimeBuilder.New()
+}
+
+// waitForPoolState waits up to five seconds for an environment's pool to reach the given state, and returns its health
+func waitForPoolState(env *runtime.WasmEnvironment, state runtime.PoolState) runtime.PoolHealth {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		health := env.Health()
+		if health.State == state || time.Now().After(deadline) {
+			return health
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
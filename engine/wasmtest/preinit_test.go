@@ -0,0 +1,166 @@
+package wasmtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+	"github.com/suborbital/sat/engine/wasmbinary"
+)
+
+func TestWasmRunnerPreinit(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			ref, err := refFromFile("preinit", "../testdata/preinit/preinit.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			run := func(config runtime.Config) ([]byte, error) {
+				doWasm, err := engine.New(engine.UseRuntime(name)).RegisterWithConfig("preinit", ref, config)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to RegisterWithConfig")
+				}
+
+				res, err := doWasm(nil).Then()
+				if err != nil {
+					return nil, err
+				}
+
+				return res.([]byte), nil
+			}
+
+			expected, err := run(runtime.Config{})
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to run"))
+			}
+
+			if len(expected) != 31 || expected[30] != 1 {
+				t.Fatalf("expected _start to have run once, got %v", expected)
+			}
+
+			dir := t.TempDir()
+
+			// the second run loads the snapshot from the cache
+			for i := 0; i < 2; i++ {
+				res, err := run(runtime.Config{Preinit: true, CacheDir: dir})
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "failed to run preinitialized"))
+				}
+
+				if !bytes.Equal(res, expected) {
+					t.Errorf("expected the preinitialized module to give %v, got %v", expected, res)
+				}
+			}
+
+			snapshots, _ := filepath.Glob(filepath.Join(dir, "preinit-"+name, "*"))
+			if len(snapshots) != 1 {
+				t.Errorf("expected one cached snapshot, got %v", snapshots)
+			}
+
+			// logging is snapshot-safe, so the module can log as it is preinitialized
+			res, err := run(runtime.Config{Preinit: true, WASI: runtime.WASI{Args: []string{"log"}}})
+			if err != nil {
+				t.Error(errors.Wrap(err, "failed to run preinitialized module that logs"))
+			} else if !bytes.Equal(res, expected) {
+				t.Errorf("expected the preinitialized module that logs to give %v, got %v", expected, res)
+			}
+
+			// returning a result isn't, so the module is refused when its initialization does so
+			_, err = run(runtime.Config{Preinit: true, WASI: runtime.WASI{Args: []string{"return", "result"}}})
+			if err == nil || !strings.Contains(err.Error(), runtime.ErrNotSnapshotSafe.Error()) || !strings.Contains(err.Error(), "return_result") {
+				t.Errorf("expected return_result to be refused as the module is preinitialized, got %v", err)
+			}
+
+			// nor are the random numbers or time that it reads, which every instance would share
+			for _, args := range [][]string{{"read", "random", "bytes"}, {"read", "the", "clock", "time"}} {
+				_, err = run(runtime.Config{Preinit: true, WASI: runtime.WASI{Args: args}})
+				if !errors.Is(err, runtime.ErrPreinitReadSources) {
+					t.Errorf("expected the module to be refused for reading its sources with args %v, got %v", args, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPreinitialize(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			ref, err := refFromFile("preinit", "../testdata/preinit/preinit.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			preinitialized, err := runtime.Preinitialize(ref, api.New().HostFunctions(), name, runtime.Config{})
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Preinitialize"))
+			}
+
+			iface, err := wasmbinary.ReadInterface(preinitialized.Data)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to ReadInterface"))
+			}
+
+			for _, e := range iface.Exports {
+				if e.Name == "_start" {
+					t.Error("expected _start not to be exported by the preinitialized module")
+				}
+			}
+
+			if len(iface.Memories) != 1 || iface.Memories[0].Min != 2 {
+				t.Errorf("expected the memory to start at the 2 pages that _start grew it to, got %+v", iface.Memories)
+			}
+
+			// instances of the preinitialized module start where _start left off, without running it
+			doWasm, err := engine.New(engine.UseRuntime(name)).RegisterWithConfig("preinit", preinitialized, runtime.Config{})
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Register"))
+			}
+
+			res, err := doWasm(nil).Then()
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to run preinitialized module"))
+			}
+
+			out := res.([]byte)
+
+			if v := int32(binary.LittleEndian.Uint32(out[0:])); v != -123456 {
+				t.Errorf("expected the i32 global to be -123456, got %d", v)
+			}
+
+			if v := binary.LittleEndian.Uint64(out[4:]); v != 0x1122334455667788 {
+				t.Errorf("expected the i64 global to be 0x1122334455667788, got %#x", v)
+			}
+
+			if v := math.Float64frombits(binary.LittleEndian.Uint64(out[12:])); v != 1.5 {
+				t.Errorf("expected the f64 global to be 1.5, got %f", v)
+			}
+
+			if out[20] != 7 || string(out[21:29]) != "jello\x00\x00\x00" {
+				t.Errorf("expected the memory that _start wrote, got %v", out[20:29])
+			}
+
+			if out[30] != 1 {
+				t.Errorf("expected _start to have run once, got %d", out[30])
+			}
+		})
+	}
+
+	ref, err := refFromFile("preinit", "../testdata/preinit/preinit.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+	}
+
+	config := runtime.Config{WASI: runtime.WASI{Clock: runtime.SourceHost}}
+	if _, err := runtime.Preinitialize(ref, api.New().HostFunctions(), runtime.Backends()[0], config); !errors.Is(err, runtime.ErrPreinitHostSources) {
+		t.Errorf("expected the host's clock to be refused, got %v", err)
+	}
+}
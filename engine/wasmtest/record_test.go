@@ -0,0 +1,116 @@
+package wasmtest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/e2core/scheduler"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var requests atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("hello from the server"))
+	}))
+	defer server.Close()
+
+	recording := &bytes.Buffer{}
+
+	e := engine.New(engine.Record(api.NewRecordWriter(recording)))
+
+	e.RegisterFromFile("fetch", "../testdata/fetch/fetch.wasm")
+	e.RegisterFromFile("tinygo-cache", "../testdata/tinygo-cache/tinygo-cache.wasm")
+
+	// the module's second request goes to the internet, so whether it succeeds depends on where the test runs
+	e.Do(scheduler.NewJob("fetch", server.URL)).Then()
+
+	if _, err := e.Do(scheduler.NewJob("tinygo-cache", "very important")).Then(); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Do tinygo-cache"))
+	}
+
+	invocations, err := api.ReadInvocations(recording)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadInvocations"))
+	}
+
+	if len(invocations) != 2 {
+		t.Fatalf("expected 2 invocations to be recorded, got %d", len(invocations))
+	}
+
+	fetch, cache := invocations[0], invocations[1]
+
+	if fetch.Module != "fetch" || string(fetch.Input) != server.URL || len(fetch.HostCalls) != 2 {
+		t.Fatalf("expected the fetch module's input and 2 host calls to be recorded, got %+v", fetch)
+	}
+
+	if call := fetch.HostCalls[0]; call.Name != "fetch_url" || call.Args[1] != server.URL || call.Status != http.StatusOK || string(call.Result) != "hello from the server" {
+		t.Errorf("expected the response from the server to be recorded, got %+v", call)
+	}
+
+	if call := fetch.HostCalls[1]; call.Args[0] != http.MethodPost || string(call.Data) != `{"message": "testing the echo!"}` {
+		t.Errorf("expected the POST request's body to be recorded, got %+v", call)
+	}
+
+	if len(cache.HostCalls) != 2 || cache.HostCalls[0].String() != `cache_set("name", "0", "very important")` || string(cache.HostCalls[1].Result) != "very important" || string(cache.Output) != "very important" {
+		t.Errorf("expected the cache calls and output to be recorded, got %+v", cache)
+	}
+
+	// the host calls are served from the recording, so the server is never asked again
+	before := requests.Load()
+
+	replayer := api.NewReplayer()
+
+	r := engine.New(engine.Record(replayer))
+
+	r.RegisterFromFile("fetch", "../testdata/fetch/fetch.wasm")
+	r.RegisterFromFile("tinygo-cache", "../testdata/tinygo-cache/tinygo-cache.wasm")
+
+	replay := func(recorded api.Invocation, input string) (api.Invocation, []api.Divergence) {
+		t.Helper()
+
+		return replayer.Replay(recorded, func() {
+			r.Do(scheduler.NewJob(recorded.Module, input)).Then()
+		})
+	}
+
+	for _, recorded := range invocations {
+		replayed, divergences := replay(recorded, string(recorded.Input))
+		if len(divergences) > 0 {
+			t.Errorf("expected %s to replay without diverging, got %v", recorded.Module, divergences)
+		}
+
+		if !bytes.Equal(replayed.Output, recorded.Output) {
+			t.Errorf("expected %s to replay its output %q, got %q", recorded.Module, recorded.Output, replayed.Output)
+		}
+	}
+
+	if requests.Load() != before {
+		t.Error("expected the replay not to make any requests")
+	}
+
+	// a call with different arguments diverges
+	_, divergences := replay(cache, "unimportant")
+	if len(divergences) != 1 || divergences[0].String() != `host call 1: expected cache_set("name", "0", "very important"), got cache_set("name", "0", "unimportant")` {
+		t.Errorf("expected the cache_set call to diverge, got %v", divergences)
+	}
+
+	// and a call that comes back differently changes the output
+	changed := cache
+	changed.HostCalls = append([]api.HostCall{}, cache.HostCalls...)
+	changed.HostCalls[1].Result = []byte("something else")
+
+	replayed, divergences := replay(changed, "very important")
+	if len(divergences) != 1 || divergences[0].Call != -1 || string(replayed.Output) != "something else" {
+		t.Errorf("expected only the output to diverge, got %s with %v", replayed.Output, divergences)
+	}
+}
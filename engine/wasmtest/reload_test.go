@@ -0,0 +1,116 @@
+package wasmtest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/tenant"
+	"github.com/suborbital/e2core/scheduler"
+
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestReload(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			e := engine.New(engine.UseRuntime(name))
+
+			helloEcho, err := refFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			witEcho, err := refFromFile("wit-echo", "../testdata/wit-echo/wit-echo.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			badABI, err := refFromFile("bad-abi", "../testdata/bad-abi/bad-abi.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			doWasm, err := e.RegisterWithConfig("echo", helloEcho, runtime.Config{Pool: runtime.Pool{Mode: runtime.PoolReuse}})
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to RegisterWithConfig"))
+			}
+
+			expectOutput(t, doWasm, "joe", "hello joe")
+
+			// the new version is built against the other ABI, which each instance is run with
+			if err := e.Reload("echo", witEcho); err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Reload"))
+			}
+
+			expectOutput(t, doWasm, "joe", "joe")
+
+			// versions that fail to build leave the old version serving
+			for _, bad := range []*tenant.WasmModuleRef{badABI, tenant.NewWasmModuleRef("garbage", "", []byte("not a module"))} {
+				if err := e.Reload("echo", bad); err == nil {
+					t.Errorf("expected reloading %s to fail", bad.Name)
+				}
+
+				expectOutput(t, doWasm, "joe", "joe")
+			}
+
+			if err := e.Reload("missing", witEcho); err == nil {
+				t.Error("expected reloading an unregistered module to fail")
+			}
+		})
+	}
+}
+
+func TestReloadDrainsInstances(t *testing.T) {
+	e := engine.New()
+
+	ref, err := refFromFile("counter", "../testdata/counter/counter.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+	}
+
+	// a single reused instance counts its invocations, so it can be seen whether it has been replaced
+	config := runtime.Config{Pool: runtime.Pool{Mode: runtime.PoolReuse}, Limits: runtime.Limits{Instances: 1}}
+
+	doWasm, err := e.RegisterWithConfig("counter", ref, config)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to RegisterWithConfig"))
+	}
+
+	for i, want := range [][]byte{{1, 1}, {2, 2}, {1, 1}, {2, 2}} {
+		if i == 2 {
+			if err := e.Reload("counter", ref); err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Reload"))
+			}
+		}
+
+		res, err := doWasm(nil).Then()
+		if err != nil {
+			t.Fatal(errors.Wrapf(err, "failed to Then %d", i))
+		}
+
+		if !bytes.Equal(res.([]byte), want) {
+			t.Errorf("invocation %d: expected %v, got %v", i, want, res.([]byte))
+		}
+	}
+
+	if health, _ := e.Health("counter"); health.State != runtime.PoolHealthy || health.Instances != 1 {
+		t.Errorf("expected a single healthy instance, got %+v", health)
+	}
+}
+
+// expectOutput runs a module with input, and checks that it returned expected
+func expectOutput(t *testing.T, doWasm scheduler.JobFunc, input, expected string) {
+	t.Helper()
+
+	res, err := doWasm(input).Then()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Then"))
+	}
+
+	if string(res.([]byte)) != expected {
+		t.Errorf("expected %q, got %q", expected, string(res.([]byte)))
+	}
+}
@@ -0,0 +1,179 @@
+package wasmtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/capabilities"
+	"github.com/suborbital/appspec/request"
+	"github.com/suborbital/vektor/vlog"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestWasmRunnerTrapKinds(t *testing.T) {
+	cases := []struct {
+		op       string
+		kind     runtime.TrapKind
+		function string
+	}{
+		{op: "u", kind: runtime.TrapUnreachable, function: "do_unreachable"},
+		{op: "d", kind: runtime.TrapIntegerDivide, function: "do_divide"},
+		{op: "m", kind: runtime.TrapOutOfBounds, function: "do_load"},
+		{op: "s", kind: runtime.TrapStackOverflow, function: "do_recurse"},
+	}
+
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			for _, c := range cases {
+				trap, _, err := runTrap(name, c.op)
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "failed to runTrap"))
+				}
+
+				if trap.Kind != c.kind {
+					t.Errorf("expected %s to raise a %s trap, got %s (%s)", c.op, c.kind, trap.Kind, trap.Message)
+				}
+
+				// a stack overflow's backtrace is cut short, and some runtimes don't give one at all
+				if c.kind == runtime.TrapStackOverflow {
+					if len(trap.Frames) > 64 || (len(trap.Frames) > 0 && trap.Frames[0].Function != c.function) {
+						t.Errorf("expected a short backtrace of %s, got:\n%s", c.function, trap.Backtrace())
+					}
+
+					continue
+				}
+
+				if len(trap.Frames) != 2 || trap.Frames[0].Function != c.function || trap.Frames[1].Function != "run_e" {
+					t.Errorf("expected %s to trap in %s called from run_e, got:\n%s", c.op, c.function, trap.Backtrace())
+				}
+			}
+		})
+	}
+}
+
+func TestWasmRunnerTrapAbort(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			trap, logged, err := runTrap(name, "a")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to runTrap"))
+			}
+
+			if trap.Kind != runtime.TrapAbort || trap.Message != "runnable abort: something broke" {
+				t.Fatalf("expected the abort, got %s", trap)
+			}
+
+			// the abort's source location comes first, followed by the backtrace of the trap that followed it
+			if len(trap.Frames) != 3 {
+				t.Fatalf("expected 3 frames, got:\n%s", trap.Backtrace())
+			}
+
+			location := runtime.TrapFrame{File: "src/lib.rs", Line: 12, Column: 5}
+			if trap.Frames[0] != location {
+				t.Errorf("expected the abort location %s, got %s", location, trap.Frames[0])
+			}
+
+			if trap.Frames[1].Function != "do_abort" || trap.Frames[2].Function != "run_e" {
+				t.Errorf("expected the abort to be followed by its backtrace, got:\n%s", trap.Backtrace())
+			}
+
+			if !strings.Contains(logged.Message, "wasm trap (abort): runnable abort: something broke") || !strings.Contains(logged.Message, "1: do_abort") {
+				t.Errorf("expected the trap and its backtrace to be logged, got %q", logged.Message)
+			}
+		})
+	}
+}
+
+func TestWasmRunnerTrapSourceLines(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			ref, err := refFromFile("panic-at-the-disco", "../../examples/panic-at-the-disco/panic-at-the-disco.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			doWasm, err := engine.New(engine.UseRuntime(name)).RegisterWithConfig("panic-at-the-disco", ref, runtime.Config{})
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Register"))
+			}
+
+			_, err = doWasm(&request.CoordinatedRequest{Method: "GET", URL: "/", ID: uuid.New().String()}).Then()
+
+			trap := &runtime.Trap{}
+			if !errors.As(err, &trap) {
+				t.Fatalf("expected a trap, got %v", err)
+			}
+
+			if trap.Kind != runtime.TrapUnreachable {
+				t.Errorf("expected an unreachable trap, got %s", trap.Kind)
+			}
+
+			// the module is built with debug info, so the frames of the panic have source lines
+			found := false
+			for _, f := range trap.Frames {
+				if strings.HasSuffix(f.File, "lib.rs") && f.Line > 0 {
+					found = true
+				}
+			}
+
+			if !found {
+				t.Errorf("expected a frame in lib.rs, got:\n%s", trap.Backtrace())
+			}
+		})
+	}
+}
+
+// runTrap runs the trap test module with the given op on the named runtime, and returns the trap it raised and the
+// line that was logged for it, which must carry the request's ID
+func runTrap(name, op string) (*runtime.Trap, *outputLog, error) {
+	buf := &bytes.Buffer{}
+	logger := vlog.Default(vlog.WithWriter(buf), vlog.Level(vlog.LogLevelDebug))
+
+	hostAPI, err := api.NewWithConfig(capabilities.DefaultConfigWithLogger(logger))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to api.NewWithConfig")
+	}
+
+	ref, err := refFromFile("trap", "../testdata/trap/trap.wasm")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to refFromFile")
+	}
+
+	req := &request.CoordinatedRequest{
+		Method: "POST",
+		URL:    "/trap",
+		ID:     uuid.New().String(),
+		Body:   []byte(op),
+	}
+
+	doWasm, err := engine.NewWithAPI(hostAPI, engine.UseRuntime(name)).RegisterWithConfig("trap", ref, runtime.Config{})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to Register")
+	}
+
+	_, err = doWasm(req).Then()
+
+	trap := &runtime.Trap{}
+	if !errors.As(err, &trap) {
+		return nil, nil, errors.Errorf("expected a trap, got %v", err)
+	}
+
+	logged := &outputLog{}
+	if err := json.Unmarshal(buf.Bytes(), logged); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to Unmarshal log")
+	}
+
+	if logged.Scope.RequestID != req.ID || logged.Level != 1 {
+		return nil, nil, errors.Errorf("expected the trap to be logged as an error with request ID %s, got %+v", req.ID, logged)
+	}
+
+	return trap, logged, nil
+}
@@ -0,0 +1,224 @@
+package wasmtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+// wasiProbe is what the wasi test module could see
+type wasiProbe struct {
+	env          []string
+	args         []string
+	prestatErrno uint32
+	createErrno  uint32
+	readErrno    uint32
+	clock        time.Time
+	random       []byte
+}
+
+func TestWasmRunnerWASIDefault(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("SAT_WASI_TEST", "secret")
+
+			probe, err := probeWASI(name, runtime.Config{})
+			if err != nil && strings.Contains(err.Error(), runtime.ErrWASISourceNotSupported.Error()) {
+				t.Skip("runtime cannot withhold the host's clock and random sources")
+			} else if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to probeWASI"))
+			}
+
+			if len(probe.env) != 0 {
+				t.Errorf("expected no environment, got %v", probe.env)
+			}
+
+			if !reflect.DeepEqual(probe.args, []string{"wasi"}) {
+				t.Errorf("expected only the program name as an argument, got %v", probe.args)
+			}
+
+			// some runtimes preopen a directory of their own, but it must not give access to the host
+			if probe.createErrno == 0 {
+				t.Error("expected creating a file to fail without a preopened directory")
+			}
+
+			if time.Since(probe.clock) < 24*time.Hour {
+				t.Errorf("expected a fake clock, got %s", probe.clock)
+			}
+
+			// the random stream is the same every time when the host's source is withheld
+			again, err := probeWASI(name, runtime.Config{})
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to probeWASI"))
+			}
+
+			if !bytes.Equal(probe.random, again.random) {
+				t.Errorf("expected deterministic random bytes, got %v and %v", probe.random, again.random)
+			}
+		})
+	}
+}
+
+func TestWasmRunnerWASIConfigured(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("SAT_WASI_TEST", "passed-through")
+
+			dir := t.TempDir()
+
+			// reusing the instance stops a replacement being built in the background after the directory is removed
+			config := runtime.Config{
+				Pool: runtime.Pool{Mode: runtime.PoolReuse},
+				WASI: runtime.WASI{
+					Env:      []string{"SAT_WASI_TEST", "SAT_WASI_UNSET", "GREETING=hello"},
+					Args:     []string{"--verbose"},
+					Preopens: []runtime.Preopen{{Host: dir, Guest: "/data", Writable: true}},
+					Clock:    runtime.SourceHost,
+					Random:   runtime.SourceHost,
+				},
+			}
+
+			probe, err := probeWASI(name, config)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to probeWASI"))
+			}
+
+			if want := []string{"SAT_WASI_TEST=passed-through", "GREETING=hello"}; !reflect.DeepEqual(probe.env, want) {
+				t.Errorf("expected environment %v, got %v", want, probe.env)
+			}
+
+			if want := []string{"wasi", "--verbose"}; !reflect.DeepEqual(probe.args, want) {
+				t.Errorf("expected arguments %v, got %v", want, probe.args)
+			}
+
+			if probe.prestatErrno != 0 || probe.createErrno != 0 {
+				t.Fatalf("expected a writable preopened directory, got errnos %d and %d", probe.prestatErrno, probe.createErrno)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "sat-wasi-probe.txt")); err != nil {
+				t.Error(errors.Wrap(err, "expected the module to create a file in the preopened directory"))
+			}
+
+			if since := time.Since(probe.clock); since < 0 || since > time.Minute {
+				t.Errorf("expected the host's clock, got %s", probe.clock)
+			}
+		})
+	}
+}
+
+func TestWasmRunnerWASIReadOnlyPreopen(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			if err := os.WriteFile(filepath.Join(dir, "sat-wasi-read.txt"), []byte("readable"), 0600); err != nil {
+				t.Fatal(errors.Wrap(err, "failed to WriteFile"))
+			}
+
+			// the default form of a preopen, as given in SAT_WASI_PREOPENS, is read-only
+			preopen, err := runtime.ParsePreopen(dir + ":/data")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to ParsePreopen"))
+			}
+
+			config := runtime.Config{
+				Pool: runtime.Pool{Mode: runtime.PoolReuse},
+				WASI: runtime.WASI{
+					Preopens: []runtime.Preopen{preopen},
+					Clock:    runtime.SourceHost,
+					Random:   runtime.SourceHost,
+				},
+			}
+
+			probe, err := probeWASI(name, config)
+			if err != nil {
+				// runtimes that cannot enforce a read-only directory must refuse to mount it, Wasmtime enforces it
+				if name == "wasmtime" || !strings.Contains(err.Error(), runtime.ErrReadOnlyPreopenNotSupported.Error()) {
+					t.Fatal(errors.Wrap(err, "failed to probeWASI"))
+				}
+
+				return
+			}
+
+			if probe.prestatErrno != 0 {
+				t.Fatalf("expected a preopened directory, got errno %d", probe.prestatErrno)
+			}
+
+			if probe.readErrno != 0 {
+				t.Errorf("expected reading a file in a read-only directory to succeed, got errno %d", probe.readErrno)
+			}
+
+			if probe.createErrno == 0 {
+				t.Error("expected creating a file in a read-only directory to fail")
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "sat-wasi-probe.txt")); err == nil {
+				t.Error("expected no file to be created in the read-only directory")
+			}
+		})
+	}
+}
+
+// probeWASI runs the wasi test module on the named runtime and decodes what it reports
+func probeWASI(name string, config runtime.Config) (wasiProbe, error) {
+	ref, err := refFromFile("wasi", "../testdata/wasi/wasi.wasm")
+	if err != nil {
+		return wasiProbe{}, errors.Wrap(err, "failed to refFromFile")
+	}
+
+	doWasm, err := engine.New(engine.UseRuntime(name)).RegisterWithConfig("wasi", ref, config)
+	if err != nil {
+		return wasiProbe{}, errors.Wrap(err, "failed to RegisterWithConfig")
+	}
+
+	res, err := doWasm(nil).Then()
+	if err != nil {
+		return wasiProbe{}, errors.Wrap(err, "failed to Then")
+	}
+
+	return decodeWASIProbe(res.([]byte))
+}
+
+// decodeWASIProbe decodes what the wasi test module reports
+func decodeWASIProbe(out []byte) (wasiProbe, error) {
+	if len(out) < 44 {
+		return wasiProbe{}, errors.Errorf("expected at least 44 bytes, got %d", len(out))
+	}
+
+	envSize := binary.LittleEndian.Uint32(out[4:])
+
+	probe := wasiProbe{
+		env:          splitNulls(out[44 : 44+envSize]),
+		args:         splitNulls(out[44+envSize:]),
+		prestatErrno: binary.LittleEndian.Uint32(out[16:]),
+		createErrno:  binary.LittleEndian.Uint32(out[20:]),
+		clock:        time.Unix(0, int64(binary.LittleEndian.Uint64(out[24:]))),
+		random:       out[32:40],
+		readErrno:    binary.LittleEndian.Uint32(out[40:]),
+	}
+
+	return probe, nil
+}
+
+// splitNulls splits a buffer of null-terminated strings
+func splitNulls(buf []byte) []string {
+	strs := []string{}
+
+	for _, s := range bytes.Split(buf, []byte{0}) {
+		if len(s) > 0 {
+			strs = append(strs, string(s))
+		}
+	}
+
+	return strs
+}
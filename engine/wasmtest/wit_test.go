@@ -0,0 +1,132 @@
+package wasmtest
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/e2core/scheduler"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
+)
+
+func TestWasmRunnerABIv2(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			// modules built against either version of the host API run side by side in the same engine
+			e := engine.New(engine.UseRuntime(name))
+
+			doV1, err := e.RegisterFromFile("hello-echo", "../testdata/hello-echo/hello-echo.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to RegisterFromFile"))
+			}
+
+			doV2, err := e.RegisterFromFile("wit-echo", "../testdata/wit-echo/wit-echo.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to RegisterFromFile"))
+			}
+
+			res, err := doV1("world").Then()
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Then v1"))
+			}
+
+			if string(res.([]byte)) != "hello world" {
+				t.Errorf("expected 'hello world', got %s", string(res.([]byte)))
+			}
+
+			// the input makes a round trip through the cache, which lowers it into memory allocated by the module
+			for _, input := range []string{"my name is joe", "hello from the component model"} {
+				res, err := doV2(input).Then()
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "failed to Then v2"))
+				}
+
+				if string(res.([]byte)) != input {
+					t.Errorf("expected %q, got %q", input, string(res.([]byte)))
+				}
+			}
+
+			_, err = doV2("").Then()
+
+			runErr := scheduler.RunErr{}
+			if !errors.As(err, &runErr) || runErr.Code != 400 || runErr.Message != "empty input" {
+				t.Errorf("expected a RunErr with code 400, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWasmRunnerABIv2Trap(t *testing.T) {
+	for _, name := range runtime.Backends() {
+		t.Run(name, func(t *testing.T) {
+			ref, err := refFromFile("wit-trap", "../testdata/wit-trap/wit-trap.wasm")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to refFromFile"))
+			}
+
+			// with a single reused instance, the call after the trap would run in the instance that trapped
+			config := runtime.Config{Pool: runtime.Pool{Mode: runtime.PoolReuse}, Limits: runtime.Limits{Instances: 1}}
+			doWasm, err := engine.New(engine.UseRuntime(name)).RegisterWithConfig("wit-trap", ref, config)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to RegisterWithConfig"))
+			}
+
+			if _, err := doWasm("trap").Then(); err == nil {
+				t.Fatal("expected the call to trap")
+			}
+
+			res, err := doWasm("again").Then()
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "failed to Then"))
+			}
+
+			if string(res.([]byte)) != "ok" {
+				t.Errorf("expected the instance that trapped to be replaced, got %q", string(res.([]byte)))
+			}
+		})
+	}
+}
+
+func TestCheckABIv2(t *testing.T) {
+	module, err := os.ReadFile("../testdata/wit-echo/wit-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+	}
+
+	report, err := runtime.CheckABI(module, api.New().HostFunctions())
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to CheckABI"))
+	}
+
+	if report.Version != runtime.ABIv2 || len(report.Problems) != 0 {
+		t.Errorf("expected a v2 module without problems, got v%d with %q", report.Version, report.Problems)
+	}
+
+	// a host that only provides the original host functions can't run the module
+	v1Fns := []runtime.HostFn{}
+	for _, fn := range api.New().HostFunctions() {
+		if fn.Module == "" {
+			v1Fns = append(v1Fns, fn)
+		}
+	}
+
+	report, err = runtime.CheckABI(module, v1Fns)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to CheckABI"))
+	}
+
+	expected := []string{
+		"unknown import sat:host/log@2.0.0.log, sat provides no such host function",
+		"unknown import sat:host/cache@2.0.0.set, sat provides no such host function",
+		"unknown import sat:host/cache@2.0.0.get, sat provides no such host function",
+	}
+
+	if !reflect.DeepEqual(report.Problems, expected) {
+		t.Errorf("expected problems %q, got %q", expected, report.Problems)
+	}
+}
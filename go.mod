@@ -1,12 +1,14 @@
 module github.com/suborbital/sat
 
-go 1.20
+go 1.22.0
 
 require (
 	github.com/bytecodealliance/wasmtime-go/v5 v5.0.0
 	github.com/docker/go-connections v0.4.0
 	github.com/google/uuid v1.3.0
 	github.com/lib/pq v1.10.7
+	github.com/opencontainers/go-digest v1.0.0
+	github.com/opencontainers/image-spec v1.0.3-0.20211202183452-c5a74bcca799
 	github.com/pkg/errors v0.9.1
 	github.com/second-state/WasmEdge-go v0.11.0
 	github.com/sethvargo/go-envconfig v0.8.2
@@ -16,6 +18,7 @@ require (
 	github.com/suborbital/go-kit v0.0.0-20220913125118-e0faaefc95df
 	github.com/suborbital/vektor v0.5.3-0.20220706142315-ee5378e49e18
 	github.com/testcontainers/testcontainers-go v0.14.0
+	github.com/tetratelabs/wazero v1.9.0
 	github.com/wasmerio/wasmer-go v1.0.4
 	go.opentelemetry.io/otel v1.10.0
 	go.opentelemetry.io/otel/metric v0.32.1
@@ -61,8 +64,6 @@ require (
 	github.com/moby/sys/mountinfo v0.6.2 // indirect
 	github.com/moby/term v0.0.0-20210619224110-3f7ff695adc6 // indirect
 	github.com/morikuni/aec v1.0.0 // indirect
-	github.com/opencontainers/go-digest v1.0.0 // indirect
-	github.com/opencontainers/image-spec v1.0.3-0.20211202183452-c5a74bcca799 // indirect
 	github.com/opencontainers/runc v1.1.3 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/schollz/peerdiscovery v1.6.12 // indirect
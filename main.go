@@ -22,6 +22,38 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if conf.Command == sat.CommandPrecompile {
+		if err = sat.Precompile(conf); err != nil {
+			conf.Logger.Error(errors.Wrap(err, "precompile"))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if conf.Command == sat.CommandSign {
+		if err = sat.Sign(conf, os.Stdout); err != nil {
+			conf.Logger.Error(errors.Wrap(err, "sign"))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if conf.Command == sat.CommandInspect {
+		if err = sat.Inspect(conf, os.Stdout); err != nil {
+			conf.Logger.Error(errors.Wrap(err, "inspect"))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if conf.Command == sat.CommandReplay {
+		if err = sat.Replay(conf, os.Stdout); err != nil {
+			conf.Logger.Error(errors.Wrap(err, "replay"))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if conf.UseStdin {
 		if err = runStdIn(conf); err != nil {
 			conf.Logger.Error(errors.Wrap(err, "startup in StdIn"))
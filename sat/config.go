@@ -21,11 +21,33 @@ import (
 	"github.com/suborbital/e2core/options"
 	"github.com/suborbital/vektor/vlog"
 
+	wruntime "github.com/suborbital/sat/engine/runtime"
 	satOptions "github.com/suborbital/sat/sat/options"
+	"github.com/suborbital/sat/sat/signature"
 )
 
 var useStdin bool
 
+// CommandPrecompile compiles the module ahead of time to fill the compile cache, rather than serving it
+const CommandPrecompile = "precompile"
+
+// CommandInspect prints what the module imports, exports and requires, rather than serving it
+const CommandInspect = "inspect"
+
+// CommandSign signs modules so that a sat trusting the signing key will load them, rather than serving them
+const CommandSign = "sign"
+
+// CommandReplay runs the module again for each invocation in a recording, with its host calls served from the
+// recording, and reports how the invocations diverged from it rather than serving the module
+const CommandReplay = "replay"
+
+var commands = map[string]bool{
+	CommandPrecompile: true,
+	CommandInspect:    true,
+	CommandSign:       true,
+	CommandReplay:     true,
+}
+
 func init() {
 	flag.BoolVar(&useStdin, "stdin", false, "read stdin as input, return output to stdout and then terminate")
 }
@@ -45,6 +67,27 @@ type Config struct {
 	ProcUUID        string
 	TracerConfig    satOptions.TracerConfig
 	MetricsConfig   satOptions.MetricsConfig
+	RuntimeConfig   wruntime.Config
+	SchedulerConfig SchedulerConfig
+	Runtime         string
+	Command         string
+	JSONOutput      bool
+	// Routes send requests to each of the modules when sat serves several, and is empty when it serves one
+	Routes []Route
+	// ModuleConfigs are the configs of each of the modules by name when sat serves several, since each module is
+	// configured by its own .module.yml
+	ModuleConfigs map[string]ModuleConfig
+	ReloadConfig  satOptions.ReloadConfig
+	SignConfig    SignConfig
+	// TrustedKeys are the keys that modules must be signed with, and is empty when they needn't be signed
+	TrustedKeys signature.KeySet
+	// RecordFile is the file that invocations are recorded to, and is empty when they aren't recorded
+	RecordFile string
+	// Recording is the file of recorded invocations that the replay command replays
+	Recording string
+
+	// appSource is the control plane that the module was fetched from, and is nil if it wasn't
+	appSource system.Source
 }
 
 type satInfo struct {
@@ -55,17 +98,216 @@ type app struct {
 	Name string `json:"name"`
 }
 
+// ModuleConfig is how a module is run, which the environment sets the defaults of and its .module.yml overrides
+type ModuleConfig struct {
+	RuntimeConfig   wruntime.Config
+	SchedulerConfig SchedulerConfig
+}
+
+// moduleDotYaml holds the sat-specific additions to a module's .module.yml
+type moduleDotYaml struct {
+	Runtime   *wruntime.Config `yaml:"runtime"`
+	Scheduler *SchedulerConfig `yaml:"scheduler"`
+}
+
 func ConfigFromArgs() (*Config, error) {
 	flag.Parse()
 	args := flag.Args()
 
 	if len(args) < 1 {
-		return nil, errors.New("missing argument: module (path, URL or FQMN)")
+		return nil, errors.New("missing argument: module (path, URL, OCI reference or FQMN)")
+	}
+
+	// the first argument can optionally be a command, followed by its flags and the module
+	command := ""
+	jsonOutput := false
+	signConfig := SignConfig{}
+	if commands[args[0]] {
+		command = args[0]
+
+		commandFlags := flag.NewFlagSet(command, flag.ExitOnError)
+		switch command {
+		case CommandInspect:
+			commandFlags.BoolVar(&jsonOutput, "json", false, "print the inspection as JSON rather than text")
+		case CommandSign:
+			signConfig.flags(commandFlags)
+		}
+
+		commandFlags.Parse(args[1:])
+		args = commandFlags.Args()
+
+		// signing only reads and writes files, so needs none of the configuration used to run a module
+		if command == CommandSign {
+			return signConfig.config(args)
+		}
+
+		if len(args) < 1 {
+			return nil, fmt.Errorf("missing argument: %s requires a module (path, URL, OCI reference or FQMN)", command)
+		}
+	}
+
+	// the module is followed by the recording to replay
+	recording := ""
+	if command == CommandReplay {
+		if len(args) != 2 {
+			return nil, errors.New("replay requires a module (path, URL, OCI reference or FQMN) and a recording (path)")
+		}
+
+		recording = args[1]
+		args = args[:1]
+	}
+
+	config, err := ConfigFromModuleArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.Routes) > 0 && (command == CommandInspect || command == CommandReplay || config.UseStdin) {
+		return nil, errors.New("inspect, replay and -stdin take a single module")
+	}
+
+	config.Command = command
+	config.Recording = recording
+	config.JSONOutput = jsonOutput
+
+	return config, nil
+}
+
+// ConfigFromModuleArgs creates the config for the modules named by args, which is a single module (path, URL or FQMN)
+// or, to serve several modules from one sat, paths to several modules, a directory of modules or a routes file
+func ConfigFromModuleArgs(args []string) (*Config, error) {
+	routes, err := routesFromArgs(args)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to routesFromArgs")
+	}
+
+	if len(routes) == 0 {
+		return ConfigFromRunnableArg(args[0])
+	}
+
+	config, err := ConfigFromRunnableArg(routes[0].Module)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := satOptions.Resolve(envconfig.OsLookuper())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to options.Resolve")
 	}
 
-	runnableArg := args[0]
+	// the modules are each registered under their own name, and configured by their own .module.yml
+	config.Routes = routes
+	config.JobType = routes[0].Name()
+	config.ModuleConfigs = map[string]ModuleConfig{}
 
-	return ConfigFromRunnableArg(runnableArg)
+	for _, r := range routes {
+		if _, exists := config.ModuleConfigs[r.Name()]; exists {
+			continue
+		}
+
+		moduleConfig, err := moduleConfigFromOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		dotYaml := &moduleDotYaml{Runtime: &moduleConfig.RuntimeConfig, Scheduler: &moduleConfig.SchedulerConfig}
+		if _, err := findModuleDotYaml(r.Module, dotYaml); err != nil {
+			return nil, errors.Wrapf(err, "failed to findModuleDotYaml for %s", r.Name())
+		}
+
+		if err := moduleConfig.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid config for %s", r.Name())
+		}
+
+		config.ModuleConfigs[r.Name()] = moduleConfig
+	}
+
+	return config, nil
+}
+
+// moduleConfigFromOptions returns the config that the environment sets for every module, before its .module.yml
+func moduleConfigFromOptions(opts satOptions.Options) (ModuleConfig, error) {
+	runtimeConfig := wruntime.Config{
+		Timeout: opts.ExecConfig.Timeout,
+		Fuel:    opts.ExecConfig.Fuel,
+		Limits: wruntime.Limits{
+			MemoryPages:   opts.LimitsConfig.MemoryPages,
+			TableElements: opts.LimitsConfig.TableElements,
+			Instances:     opts.LimitsConfig.Instances,
+		},
+		Pool: wruntime.Pool{
+			Mode:    wruntime.PoolMode(opts.PoolConfig.Mode),
+			MaxUses: opts.PoolConfig.MaxUses,
+		},
+		WASI: wruntime.WASI{
+			Env:    opts.WASIConfig.Env,
+			Args:   opts.WASIConfig.Args,
+			Clock:  wruntime.WASISource(opts.WASIConfig.Clock),
+			Random: wruntime.WASISource(opts.WASIConfig.Random),
+		},
+		Output: wruntime.Output{
+			StdoutLevel: wruntime.LogLevel(opts.OutputConfig.StdoutLevel),
+			StderrLevel: wruntime.LogLevel(opts.OutputConfig.StderrLevel),
+			MaxBytes:    opts.OutputConfig.MaxBytes,
+			DebugHeader: opts.OutputConfig.DebugHeader,
+		},
+		Preinit:    opts.Preinit,
+		DebugTraps: opts.DebugTraps,
+		CacheDir:   opts.CompileCacheDir,
+	}
+
+	schedulerConfig := SchedulerConfig{
+		MinWorkers:   opts.SchedulerConfig.MinWorkers,
+		MaxWorkers:   opts.SchedulerConfig.MaxWorkers,
+		PreWarm:      opts.SchedulerConfig.PreWarm,
+		Retries:      opts.SchedulerConfig.Retries,
+		RetryBackoff: opts.SchedulerConfig.RetryBackoff,
+		QueueDepth:   opts.SchedulerConfig.QueueDepth,
+	}
+
+	for _, p := range opts.WASIConfig.Preopens {
+		preopen, err := wruntime.ParsePreopen(p)
+		if err != nil {
+			return ModuleConfig{}, errors.Wrap(err, "failed to ParsePreopen")
+		}
+
+		runtimeConfig.WASI.Preopens = append(runtimeConfig.WASI.Preopens, preopen)
+	}
+
+	return ModuleConfig{RuntimeConfig: runtimeConfig, SchedulerConfig: schedulerConfig}, nil
+}
+
+// Validate returns an error if the module can't be run as configured
+func (m ModuleConfig) Validate() error {
+	runtimeConfig := m.RuntimeConfig
+
+	if err := runtimeConfig.Pool.Validate(); err != nil {
+		return errors.Wrap(err, "failed to Pool.Validate")
+	}
+
+	if err := runtimeConfig.WASI.Validate(); err != nil {
+		return errors.Wrap(err, "failed to WASI.Validate")
+	}
+
+	if err := runtimeConfig.Output.Validate(); err != nil {
+		return errors.Wrap(err, "failed to Output.Validate")
+	}
+
+	if err := runtimeConfig.CheckPreinit(); err != nil {
+		return errors.Wrap(err, "failed to CheckPreinit")
+	}
+
+	if runtimeConfig.Deterministic != nil {
+		if err := runtimeConfig.Deterministic.Validate(runtimeConfig.WASI, runtimeConfig.Pool); err != nil {
+			return errors.Wrap(err, "failed to Deterministic.Validate")
+		}
+	}
+
+	if err := m.SchedulerConfig.Validate(); err != nil {
+		return errors.Wrap(err, "failed to SchedulerConfig.Validate")
+	}
+
+	return nil
 }
 
 func ConfigFromRunnableArg(runnableArg string) (*Config, error) {
@@ -81,6 +323,14 @@ func ConfigFromRunnableArg(runnableArg string) (*Config, error) {
 		return nil, errors.Wrap(err, "configFromRunnableArg options.Resolve")
 	}
 
+	// the environment sets the defaults for the module's runtime, which .module.yml can then override
+	moduleConfig, err := moduleConfigFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	runtimeConfig, schedulerConfig := moduleConfig.RuntimeConfig, moduleConfig.SchedulerConfig
+
 	// first, determine if we need to connect to a control plane
 	controlPlane := ""
 	useControlPlane := false
@@ -100,10 +350,21 @@ func ConfigFromRunnableArg(runnableArg string) (*Config, error) {
 		}
 	}
 
+	// a module pulled from an OCI registry is then loaded from the cache like any module on disk, with its .module.yml
+	if isOCI(runnableArg) {
+		logger.Debug("pulling module from OCI registry")
+		pulled, err := pullFromOCI(runnableArg, opts.DownloadConfig, logger)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to pullFromOCI")
+		}
+
+		runnableArg = pulled
+	}
+
 	// next, handle the module arg being a URL, an FQMN, or a path on disk
 	if isURL(runnableArg) {
 		logger.Debug("fetching module from URL")
-		tmpFile, err := downloadFromURL(runnableArg)
+		tmpFile, err := downloadFromURL(runnableArg, opts.DownloadConfig, logger)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to downloadFromURL")
 		}
@@ -129,7 +390,7 @@ func ConfigFromRunnableArg(runnableArg string) (*Config, error) {
 			caps = *rendered
 		}
 	} else {
-		diskRunnable, err := findModuleDotYaml(runnableArg)
+		diskRunnable, err := findModuleDotYaml(runnableArg, &moduleDotYaml{Runtime: &runtimeConfig, Scheduler: &schedulerConfig})
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to findRunnable")
 		}
@@ -148,6 +409,30 @@ func ConfigFromRunnableArg(runnableArg string) (*Config, error) {
 		module = diskRunnable
 	}
 
+	// fail fast if the selected runtime was not compiled in, rather than when the first request arrives
+	runtimeName := opts.Runtime
+	if runtimeName == "" {
+		runtimeName = wruntime.DefaultBackend()
+	}
+
+	if _, err := wruntime.Backend(runtimeName); err != nil {
+		return nil, errors.Wrap(err, "failed to select runtime")
+	}
+
+	moduleConfig = ModuleConfig{RuntimeConfig: runtimeConfig, SchedulerConfig: schedulerConfig}
+	if err := moduleConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	if opts.ReloadConfig.Watch && opts.ReloadConfig.Interval <= 0 {
+		return nil, fmt.Errorf("SAT_RELOAD_INTERVAL must be positive to watch the module, got %s", opts.ReloadConfig.Interval)
+	}
+
+	trustedKeys, err := signature.ParseKeys(opts.TrustedKeys)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse SAT_TRUSTED_KEYS")
+	}
+
 	// set some defaults in the case we're not running in an application
 	portInt, _ := strconv.Atoi(string(opts.Port))
 	jobType := strings.TrimSuffix(filepath.Base(runnableArg), ".wasm")
@@ -188,12 +473,42 @@ func ConfigFromRunnableArg(runnableArg string) (*Config, error) {
 		TracerConfig:    opts.TracerConfig,
 		MetricsConfig:   opts.MetricsConfig,
 		ProcUUID:        string(opts.ProcUUID),
+		RuntimeConfig:   runtimeConfig,
+		SchedulerConfig: schedulerConfig,
+		Runtime:         runtimeName,
+		ReloadConfig:    opts.ReloadConfig,
+		TrustedKeys:     trustedKeys,
+		RecordFile:      opts.RecordFile,
+	}
+
+	if useControlPlane && module != nil {
+		c.appSource = appClient
 	}
 
 	return c, nil
 }
 
-func findModuleDotYaml(runnableArg string) (*tenant.Module, error) {
+// fromControlPlane returns true if the named module was fetched from the control plane
+func (c *Config) fromControlPlane(name string) bool {
+	return c.appSource != nil && name == c.JobType
+}
+
+// moduleConfig returns the config of the named module
+func (c *Config) moduleConfig(name string) ModuleConfig {
+	moduleConfig, exists := c.ModuleConfigs[name]
+	if !exists {
+		moduleConfig = ModuleConfig{RuntimeConfig: c.RuntimeConfig, SchedulerConfig: c.SchedulerConfig}
+	}
+
+	// each of the module's workers adds an instance to its pool, so the pool never holds more than one per worker
+	moduleConfig.RuntimeConfig.Pool.Size = moduleConfig.SchedulerConfig.MaxWorkers
+
+	return moduleConfig
+}
+
+// findModuleDotYaml loads the .module.yml next to the module (if any), applying its runtime and scheduler sections on
+// top of the configs in dotYaml
+func findModuleDotYaml(runnableArg string, dotYaml *moduleDotYaml) (*tenant.Module, error) {
 	filename := filepath.Base(runnableArg)
 	moduleFilepath := strings.Replace(runnableArg, filename, ".module.yml", -1)
 
@@ -212,5 +527,10 @@ func findModuleDotYaml(runnableArg string) (*tenant.Module, error) {
 		return nil, errors.Wrap(err, "failed to Unmarshal")
 	}
 
+	// unmarshalling onto the existing configs only replaces the values that are set in the file
+	if err := yaml.Unmarshal(runnableBytes, dotYaml); err != nil {
+		return nil, errors.Wrap(err, "failed to Unmarshal runtime config")
+	}
+
 	return module, nil
 }
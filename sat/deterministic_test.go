@@ -0,0 +1,56 @@
+package sat
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	wruntime "github.com/suborbital/sat/engine/runtime"
+)
+
+func TestDeterministicModuleDotYaml(t *testing.T) {
+	dir := t.TempDir()
+
+	copyModule(t, "../examples/hello-echo/hello-echo.wasm", filepath.Join(dir, "hello-echo.wasm"))
+
+	dotYaml := `name: hello-echo
+namespace: default
+lang: rust
+runtime:
+  deterministic:
+    clock: fixed
+    time: 2030-06-01T12:00:00Z
+    stubs:
+      fetch_url:
+        https://example.com: hello
+`
+	if err := os.WriteFile(filepath.Join(dir, ".module.yml"), []byte(dotYaml), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile .module.yml"))
+	}
+
+	config, err := ConfigFromRunnableArg(filepath.Join(dir, "hello-echo.wasm"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ConfigFromRunnableArg"))
+	}
+
+	expected := &wruntime.Deterministic{
+		Clock: wruntime.ClockFixed,
+		Time:  time.Date(2030, time.June, 1, 12, 0, 0, 0, time.UTC),
+		Stubs: map[string]map[string]string{"fetch_url": {"https://example.com": "hello"}},
+	}
+
+	if !reflect.DeepEqual(config.RuntimeConfig.Deterministic, expected) {
+		t.Errorf("expected %+v, got %+v", expected, config.RuntimeConfig.Deterministic)
+	}
+
+	// the host's clock would make the module's invocations differ
+	t.Setenv("SAT_WASI_CLOCK", "host")
+
+	if _, err := ConfigFromRunnableArg(filepath.Join(dir, "hello-echo.wasm")); err == nil {
+		t.Error("expected a deterministic module given the host's clock to be rejected")
+	}
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/suborbital/sat/api"
 	"github.com/suborbital/sat/engine"
+	"github.com/suborbital/sat/engine/runtime"
 )
 
 var (
@@ -43,7 +44,7 @@ type Executor struct {
 }
 
 // New creates an Executor
-func New(log *vlog.Logger, config capabilities.CapabilityConfig) (*Executor, error) {
+func New(log *vlog.Logger, config capabilities.CapabilityConfig, opts ...engine.Option) (*Executor, error) {
 	api, err := api.NewWithConfig(config)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to NewWithConfig")
@@ -51,13 +52,22 @@ func New(log *vlog.Logger, config capabilities.CapabilityConfig) (*Executor, err
 
 	e := &Executor{
 		log:      log,
-		engine:   engine.NewWithAPI(api),
+		engine:   engine.NewWithAPI(api, opts...),
 		capCache: make(map[string]*capabilities.Capabilities),
 	}
 
 	return e, nil
 }
 
+// Precompile compiles a module ahead of time, filling the compile cache
+func (e *Executor) Precompile(ref *tenant.WasmModuleRef, config runtime.Config) error {
+	if e.engine == nil {
+		return ErrExecutorNotConfigured
+	}
+
+	return e.engine.Precompile(ref, config)
+}
+
 // Do executes a local or remote job.
 func (e *Executor) Do(jobType string, req *request.CoordinatedRequest, ctx *vk.Ctx, cb bus.MsgFunc) (interface{}, error) {
 	if e.engine == nil {
@@ -89,6 +99,24 @@ func (e *Executor) Do(jobType string, req *request.CoordinatedRequest, ctx *vk.C
 	return result, err
 }
 
+// PreWarm builds instances of a registered job ahead of time, so that its first requests don't wait for them
+func (e *Executor) PreWarm(jobType string, count int) error {
+	if e.engine == nil {
+		return ErrExecutorNotConfigured
+	}
+
+	return e.engine.PreWarm(jobType, count)
+}
+
+// Health returns the health of a registered job's pool of instances, or false if the job isn't handled locally
+func (e *Executor) Health(jobType string) (runtime.PoolHealth, bool) {
+	if e.engine == nil {
+		return runtime.PoolHealth{}, false
+	}
+
+	return e.engine.Health(jobType)
+}
+
 // UseGrav sets a Bus instance to use (in case one was not provided initially)
 func (e *Executor) UseBus(b *bus.Bus) {
 	e.bus = b
@@ -97,11 +125,35 @@ func (e *Executor) UseBus(b *bus.Bus) {
 
 // Register registers a Runnable.
 func (e *Executor) Register(jobType string, ref *tenant.WasmModuleRef, opts ...scheduler.Option) error {
+	return e.RegisterWithConfig(jobType, ref, runtime.Config{}, opts...)
+}
+
+// RegisterWithConfig registers a Runnable, building its instances using the given runtime config. It fails if the
+// module doesn't match the ABI, reporting every problem with it, or if the runtime is unavailable or can't honour the
+// config.
+func (e *Executor) RegisterWithConfig(jobType string, ref *tenant.WasmModuleRef, config runtime.Config, opts ...scheduler.Option) error {
+	if e.engine == nil {
+		return ErrExecutorNotConfigured
+	}
+
+	// the engine checks the module against the ABI as it loads it
+	if _, err := e.engine.RegisterWithConfig(jobType, ref, config, opts...); err != nil {
+		return errors.Wrap(err, "failed to RegisterWithConfig")
+	}
+
+	return nil
+}
+
+// Reload replaces a registered Runnable with a new version of its module, which keeps serving the old version if the
+// new one doesn't match the ABI or fails to build
+func (e *Executor) Reload(jobType string, ref *tenant.WasmModuleRef) error {
 	if e.engine == nil {
 		return ErrExecutorNotConfigured
 	}
 
-	e.engine.Register(jobType, ref, opts...)
+	if err := e.engine.Reload(jobType, ref); err != nil {
+		return errors.Wrap(err, "failed to engine.Reload")
+	}
 
 	return nil
 }
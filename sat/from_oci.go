@@ -0,0 +1,652 @@
+package sat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/vektor/vlog"
+
+	satOptions "github.com/suborbital/sat/sat/options"
+)
+
+// ociScheme prefixes references to modules in OCI registries, as in oci://ghcr.io/acmeco/hello:v1 or
+// oci://ghcr.io/acmeco/hello@sha256:<hex>
+const ociScheme = "oci://"
+
+// the media types of the layers that modules are pulled from. The tools that push modules don't agree on a media
+// type, so a layer whose title ends in .wasm is taken to be the module too.
+const (
+	mediaTypeWasmLayer       = "application/vnd.wasm.content.layer.v1+wasm"
+	mediaTypeModuleWasmLayer = "application/vnd.module.wasm.content.layer.v1+wasm"
+	mediaTypeWasm            = "application/wasm"
+	// mediaTypeModuleDotYaml is the optional layer holding the module's .module.yml, which may instead be titled
+	// .module.yml
+	mediaTypeModuleDotYaml = "application/vnd.suborbital.module.v1+yaml"
+)
+
+// the media types of Docker's manifests, which registries serve in place of OCI's
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// manifestAccept lists the manifests that can be pulled from
+var manifestAccept = strings.Join([]string{
+	v1.MediaTypeImageManifest,
+	v1.MediaTypeImageIndex,
+	mediaTypeDockerManifest,
+	mediaTypeDockerManifestList,
+}, ", ")
+
+// ociManifest is an image manifest, or an index of manifests for several platforms
+type ociManifest struct {
+	v1.Manifest
+	Manifests []v1.Descriptor `json:"manifests,omitempty"`
+}
+
+// ociReference names a module in an OCI registry by tag or by digest
+type ociReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     digest.Digest
+}
+
+// registryCredentials are the credentials that the docker config holds for a registry
+type registryCredentials struct {
+	Username string
+	Password string
+}
+
+// ociClient pulls a module from an OCI registry into the download cache
+type ociClient struct {
+	*downloader
+	ref   ociReference
+	creds *registryCredentials
+	// authorization is sent with each request once the registry has asked for it
+	authorization string
+}
+
+func isOCI(val string) bool {
+	return strings.HasPrefix(val, ociScheme)
+}
+
+// pullFromOCI pulls the module that ref names (or finds it in the cache), along with its .module.yml if it has one,
+// and returns the path of the module in the cache. Modules are cached under the digest of the manifest that ref
+// resolves to, so a module pulled by digest is only pulled once, and one pulled by tag once for each version.
+func pullFromOCI(ref string, config satOptions.DownloadConfig, logger *vlog.Logger) (string, error) {
+	r, err := parseOCIReference(ref)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parseOCIReference")
+	}
+
+	d, err := newDownloader(config, logger)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to newDownloader")
+	}
+
+	creds, err := dockerCredentials(r.Registry, logger)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to dockerCredentials")
+	}
+
+	c := &ociClient{downloader: d, ref: r, creds: creds}
+
+	return c.pull()
+}
+
+// parseOCIReference parses a reference of the form oci://registry/repository[:tag][@digest], whose tag defaults to
+// latest
+func parseOCIReference(ref string) (ociReference, error) {
+	rest := strings.TrimPrefix(ref, ociScheme)
+
+	slash := strings.Index(rest, "/")
+	if slash <= 0 || slash == len(rest)-1 {
+		return ociReference{}, fmt.Errorf("%s should name a registry and repository, as in %sregistry/repository:tag", ref, ociScheme)
+	}
+
+	r := ociReference{Registry: rest[:slash]}
+	repo := rest[slash+1:]
+
+	if at := strings.Index(repo, "@"); at >= 0 {
+		d, err := digest.Parse(repo[at+1:])
+		if err != nil {
+			return ociReference{}, errors.Wrapf(err, "failed to digest.Parse %s", repo[at+1:])
+		}
+
+		r.Digest = d
+		repo = repo[:at]
+	}
+
+	if colon := strings.LastIndex(repo, ":"); colon >= 0 {
+		r.Tag = repo[colon+1:]
+		repo = repo[:colon]
+	}
+
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+
+	// Docker Hub's official images live under library/
+	if r.Registry == "docker.io" && !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+
+	r.Repository = repo
+
+	return r, nil
+}
+
+// reference is the digest that the reference names, or its tag if it has no digest
+func (r ociReference) reference() string {
+	if r.Digest != "" {
+		return r.Digest.String()
+	}
+
+	return r.Tag
+}
+
+// name is the name that the module is served under, which is the last part of its repository
+func (r ociReference) name() string {
+	return path.Base(r.Repository)
+}
+
+// baseURL is the URL of the repository in the registry's API, which is served over plain HTTP only on localhost
+func (r ociReference) baseURL() string {
+	host := r.Registry
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+
+	scheme := "https"
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	if hostname == "localhost" || net.ParseIP(hostname).IsLoopback() {
+		scheme = "http"
+	}
+
+	return fmt.Sprintf("%s://%s/v2/%s", scheme, host, r.Repository)
+}
+
+// pull returns the path of the module in the cache, pulling it if the cache doesn't hold the version that the
+// reference names
+func (c *ociClient) pull() (string, error) {
+	if c.ref.Digest != "" {
+		if filename, ok := c.cachedPull(c.ref.Digest); ok {
+			c.log.Debug("using cached module", c.ref.Digest.String(), "for", c.ref.Repository)
+			return filename, nil
+		}
+	}
+
+	manifest, resolved, err := c.manifest(c.ref.reference())
+	if err != nil {
+		return "", errors.Wrap(err, "failed to fetch manifest")
+	}
+
+	if filename, ok := c.cachedPull(resolved); ok {
+		c.log.Debug("using cached module", resolved.String(), "for", c.ref.Repository)
+		return filename, nil
+	}
+
+	// an index is resolved to the module's manifest, though the pull is cached under the index's digest
+	if len(manifest.Manifests) > 0 {
+		desc, err := moduleManifest(manifest.Manifests)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to moduleManifest")
+		}
+
+		if manifest, _, err = c.manifest(desc.Digest.String()); err != nil {
+			return "", errors.Wrap(err, "failed to fetch manifest from index")
+		}
+	}
+
+	wasm, dotYaml, err := moduleLayers(manifest)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to moduleLayers")
+	}
+
+	dir := c.pullDir(resolved)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", errors.Wrap(err, "failed to MkdirAll")
+	}
+
+	filename := filepath.Join(dir, c.ref.name()+".wasm")
+
+	if err := c.blob(*wasm, filename); err != nil {
+		return "", errors.Wrap(err, "failed to pull module layer")
+	}
+
+	if dotYaml != nil {
+		if err := c.blob(*dotYaml, filepath.Join(dir, ".module.yml")); err != nil {
+			return "", errors.Wrap(err, "failed to pull .module.yml layer")
+		}
+	}
+
+	// the manifest is written last, since it marks the pull as complete
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to Marshal manifest")
+	}
+
+	if err := writeAtomically(filepath.Join(dir, "manifest.json"), bytes.NewReader(data)); err != nil {
+		return "", errors.Wrap(err, "failed to writeAtomically manifest")
+	}
+
+	c.log.Debug("pulled", c.ref.Repository, "at", resolved.String())
+
+	return filename, nil
+}
+
+// pullDir is where the pull of the manifest with digest is cached
+func (c *ociClient) pullDir(d digest.Digest) string {
+	return filepath.Join(c.cacheDir, "oci", d.Algorithm().String(), d.Encoded())
+}
+
+// cachedPull returns the path of the module that was pulled from the manifest with digest, if the cache holds all of
+// its layers intact
+func (c *ociClient) cachedPull(d digest.Digest) (string, bool) {
+	dir := c.pullDir(d)
+
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return "", false
+	}
+
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return "", false
+	}
+
+	wasm, dotYaml, err := moduleLayers(manifest)
+	if err != nil {
+		return "", false
+	}
+
+	filename := filepath.Join(dir, c.ref.name()+".wasm")
+
+	if !cachedBlob(*wasm, filename) || (dotYaml != nil && !cachedBlob(*dotYaml, filepath.Join(dir, ".module.yml"))) {
+		return "", false
+	}
+
+	return filename, true
+}
+
+// manifest fetches the manifest named by reference, returning it along with its digest. A manifest fetched by digest
+// must match it.
+func (c *ociClient) manifest(reference string) (*ociManifest, digest.Digest, error) {
+	var data []byte
+
+	err := c.fetch("/manifests/"+reference, manifestAccept, func(resp *http.Response) error {
+		var readErr error
+		data, readErr = io.ReadAll(resp.Body)
+
+		return readErr
+	})
+
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to fetch %s:%s", c.ref.Repository, reference)
+	}
+
+	resolved := digest.FromBytes(data)
+
+	if expected, err := digest.Parse(reference); err == nil {
+		if resolved = expected.Algorithm().FromBytes(data); resolved != expected {
+			return nil, "", errors.Wrapf(ErrDigestMismatch, "manifest has digest %s, expected %s", resolved, expected)
+		}
+	}
+
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, "", errors.Wrap(err, "failed to Unmarshal manifest")
+	}
+
+	return manifest, resolved, nil
+}
+
+// blob fetches the blob that desc describes into filename, failing if it doesn't match the descriptor's digest
+func (c *ociClient) blob(desc v1.Descriptor, filename string) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return errors.Wrap(err, "failed to Validate layer digest")
+	}
+
+	err := c.fetch("/blobs/"+desc.Digest.String(), "", func(resp *http.Response) error {
+		return storeBlob(resp.Body, desc.Digest, filename)
+	})
+
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch blob %s", desc.Digest)
+	}
+
+	return nil
+}
+
+// fetch requests a path in the repository, authenticating with the registry if it asks
+func (c *ociClient) fetch(path, accept string, store func(resp *http.Response) error) error {
+	header := http.Header{}
+
+	if accept != "" {
+		header.Set("Accept", accept)
+	}
+
+	if c.authorization != "" {
+		header.Set("Authorization", c.authorization)
+	}
+
+	_, err := c.get(c.ref.baseURL()+path, header, store)
+
+	statusErr := statusError{}
+	if c.authorization == "" && errors.As(err, &statusErr) && statusErr.code == http.StatusUnauthorized {
+		if err := c.authenticate(statusErr.challenge); err != nil {
+			return errors.Wrapf(err, "failed to authenticate with %s", c.ref.Registry)
+		}
+
+		return c.fetch(path, accept, store)
+	}
+
+	return err
+}
+
+// authenticate answers the registry's challenge, setting the authorization that is sent with each request
+func (c *ociClient) authenticate(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.creds == nil {
+			return fmt.Errorf("the registry requires credentials, and the docker config has none for %s", c.ref.Registry)
+		}
+
+		c.authorization = "Basic " + c.creds.basic()
+	case "bearer":
+		token, err := c.token(params)
+		if err != nil {
+			return errors.Wrap(err, "failed to fetch token")
+		}
+
+		c.authorization = "Bearer " + token
+	default:
+		return fmt.Errorf("the registry asked for unsupported authentication %q", challenge)
+	}
+
+	return nil
+}
+
+// token fetches a token to pull from the repository from the registry's token service, which is anonymous unless
+// the docker config has credentials for the registry
+func (c *ociClient) token(params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("the registry's token realm %q is not a URL", params["realm"])
+	}
+
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", c.ref.Repository)
+	}
+
+	query := realm.Query()
+	query.Set("scope", scope)
+
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+
+	realm.RawQuery = query.Encode()
+
+	header := http.Header{}
+	if c.creds != nil {
+		header.Set("Authorization", "Basic "+c.creds.basic())
+	}
+
+	resp := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+
+	_, err = c.get(realm.String(), header, func(r *http.Response) error {
+		return json.NewDecoder(r.Body).Decode(&resp)
+	})
+
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get token")
+	}
+
+	if resp.Token == "" {
+		resp.Token = resp.AccessToken
+	}
+
+	if resp.Token == "" {
+		return "", errors.New("the token service returned no token")
+	}
+
+	return resp.Token, nil
+}
+
+// moduleManifest picks the manifest of the module from an index, which is the one for a Wasm platform if there are
+// several
+func moduleManifest(manifests []v1.Descriptor) (*v1.Descriptor, error) {
+	if len(manifests) == 1 {
+		return &manifests[0], nil
+	}
+
+	for i, m := range manifests {
+		if m.Platform != nil && (m.Platform.Architecture == "wasm" || strings.HasPrefix(m.Platform.OS, "wasi")) {
+			return &manifests[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("the index lists %d manifests, and none is for a Wasm platform", len(manifests))
+}
+
+// moduleLayers finds the layer of a manifest that holds the module, and the one holding its .module.yml (which is
+// nil if it has none)
+func moduleLayers(manifest *ociManifest) (wasm, dotYaml *v1.Descriptor, err error) {
+	for i, layer := range manifest.Layers {
+		title := layer.Annotations[v1.AnnotationTitle]
+
+		switch {
+		case layer.MediaType == mediaTypeModuleDotYaml || title == ".module.yml":
+			dotYaml = &manifest.Layers[i]
+		case layer.MediaType == mediaTypeWasmLayer, layer.MediaType == mediaTypeModuleWasmLayer,
+			layer.MediaType == mediaTypeWasm, strings.HasSuffix(title, ".wasm"):
+			if wasm != nil {
+				return nil, nil, errors.New("the manifest has more than one Wasm layer")
+			}
+
+			wasm = &manifest.Layers[i]
+		}
+	}
+
+	if wasm == nil {
+		return nil, nil, fmt.Errorf("the manifest has no Wasm layer among its %d layers", len(manifest.Layers))
+	}
+
+	return wasm, dotYaml, nil
+}
+
+// storeBlob writes a blob to filename, which is left untouched if the blob doesn't match its digest
+func storeBlob(body io.Reader, d digest.Digest, filename string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to CreateTemp")
+	}
+
+	defer os.Remove(tmp.Name())
+
+	verifier := d.Verifier()
+
+	_, err = io.Copy(io.MultiWriter(tmp, verifier), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to Copy blob")
+	}
+
+	if !verifier.Verified() {
+		return errors.Wrapf(ErrDigestMismatch, "blob doesn't match its digest %s", d)
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return errors.Wrap(err, "failed to Rename")
+	}
+
+	return nil
+}
+
+// cachedBlob returns true if filename holds the blob that desc describes
+func cachedBlob(desc v1.Descriptor, filename string) bool {
+	if desc.Digest.Validate() != nil {
+		return false
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+
+	defer file.Close()
+
+	verifier := desc.Digest.Verifier()
+	if _, err := io.Copy(verifier, file); err != nil {
+		return false
+	}
+
+	return verifier.Verified()
+}
+
+// parseChallenge splits a WWW-Authenticate challenge into its scheme and parameters, as in
+// Bearer realm="https://auth.example.com/token",service="registry.example.com"
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimLeft(rest, ", ") {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+
+			continue
+		}
+
+		value, rest, _ = strings.Cut(value, ",")
+		params[key] = strings.TrimSpace(value)
+	}
+
+	return scheme, params
+}
+
+// basic encodes the credentials for basic authentication
+func (r *registryCredentials) basic() string {
+	return base64.StdEncoding.EncodeToString([]byte(r.Username + ":" + r.Password))
+}
+
+// dockerConfig is the part of the docker config file that holds the credentials for each registry
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// dockerCredentials returns the credentials that the docker config file ($DOCKER_CONFIG/config.json, or
+// ~/.docker/config.json) holds for a registry, or nil if it has none. Credentials kept by a credential helper can't be
+// used, and need to be in the file.
+func dockerCredentials(registry string, logger *vlog.Logger) (*registryCredentials, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+
+		dir = filepath.Join(home, ".docker")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to ReadFile")
+	}
+
+	config := dockerConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrap(err, "failed to Unmarshal docker config")
+	}
+
+	// Docker Hub's credentials are kept under the URL of its old index
+	if registry == "docker.io" {
+		registry = "index.docker.io"
+	}
+
+	for key, auth := range config.Auths {
+		host := key
+		if u, err := url.Parse(key); err == nil && u.Host != "" {
+			host = u.Host
+		}
+
+		if host != registry {
+			continue
+		}
+
+		if auth.Auth == "" {
+			return &registryCredentials{Username: auth.Username, Password: auth.Password}, nil
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode the docker config's auth for %s", key)
+		}
+
+		username, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return nil, fmt.Errorf("the docker config's auth for %s is not username:password", key)
+		}
+
+		return &registryCredentials{Username: username, Password: password}, nil
+	}
+
+	if config.CredHelpers[registry] != "" || config.CredsStore != "" {
+		logger.Warn("the docker config keeps credentials in a credential helper, which sat can't use, so", registry, "will be pulled from anonymously")
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,261 @@
+package sat
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/vektor/vlog"
+
+	satOptions "github.com/suborbital/sat/sat/options"
+)
+
+// testRegistry is a stand-in for an OCI registry that serves a single repository, and only to clients holding a token
+// from its token service
+type testRegistry struct {
+	*httptest.Server
+	repository string
+	manifests  map[string][]byte
+	blobs      map[digest.Digest][]byte
+	requests   atomic.Int64
+}
+
+func newTestRegistry(t *testing.T, repository, username, password string) *testRegistry {
+	r := &testRegistry{
+		repository: repository,
+		manifests:  map[string][]byte{},
+		blobs:      map[digest.Digest][]byte{},
+	}
+
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.requests.Add(1)
+
+		if req.URL.Path == "/token" {
+			if user, pass, ok := req.BasicAuth(); !ok || user != username || pass != password {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]string{"token": "letmein"})
+
+			return
+		}
+
+		if req.Header.Get("Authorization") != "Bearer letmein" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:%s:pull"`, r.URL, repository))
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		prefix := "/v2/" + repository
+
+		switch {
+		case strings.HasPrefix(req.URL.Path, prefix+"/manifests/"):
+			manifest, ok := r.manifests[strings.TrimPrefix(req.URL.Path, prefix+"/manifests/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.Header().Set("Content-Type", v1.MediaTypeImageManifest)
+			w.Write(manifest)
+		case strings.HasPrefix(req.URL.Path, prefix+"/blobs/"):
+			blob, ok := r.blobs[digest.Digest(strings.TrimPrefix(req.URL.Path, prefix+"/blobs/"))]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.Write(blob)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(r.Server.Close)
+
+	return r
+}
+
+// push adds a manifest with the given layers to the registry under tag, and returns its digest
+func (r *testRegistry) push(t *testing.T, tag string, layers map[string][]byte) digest.Digest {
+	manifest := v1.Manifest{MediaType: v1.MediaTypeImageManifest}
+	manifest.SchemaVersion = 2
+
+	for mediaType, blob := range layers {
+		d := digest.FromBytes(blob)
+		r.blobs[d] = blob
+
+		manifest.Layers = append(manifest.Layers, v1.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(blob))})
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Marshal"))
+	}
+
+	d := digest.FromBytes(data)
+	r.manifests[tag] = data
+	r.manifests[d.String()] = data
+
+	return d
+}
+
+// ref returns a reference to the module in the registry
+func (r *testRegistry) ref(reference string) string {
+	host := strings.TrimPrefix(r.URL, "http://")
+
+	if strings.HasPrefix(reference, "sha256:") {
+		return fmt.Sprintf("%s%s/%s@%s", ociScheme, host, r.repository, reference)
+	}
+
+	return fmt.Sprintf("%s%s/%s:%s", ociScheme, host, r.repository, reference)
+}
+
+func TestPullFromOCI(t *testing.T) {
+	helloEcho, err := os.ReadFile("../examples/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+	}
+
+	returnErr, err := os.ReadFile("../examples/return-err/return-err.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+	}
+
+	registry := newTestRegistry(t, "acmeco/hello-echo", "joe", "secret")
+
+	v1Digest := registry.push(t, "v1", map[string][]byte{
+		mediaTypeWasmLayer:     helloEcho,
+		mediaTypeModuleDotYaml: []byte("name: hello-echo\nnamespace: greetings\nscheduler:\n  maxWorkers: 2\n"),
+	})
+
+	registry.push(t, "v2", map[string][]byte{mediaTypeWasm: returnErr})
+
+	// credentials for the registry come from the docker config
+	dockerDir := t.TempDir()
+	dockerConfig := fmt.Sprintf(`{"auths": {"%s": {"auth": "%s"}}}`, strings.TrimPrefix(registry.URL, "http://"), base64.StdEncoding.EncodeToString([]byte("joe:secret")))
+
+	if err := os.WriteFile(filepath.Join(dockerDir, "config.json"), []byte(dockerConfig), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile"))
+	}
+
+	t.Setenv("DOCKER_CONFIG", dockerDir)
+	t.Setenv("SAT_DOWNLOAD_CACHE_DIR", t.TempDir())
+
+	config, err := ConfigFromRunnableArg(registry.ref("v1"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ConfigFromRunnableArg"))
+	}
+
+	// the module is named after its repository, and configured by its .module.yml
+	if config.JobType != "hello-echo" || config.Module == nil || config.Module.Namespace != "greetings" || config.SchedulerConfig.MaxWorkers != 2 {
+		t.Errorf("expected hello-echo to be configured by its .module.yml, got %s %+v %+v", config.JobType, config.Module, config.SchedulerConfig)
+	}
+
+	if data, err := os.ReadFile(config.RunnableArg); err != nil || string(data) != string(helloEcho) {
+		t.Errorf("expected %s to hold the module, got %v", config.RunnableArg, err)
+	}
+
+	downloadConfig := satOptions.DownloadConfig{Timeout: time.Second, CacheDir: os.Getenv("SAT_DOWNLOAD_CACHE_DIR")}
+
+	// a module pulled by digest is only ever pulled once
+	before := registry.requests.Load()
+
+	filename, err := pullFromOCI(registry.ref(v1Digest.String()), downloadConfig, vlog.Default())
+	if err != nil || filename != config.RunnableArg || registry.requests.Load() != before {
+		t.Errorf("expected the cached module to be used without a request, got %s (%v) after %d requests", filename, err, registry.requests.Load()-before)
+	}
+
+	v2, err := pullFromOCI(registry.ref("v2"), downloadConfig, vlog.Default())
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to pullFromOCI"))
+	}
+
+	if data, err := os.ReadFile(v2); err != nil || string(data) != string(returnErr) || v2 == filename {
+		t.Errorf("expected the v2 tag to be pulled separately, got %s (%v)", v2, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(v2), ".module.yml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no .module.yml for a manifest without one, got %v", err)
+	}
+
+	// a blob that doesn't match its digest is refused
+	tampered := registry.push(t, "tampered", map[string][]byte{mediaTypeWasm: []byte("a module")})
+	for d := range registry.blobs {
+		if string(registry.blobs[d]) == "a module" {
+			registry.blobs[d] = []byte("something else")
+		}
+	}
+
+	if _, err := pullFromOCI(registry.ref(tampered.String()), downloadConfig, vlog.Default()); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("expected a digest mismatch, got %v", err)
+	}
+
+	if _, err := pullFromOCI(registry.ref("missing"), downloadConfig, vlog.Default()); err == nil {
+		t.Error("expected an error for a missing tag")
+	}
+
+	// without credentials, the token service turns sat away
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	if _, err := pullFromOCI(registry.ref("v2"), satOptions.DownloadConfig{CacheDir: t.TempDir()}, vlog.Default()); err == nil {
+		t.Error("expected an error without credentials")
+	}
+}
+
+func TestParseOCIReference(t *testing.T) {
+	d := digest.FromString("module")
+
+	tests := []struct {
+		ref      string
+		expected ociReference
+		baseURL  string
+	}{
+		{"oci://ghcr.io/acmeco/hello:v1", ociReference{Registry: "ghcr.io", Repository: "acmeco/hello", Tag: "v1"}, "https://ghcr.io/v2/acmeco/hello"},
+		{"oci://ghcr.io/acmeco/hello", ociReference{Registry: "ghcr.io", Repository: "acmeco/hello", Tag: "latest"}, "https://ghcr.io/v2/acmeco/hello"},
+		{"oci://localhost:5000/hello@" + d.String(), ociReference{Registry: "localhost:5000", Repository: "hello", Digest: d}, "http://localhost:5000/v2/hello"},
+		{"oci://docker.io/hello:v1@" + d.String(), ociReference{Registry: "docker.io", Repository: "library/hello", Tag: "v1", Digest: d}, "https://registry-1.docker.io/v2/library/hello"},
+	}
+
+	for _, test := range tests {
+		r, err := parseOCIReference(test.ref)
+		if err != nil {
+			t.Errorf("%s: %s", test.ref, err)
+			continue
+		}
+
+		if r != test.expected || r.baseURL() != test.baseURL {
+			t.Errorf("%s: expected %+v at %s, got %+v at %s", test.ref, test.expected, test.baseURL, r, r.baseURL())
+		}
+	}
+
+	for _, ref := range []string{"oci://ghcr.io", "oci://ghcr.io/", "oci://ghcr.io/hello@sha256:nope"} {
+		if _, err := parseOCIReference(ref); err == nil {
+			t.Errorf("%s: expected an error", ref)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull,push"`)
+
+	expected := map[string]string{"realm": "https://auth.example.com/token", "service": "registry.example.com", "scope": "repository:a/b:pull,push"}
+
+	if scheme != "Bearer" || fmt.Sprint(params) != fmt.Sprint(expected) {
+		t.Errorf("expected Bearer %v, got %s %v", expected, scheme, params)
+	}
+}
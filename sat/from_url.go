@@ -1,6 +1,10 @@
 package sat
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -8,51 +12,423 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/suborbital/vektor/vlog"
+
+	satOptions "github.com/suborbital/sat/sat/options"
+	"github.com/suborbital/sat/sat/signature"
 )
 
-func downloadFromURL(URL string) (string, error) {
+// digestFragment prefixes the URL fragment that pins the digest of the module at the URL, as in
+// https://example.com/module.wasm#sha256=<hex>
+const digestFragment = "sha256="
+
+// ErrDigestMismatch is returned when a downloaded module doesn't have the digest that its URL pins
+var ErrDigestMismatch = errors.New("module doesn't match the digest pinned by its URL")
+
+// statusError is returned when the server responds to a download with an unexpected status
+type statusError struct {
+	code int
+	// challenge is the authentication that the server asks for when the status is 401
+	challenge string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("failed to download with status code: %d", e.code)
+}
+
+// cachedURL records the module last downloaded from a URL, so that it can be revalidated with its ETag
+type cachedURL struct {
+	URL    string `json:"url"`
+	ETag   string `json:"etag,omitempty"`
+	Digest string `json:"digest"`
+}
+
+// downloader downloads modules into a cache where each is stored under its digest, so that modules with the same
+// filename never replace each other and a module is only downloaded again when it changes
+type downloader struct {
+	client   *http.Client
+	config   satOptions.DownloadConfig
+	cacheDir string
+	log      *vlog.Logger
+}
+
+// downloadFromURL downloads the module at URL (or finds it in the cache), along with its detached signature if the
+// server has one at the same URL with the signature suffix, and returns the path of the module in the cache
+func downloadFromURL(URL string, config satOptions.DownloadConfig, logger *vlog.Logger) (string, error) {
+	d, err := newDownloader(config, logger)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to newDownloader")
+	}
+
+	return d.module(URL)
+}
+
+// newDownloader creates a downloader, and the cache that it downloads into
+func newDownloader(config satOptions.DownloadConfig, logger *vlog.Logger) (*downloader, error) {
+	cacheDir := config.CacheDir
+	if cacheDir == "" {
+		cacheDir = defaultDownloadCacheDir()
+	}
+
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to MkdirAll")
+	}
+
+	d := &downloader{
+		client:   &http.Client{Timeout: config.Timeout},
+		config:   config,
+		cacheDir: cacheDir,
+		log:      logger,
+	}
+
+	return d, nil
+}
+
+// defaultDownloadCacheDir is the cache used when SAT_DOWNLOAD_CACHE_DIR isn't set, which is private to the user where
+// possible
+func defaultDownloadCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "suborbital", "sat", "modules")
+	}
+
+	return filepath.Join(os.TempDir(), "suborbital", "modules")
+}
+
+// module returns the path of the module at URL in the cache, downloading it if the cache doesn't hold its current
+// version
+func (d *downloader) module(URL string) (string, error) {
 	urlObj, err := url.Parse(URL)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to url.Parse")
 	}
 
+	pinned, err := pinnedDigest(urlObj)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to pinnedDigest")
+	}
+
+	if pinned == "" && d.config.RequireDigest {
+		return "", fmt.Errorf("SAT_DOWNLOAD_REQUIRE_DIGEST is set, but %s doesn't pin its module with #%s<hex>", URL, digestFragment)
+	}
+
+	// the fragment is never sent, and the cache records the URL without it
+	urlObj.Fragment = ""
+	URL = urlObj.String()
 	name := filepath.Base(urlObj.Path)
 
+	filename, err := d.fetchModule(URL, name, pinned)
+	if err != nil {
+		return "", err
+	}
+
+	sigURL := *urlObj
+	sigURL.Path += signature.DetachedSuffix
+
+	d.fetchSignature(sigURL.String(), filename+signature.DetachedSuffix)
+
+	return filename, nil
+}
+
+// fetchModule returns the path of the module at URL in the cache, downloading it unless it is pinned to a digest that
+// is already cached, or the server reports that the cached version is current
+func (d *downloader) fetchModule(URL, name, pinned string) (string, error) {
+	if pinned != "" && d.cached(pinned, name) {
+		d.log.Debug("using cached module", pinned, "for", URL)
+		return d.modulePath(pinned, name), nil
+	}
+
+	record := d.readRecord(URL)
+
+	header := http.Header{}
+	if record != nil && record.ETag != "" && d.cached(record.Digest, name) {
+		header.Set("If-None-Match", record.ETag)
+	}
+
+	digest, etag := "", ""
+
+	modified, err := d.get(URL, header, func(resp *http.Response) error {
+		var storeErr error
+		digest, storeErr = d.storeModule(resp.Body, name, pinned)
+		etag = resp.Header.Get("ETag")
+
+		return storeErr
+	})
+
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to download %s", URL)
+	}
+
+	if !modified {
+		d.log.Debug("cached module", record.Digest, "is current for", URL)
+		digest, etag = record.Digest, record.ETag
+	}
+
+	if pinned != "" && digest != pinned {
+		return "", errors.Wrapf(ErrDigestMismatch, "%s has digest sha256:%s, expected sha256:%s", URL, digest, pinned)
+	}
+
+	if err := d.writeRecord(cachedURL{URL: URL, ETag: etag, Digest: digest}); err != nil {
+		d.log.Warn("failed to record the download of", URL, "in the cache, it will be downloaded again:", err.Error())
+	}
+
+	return d.modulePath(digest, name), nil
+}
+
+// fetchSignature downloads the detached signature at URL to filename. A signature that the server no longer has is
+// removed, while one that can't be downloaded is kept, since it signs the same module (the filename being particular
+// to the module's digest).
+func (d *downloader) fetchSignature(URL, filename string) {
+	_, err := d.get(URL, nil, func(resp *http.Response) error {
+		return writeAtomically(filename, resp.Body)
+	})
+
+	if err == nil {
+		return
+	}
+
+	statusErr := statusError{}
+	if !errors.As(err, &statusErr) || retryable(err) {
+		d.log.Warn("failed to download the signature of the module, using the one already downloaded (if any):", err.Error())
+		return
+	}
+
+	if err := os.Remove(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		d.log.Warn("failed to Remove stale signature:", err.Error())
+	}
+}
+
+// get requests URL with header, passing a successful response to store, and returns false if the server reports that
+// the version tagged by the header's If-None-Match is current. Requests that fail in a way that might not happen again
+// are retried with a backoff.
+func (d *downloader) get(URL string, header http.Header, store func(resp *http.Response) error) (bool, error) {
+	backoff := d.config.RetryBackoff
+
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		var modified bool
+		if modified, err = d.getOnce(URL, header, store); err == nil {
+			return modified, nil
+		}
+
+		if !retryable(err) || attempt >= d.config.Retries {
+			break
+		}
+
+		d.log.Warn(fmt.Sprintf("failed to download %s (attempt %d of %d), retrying in %s: %s", URL, attempt+1, d.config.Retries+1, backoff, err.Error()))
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	return false, err
+}
+
+// getOnce makes a single attempt at get
+func (d *downloader) getOnce(URL string, header http.Header, store func(resp *http.Response) error) (bool, error) {
 	req, err := http.NewRequest(http.MethodGet, URL, nil)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to NewRequest")
+		return false, errors.Wrap(err, "failed to NewRequest")
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := d.client.Do(req)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to Do request")
+		return false, errors.Wrap(err, "failed to Do request")
 	}
 
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("failed to download with status code: %d", resp.StatusCode)
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && req.Header.Get("If-None-Match") != "" {
+		return false, nil
 	}
 
-	tmp := os.TempDir()
-	dir := filepath.Join(tmp, "suborbital", "blocks")
-	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+	if resp.StatusCode != http.StatusOK {
+		return false, statusError{code: resp.StatusCode, challenge: resp.Header.Get("WWW-Authenticate")}
+	}
+
+	if err := store(resp); err != nil {
+		return false, errors.Wrap(err, "failed to store response")
+	}
+
+	return true, nil
+}
+
+// retryable returns true if a download that failed with err might succeed if it is tried again
+func retryable(err error) bool {
+	if errors.Is(err, ErrDigestMismatch) {
+		return false
+	}
+
+	statusErr := statusError{}
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= http.StatusInternalServerError || statusErr.code == http.StatusTooManyRequests
+	}
+
+	return true
+}
+
+// storeModule writes a downloaded module into the cache under its digest, which it returns. A module that doesn't
+// match the pinned digest (if any) is discarded.
+func (d *downloader) storeModule(body io.Reader, name, pinned string) (string, error) {
+	tmp, err := os.CreateTemp(d.cacheDir, name+".*.tmp")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to CreateTemp")
+	}
+
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+
+	_, err = io.Copy(io.MultiWriter(tmp, hash), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return "", errors.Wrap(err, "failed to Copy module")
+	}
+
+	digest := hex.EncodeToString(hash.Sum(nil))
+
+	if pinned != "" && digest != pinned {
+		return "", errors.Wrapf(ErrDigestMismatch, "downloaded module has digest sha256:%s, expected sha256:%s", digest, pinned)
+	}
+
+	if err := os.MkdirAll(filepath.Join(d.cacheDir, digest), 0700); err != nil {
 		return "", errors.Wrap(err, "failed to MkdirAll")
 	}
 
-	filename := filepath.Join(dir, name)
+	if err := os.Rename(tmp.Name(), d.modulePath(digest, name)); err != nil {
+		return "", errors.Wrap(err, "failed to Rename")
+	}
+
+	return digest, nil
+}
+
+// modulePath is where a module is stored in the cache, which keeps its filename so that it is named the same
+func (d *downloader) modulePath(digest, name string) string {
+	return filepath.Join(d.cacheDir, digest, name)
+}
+
+// cached returns true if the cache holds the module with digest, removing a cached module that has been corrupted
+func (d *downloader) cached(digest, name string) bool {
+	filename := d.modulePath(digest, name)
 
-	file, err := os.Create(filename)
+	file, err := os.Open(filename)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to Open file")
+		return false
 	}
 
-	defer resp.Body.Close()
-	if _, err := io.Copy(file, resp.Body); err != nil {
-		return "", errors.Wrap(err, "failed to Copy file")
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return false
 	}
 
-	return filename, nil
+	if hex.EncodeToString(hash.Sum(nil)) != digest {
+		d.log.Warn("removing corrupted module", filename, "from the cache")
+		os.Remove(filename)
+
+		return false
+	}
+
+	return true
+}
+
+// recordPath is where the record of the module downloaded from URL is kept
+func (d *downloader) recordPath(URL string) string {
+	sum := sha256.Sum256([]byte(URL))
+
+	return filepath.Join(d.cacheDir, "urls", hex.EncodeToString(sum[:])+".json")
+}
+
+// readRecord returns the record of the module last downloaded from URL, or nil if there is none
+func (d *downloader) readRecord(URL string) *cachedURL {
+	data, err := os.ReadFile(d.recordPath(URL))
+	if err != nil {
+		return nil
+	}
+
+	record := &cachedURL{}
+	if err := json.Unmarshal(data, record); err != nil || record.URL != URL {
+		return nil
+	}
+
+	return record
+}
+
+// writeRecord records the module downloaded from a URL
+func (d *downloader) writeRecord(record cachedURL) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to Marshal")
+	}
+
+	filename := d.recordPath(record.URL)
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return errors.Wrap(err, "failed to MkdirAll")
+	}
+
+	if err := writeAtomically(filename, bytes.NewReader(data)); err != nil {
+		return errors.Wrap(err, "failed to writeAtomically")
+	}
+
+	return nil
+}
+
+// writeAtomically writes a file by renaming a temporary file over it, so that it is never seen half-written
+func writeAtomically(filename string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to CreateTemp")
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to Copy")
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return errors.Wrap(err, "failed to Rename")
+	}
+
+	return nil
+}
+
+// pinnedDigest returns the hex-encoded sha256 digest that a URL pins its module to, which is empty if it doesn't
+func pinnedDigest(URL *url.URL) (string, error) {
+	if URL.Fragment == "" {
+		return "", nil
+	}
+
+	digest := strings.TrimPrefix(URL.Fragment, digestFragment)
+	if digest == URL.Fragment {
+		return "", fmt.Errorf("URL fragment %q should pin the module's digest as %s<hex>", URL.Fragment, digestFragment)
+	}
+
+	digest = strings.ToLower(digest)
+
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("pinned digest %q is not a hex-encoded sha256 digest", digest)
+	}
+
+	return digest, nil
 }
 
 func isURL(val string) bool {
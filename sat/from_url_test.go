@@ -0,0 +1,171 @@
+package sat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/vektor/vlog"
+
+	satOptions "github.com/suborbital/sat/sat/options"
+	"github.com/suborbital/sat/sat/signature"
+)
+
+func TestDownloadFromURL(t *testing.T) {
+	modules := map[string][]byte{}
+	for path, filename := range map[string]string{
+		"/a/echo.wasm": "../examples/hello-echo/hello-echo.wasm",
+		"/b/echo.wasm": "../examples/return-err/return-err.wasm",
+	} {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+		}
+
+		modules[path] = data
+	}
+
+	var requests, downloads, unavailable atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		if unavailable.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.URL.Path == "/a/echo.wasm"+signature.DetachedSuffix {
+			w.Write([]byte("signature"))
+			return
+		}
+
+		module, ok := modules[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		digest := sha256.Sum256(module)
+		etag := `"` + hex.EncodeToString(digest[:]) + `"`
+
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		downloads.Add(1)
+
+		w.Header().Set("ETag", etag)
+		w.Write(module)
+	}))
+	defer server.Close()
+
+	config := satOptions.DownloadConfig{
+		Timeout:      time.Second,
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+		CacheDir:     t.TempDir(),
+	}
+
+	download := func(path string) (string, error) {
+		t.Helper()
+
+		unavailable.Store(0)
+
+		return downloadFromURL(server.URL+path, config, vlog.Default())
+	}
+
+	a, err := download("/a/echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to downloadFromURL"))
+	}
+
+	b, err := download("/b/echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to downloadFromURL"))
+	}
+
+	// modules with the same filename are kept apart, and each keeps its filename
+	if a == b || filepath.Base(a) != "echo.wasm" || filepath.Base(b) != "echo.wasm" {
+		t.Errorf("expected two distinct modules named echo.wasm, got %s and %s", a, b)
+	}
+
+	for filename, path := range map[string]string{a: "/a/echo.wasm", b: "/b/echo.wasm"} {
+		if data, err := os.ReadFile(filename); err != nil || string(data) != string(modules[path]) {
+			t.Errorf("expected %s to hold the module downloaded from %s", filename, path)
+		}
+	}
+
+	if sig, err := os.ReadFile(a + signature.DetachedSuffix); err != nil || string(sig) != "signature" {
+		t.Errorf("expected the module's detached signature to be downloaded next to it, got %q", string(sig))
+	}
+
+	if _, err := os.Stat(b + signature.DetachedSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no signature for a module without one, got %v", err)
+	}
+
+	// the cached module is revalidated rather than downloaded again
+	if again, err := download("/a/echo.wasm"); err != nil || again != a || downloads.Load() != 2 {
+		t.Errorf("expected the cached module to be used, got %s (%v) after %d downloads", again, err, downloads.Load())
+	}
+
+	digest := sha256.Sum256(modules["/a/echo.wasm"])
+	pinned := "/a/echo.wasm#sha256=" + hex.EncodeToString(digest[:])
+
+	// a pinned module that's cached needs no request (besides its signature)
+	before := requests.Load()
+
+	if again, err := download(pinned); err != nil || again != a || requests.Load() != before+1 {
+		t.Errorf("expected the pinned module to come from the cache, got %s (%v) after %d requests", again, err, requests.Load()-before)
+	}
+
+	// the cache would hold a module with the pinned digest, so the mismatch is found in a fresh one
+	fresh := satOptions.DownloadConfig{CacheDir: t.TempDir()}
+
+	if _, err := downloadFromURL(server.URL+"/b/echo.wasm#sha256="+hex.EncodeToString(digest[:]), fresh, vlog.Default()); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("expected a digest mismatch, got %v", err)
+	}
+
+	if _, err := download("/a/echo.wasm#md5=abc"); err == nil {
+		t.Error("expected an error for a malformed digest")
+	}
+
+	// failures are retried until the retries run out, while a missing module is not retried
+	unavailable.Store(2)
+	before = downloads.Load()
+
+	if _, err := downloadFromURL(server.URL+"/b/echo.wasm", satOptions.DownloadConfig{Retries: 2, CacheDir: t.TempDir()}, vlog.Default()); err != nil || downloads.Load() != before+1 {
+		t.Errorf("expected the download to succeed once the server was available, got %v", err)
+	}
+
+	unavailable.Store(3)
+
+	if _, err := downloadFromURL(server.URL+"/b/echo.wasm", satOptions.DownloadConfig{Retries: 2, CacheDir: t.TempDir()}, vlog.Default()); err == nil {
+		t.Error("expected the download to fail once the retries ran out")
+	}
+
+	before = requests.Load()
+
+	if _, err := download("/missing.wasm"); err == nil || requests.Load() != before+1 {
+		t.Errorf("expected a missing module to fail without retrying, got %v after %d requests", err, requests.Load()-before)
+	}
+
+	config.RequireDigest = true
+
+	if _, err := download("/a/echo.wasm"); err == nil {
+		t.Error("expected a URL without a digest to be refused when one is required")
+	}
+
+	if _, err := download(pinned); err != nil {
+		t.Error(errors.Wrap(err, "failed to downloadFromURL a pinned module when a digest is required"))
+	}
+}
@@ -11,12 +11,34 @@ import (
 	"github.com/suborbital/e2core/scheduler"
 	"github.com/suborbital/vektor/vk"
 
+	wruntime "github.com/suborbital/sat/engine/runtime"
 	"github.com/suborbital/sat/sat/executor"
 	"github.com/suborbital/sat/sat/metrics"
 )
 
+// trapResponse is the error response for a module that trapped, when traps are being debugged
+type trapResponse struct {
+	Status  int            `json:"status"`
+	Message string         `json:"message"`
+	Trap    *wruntime.Trap `json:"trap"`
+}
+
 func (s *Sat) handler(exec *executor.Executor) vk.HandlerFunc {
-	return func(r *http.Request, ctx *vk.Ctx) (interface{}, error) {
+	return func(r *http.Request, ctx *vk.Ctx) (_ interface{}, err error) {
+		jobName, params, ok := s.router.match(r.Method, r.URL.Path)
+		if !ok {
+			return nil, vk.E(http.StatusNotFound, "no module is routed to "+r.URL.Path)
+		}
+
+		stats := s.stats[jobName]
+		stats.requests.Add(1)
+
+		defer func() {
+			if err != nil {
+				stats.errors.Add(1)
+			}
+		}()
+
 		spanCtx, span := s.tracer.Start(ctx.Context, "vkhandler", trace.WithAttributes(
 			attribute.String("request_id", ctx.RequestID()),
 		))
@@ -32,28 +54,61 @@ func (s *Sat) handler(exec *executor.Executor) vk.HandlerFunc {
 			return nil, vk.E(http.StatusInternalServerError, "unknown error")
 		}
 
+		// the params are those of the module's route, rather than of the catch-all that sat registers every method on
+		req.Params = params
+
+		if !s.admit(jobName) {
+			s.log.Debug("fn", jobName, "turned away a request, the queue is full")
+			return nil, vk.E(http.StatusServiceUnavailable, "too many requests are queued")
+		}
+
+		defer s.release(jobName)
+
 		t := metrics.NewTimer()
 
 		var runErr scheduler.RunErr
+		var limitErr wruntime.LimitError
 
-		result, err := exec.Do(s.jobName, req, ctx, nil)
+		result, err := exec.Do(jobName, req, ctx, nil)
 		if err != nil {
+			if errors.As(err, &limitErr) {
+				s.metrics.LimitHits.Add(spanCtx, 1, attribute.String("limit", limitErr.Limit))
+			}
+
 			if errors.As(err, &runErr) {
 				// runErr would be an actual error returned from a function
 				// should find a better way to determine if a RunErr is "non-nil"
 				if runErr.Code != 0 || runErr.Message != "" {
-					s.log.Debug("fn", s.jobName, "returned an error")
+					s.log.Debug("fn", jobName, "returned an error")
 					return nil, vk.E(runErr.Code, runErr.Message)
 				}
 			}
 
+			var trap *wruntime.Trap
+			if errors.As(err, &trap) {
+				// the runner has already logged the trap and its backtrace alongside the request ID
+				s.log.Debug("fn", jobName, "trapped")
+
+				if s.config.moduleConfig(jobName).RuntimeConfig.DebugTraps {
+					stats.errors.Add(1)
+
+					return vk.R(http.StatusInternalServerError, trapResponse{
+						Status:  http.StatusInternalServerError,
+						Message: trap.Error(),
+						Trap:    trap,
+					}), nil
+				}
+
+				return nil, vk.E(http.StatusInternalServerError, "unknown error")
+			}
+
 			s.log.Error(errors.Wrap(err, "failed to exec.Do"))
 			return nil, vk.E(http.StatusInternalServerError, "unknown error")
 		}
 		s.metrics.FunctionTime.Record(spanCtx, t.Observe(), attribute.String("id", req.ID))
 
 		if result == nil {
-			s.log.Debug("fn", s.jobName, "returned a nil result")
+			s.log.Debug("fn", jobName, "returned a nil result")
 			return nil, nil
 		}
 
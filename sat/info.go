@@ -0,0 +1,47 @@
+package sat
+
+import (
+	"net/http"
+
+	"github.com/suborbital/sat/sat/signature"
+)
+
+// InfoPath describes sat and the modules that it serves, including the outcome of verifying each module's signature
+const InfoPath = "/meta/info"
+
+// InfoResponse is the body returned from the info endpoint
+type InfoResponse struct {
+	SatVersion string `json:"satVersion"`
+	Runtime    string `json:"runtime"`
+	// TrustedKeys are the keys that modules must be signed with, and is empty when modules needn't be signed
+	TrustedKeys []string              `json:"trustedKeys"`
+	Modules     map[string]ModuleInfo `json:"modules"`
+}
+
+// ModuleInfo describes one of the modules that sat serves
+type ModuleInfo struct {
+	// Signature is the signature of the version being served, as verified when it was loaded
+	Signature signature.Result `json:"signature"`
+}
+
+// serveInfo responds with the description of sat and its modules
+func (s *Sat) serveInfo(w http.ResponseWriter, r *http.Request) {
+	resp := InfoResponse{
+		SatVersion:  SatDotVersion,
+		Runtime:     s.config.Runtime,
+		TrustedKeys: s.config.TrustedKeys.IDs(),
+		Modules:     map[string]ModuleInfo{},
+	}
+
+	for _, name := range s.modules {
+		info := ModuleInfo{}
+
+		if result := s.stats[name].signature.Load(); result != nil {
+			info.Signature = *result
+		}
+
+		resp.Modules[name] = info
+	}
+
+	s.writeMeta(w, r, http.StatusOK, resp)
+}
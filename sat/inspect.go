@@ -0,0 +1,349 @@
+package sat
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/suborbital/appspec/tenant"
+
+	"github.com/suborbital/sat/api"
+	wruntime "github.com/suborbital/sat/engine/runtime"
+	"github.com/suborbital/sat/engine/wasmbinary"
+	"github.com/suborbital/sat/sat/signature"
+)
+
+// pageSize is the size of a page of Wasm memory
+const pageSize = 64 * 1024
+
+// the capabilities that imports are grouped into besides those of the host API
+const (
+	capabilityWASI    = "wasi"
+	capabilityUnknown = "unknown"
+)
+
+// languageUnknown is reported when a module carries no trace of the toolchain that built it
+const languageUnknown = "unknown"
+
+// languageMarkers are strings that a language's runtime or SDK leaves in the modules it builds
+var languageMarkers = []struct {
+	marker   string
+	language string
+}{
+	{marker: "tinygo", language: "tinygo"},
+	{marker: "GRAIN$", language: "grain"},
+	{marker: "_grainEnv", language: "grain"},
+	{marker: "~lib/", language: "assemblyscript"},
+}
+
+// Inspection describes a module without running it
+type Inspection struct {
+	Module   string              `json:"module"`
+	Digest   string              `json:"digest"`
+	Size     int                 `json:"size"`
+	Language string              `json:"language"`
+	ABI      wruntime.ABIFlavour `json:"abi"`
+	// ABIVersion is the version of the host API that the module was built against
+	ABIVersion wruntime.ABIVersion `json:"abiVersion"`
+	// Capabilities maps each capability to the host functions that the module imports from it
+	Capabilities map[string][]string `json:"capabilities"`
+	Imports      []InspectedSymbol   `json:"imports"`
+	Exports      []InspectedSymbol   `json:"exports"`
+	Memory       *InspectedMemory    `json:"memory,omitempty"`
+	Metadata     *tenant.Module      `json:"metadata,omitempty"`
+	// Signature is the outcome of verifying the module's signature against the trusted keys
+	Signature *signature.Result `json:"signature,omitempty"`
+	// Problems lists every way in which the module doesn't match the ABI
+	Problems []string `json:"problems,omitempty"`
+}
+
+// InspectedSymbol is an import or export of the module
+type InspectedSymbol struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// InspectedMemory is the memory that the module needs
+type InspectedMemory struct {
+	MinPages uint32 `json:"minPages"`
+	// MaxPages is nil if the memory can grow without bound
+	MaxPages *uint32 `json:"maxPages,omitempty"`
+	MinBytes uint64  `json:"minBytes"`
+	Imported bool    `json:"imported"`
+}
+
+// Inspect writes a description of the configured module to w, as text or as JSON
+func Inspect(config *Config, w io.Writer) error {
+	ref, err := moduleRef(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to moduleRef")
+	}
+
+	inspection, err := inspect(config.JobType, ref.Data, config.Module)
+	if err != nil {
+		return errors.Wrap(err, "failed to inspect")
+	}
+
+	// a module that sat would refuse to load is still inspected, with its signature describing why
+	result, _ := moduleSignature(config, config.routes()[0], ref.Data)
+	inspection.Signature = &result
+
+	if config.JSONOutput {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(inspection); err != nil {
+			return errors.Wrap(err, "failed to Encode")
+		}
+
+		return nil
+	}
+
+	if err := inspection.writeText(w); err != nil {
+		return errors.Wrap(err, "failed to writeText")
+	}
+
+	return nil
+}
+
+// inspect reads everything there is to know about a module from its binary and metadata
+func inspect(name string, module []byte, metadata *tenant.Module) (*Inspection, error) {
+	iface, err := wasmbinary.ReadInterface(module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ReadInterface")
+	}
+
+	report, err := wruntime.CheckABI(module, api.New().HostFunctions())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to CheckABI")
+	}
+
+	language, err := detectLanguage(module, report)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to detectLanguage")
+	}
+
+	digest := sha256.Sum256(module)
+
+	inspection := &Inspection{
+		Module:       name,
+		Digest:       "sha256:" + hex.EncodeToString(digest[:]),
+		Size:         len(module),
+		Language:     language,
+		ABI:          report.Flavour,
+		ABIVersion:   report.Version,
+		Capabilities: map[string][]string{},
+		Imports:      []InspectedSymbol{},
+		Exports:      []InspectedSymbol{},
+		Problems:     report.Problems,
+	}
+
+	for _, imp := range iface.Imports {
+		inspection.Imports = append(inspection.Imports, inspectedSymbol(imp.Module+"."+imp.Name, imp.Kind, imp.Type))
+
+		capability := importCapability(imp)
+		inspection.Capabilities[capability] = append(inspection.Capabilities[capability], imp.Name)
+	}
+
+	for _, exp := range iface.Exports {
+		inspection.Exports = append(inspection.Exports, inspectedSymbol(exp.Name, exp.Kind, exp.Type))
+	}
+
+	if len(iface.Memories) > 0 {
+		memory := iface.Memories[0]
+
+		inspection.Memory = &InspectedMemory{
+			MinPages: memory.Min,
+			MinBytes: uint64(memory.Min) * pageSize,
+			Imported: memory.Imported,
+		}
+
+		if memory.HasMax {
+			inspection.Memory.MaxPages = &memory.Max
+		}
+	}
+
+	if metadata != nil {
+		// the module's bytes were already described above, so don't repeat them
+		withoutRef := *metadata
+		withoutRef.WasmRef = nil
+		inspection.Metadata = &withoutRef
+	}
+
+	return inspection, nil
+}
+
+// importCapability returns the capability that an import belongs to
+func importCapability(imp wasmbinary.Import) string {
+	if wruntime.IsWASIModule(imp.Module) {
+		return capabilityWASI
+	}
+
+	capability, ok := api.CapabilityForImport(imp.Module, imp.Name)
+	if !ok {
+		return capabilityUnknown
+	}
+
+	return capability
+}
+
+// writeText writes the symbol as a row of a table
+func (s InspectedSymbol) writeText(w io.Writer) {
+	if s.Signature == "" {
+		fmt.Fprintf(w, "  %s\t%s\n", s.Name, s.Kind)
+		return
+	}
+
+	fmt.Fprintf(w, "  %s\t%s\t%s\n", s.Name, s.Kind, s.Signature)
+}
+
+func inspectedSymbol(name string, kind byte, typ *wasmbinary.FuncType) InspectedSymbol {
+	symbol := InspectedSymbol{Name: name, Kind: wasmbinary.KindName(kind)}
+
+	if typ != nil {
+		symbol.Signature = typ.String()
+	}
+
+	return symbol
+}
+
+// detectLanguage guesses the language or SDK that built the module from the traces its toolchain leaves behind
+func detectLanguage(module []byte, report *wruntime.ABIReport) (string, error) {
+	if report.Flavour == wruntime.ABISwift {
+		return "swift", nil
+	}
+
+	customs, err := wasmbinary.CustomSections(module)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to CustomSections")
+	}
+
+	// TinyGo reports the C99 of its runtime as the language, so only trust the producers section for anything else
+	producerLanguage := ""
+
+	for _, c := range customs {
+		if c.Name != wasmbinary.ProducersSection {
+			continue
+		}
+
+		producers, err := wasmbinary.ReadProducers(c.Data)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to ReadProducers")
+		}
+
+		if languages := producers["language"]; len(languages) > 0 {
+			producerLanguage = strings.ToLower(languages[0].Name)
+		}
+	}
+
+	if producerLanguage != "" && producerLanguage != "c99" && producerLanguage != "c11" {
+		return producerLanguage, nil
+	}
+
+	for _, m := range languageMarkers {
+		if bytes.Contains(module, []byte(m.marker)) {
+			return m.language, nil
+		}
+	}
+
+	if producerLanguage != "" {
+		return "c", nil
+	}
+
+	return languageUnknown, nil
+}
+
+// writeText writes the inspection in a human-readable form
+func (i *Inspection) writeText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "module:\t%s\n", i.Module)
+	fmt.Fprintf(tw, "digest:\t%s\n", i.Digest)
+	fmt.Fprintf(tw, "size:\t%d bytes\n", i.Size)
+	fmt.Fprintf(tw, "language:\t%s\n", i.Language)
+	if i.ABIVersion == wruntime.ABIv2 {
+		fmt.Fprintf(tw, "abi:\tv2 (%s@%s)\n", wruntime.WITPackage, wruntime.WITVersion)
+	} else {
+		fmt.Fprintf(tw, "abi:\tv1 (%s)\n", i.ABI)
+	}
+
+	if i.Signature != nil {
+		fmt.Fprintf(tw, "signature:\t%s\n", i.Signature)
+	}
+
+	if i.Memory != nil {
+		max := "no maximum"
+		if i.Memory.MaxPages != nil {
+			max = fmt.Sprintf("maximum %d pages", *i.Memory.MaxPages)
+		}
+
+		source := "defined"
+		if i.Memory.Imported {
+			source = "imported"
+		}
+
+		fmt.Fprintf(tw, "memory:\t%d pages (%d bytes) minimum, %s, %s\n", i.Memory.MinPages, i.Memory.MinBytes, max, source)
+	} else {
+		fmt.Fprint(tw, "memory:\tnone\n")
+	}
+
+	fmt.Fprint(tw, "\ncapabilities:\n")
+
+	capabilities := make([]string, 0, len(i.Capabilities))
+	for c := range i.Capabilities {
+		capabilities = append(capabilities, c)
+	}
+
+	sort.Strings(capabilities)
+
+	for _, c := range capabilities {
+		fmt.Fprintf(tw, "  %s\t%s\n", c, strings.Join(i.Capabilities[c], ", "))
+	}
+
+	fmt.Fprint(tw, "\nimports:\n")
+	for _, s := range i.Imports {
+		s.writeText(tw)
+	}
+
+	fmt.Fprint(tw, "\nexports:\n")
+	for _, s := range i.Exports {
+		s.writeText(tw)
+	}
+
+	if len(i.Problems) > 0 {
+		fmt.Fprint(tw, "\nabi problems:\n")
+		for _, p := range i.Problems {
+			fmt.Fprintf(tw, "  - %s\n", p)
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return errors.Wrap(err, "failed to Flush")
+	}
+
+	if i.Metadata == nil {
+		return nil
+	}
+
+	metadata, err := yaml.Marshal(i.Metadata)
+	if err != nil {
+		return errors.Wrap(err, "failed to Marshal metadata")
+	}
+
+	fmt.Fprint(w, "\nmetadata (.module.yml):\n")
+	for _, line := range strings.Split(strings.TrimSuffix(string(metadata), "\n"), "\n") {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+
+	return nil
+}
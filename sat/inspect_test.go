@@ -0,0 +1,171 @@
+package sat
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/tenant"
+)
+
+func TestInspect(t *testing.T) {
+	data, err := os.ReadFile("../examples/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+	}
+
+	inspection, err := inspect("hello-echo", data, nil)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to inspect"))
+	}
+
+	digest := sha256.Sum256(data)
+	if inspection.Digest != "sha256:"+hex.EncodeToString(digest[:]) {
+		t.Errorf("unexpected digest %s", inspection.Digest)
+	}
+
+	if inspection.Language != "rust" || inspection.ABI != "standard" || len(inspection.Problems) != 0 {
+		t.Errorf("unexpected inspection %+v", inspection)
+	}
+
+	expected := map[string][]string{
+		"core":   {"return_error", "return_result"},
+		"logger": {"log_msg"},
+		"wasi":   {"fd_write", "proc_exit", "environ_sizes_get", "environ_get"},
+	}
+
+	if !reflect.DeepEqual(inspection.Capabilities, expected) {
+		t.Errorf("expected capabilities %v, got %v", expected, inspection.Capabilities)
+	}
+
+	if inspection.Memory == nil || inspection.Memory.MinPages != 17 || inspection.Memory.MaxPages != nil || inspection.Memory.Imported {
+		t.Errorf("unexpected memory %+v", inspection.Memory)
+	}
+
+	found := false
+	for _, exp := range inspection.Exports {
+		if exp.Name == "run_e" {
+			found = exp.Kind == "func" && exp.Signature == "(param i32 i32 i32)"
+		}
+	}
+
+	if !found {
+		t.Errorf("expected run_e to be exported, got %+v", inspection.Exports)
+	}
+}
+
+func TestInspectLanguage(t *testing.T) {
+	modules := map[string]string{
+		"../examples/hello-echo/hello-echo.wasm":                      "rust",
+		"../engine/testdata/tinygo-log/tinygo-log.wasm":               "tinygo",
+		"../engine/testdata/grain-echo/lib.gr.wasm":                   "grain",
+		"../engine/testdata/as-echo/as-echo.wasm":                     "unknown",
+		"../engine/testdata/tinygo-hello-echo/tinygo-hello-echo.wasm": "tinygo",
+	}
+
+	for filename, language := range modules {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+		}
+
+		inspection, err := inspect(filename, data, nil)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "failed to inspect"))
+		}
+
+		if inspection.Language != language {
+			t.Errorf("expected %s to be detected as %s, got %s", filename, language, inspection.Language)
+		}
+	}
+}
+
+func TestInspectText(t *testing.T) {
+	data, err := os.ReadFile("../examples/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+	}
+
+	metadata := &tenant.Module{
+		Name:      "hello-echo",
+		Namespace: "default",
+		Lang:      "rust",
+		WasmRef:   tenant.NewWasmModuleRef("hello-echo", "", data),
+	}
+
+	inspection, err := inspect("hello-echo", data, metadata)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to inspect"))
+	}
+
+	if inspection.Metadata.WasmRef != nil || metadata.WasmRef == nil {
+		t.Error("expected the WasmRef to be left out of the inspection, without modifying the module")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := inspection.writeText(buf); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to writeText"))
+	}
+
+	for _, line := range []string{
+		"language:  rust",
+		"  logger  log_msg",
+		"  run_e        func  (param i32 i32 i32)",
+		"metadata (.module.yml):",
+		"  namespace: default",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected the text to contain %q, got:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestInspectModuleDotYaml(t *testing.T) {
+	data, err := os.ReadFile("../examples/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+	}
+
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "hello-echo.wasm"), data, 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile module"))
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ".module.yml"), []byte("name: hello-echo\nnamespace: greetings\nlang: rust\n"), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile .module.yml"))
+	}
+
+	config, err := ConfigFromRunnableArg(filepath.Join(dir, "hello-echo.wasm"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ConfigFromRunnableArg"))
+	}
+
+	config.JSONOutput = true
+
+	buf := &bytes.Buffer{}
+	if err := Inspect(config, buf); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Inspect"))
+	}
+
+	inspection := &Inspection{}
+	if err := json.Unmarshal(buf.Bytes(), inspection); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Unmarshal"))
+	}
+
+	if inspection.Metadata == nil || inspection.Metadata.Namespace != "greetings" {
+		t.Errorf("expected the .module.yml metadata, got %+v", inspection.Metadata)
+	}
+
+	if inspection.Module != "hello-echo" || inspection.Language != "rust" {
+		t.Errorf("unexpected inspection %+v", inspection)
+	}
+}
@@ -124,7 +124,7 @@ func (s *Sat) sendFnResult(result *sequence.FnResult, ctx *vk.Ctx) error {
 
 	respMsg := bus.NewMsgWithParentID(MsgTypeAtmoFnResult, ctx.RequestID(), fnrJSON)
 
-	ctx.Log.Debug("function", s.jobName, "completed, sending result message", respMsg.UUID())
+	ctx.Log.Debug("function", result.FQFN, "completed, sending result message", respMsg.UUID())
 
 	if s.exec.Send(respMsg) == nil {
 		return errors.New("failed to Send fnResult")
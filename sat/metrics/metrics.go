@@ -15,6 +15,7 @@ type Metrics struct {
 	FunctionExecutions       syncint64.Counter
 	FailedFunctionExecutions syncint64.Counter
 	FunctionTime             syncint64.Histogram
+	LimitHits                syncint64.Counter
 }
 
 type Timer struct {
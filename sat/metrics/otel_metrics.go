@@ -78,9 +78,19 @@ func configureMetrics() (Metrics, error) {
 		return Metrics{}, errors.Wrap(err, "sync int 64 provider function_time")
 	}
 
+	limitHits, err := si64.Counter(
+		"limit_hits",
+		instrument.WithUnit(unit.Dimensionless),
+		instrument.WithDescription("How many function executions were stopped by a resource limit"),
+	)
+	if err != nil {
+		return Metrics{}, errors.Wrap(err, "sync int 64 provider limit_hits")
+	}
+
 	return Metrics{
 		FunctionExecutions:       functionExecutions,
 		FailedFunctionExecutions: failedFunctionExecutions,
 		FunctionTime:             functionTime,
+		LimitHits:                limitHits,
 	}, nil
 }
@@ -2,6 +2,7 @@ package options
 
 import (
 	"context"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sethvargo/go-envconfig"
@@ -12,12 +13,35 @@ type Options struct {
 	Port     port     `env:"SAT_HTTP_PORT"`
 	ProcUUID procUUID `env:"SAT_UUID"`
 
+	Runtime         string `env:"SAT_RUNTIME"`
+	CompileCacheDir string `env:"SAT_COMPILE_CACHE_DIR"`
+	DebugTraps      bool   `env:"SAT_DEBUG_TRAPS"`
+
+	// Preinit runs the module's initialization once and builds its instances from a snapshot of it, which is cached in
+	// the compile cache dir
+	Preinit bool `env:"SAT_PREINIT"`
+
+	// TrustedKeys are the ed25519 public keys (base64-encoded, or files holding them) that modules must be signed with
+	// before they are loaded. Modules aren't required to be signed when it is empty.
+	TrustedKeys []string `env:"SAT_TRUSTED_KEYS"`
+
+	// RecordFile is the file that every invocation is recorded to, as a line of JSON, so that it can be replayed
+	RecordFile string `env:"SAT_RECORD_FILE"`
+
 	ControlPlane *ControlPlane `env:",noinit"`
 	Ident        *Ident        `env:",noinit"`
 	Version      *Version      `env:",noinit"`
 
-	TracerConfig  TracerConfig  `env:",prefix=SAT_TRACER_"`
-	MetricsConfig MetricsConfig `env:",prefix=SAT_METRICS_"`
+	TracerConfig    TracerConfig    `env:",prefix=SAT_TRACER_"`
+	MetricsConfig   MetricsConfig   `env:",prefix=SAT_METRICS_"`
+	ExecConfig      ExecConfig      `env:",prefix=SAT_EXEC_"`
+	LimitsConfig    LimitsConfig    `env:",prefix=SAT_LIMITS_"`
+	PoolConfig      PoolConfig      `env:",prefix=SAT_POOL_"`
+	WASIConfig      WASIConfig      `env:",prefix=SAT_WASI_"`
+	OutputConfig    OutputConfig    `env:",prefix=SAT_OUTPUT_"`
+	SchedulerConfig SchedulerConfig `env:",prefix=SAT_SCHEDULER_"`
+	ReloadConfig    ReloadConfig    `env:",prefix=SAT_RELOAD_"`
+	DownloadConfig  DownloadConfig  `env:",prefix=SAT_DOWNLOAD_"`
 }
 
 // ControlPlane is a struct, so we can use a pointer, so we can check whether it's been set in config. If set, it holds
@@ -46,6 +70,90 @@ type OtelMetricsConfig struct {
 	Endpoint string `env:"ENDPOINT"`
 }
 
+// ExecConfig holds the budget given to each execution of the module. Zero values mean unbounded, and Wasmer (which
+// can't enforce either) refuses modules that are given one. All configuration options have a prefix of SAT_EXEC_
+// specified in the parent Options struct.
+type ExecConfig struct {
+	Timeout time.Duration `env:"TIMEOUT"`
+	Fuel    uint64        `env:"FUEL"`
+}
+
+// LimitsConfig holds the resource limits applied to the module's instances. Zero values mean unlimited. All
+// configuration options have a prefix of SAT_LIMITS_ specified in the parent Options struct.
+type LimitsConfig struct {
+	MemoryPages   uint32 `env:"MEMORY_PAGES"`
+	TableElements uint32 `env:"TABLE_ELEMENTS"`
+	Instances     int    `env:"INSTANCES"`
+}
+
+// PoolConfig determines how the module's instances are reused between executions. Mode is one of fresh (the default),
+// snapshot or reuse. All configuration options have a prefix of SAT_POOL_ specified in the parent Options struct.
+type PoolConfig struct {
+	Mode    string `env:"MODE"`
+	MaxUses int    `env:"MAX_USES"`
+}
+
+// WASIConfig holds what the module can access through WASI, nothing is exposed by default. Env lists the host
+// environment variables passed through (NAME or NAME=value), Preopens lists directories as host[:guest[:ro|rw]] (which
+// are read-only unless rw is given, and must be rw on Wasmer and WasmEdge), and Clock and Random are either none or
+// host. WasmEdge can't withhold the host's clock and random sources, so it refuses modules that import them unless
+// they are host. All configuration options have a prefix of SAT_WASI_ specified in the
+// parent Options struct.
+type WASIConfig struct {
+	Env      []string `env:"ENV"`
+	Args     []string `env:"ARGS"`
+	Preopens []string `env:"PREOPENS"`
+	Clock    string   `env:"CLOCK"`
+	Random   string   `env:"RANDOM"`
+}
+
+// OutputConfig determines how the module's stdout and stderr are logged. The levels are one of none, error, warn, info
+// or debug, and default to info for stdout and warn for stderr. MaxBytes caps how much of each stream is kept per
+// execution, and DebugHeader returns the output in the X-Sat-Stdout and X-Sat-Stderr response headers. All
+// configuration options have a prefix of SAT_OUTPUT_ specified in the parent Options struct.
+type OutputConfig struct {
+	StdoutLevel string `env:"STDOUT_LEVEL"`
+	StderrLevel string `env:"STDERR_LEVEL"`
+	MaxBytes    int    `env:"MAX_BYTES"`
+	DebugHeader bool   `env:"DEBUG_HEADER"`
+}
+
+// SchedulerConfig determines how many workers run the module's invocations. MinWorkers are kept when idle, up to
+// MaxWorkers are started as invocations queue up, and PreWarm instances are built before sat starts serving. Retries
+// and RetryBackoff apply to starting workers, and QueueDepth caps the invocations waiting for a worker (zero means
+// unbounded). All configuration options have a prefix of SAT_SCHEDULER_ specified in the parent Options struct.
+type SchedulerConfig struct {
+	MinWorkers   int           `env:"MIN_WORKERS,default=1"`
+	MaxWorkers   int           `env:"MAX_WORKERS,default=24"`
+	PreWarm      int           `env:"PREWARM,default=1"`
+	Retries      int           `env:"RETRIES"`
+	RetryBackoff time.Duration `env:"RETRY_BACKOFF"`
+	QueueDepth   int           `env:"QUEUE_DEPTH"`
+}
+
+// ReloadConfig determines whether sat watches its modules' files for changes, reloading each module that changes
+// without restarting, and how often the files are checked. Endpoint serves the reload endpoint, which requires
+// SAT_ENV_TOKEN as a bearer token when sat has one. A module's .module.yml isn't watched, as its runtime and scheduler
+// settings only take effect when sat starts. All configuration options have a prefix of SAT_RELOAD_ specified in the
+// parent Options struct.
+type ReloadConfig struct {
+	Watch    bool          `env:"WATCH"`
+	Interval time.Duration `env:"INTERVAL,default=1s"`
+	Endpoint bool          `env:"ENDPOINT"`
+}
+
+// DownloadConfig determines how a module is downloaded when sat is started with its URL. Failed downloads are retried
+// with a backoff that doubles after each attempt, and downloaded modules are cached in CacheDir (by default in the
+// temp dir) by their digest. RequireDigest refuses URLs that don't pin their module's digest with #sha256=<hex>. All
+// configuration options have a prefix of SAT_DOWNLOAD_ specified in the parent Options struct.
+type DownloadConfig struct {
+	Timeout       time.Duration `env:"TIMEOUT,default=30s"`
+	Retries       int           `env:"RETRIES,default=3"`
+	RetryBackoff  time.Duration `env:"RETRY_BACKOFF,default=500ms"`
+	RequireDigest bool          `env:"REQUIRE_DIGEST"`
+	CacheDir      string        `env:"CACHE_DIR"`
+}
+
 // TracerConfig holds values specific to setting up the tracer. It's only used in proxy mode. All configuration options
 // have a prefix of SAT_TRACER_ specified in the parent Options struct.
 type TracerConfig struct {
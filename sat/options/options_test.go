@@ -2,6 +2,7 @@ package options
 
 import (
 	"testing"
+	"time"
 
 	"github.com/sethvargo/go-envconfig"
 	"github.com/stretchr/testify/assert"
@@ -22,6 +23,12 @@ func TestResolve(t *testing.T) {
 				"SAT_ENV_TOKEN":                 "envtoken",
 				"SAT_HTTP_PORT":                 "1234",
 				"SAT_UUID":                      "63147f8b-cd25-4eba-acc2-6ff48e6970b6",
+				"SAT_RUNTIME":                   "wasmer",
+				"SAT_COMPILE_CACHE_DIR":         "/var/cache/sat",
+				"SAT_DEBUG_TRAPS":               "true",
+				"SAT_PREINIT":                   "true",
+				"SAT_TRUSTED_KEYS":              "a.pub,b.pub",
+				"SAT_RECORD_FILE":               "/var/log/sat/invocations.jsonl",
 				"SAT_CONTROL_PLANE":             "https://localhost:9091",
 				"SAT_TRACER_TYPE":               "custom1",
 				"SAT_RUNNABLE_IDENT":            "ident52",
@@ -35,14 +42,50 @@ func TestResolve(t *testing.T) {
 				"SAT_METRICS_TYPE":              "otel",
 				"SAT_METRICS_SERVICENAME":       "metricsservice",
 				"SAT_METRICS_OTEL_ENDPOINT":     "localhost:1111",
+				"SAT_EXEC_TIMEOUT":              "5s",
+				"SAT_EXEC_FUEL":                 "1000000",
+				"SAT_LIMITS_MEMORY_PAGES":       "256",
+				"SAT_LIMITS_TABLE_ELEMENTS":     "1024",
+				"SAT_LIMITS_INSTANCES":          "2",
+				"SAT_POOL_MODE":                 "snapshot",
+				"SAT_POOL_MAX_USES":             "100",
+				"SAT_WASI_ENV":                  "HOME,GREETING=hello",
+				"SAT_WASI_ARGS":                 "--verbose",
+				"SAT_WASI_PREOPENS":             "/srv/data:/data,/tmp:/tmp:rw",
+				"SAT_WASI_CLOCK":                "host",
+				"SAT_WASI_RANDOM":               "none",
+				"SAT_OUTPUT_STDOUT_LEVEL":       "debug",
+				"SAT_OUTPUT_STDERR_LEVEL":       "none",
+				"SAT_OUTPUT_MAX_BYTES":          "1024",
+				"SAT_OUTPUT_DEBUG_HEADER":       "true",
+				"SAT_SCHEDULER_MIN_WORKERS":     "2",
+				"SAT_SCHEDULER_MAX_WORKERS":     "128",
+				"SAT_SCHEDULER_PREWARM":         "4",
+				"SAT_SCHEDULER_RETRIES":         "3",
+				"SAT_SCHEDULER_RETRY_BACKOFF":   "2s",
+				"SAT_SCHEDULER_QUEUE_DEPTH":     "512",
+				"SAT_RELOAD_WATCH":              "true",
+				"SAT_RELOAD_INTERVAL":           "250ms",
+				"SAT_RELOAD_ENDPOINT":           "true",
+				"SAT_DOWNLOAD_TIMEOUT":          "5s",
+				"SAT_DOWNLOAD_RETRIES":          "5",
+				"SAT_DOWNLOAD_RETRY_BACKOFF":    "1s",
+				"SAT_DOWNLOAD_REQUIRE_DIGEST":   "true",
+				"SAT_DOWNLOAD_CACHE_DIR":        "/var/cache/sat",
 			},
 			want: Options{
-				EnvToken:     "envtoken",
-				Port:         "1234",
-				ProcUUID:     "63147f8b-cd25-4eba-acc2-6ff48e6970b6",
-				ControlPlane: &ControlPlane{Address: "https://localhost:9091"},
-				Ident:        &Ident{Data: "ident52"},
-				Version:      &Version{Data: "v9.5.4"},
+				EnvToken:        "envtoken",
+				Port:            "1234",
+				ProcUUID:        "63147f8b-cd25-4eba-acc2-6ff48e6970b6",
+				Runtime:         "wasmer",
+				CompileCacheDir: "/var/cache/sat",
+				DebugTraps:      true,
+				Preinit:         true,
+				TrustedKeys:     []string{"a.pub", "b.pub"},
+				RecordFile:      "/var/log/sat/invocations.jsonl",
+				ControlPlane:    &ControlPlane{Address: "https://localhost:9091"},
+				Ident:           &Ident{Data: "ident52"},
+				Version:         &Version{Data: "v9.5.4"},
 				TracerConfig: TracerConfig{
 					TracerType:  "custom1",
 					ServiceName: "service 543",
@@ -61,6 +104,52 @@ func TestResolve(t *testing.T) {
 					ServiceName: "metricsservice",
 					OtelMetrics: &OtelMetricsConfig{Endpoint: "localhost:1111"},
 				},
+				ExecConfig: ExecConfig{
+					Timeout: 5 * time.Second,
+					Fuel:    1000000,
+				},
+				LimitsConfig: LimitsConfig{
+					MemoryPages:   256,
+					TableElements: 1024,
+					Instances:     2,
+				},
+				PoolConfig: PoolConfig{
+					Mode:    "snapshot",
+					MaxUses: 100,
+				},
+				WASIConfig: WASIConfig{
+					Env:      []string{"HOME", "GREETING=hello"},
+					Args:     []string{"--verbose"},
+					Preopens: []string{"/srv/data:/data", "/tmp:/tmp:rw"},
+					Clock:    "host",
+					Random:   "none",
+				},
+				OutputConfig: OutputConfig{
+					StdoutLevel: "debug",
+					StderrLevel: "none",
+					MaxBytes:    1024,
+					DebugHeader: true,
+				},
+				SchedulerConfig: SchedulerConfig{
+					MinWorkers:   2,
+					MaxWorkers:   128,
+					PreWarm:      4,
+					Retries:      3,
+					RetryBackoff: 2 * time.Second,
+					QueueDepth:   512,
+				},
+				ReloadConfig: ReloadConfig{
+					Watch:    true,
+					Interval: 250 * time.Millisecond,
+					Endpoint: true,
+				},
+				DownloadConfig: DownloadConfig{
+					Timeout:       5 * time.Second,
+					Retries:       5,
+					RetryBackoff:  time.Second,
+					RequireDigest: true,
+					CacheDir:      "/var/cache/sat",
+				},
 			},
 			wantErr: assert.NoError,
 		},
@@ -85,9 +174,66 @@ func TestResolve(t *testing.T) {
 					ServiceName: "sat",
 					OtelMetrics: nil,
 				},
+				SchedulerConfig: SchedulerConfig{
+					MinWorkers: 1,
+					MaxWorkers: 24,
+					PreWarm:    1,
+				},
+				ReloadConfig: ReloadConfig{
+					Interval: time.Second,
+				},
+				DownloadConfig: DownloadConfig{
+					Timeout:      30 * time.Second,
+					Retries:      3,
+					RetryBackoff: 500 * time.Millisecond,
+				},
+			},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "scheduler can be pinned to a fixed number of workers without pre-warming",
+			configs: map[string]string{
+				"SAT_HTTP_PORT":             "12345",
+				"SAT_UUID":                  "63147f8b-cd25-4eba-acc2-6ff48e6970b6",
+				"SAT_SCHEDULER_MIN_WORKERS": "2",
+				"SAT_SCHEDULER_MAX_WORKERS": "2",
+				"SAT_SCHEDULER_PREWARM":     "0",
+			},
+			want: Options{
+				Port:     "12345",
+				ProcUUID: "63147f8b-cd25-4eba-acc2-6ff48e6970b6",
+				TracerConfig: TracerConfig{
+					TracerType:  "none",
+					ServiceName: "sat",
+					Probability: 0.5,
+				},
+				MetricsConfig: MetricsConfig{
+					Type:        "none",
+					ServiceName: "sat",
+				},
+				SchedulerConfig: SchedulerConfig{
+					MinWorkers: 2,
+					MaxWorkers: 2,
+				},
+				ReloadConfig: ReloadConfig{
+					Interval: time.Second,
+				},
+				DownloadConfig: DownloadConfig{
+					Timeout:      30 * time.Second,
+					Retries:      3,
+					RetryBackoff: 500 * time.Millisecond,
+				},
 			},
 			wantErr: assert.NoError,
 		},
+		{
+			name: "errors out on a malformed retry backoff",
+			configs: map[string]string{
+				"SAT_SCHEDULER_RETRY_BACKOFF": "soon",
+			},
+			want:    Options{},
+			wantErr: assert.Error,
+		},
 		{
 			name: "errors out on not-a-uuid",
 			configs: map[string]string{
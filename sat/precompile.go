@@ -0,0 +1,53 @@
+package sat
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine"
+	wruntime "github.com/suborbital/sat/engine/runtime"
+	"github.com/suborbital/sat/sat/executor"
+)
+
+// Precompile compiles the configured modules into the compile cache, so that
+// sat can skip compilation when it later starts with the same configuration
+func Precompile(config *Config) error {
+	if config.RuntimeConfig.CacheDir == "" {
+		return errors.New("SAT_COMPILE_CACHE_DIR must be set to precompile a module")
+	}
+
+	wruntime.UseInternalLogger(config.Logger)
+
+	exec, err := executor.New(config.Logger, config.CapConfig, engine.UseRuntime(config.Runtime))
+	if err != nil {
+		return errors.Wrap(err, "failed to executor.New")
+	}
+
+	compiled := map[string]bool{}
+
+	for _, route := range config.routes() {
+		if compiled[route.Name()] {
+			continue
+		}
+
+		ref, err := routeModuleRef(config, route)
+		if err != nil {
+			return errors.Wrap(err, "failed to routeModuleRef")
+		}
+
+		if _, err := verifyModule(config, route, ref.Data); err != nil {
+			return errors.Wrap(err, "failed to verifyModule")
+		}
+
+		runtimeConfig := config.moduleConfig(route.Name()).RuntimeConfig
+
+		if err := exec.Precompile(ref, runtimeConfig); err != nil {
+			return errors.Wrapf(err, "failed to exec.Precompile %s", route.Name())
+		}
+
+		compiled[route.Name()] = true
+
+		config.Logger.Info("compiled", route.Name(), "into", runtimeConfig.CacheDir)
+	}
+
+	return nil
+}
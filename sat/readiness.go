@@ -0,0 +1,99 @@
+package sat
+
+import (
+	"encoding/json"
+	"net/http"
+
+	wruntime "github.com/suborbital/sat/engine/runtime"
+)
+
+// ReadinessPath reports whether sat can serve requests, so that orchestrators stop routing to it when it can't. It is
+// served in front of the router, since every other path is routed to the modules.
+const ReadinessPath = "/meta/ready"
+
+// ReadinessResponse is the body returned from the readiness endpoint
+type ReadinessResponse struct {
+	// Ready is true once every module can serve requests
+	Ready bool `json:"ready"`
+	// Pool is the health of the first module's pool, and Modules that of every module's pool
+	Pool    wruntime.PoolHealth            `json:"pool"`
+	Modules map[string]wruntime.PoolHealth `json:"modules"`
+}
+
+// metaWrapper serves the readiness, metrics, info and reload endpoints ahead of the router
+func (s *Sat) metaWrapper(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == ReloadPath && s.config.ReloadConfig.Endpoint {
+			s.serveReload(w, r)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.URL.Path {
+		case ReadinessPath:
+			s.serveReadiness(w, r)
+		case MetricsPath:
+			s.serveWorkerMetrics(w, r)
+		case InfoPath:
+			s.serveInfo(w, r)
+		default:
+			inner.ServeHTTP(w, r)
+		}
+	})
+}
+
+// serveReadiness responds with the readiness of the modules
+func (s *Sat) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	resp := s.readiness()
+
+	status := http.StatusOK
+	if !resp.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	s.writeMeta(w, r, status, resp)
+}
+
+// writeMeta writes the response of a meta endpoint as JSON
+func (s *Sat) writeMeta(w http.ResponseWriter, r *http.Request, status int, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.log.Debug("failed to write", r.URL.Path, "response:", err.Error())
+	}
+}
+
+// readiness reports the health of each module's pool of instances
+func (s *Sat) readiness() ReadinessResponse {
+	resp := ReadinessResponse{Ready: true, Modules: map[string]wruntime.PoolHealth{}}
+
+	for _, name := range s.modules {
+		health := s.moduleHealth(name)
+
+		resp.Modules[name] = health
+		resp.Ready = resp.Ready && health.Ready()
+	}
+
+	resp.Pool = resp.Modules[s.jobName]
+
+	return resp
+}
+
+// moduleHealth returns the health of a module's pool of instances
+func (s *Sat) moduleHealth(name string) wruntime.PoolHealth {
+	health, ok := s.exec.Health(name)
+	if !ok {
+		return wruntime.PoolHealth{State: wruntime.PoolUnavailable}
+	}
+
+	return health
+}
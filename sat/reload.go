@@ -0,0 +1,208 @@
+package sat
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/appspec/tenant"
+
+	"github.com/suborbital/sat/sat/signature"
+)
+
+// ReloadPath reloads the modules when POSTed to, or only the module named by the module query parameter. Modules
+// fetched from the control plane are fetched again, and the others are read from disk. It is only served when
+// SAT_RELOAD_ENDPOINT is set, and requires sat's env token when it has one.
+const ReloadPath = "/meta/reload"
+
+// ReloadResponse is the body returned from the reload endpoint
+type ReloadResponse struct {
+	Reloaded []string `json:"reloaded"`
+	// Errors holds the reason that each module that failed to reload is still serving its old version
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Reload replaces the named module with its current version, compiling and instantiating it before any of the
+// module's instances are replaced. A version that fails to build returns an error, and the old version keeps serving.
+func (s *Sat) Reload(name string) error {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	route, ok := s.moduleRoute(name)
+	if !ok {
+		return fmt.Errorf("module %s is not served", name)
+	}
+
+	ref, err := s.currentModuleRef(route)
+	if err != nil {
+		return errors.Wrap(err, "failed to currentModuleRef")
+	}
+
+	result, err := verifyModule(s.config, route, ref.Data)
+	if err != nil {
+		return errors.Wrap(err, "failed to verifyModule")
+	}
+
+	if err := s.exec.Reload(name, ref); err != nil {
+		return errors.Wrap(err, "failed to exec.Reload")
+	}
+
+	s.stats[name].signature.Store(&result)
+
+	s.log.Info("reloaded", name)
+
+	return nil
+}
+
+// moduleRoute returns the first route that sends requests to the named module
+func (s *Sat) moduleRoute(name string) (Route, bool) {
+	for _, route := range s.config.routes() {
+		if route.Name() == name {
+			return route, true
+		}
+	}
+
+	return Route{}, false
+}
+
+// currentModuleRef returns a reference to the current version of a route's module
+func (s *Sat) currentModuleRef(route Route) (*tenant.WasmModuleRef, error) {
+	if s.config.fromControlPlane(route.Name()) {
+		module, err := s.config.appSource.GetModule(s.config.RunnableArg)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to GetModule")
+		}
+
+		if module.WasmRef == nil || len(module.WasmRef.Data) == 0 {
+			return nil, fmt.Errorf("the control plane returned no module for %s", s.config.RunnableArg)
+		}
+
+		// the config is shared with the handlers, so the module that it was started with is left in it
+		return tenant.NewWasmModuleRef(module.WasmRef.Name, module.WasmRef.FQMN, module.WasmRef.Data), nil
+	}
+
+	return routeModuleRef(s.config, route)
+}
+
+// serveReload reloads the modules and responds with the outcome
+func (s *Sat) serveReload(w http.ResponseWriter, r *http.Request) {
+	if !s.reloadAuthorized(r) {
+		http.Error(w, "reloading requires sat's env token", http.StatusUnauthorized)
+		return
+	}
+
+	modules := s.modules
+
+	if name := r.URL.Query().Get("module"); name != "" {
+		if _, ok := s.stats[name]; !ok {
+			http.Error(w, fmt.Sprintf("module %s is not served", name), http.StatusNotFound)
+			return
+		}
+
+		modules = []string{name}
+	}
+
+	resp := ReloadResponse{Reloaded: []string{}}
+	status := http.StatusOK
+
+	for _, name := range modules {
+		if err := s.Reload(name); err != nil {
+			s.log.Error(errors.Wrapf(err, "failed to Reload %s, the old version will keep serving", name))
+
+			if resp.Errors == nil {
+				resp.Errors = map[string]string{}
+			}
+
+			resp.Errors[name] = err.Error()
+			status = http.StatusInternalServerError
+
+			continue
+		}
+
+		resp.Reloaded = append(resp.Reloaded, name)
+	}
+
+	s.writeMeta(w, r, status, resp)
+}
+
+// reloadAuthorized returns true if the request may reload the modules, which it can only do with sat's env token when
+// sat has one
+func (s *Sat) reloadAuthorized(r *http.Request) bool {
+	if s.config.EnvToken == "" {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.EnvToken)) == 1
+}
+
+// watchModules reloads each module whose files (including its detached signature) change on disk, until ctx is done.
+// The files are compared against their versions when it is called, and a module that is still being written when it
+// is noticed fails to build and keeps its old version, and is reloaded once it has been written.
+func (s *Sat) watchModules(ctx context.Context) {
+	versions := s.moduleFileVersions()
+
+	go func() {
+		ticker := time.NewTicker(s.config.ReloadConfig.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current := s.moduleFileVersions()
+
+			for _, name := range s.modules {
+				if current[name] == versions[name] {
+					continue
+				}
+
+				s.log.Info("module", name, "changed, reloading")
+
+				if err := s.Reload(name); err != nil {
+					s.log.Error(errors.Wrapf(err, "failed to Reload %s, the old version will keep serving", name))
+				}
+			}
+
+			versions = current
+		}
+	}()
+}
+
+// moduleFileVersions describes the version of the files that each module on disk is loaded from, which changes
+// whenever one of them is written
+func (s *Sat) moduleFileVersions() map[string]string {
+	versions := map[string]string{}
+
+	for _, name := range s.modules {
+		if s.config.fromControlPlane(name) {
+			continue
+		}
+
+		route, _ := s.moduleRoute(name)
+
+		versions[name] = fileVersion(route.Module) + fileVersion(route.Module+signature.DetachedSuffix)
+	}
+
+	return versions
+}
+
+// fileVersion describes the version of a file by its modification time and size, and is empty if it doesn't exist
+func fileVersion(filename string) string {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d:%d;", info.ModTime().UnixNano(), info.Size())
+}
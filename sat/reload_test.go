@@ -0,0 +1,190 @@
+package sat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/vektor/vtest"
+)
+
+func TestReloadEndpoint(t *testing.T) {
+	module := filepath.Join(t.TempDir(), "echo.wasm")
+	copyModule(t, "../examples/hello-echo/hello-echo.wasm", module)
+
+	sat, tp, err := satForFile(module)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to satForFile"))
+	}
+	ctx, ctxCloser := context.WithTimeout(context.Background(), time.Second)
+	defer ctxCloser()
+	defer tp.Shutdown(ctx)
+
+	vt := vtest.New(sat.testServer())
+
+	expectEcho(t, vt, 200, "hello my friend")
+
+	copyModule(t, "../examples/return-err/return-err.wasm", module)
+
+	// nothing changes until sat is told to reload, which it can't be unless the endpoint is enabled
+	expectEcho(t, vt, 200, "hello my friend")
+
+	// without it, the path is routed to the module like any other
+	req, _ := http.NewRequest(http.MethodPost, ReloadPath, bytes.NewBufferString("my friend"))
+	vt.Do(req, t).AssertStatus(200)
+
+	expectEcho(t, vt, 200, "hello my friend")
+
+	sat.config.ReloadConfig.Endpoint = true
+
+	req, _ = http.NewRequest(http.MethodPost, ReloadPath, nil)
+
+	resp := vt.Do(req, t)
+
+	resp.AssertStatus(200)
+
+	body := ReloadResponse{}
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Unmarshal"))
+	}
+
+	if len(body.Reloaded) != 1 || body.Reloaded[0] != "echo" || len(body.Errors) != 0 {
+		t.Errorf("expected echo to be reloaded, got %s", string(resp.Body))
+	}
+
+	expectEcho(t, vt, 401, `{"status":401,"message":"don't go there"}`)
+
+	// a module that can't be built leaves the old version serving
+	if err := os.WriteFile(module, []byte("not a module"), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile"))
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, ReloadPath+"?module=echo", nil)
+
+	resp = vt.Do(req, t)
+
+	resp.AssertStatus(500)
+
+	body = ReloadResponse{}
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Unmarshal"))
+	}
+
+	if len(body.Reloaded) != 0 || body.Errors["echo"] == "" {
+		t.Errorf("expected echo to fail to reload, got %s", string(resp.Body))
+	}
+
+	expectEcho(t, vt, 401, `{"status":401,"message":"don't go there"}`)
+
+	req, _ = http.NewRequest(http.MethodPost, ReloadPath+"?module=missing", nil)
+	vt.Do(req, t).AssertStatus(404)
+}
+
+func TestReloadEndpointToken(t *testing.T) {
+	module := filepath.Join(t.TempDir(), "echo.wasm")
+	copyModule(t, "../examples/hello-echo/hello-echo.wasm", module)
+
+	sat, tp, err := satForFile(module)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to satForFile"))
+	}
+	ctx, ctxCloser := context.WithTimeout(context.Background(), time.Second)
+	defer ctxCloser()
+	defer tp.Shutdown(ctx)
+
+	sat.config.ReloadConfig.Endpoint = true
+	sat.config.EnvToken = "envtoken"
+
+	vt := vtest.New(sat.testServer())
+
+	copyModule(t, "../examples/return-err/return-err.wasm", module)
+
+	// sat's env token is required to reload its modules
+	for _, auth := range []string{"", "Bearer wrong", "envtoken"} {
+		req, _ := http.NewRequest(http.MethodPost, ReloadPath, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		vt.Do(req, t).AssertStatus(401)
+	}
+
+	expectEcho(t, vt, 200, "hello my friend")
+
+	req, _ := http.NewRequest(http.MethodPost, ReloadPath, nil)
+	req.Header.Set("Authorization", "Bearer envtoken")
+
+	vt.Do(req, t).AssertStatus(200)
+
+	expectEcho(t, vt, 401, `{"status":401,"message":"don't go there"}`)
+}
+
+func TestReloadWatch(t *testing.T) {
+	module := filepath.Join(t.TempDir(), "echo.wasm")
+	copyModule(t, "../examples/hello-echo/hello-echo.wasm", module)
+
+	sat, tp, err := satForFile(module)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to satForFile"))
+	}
+	ctx, ctxCloser := context.WithTimeout(context.Background(), 10*time.Second)
+	defer ctxCloser()
+	defer tp.Shutdown(ctx)
+
+	sat.config.ReloadConfig.Interval = 10 * time.Millisecond
+
+	sat.watchModules(ctx)
+
+	vt := vtest.New(sat.testServer())
+
+	expectEcho(t, vt, 200, "hello my friend")
+
+	copyModule(t, "../examples/return-err/return-err.wasm", module)
+
+	for {
+		req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("my friend"))
+
+		if resp := vt.Do(req, t); resp.Status == 401 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatal("the module was not reloaded after it changed")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// expectEcho sends a request to sat, and checks its response
+func expectEcho(t *testing.T, vt *vtest.VTest, status int, body string) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString("my friend"))
+
+	resp := vt.Do(req, t)
+
+	resp.AssertStatus(status)
+	resp.AssertBodyString(body)
+}
+
+// copyModule replaces the module at dst with the one at src
+func copyModule(t *testing.T, src, dst string) {
+	t.Helper()
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+	}
+
+	if err := os.WriteFile(dst, data, 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile"))
+	}
+}
@@ -0,0 +1,100 @@
+package sat
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/vektor/vk"
+
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
+	wruntime "github.com/suborbital/sat/engine/runtime"
+	"github.com/suborbital/sat/sat/executor"
+)
+
+// Replay runs the module again for each invocation in the recording named by the config, with the calls that it
+// makes to capabilities served from the recording rather than made again. Each invocation is described on w along
+// with the ways it diverged from the recording, and an error is returned if any of them diverged.
+func Replay(config *Config, w io.Writer) error {
+	wruntime.UseInternalLogger(config.Logger)
+
+	file, err := os.Open(config.Recording)
+	if err != nil {
+		return errors.Wrap(err, "failed to Open recording")
+	}
+
+	defer file.Close()
+
+	invocations, err := api.ReadInvocations(file)
+	if err != nil {
+		return errors.Wrap(err, "failed to ReadInvocations")
+	}
+
+	ref, err := moduleRef(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to moduleRef")
+	}
+
+	if _, err := verifyModule(config, config.routes()[0], ref.Data); err != nil {
+		return errors.Wrap(err, "failed to verifyModule")
+	}
+
+	replayer := api.NewReplayer()
+
+	exec, err := executor.New(config.Logger, config.CapConfig, engine.UseRuntime(config.Runtime), engine.Record(replayer))
+	if err != nil {
+		return errors.Wrap(err, "failed to executor.New")
+	}
+
+	if err := exec.RegisterWithConfig(config.JobType, ref, config.RuntimeConfig); err != nil {
+		return errors.Wrap(err, "failed to exec.RegisterWithConfig")
+	}
+
+	replayed, diverged := 0, 0
+
+	for i, recorded := range invocations {
+		// a recording made by a sat serving several modules holds the invocations of each of them
+		if recorded.Module != config.JobType {
+			continue
+		}
+
+		// sat only records requests, which is all that the executor runs
+		if recorded.Request == nil {
+			return fmt.Errorf("invocation %d is not a request, so it can't be replayed", i+1)
+		}
+
+		replay, divergences := replayer.Replay(recorded, func() {
+			exec.Do(config.JobType, recorded.Request, vk.NewCtx(config.Logger, nil, nil), nil)
+		})
+
+		replayed++
+
+		fmt.Fprintf(w, "invocation %d (request %s): %d host calls", i+1, recorded.Request.ID, len(replay.HostCalls))
+
+		if len(divergences) == 0 {
+			fmt.Fprintln(w, ", matches the recording")
+			continue
+		}
+
+		fmt.Fprintln(w, ", diverged from the recording:")
+
+		for _, d := range divergences {
+			fmt.Fprintf(w, "  %s\n", d)
+		}
+
+		diverged++
+	}
+
+	if replayed == 0 {
+		return fmt.Errorf("the recording has no invocations of %s", config.JobType)
+	}
+
+	if diverged > 0 {
+		return fmt.Errorf("%d of %d invocations diverged from the recording", diverged, replayed)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package sat
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/vektor/vtest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	recording := filepath.Join(t.TempDir(), "invocations.jsonl")
+
+	t.Setenv("SAT_RECORD_FILE", recording)
+
+	sat, tp, err := satForFile("../examples/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to satForFile"))
+	}
+	ctx, ctxCloser := context.WithTimeout(context.Background(), time.Second)
+	defer ctxCloser()
+	defer tp.Shutdown(ctx)
+
+	vt := vtest.New(sat.testServer())
+
+	for _, name := range []string{"my friend", "joe"} {
+		req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer([]byte(name)))
+		vt.Do(req, t).AssertStatus(200)
+	}
+
+	replay := func(module string) (string, error) {
+		t.Helper()
+
+		config, err := ConfigFromRunnableArg(module)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "failed to ConfigFromRunnableArg"))
+		}
+
+		config.Recording = recording
+
+		out := &bytes.Buffer{}
+		err = Replay(config, out)
+
+		return out.String(), err
+	}
+
+	out, err := replay("../examples/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Replay"))
+	}
+
+	if strings.Count(out, "matches the recording") != 2 {
+		t.Errorf("expected both invocations to match the recording, got %s", out)
+	}
+
+	// another version of the module replays the same requests, but its output diverges
+	changed := filepath.Join(t.TempDir(), "hello-echo.wasm")
+	copyModule(t, "../examples/return-err/return-err.wasm", changed)
+
+	out, err = replay(changed)
+	if err == nil || !strings.Contains(out, `result: expected output "hello my friend", got error 401 "don't go there"`) {
+		t.Errorf("expected the changed module to diverge from the recording, got %s (%v)", out, err)
+	}
+
+	if _, err := replay("../examples/return-err/return-err.wasm"); err == nil {
+		t.Error("expected an error replaying a module that isn't in the recording")
+	}
+}
@@ -0,0 +1,284 @@
+package sat
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Route sends the requests that match its method and path to a module. Path is made of segments separated by /,
+// where a segment starting with : matches any single segment and a final segment starting with * matches the rest
+// of the path (including nothing at all). The segments they match are passed to the module as the request's params.
+type Route struct {
+	// Method is the HTTP method to match, or any method if it is empty or *
+	Method string `yaml:"method" json:"method,omitempty"`
+	Path   string `yaml:"path" json:"path"`
+	// Module is the module's .wasm file, which is relative to the routes file when read from one
+	Module string `yaml:"module" json:"-"`
+	// FQMN is the name that the module is registered under, which defaults to the name of its file
+	FQMN string `yaml:"fqmn" json:"module"`
+}
+
+// routesFile is the YAML file that lists the routes of a sat serving several modules
+type routesFile struct {
+	Routes []Route `yaml:"routes"`
+}
+
+// Name returns the name that the route's module is registered under
+func (r Route) Name() string {
+	if r.FQMN != "" {
+		return r.FQMN
+	}
+
+	return strings.TrimSuffix(filepath.Base(r.Module), ".wasm")
+}
+
+// moduleRoute returns the default route of a module served alongside others, which sends every request under
+// /<name> to it
+func moduleRoute(module string) Route {
+	r := Route{Module: module}
+	r.Path = fmt.Sprintf("/%s/*path", r.Name())
+
+	return r
+}
+
+// isRoutesFile returns true if the module argument names a routes file rather than a module
+func isRoutesFile(arg string) bool {
+	ext := filepath.Ext(arg)
+
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// routesFromArgs returns the routes for sat's module arguments, which can name several modules, a directory of
+// modules or a routes file. It returns no routes when sat serves a single module, which is sent every request.
+func routesFromArgs(args []string) ([]Route, error) {
+	if len(args) == 1 {
+		if isRoutesFile(args[0]) {
+			return readRoutesFile(args[0])
+		}
+
+		if info, err := os.Stat(args[0]); err != nil || !info.IsDir() {
+			return nil, nil
+		}
+	}
+
+	routes := []Route{}
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to Stat %s", arg)
+		}
+
+		if !info.IsDir() {
+			routes = append(routes, moduleRoute(arg))
+			continue
+		}
+
+		modules, err := filepath.Glob(filepath.Join(arg, "*.wasm"))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to Glob")
+		}
+
+		if len(modules) == 0 {
+			return nil, fmt.Errorf("directory %s contains no modules", arg)
+		}
+
+		sort.Strings(modules)
+
+		for _, m := range modules {
+			routes = append(routes, moduleRoute(m))
+		}
+	}
+
+	if err := validateRoutes(routes); err != nil {
+		return nil, errors.Wrap(err, "failed to validateRoutes")
+	}
+
+	return routes, nil
+}
+
+// readRoutesFile reads the routes listed in a routes file
+func readRoutesFile(filename string) ([]Route, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ReadFile")
+	}
+
+	file := routesFile{}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, errors.Wrap(err, "failed to Unmarshal routes")
+	}
+
+	if len(file.Routes) == 0 {
+		return nil, fmt.Errorf("routes file %s lists no routes", filename)
+	}
+
+	for i := range file.Routes {
+		if file.Routes[i].Module != "" && !filepath.IsAbs(file.Routes[i].Module) {
+			file.Routes[i].Module = filepath.Join(filepath.Dir(filename), file.Routes[i].Module)
+		}
+	}
+
+	if err := validateRoutes(file.Routes); err != nil {
+		return nil, errors.Wrap(err, "failed to validateRoutes")
+	}
+
+	return file.Routes, nil
+}
+
+// validateRoutes checks that every route can be matched, and that each module is only registered under one name
+func validateRoutes(routes []Route) error {
+	names := map[string]string{}
+
+	for _, r := range routes {
+		if r.Module == "" {
+			return fmt.Errorf("route %s has no module", r.Path)
+		}
+
+		if _, err := parsePattern(r.Path); err != nil {
+			return errors.Wrapf(err, "invalid path for module %s", r.Module)
+		}
+
+		if r.Method != "" && r.Method != "*" && strings.ToUpper(r.Method) != r.Method {
+			return fmt.Errorf("route %s has method %s, expected an upper case HTTP method", r.Path, r.Method)
+		}
+
+		// modules in different directories can share a filename, and so the name that they are registered under
+		module := filepath.Clean(r.Module)
+		if other, exists := names[r.Name()]; exists && other != module {
+			return fmt.Errorf("modules %s and %s are both named %s, give them different FQMNs in a routes file", other, module, r.Name())
+		}
+
+		names[r.Name()] = module
+	}
+
+	return nil
+}
+
+// router matches requests to the modules that their routes send them to, in the order the routes are listed
+type router struct {
+	routes []compiledRoute
+}
+
+type compiledRoute struct {
+	method   string
+	segments []string
+	name     string
+}
+
+// newRouter compiles routes into a router
+func newRouter(routes []Route) (*router, error) {
+	r := &router{}
+
+	for _, route := range routes {
+		segments, err := parsePattern(route.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parsePattern %s", route.Path)
+		}
+
+		method := route.Method
+		if method == "*" {
+			method = ""
+		}
+
+		r.routes = append(r.routes, compiledRoute{method: method, segments: segments, name: route.Name()})
+	}
+
+	return r, nil
+}
+
+// match returns the name of the module that the request is routed to, along with the params its path matched. The
+// params match those that a catch-all route registered with the HTTP router would have, so that a sat serving a
+// single module passes its module the same params as it always has.
+func (r *router) match(method, path string) (string, map[string]string, bool) {
+	requestSegments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	for _, route := range r.routes {
+		if route.method != "" && route.method != method {
+			continue
+		}
+
+		if params, ok := route.match(requestSegments); ok {
+			return route.name, params, true
+		}
+	}
+
+	return "", nil, false
+}
+
+// match returns the params of the path's segments if they match the route
+func (c compiledRoute) match(path []string) (map[string]string, bool) {
+	params := map[string]string{}
+
+	for i, segment := range c.segments {
+		if strings.HasPrefix(segment, "*") {
+			rest := ""
+			if i < len(path) {
+				rest = "/" + strings.Join(path[i:], "/")
+			}
+
+			params[segment[1:]] = rest
+
+			return params, true
+		}
+
+		if i >= len(path) {
+			return nil, false
+		}
+
+		if strings.HasPrefix(segment, ":") {
+			if path[i] == "" {
+				return nil, false
+			}
+
+			params[segment[1:]] = path[i]
+		} else if segment != path[i] {
+			return nil, false
+		}
+	}
+
+	if len(path) != len(c.segments) {
+		return nil, false
+	}
+
+	return params, true
+}
+
+// parsePattern splits a route's path into its segments, checking that params are named and that a catch-all is last
+func parsePattern(pattern string) ([]string, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pattern)
+	}
+
+	segments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+
+	for i, s := range segments {
+		if (strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*")) && len(s) == 1 {
+			return nil, fmt.Errorf("path %q has a param without a name", pattern)
+		}
+
+		if strings.HasPrefix(s, "*") && i != len(segments)-1 {
+			return nil, fmt.Errorf("path %q has a catch-all param before its last segment", pattern)
+		}
+	}
+
+	return segments, nil
+}
+
+// routeMethods are the HTTP methods that sat serves modules on
+var routeMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodHead,
+	http.MethodOptions,
+}
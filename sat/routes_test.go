@@ -0,0 +1,264 @@
+package sat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/suborbital/vektor/vtest"
+
+	"github.com/suborbital/sat/sat/metrics"
+)
+
+const returnErrFQMN = "fqmn://acmeco/default/return-err@v1.0.0"
+
+func TestRouterMatch(t *testing.T) {
+	r, err := newRouter([]Route{
+		{Method: http.MethodPost, Path: "/users/:name/files/*path", Module: "files.wasm"},
+		{Path: "/users/:name", Module: "users.wasm"},
+		{Method: "*", Path: "/hello/*rest", Module: "hello.wasm"},
+		{Path: "/", Module: "root.wasm"},
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to newRouter"))
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		module string
+		params map[string]string
+	}{
+		{http.MethodPost, "/users/joe/files/a/b", "files", map[string]string{"name": "joe", "path": "/a/b"}},
+		{http.MethodPost, "/users/joe/files", "files", map[string]string{"name": "joe", "path": ""}},
+		{http.MethodGet, "/users/joe/files/a", "", nil},
+		{http.MethodGet, "/users/joe", "users", map[string]string{"name": "joe"}},
+		{http.MethodGet, "/users/", "", nil},
+		{http.MethodDelete, "/hello", "hello", map[string]string{"rest": ""}},
+		{http.MethodDelete, "/hello/", "hello", map[string]string{"rest": "/"}},
+		{http.MethodGet, "/", "root", map[string]string{}},
+		{http.MethodGet, "/nope", "", nil},
+	}
+
+	for _, test := range tests {
+		module, params, ok := r.match(test.method, test.path)
+		if ok != (test.module != "") || module != test.module || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s %s: expected %q %v, got %q %v", test.method, test.path, test.module, test.params, module, params)
+		}
+	}
+}
+
+func TestRoutesFromArgs(t *testing.T) {
+	routes, err := routesFromArgs([]string{"../examples/hello-echo/hello-echo.wasm"})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to routesFromArgs"))
+	}
+
+	if routes != nil {
+		t.Errorf("expected no routes for a single module, got %v", routes)
+	}
+
+	routes, err = routesFromArgs([]string{"testdata/param-echo", "../examples/hello-echo/hello-echo.wasm"})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to routesFromArgs"))
+	}
+
+	expected := []Route{
+		{Path: "/param-echo/*path", Module: "testdata/param-echo/param-echo.wasm"},
+		{Path: "/hello-echo/*path", Module: "../examples/hello-echo/hello-echo.wasm"},
+	}
+
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected %v, got %v", expected, routes)
+	}
+
+	if _, err := routesFromArgs([]string{"testdata/param-echo/param-echo.wasm", "testdata/param-echo/param-echo.wasm"}); err != nil {
+		t.Errorf("expected a module passed twice to be served once, got %s", err)
+	}
+
+	if _, err := routesFromArgs([]string{"testdata/param-echo/param-echo.wasm", "../examples/missing.wasm"}); err == nil {
+		t.Error("expected an error for a missing module")
+	}
+
+	// modules in different directories that share a filename would be registered under the same name
+	dir := t.TempDir()
+
+	for _, sub := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0700); err != nil {
+			t.Fatal(errors.Wrap(err, "failed to Mkdir"))
+		}
+
+		copyModule(t, "../examples/hello-echo/hello-echo.wasm", filepath.Join(dir, sub, "main.wasm"))
+	}
+
+	if _, err := routesFromArgs([]string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}); err == nil {
+		t.Error("expected an error for two modules named main")
+	}
+}
+
+func TestRoutesModuleConfigs(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, sub := range []string{"granted", "plain"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0700); err != nil {
+			t.Fatal(errors.Wrap(err, "failed to Mkdir"))
+		}
+
+		copyModule(t, "../examples/hello-echo/hello-echo.wasm", filepath.Join(dir, sub, sub+".wasm"))
+	}
+
+	dotYaml := `name: granted
+namespace: default
+lang: rust
+runtime:
+  wasi:
+    env: [GRANTED=yes]
+scheduler:
+  maxWorkers: 9
+`
+	if err := os.WriteFile(filepath.Join(dir, "granted", ".module.yml"), []byte(dotYaml), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile .module.yml"))
+	}
+
+	// the first module's .module.yml applies to it alone
+	config, err := ConfigFromModuleArgs([]string{filepath.Join(dir, "granted"), filepath.Join(dir, "plain")})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ConfigFromModuleArgs"))
+	}
+
+	granted, plain := config.moduleConfig("granted"), config.moduleConfig("plain")
+
+	if !reflect.DeepEqual(granted.RuntimeConfig.WASI.Env, []string{"GRANTED=yes"}) || granted.SchedulerConfig.MaxWorkers != 9 {
+		t.Errorf("expected granted to be configured by its .module.yml, got %+v", granted)
+	}
+
+	if len(plain.RuntimeConfig.WASI.Env) != 0 || plain.SchedulerConfig.MaxWorkers == 9 {
+		t.Errorf("expected plain to be configured by the environment alone, got %+v", plain)
+	}
+
+	// each module's .module.yml is validated
+	invalid := "name: plain\nnamespace: default\nscheduler:\n  minWorkers: 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "plain", ".module.yml"), []byte(invalid), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile .module.yml"))
+	}
+
+	if _, err := ConfigFromModuleArgs([]string{filepath.Join(dir, "granted"), filepath.Join(dir, "plain")}); err == nil {
+		t.Error("expected an invalid .module.yml of the second module to be rejected")
+	}
+}
+
+func TestRoutesFile(t *testing.T) {
+	sat, tp, err := satForArgs("testdata/routes.yaml")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to satForArgs"))
+	}
+	ctx, ctxCloser := context.WithTimeout(context.Background(), time.Second)
+	defer ctxCloser()
+	defer tp.Shutdown(ctx)
+
+	vt := vtest.New(sat.testServer())
+
+	// the route's params are passed to the module, which returns the one named by its input
+	for param, expected := range map[string]string{"name": "joe", "path": "/a/b"} {
+		req, _ := http.NewRequest(http.MethodPost, "/users/joe/files/a/b", bytes.NewBufferString(param))
+
+		resp := vt.Do(req, t)
+
+		resp.AssertStatus(200)
+		resp.AssertBodyString(expected)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "/users/joe/files/a/b", bytes.NewBufferString("any"))
+	vt.Do(req, t).AssertStatus(404)
+
+	// the route to hello-echo allows any method, which includes every method that sat serves
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		req, _ = http.NewRequest(method, "/hello/there", bytes.NewBufferString("my friend"))
+
+		resp := vt.Do(req, t)
+
+		resp.AssertStatus(200)
+		resp.AssertBodyString("hello my friend")
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, "/err", bytes.NewBuffer([]byte{}))
+
+	resp := vt.Do(req, t)
+
+	resp.AssertStatus(401)
+	resp.AssertBodyString(`{"status":401,"message":"don't go there"}`)
+
+	// the route to return-err only allows POST, and nothing is routed to /nope
+	req, _ = http.NewRequest(http.MethodGet, "/err", nil)
+	vt.Do(req, t).AssertStatus(404)
+
+	req, _ = http.NewRequest(http.MethodGet, "/nope", nil)
+	vt.Do(req, t).AssertStatus(404)
+
+	req, _ = http.NewRequest(http.MethodGet, ReadinessPath, nil)
+
+	resp = vt.Do(req, t)
+
+	resp.AssertStatus(200)
+
+	readiness := ReadinessResponse{}
+	if err := json.Unmarshal(resp.Body, &readiness); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Unmarshal"))
+	}
+
+	if !readiness.Ready || len(readiness.Modules) != 3 || !readiness.Modules[returnErrFQMN].Ready() {
+		t.Errorf("expected every module to be ready, got %s", string(resp.Body))
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, MetricsPath, nil)
+
+	resp = vt.Do(req, t)
+
+	resp.AssertStatus(200)
+
+	workerMetrics := WorkerMetricsResponse{}
+	if err := json.Unmarshal(resp.Body, &workerMetrics); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Unmarshal"))
+	}
+
+	expected := map[string][2]uint64{
+		"param-echo":  {3, 1},
+		"hello-echo":  {2, 0},
+		returnErrFQMN: {1, 1},
+	}
+
+	for name, counts := range expected {
+		m, exists := workerMetrics.Modules[name]
+		if !exists || m.Requests != counts[0] || m.Errors != counts[1] || len(m.Routes) != 1 {
+			t.Errorf("expected %s to have served %d requests with %d errors, got %s", name, counts[0], counts[1], string(resp.Body))
+		}
+	}
+}
+
+func satForArgs(args ...string) (*Sat, *trace.TracerProvider, error) {
+	config, err := ConfigFromModuleArgs(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	traceProvider, err := SetupTracing(config.TracerConfig, config.Logger)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "setup tracing")
+	}
+
+	sat, err := New(config, traceProvider, metrics.SetupNoopMetrics())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sat, traceProvider, nil
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -15,10 +16,11 @@ import (
 	"github.com/suborbital/e2core/bus/bus"
 	"github.com/suborbital/e2core/bus/discovery/local"
 	"github.com/suborbital/e2core/bus/transport/websocket"
-	"github.com/suborbital/e2core/scheduler"
 	"github.com/suborbital/vektor/vk"
 	"github.com/suborbital/vektor/vlog"
 
+	"github.com/suborbital/sat/api"
+	"github.com/suborbital/sat/engine"
 	wruntime "github.com/suborbital/sat/engine/runtime"
 	"github.com/suborbital/sat/sat/executor"
 	"github.com/suborbital/sat/sat/metrics"
@@ -41,6 +43,18 @@ type Sat struct {
 	log       *vlog.Logger
 	tracer    trace.Tracer
 	metrics   metrics.Metrics
+
+	// admission bounds the requests to each module that are running or queued, and has no entry for modules that are
+	// unbounded
+	admission map[string]chan struct{}
+
+	// router sends requests to the modules, which are listed in the order of their first route
+	router  *router
+	modules []string
+	stats   map[string]*moduleStats
+
+	// reloadLock stops a module being reloaded by the watcher and the reload endpoint at once
+	reloadLock sync.Mutex
 }
 
 type loggerScope struct {
@@ -56,34 +70,80 @@ var headless = false
 func New(config *Config, traceProvider trace.TracerProvider, mtx metrics.Metrics) (*Sat, error) {
 	wruntime.UseInternalLogger(config.Logger)
 
-	exec, err := executor.New(config.Logger, config.CapConfig)
+	engineOpts := []engine.Option{engine.UseRuntime(config.Runtime)}
+
+	// each invocation is appended to the recording as it ends, so the file is left open for as long as sat runs
+	if config.RecordFile != "" {
+		recording, err := os.OpenFile(config.RecordFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open SAT_RECORD_FILE")
+		}
+
+		config.Logger.Warn("recording every invocation to", config.RecordFile, "including the values of any secrets that modules read")
+
+		engineOpts = append(engineOpts, engine.Record(api.NewRecordWriter(recording)))
+	}
+
+	exec, err := executor.New(config.Logger, config.CapConfig, engineOpts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to executor.New")
 	}
 
-	var runnable *tenant.WasmModuleRef
-	if config.Module != nil && len(config.Module.WasmRef.Data) > 0 {
-		runnable = tenant.NewWasmModuleRef(config.Module.WasmRef.Name, config.Module.WasmRef.FQMN, config.Module.WasmRef.Data)
-	} else {
-		ref, err := refFromFilename("", "", config.RunnableArg)
+	routes := config.routes()
+
+	router, err := newRouter(routes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to newRouter")
+	}
+
+	// each module is registered once under its name, however many routes it is served on (the routes were validated
+	// to name each module once, so a name that has been registered is the same module)
+	modules := []string{}
+	stats := map[string]*moduleStats{}
+	admission := map[string]chan struct{}{}
+
+	for _, route := range routes {
+		name := route.Name()
+		if _, exists := stats[name]; exists {
+			continue
+		}
+
+		runnable, err := routeModuleRef(config, route)
 		if err != nil {
-			return nil, errors.Wrap(err, "faild to refFromFilename")
+			return nil, errors.Wrap(err, "failed to routeModuleRef")
 		}
 
-		runnable = ref
-	}
+		result, err := verifyModule(config, route, runnable.Data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to verifyModule")
+		}
 
-	err = exec.Register(
-		config.JobType,
-		runnable,
-		scheduler.Autoscale(24),
-		scheduler.MaxRetries(0),
-		scheduler.RetrySeconds(0),
-		scheduler.PreWarm(),
-	)
+		moduleConfig := config.moduleConfig(name)
 
-	if err != nil {
-		return nil, errors.Wrap(err, "exec.Register")
+		err = exec.RegisterWithConfig(
+			name,
+			runnable,
+			moduleConfig.RuntimeConfig,
+			moduleConfig.SchedulerConfig.options()...,
+		)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "exec.RegisterWithConfig")
+		}
+
+		// a module that fails to instantiate is reported by the readiness endpoint rather than stopping sat from
+		// starting, since the workers keep trying to build their instances
+		if err := exec.PreWarm(name, moduleConfig.SchedulerConfig.PreWarm); err != nil {
+			config.Logger.Error(errors.Wrapf(err, "failed to exec.PreWarm %s", name))
+		}
+
+		modules = append(modules, name)
+		stats[name] = &moduleStats{}
+		stats[name].signature.Store(&result)
+
+		if queue := moduleConfig.SchedulerConfig.admission(); queue != nil {
+			admission[name] = queue
+		}
 	}
 
 	if traceProvider == nil {
@@ -103,6 +163,10 @@ func New(config *Config, traceProvider trace.TracerProvider, mtx metrics.Metrics
 		log:       config.Logger,
 		tracer:    traceProvider.Tracer("sat"),
 		metrics:   mtx,
+		admission: admission,
+		router:    router,
+		modules:   modules,
+		stats:     stats,
 	}
 
 	// no need to continue setup if we're in stdin mode, so return here
@@ -117,20 +181,17 @@ func New(config *Config, traceProvider trace.TracerProvider, mtx metrics.Metrics
 		vk.UseHTTPPort(config.Port),
 		vk.UseEnvPrefix("SAT"),
 		vk.UseQuietRoutes("/meta/metrics"),
+		vk.UseRouterWrapper(sat.metaWrapper),
 	)
 
 	// if a transport is configured, enable bus and metrics endpoints, otherwise enable server mode
 	if sat.transport != nil {
 		sat.vektor.HandleHTTP(http.MethodGet, "/meta/message", sat.transport.HTTPHandlerFunc())
-		sat.vektor.GET("/meta/metrics", sat.workerMetricsHandler())
 	} else {
-		// allow any HTTP method
-		sat.vektor.GET("/*any", sat.handler(exec))
-		sat.vektor.POST("/*any", sat.handler(exec))
-		sat.vektor.PATCH("/*any", sat.handler(exec))
-		sat.vektor.DELETE("/*any", sat.handler(exec))
-		sat.vektor.HEAD("/*any", sat.handler(exec))
-		sat.vektor.OPTIONS("/*any", sat.handler(exec))
+		// allow any HTTP method, and leave it to the handler to route the request to a module
+		for _, method := range routeMethods {
+			sat.vektor.Handle(method, "/*any", sat.handler(exec))
+		}
 	}
 
 	return sat, nil
@@ -153,6 +214,10 @@ func (s *Sat) Start(ctx context.Context) error {
 		}
 	}
 
+	if s.config.ReloadConfig.Watch {
+		s.watchModules(ctx)
+	}
+
 	select {
 	case <-ctx.Done():
 		if err := s.Shutdown(); err != nil {
@@ -201,7 +266,7 @@ func (s *Sat) setupGrav() error {
 	// and broadcast its "interest" (i.e. the loaded function)
 	s.bus = bus.New(
 		bus.UseBelongsTo(s.config.Identifier),
-		bus.UseInterests(s.config.JobType),
+		bus.UseInterests(s.modules...),
 		bus.UseLogger(s.config.Logger),
 		bus.UseMeshTransport(s.transport),
 		bus.UseDiscovery(local.New()),
@@ -211,8 +276,10 @@ func (s *Sat) setupGrav() error {
 	// set up the Executor to listen for jobs and handle them
 	s.exec.UseBus(s.bus)
 
-	if err := s.exec.ListenAndRun(s.config.JobType, s.handleFnResult); err != nil {
-		return errors.Wrap(err, "executor.ListenAndRun")
+	for _, name := range s.modules {
+		if err := s.exec.ListenAndRun(name, s.handleFnResult); err != nil {
+			return errors.Wrap(err, "executor.ListenAndRun")
+		}
 	}
 
 	if err := connectStaticPeers(s.config.Logger, s.bus); err != nil {
@@ -227,6 +294,40 @@ func (s *Sat) testServer() *vk.Server {
 	return s.vektor
 }
 
+// moduleRef returns a reference to the module that the config describes
+func moduleRef(config *Config) (*tenant.WasmModuleRef, error) {
+	// modules described by a .module.yml on disk have no WasmRef, and are loaded from the runnable arg
+	if config.Module != nil && config.Module.WasmRef != nil && len(config.Module.WasmRef.Data) > 0 {
+		return tenant.NewWasmModuleRef(config.Module.WasmRef.Name, config.Module.WasmRef.FQMN, config.Module.WasmRef.Data), nil
+	}
+
+	ref, err := refFromFilename("", "", config.RunnableArg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to refFromFilename")
+	}
+
+	return ref, nil
+}
+
+// routes returns the routes that the config serves, which send every request to the module when there is only one
+func (c *Config) routes() []Route {
+	if len(c.Routes) > 0 {
+		return c.Routes
+	}
+
+	// the param is named after the route that sat has always served its module on
+	return []Route{{Path: "/*any", Module: c.RunnableArg, FQMN: c.JobType}}
+}
+
+// routeModuleRef returns a reference to the module that a route sends requests to
+func routeModuleRef(config *Config, route Route) (*tenant.WasmModuleRef, error) {
+	if route.Name() == config.JobType {
+		return moduleRef(config)
+	}
+
+	return refFromFilename("", "", route.Module)
+}
+
 func refFromFilename(name, fqmn, filename string) (*tenant.WasmModuleRef, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -3,6 +3,7 @@ package sat
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"net/http"
 	"testing"
 	"time"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/suborbital/vektor/vtest"
 
+	wruntime "github.com/suborbital/sat/engine/runtime"
 	"github.com/suborbital/sat/sat/metrics"
 )
 
@@ -94,6 +96,65 @@ func TestPanicRequest(t *testing.T) {
 	resp.AssertBodyString(`{"status":500,"message":"unknown error"}`)
 }
 
+func TestPanicRequestDebugTraps(t *testing.T) {
+	sat, tp, err := satForFile("../examples/panic-at-the-disco/panic-at-the-disco.wasm")
+	if err != nil {
+		t.Error(errors.Wrap(err, "failed to satForFile"))
+		return
+	}
+	ctx, ctxCloser := context.WithTimeout(context.Background(), time.Second)
+	defer ctxCloser()
+	defer tp.Shutdown(ctx)
+
+	sat.config.RuntimeConfig.DebugTraps = true
+
+	vt := vtest.New(sat.testServer())
+
+	req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer([]byte{}))
+
+	resp := vt.Do(req, t)
+
+	resp.AssertStatus(500)
+
+	body := trapResponse{}
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Unmarshal"))
+	}
+
+	if body.Status != 500 || body.Trap == nil || body.Trap.Kind != wruntime.TrapUnreachable || len(body.Trap.Frames) == 0 {
+		t.Errorf("expected the trap in the response, got %s", string(resp.Body))
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	sat, tp, err := satForFile("../examples/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Error(errors.Wrap(err, "failed to satForFile"))
+		return
+	}
+	ctx, ctxCloser := context.WithTimeout(context.Background(), time.Second)
+	defer ctxCloser()
+	defer tp.Shutdown(ctx)
+
+	vt := vtest.New(sat.testServer())
+
+	req, _ := http.NewRequest(http.MethodGet, ReadinessPath, nil)
+
+	resp := vt.Do(req, t)
+
+	resp.AssertStatus(200)
+
+	body := ReadinessResponse{}
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Unmarshal"))
+	}
+
+	// the pool is pre-warmed before sat starts serving
+	if !body.Ready || body.Pool.State != wruntime.PoolHealthy || body.Pool.Instances == 0 {
+		t.Errorf("expected a ready, healthy pool, got %s", string(resp.Body))
+	}
+}
+
 func satForFile(filepath string) (*Sat, *trace.TracerProvider, error) {
 	config, err := ConfigFromRunnableArg(filepath)
 	if err != nil {
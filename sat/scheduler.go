@@ -0,0 +1,91 @@
+package sat
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/suborbital/e2core/scheduler"
+)
+
+// SchedulerConfig determines how the module's invocations are scheduled onto workers, each of which runs one
+// invocation at a time on an instance of its own
+type SchedulerConfig struct {
+	// MinWorkers is the number of workers that are kept when the module is idle
+	MinWorkers int `yaml:"minWorkers" json:"minWorkers"`
+	// MaxWorkers is the number of workers that the scheduler scales up to as invocations queue up
+	MaxWorkers int `yaml:"maxWorkers" json:"maxWorkers"`
+	// PreWarm is the number of instances built before sat starts serving, which workers take over as they start
+	PreWarm int `yaml:"preWarm" json:"preWarm"`
+	// Retries and RetryBackoff determine how often, and how far apart, the scheduler retries starting a worker
+	Retries      int           `yaml:"retries" json:"retries"`
+	RetryBackoff time.Duration `yaml:"retryBackoff" json:"retryBackoff"`
+	// QueueDepth is the number of invocations that can wait for a worker once MaxWorkers are busy, beyond which
+	// requests are turned away. Zero means unbounded.
+	QueueDepth int `yaml:"queueDepth" json:"queueDepth"`
+}
+
+// Validate returns an error if the config can't be scheduled
+func (s SchedulerConfig) Validate() error {
+	if s.MinWorkers < 1 {
+		return fmt.Errorf("minWorkers must be at least 1, got %d", s.MinWorkers)
+	}
+
+	if s.MaxWorkers < s.MinWorkers {
+		return fmt.Errorf("maxWorkers (%d) must be at least minWorkers (%d)", s.MaxWorkers, s.MinWorkers)
+	}
+
+	if s.PreWarm < 0 || s.PreWarm > s.MaxWorkers {
+		return fmt.Errorf("preWarm must be between 0 and maxWorkers (%d), got %d", s.MaxWorkers, s.PreWarm)
+	}
+
+	if s.Retries < 0 || s.RetryBackoff < 0 || s.QueueDepth < 0 {
+		return fmt.Errorf("retries, retryBackoff and queueDepth can't be negative")
+	}
+
+	return nil
+}
+
+// options returns the scheduler options for the config. Pre-warming is left to the engine, which builds the
+// instances itself so that sat doesn't start serving until they exist.
+func (s SchedulerConfig) options() []scheduler.Option {
+	return []scheduler.Option{
+		scheduler.PoolSize(s.MinWorkers),
+		scheduler.Autoscale(s.MaxWorkers),
+		scheduler.MaxRetries(s.Retries),
+		// the scheduler only waits in whole seconds, so the backoff is rounded up
+		scheduler.RetrySeconds(int(math.Ceil(s.RetryBackoff.Seconds()))),
+	}
+}
+
+// admission bounds the invocations that are running or waiting for a worker, and is nil when unbounded
+func (s SchedulerConfig) admission() chan struct{} {
+	if s.QueueDepth == 0 {
+		return nil
+	}
+
+	return make(chan struct{}, s.MaxWorkers+s.QueueDepth)
+}
+
+// admit takes a place for a request to the named module among those running or queued, and returns false if there is
+// none left
+func (s *Sat) admit(name string) bool {
+	queue, bounded := s.admission[name]
+	if !bounded {
+		return true
+	}
+
+	select {
+	case queue <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release gives up a place taken by admit
+func (s *Sat) release(name string) {
+	if queue, bounded := s.admission[name]; bounded {
+		<-queue
+	}
+}
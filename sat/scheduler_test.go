@@ -0,0 +1,98 @@
+package sat
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestSchedulerConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SchedulerConfig
+		wantErr bool
+	}{
+		{name: "defaults", config: SchedulerConfig{MinWorkers: 1, MaxWorkers: 24, PreWarm: 1}},
+		{name: "fixed", config: SchedulerConfig{MinWorkers: 2, MaxWorkers: 2, PreWarm: 2}},
+		{name: "no workers", config: SchedulerConfig{MinWorkers: 0, MaxWorkers: 24}, wantErr: true},
+		{name: "max below min", config: SchedulerConfig{MinWorkers: 4, MaxWorkers: 2}, wantErr: true},
+		{name: "pre-warm beyond max", config: SchedulerConfig{MinWorkers: 1, MaxWorkers: 2, PreWarm: 3}, wantErr: true},
+		{name: "negative queue", config: SchedulerConfig{MinWorkers: 1, MaxWorkers: 2, QueueDepth: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSchedulerConfigModuleDotYaml(t *testing.T) {
+	data, err := os.ReadFile("../examples/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+	}
+
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "hello-echo.wasm"), data, 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile module"))
+	}
+
+	dotYaml := "name: hello-echo\nnamespace: default\nlang: rust\nscheduler:\n  maxWorkers: 2\n  preWarm: 2\n  retryBackoff: 1500ms\n"
+	if err := os.WriteFile(filepath.Join(dir, ".module.yml"), []byte(dotYaml), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile .module.yml"))
+	}
+
+	t.Setenv("SAT_SCHEDULER_MIN_WORKERS", "2")
+	t.Setenv("SAT_SCHEDULER_RETRIES", "3")
+
+	config, err := ConfigFromRunnableArg(filepath.Join(dir, "hello-echo.wasm"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ConfigFromRunnableArg"))
+	}
+
+	// the environment sets the defaults, and .module.yml overrides the values it sets
+	expected := SchedulerConfig{MinWorkers: 2, MaxWorkers: 2, PreWarm: 2, Retries: 3, RetryBackoff: 1500 * time.Millisecond}
+	if config.SchedulerConfig != expected {
+		t.Errorf("expected %+v, got %+v", expected, config.SchedulerConfig)
+	}
+
+	// an invalid combination is rejected up front
+	t.Setenv("SAT_SCHEDULER_MIN_WORKERS", "3")
+
+	if _, err := ConfigFromRunnableArg(filepath.Join(dir, "hello-echo.wasm")); err == nil {
+		t.Error("expected minWorkers above maxWorkers to be rejected")
+	}
+}
+
+func TestSchedulerAdmission(t *testing.T) {
+	sat := &Sat{admission: map[string]chan struct{}{"module": SchedulerConfig{MinWorkers: 1, MaxWorkers: 1, QueueDepth: 1}.admission()}}
+
+	// one request can run and one can wait for it
+	if !sat.admit("module") || !sat.admit("module") {
+		t.Fatal("expected two requests to be admitted")
+	}
+
+	if sat.admit("module") {
+		t.Fatal("expected the third request to be turned away")
+	}
+
+	sat.release("module")
+
+	if !sat.admit("module") {
+		t.Error("expected a request to be admitted once another finished")
+	}
+
+	unbounded := &Sat{admission: map[string]chan struct{}{}}
+	for i := 0; i < 100; i++ {
+		if !unbounded.admit("module") {
+			t.Fatal("expected an unbounded queue to admit every request")
+		}
+	}
+}
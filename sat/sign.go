@@ -0,0 +1,128 @@
+package sat
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/vektor/vlog"
+
+	"github.com/suborbital/sat/sat/signature"
+)
+
+// SignConfig holds the flags of the sign command
+type SignConfig struct {
+	// Key is the private key file to sign with
+	Key string
+	// Embed adds the signature to the module itself rather than writing it to a detached signature file
+	Embed bool
+	// GenerateKey creates a new private key file rather than signing modules
+	GenerateKey bool
+	// Files are the modules to sign, or the key file to create
+	Files []string
+}
+
+// flags registers the sign command's flags
+func (c *SignConfig) flags(flags *flag.FlagSet) {
+	flags.StringVar(&c.Key, "key", "", "the private key file to sign the modules with")
+	flags.BoolVar(&c.Embed, "embed", false, "embed the signature in the module rather than writing it to <module>"+signature.DetachedSuffix)
+	flags.BoolVar(&c.GenerateKey, "generate-key", false, "create a private key file at the given path and print its public key, rather than signing")
+}
+
+// config validates the sign command's arguments and returns its config
+func (c SignConfig) config(args []string) (*Config, error) {
+	c.Files = args
+
+	if c.GenerateKey {
+		if len(args) != 1 {
+			return nil, errors.New("sign -generate-key requires the path of the key file to create")
+		}
+	} else {
+		if c.Key == "" {
+			return nil, errors.New("missing flag: sign requires -key, a private key file created by sign -generate-key")
+		}
+
+		if len(args) < 1 {
+			return nil, errors.New("missing argument: sign requires a module (path)")
+		}
+	}
+
+	config := &Config{
+		Command:    CommandSign,
+		SignConfig: c,
+		Logger:     vlog.Default(vlog.EnvPrefix("SAT"), vlog.AppMeta(satInfo{SatVersion: SatDotVersion})),
+	}
+
+	return config, nil
+}
+
+// Sign signs the modules named by the config, writing each signature next to its module or embedding it in the
+// module, or creates a new private key when the config asks for one. What was done is described on w.
+func Sign(config *Config, w io.Writer) error {
+	if config.SignConfig.GenerateKey {
+		filename := config.SignConfig.Files[0]
+
+		pub, err := signature.GenerateKey(filename)
+		if err != nil {
+			return errors.Wrap(err, "failed to GenerateKey")
+		}
+
+		fmt.Fprintf(w, "created private key %s\n", filename)
+		fmt.Fprintf(w, "public key (add it to SAT_TRUSTED_KEYS to load the modules it signs): %s\n", signature.KeyID(pub))
+
+		return nil
+	}
+
+	key, err := signature.ReadPrivateKey(config.SignConfig.Key)
+	if err != nil {
+		return errors.Wrap(err, "failed to ReadPrivateKey")
+	}
+
+	keyID := signature.KeyID(key.Public().(ed25519.PublicKey))
+
+	for _, filename := range config.SignConfig.Files {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return errors.Wrap(err, "failed to Stat")
+		}
+
+		module, err := os.ReadFile(filename)
+		if err != nil {
+			return errors.Wrap(err, "failed to ReadFile")
+		}
+
+		detachedFile := filename + signature.DetachedSuffix
+
+		if !config.SignConfig.Embed {
+			if err := os.WriteFile(detachedFile, signature.Detached(module, key), 0644); err != nil {
+				return errors.Wrap(err, "failed to WriteFile")
+			}
+
+			fmt.Fprintf(w, "signed %s with key %s, writing the signature to %s\n", filename, keyID, detachedFile)
+
+			continue
+		}
+
+		signed, err := signature.Embed(module, key)
+		if err != nil {
+			return errors.Wrapf(err, "failed to Embed signature in %s", filename)
+		}
+
+		if err := os.WriteFile(filename, signed, info.Mode().Perm()); err != nil {
+			return errors.Wrap(err, "failed to WriteFile")
+		}
+
+		fmt.Fprintf(w, "signed %s with key %s, embedding the signature\n", filename, keyID)
+
+		// sat checks a detached signature in place of the embedded one, and that signature no longer matches
+		if _, err := os.Stat(detachedFile); err == nil {
+			fmt.Fprintf(w, "warning: %s takes precedence over the embedded signature, and should be removed\n", detachedFile)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+// Package signature signs Wasm modules with ed25519 keys, and verifies modules against a set of trusted keys before
+// they are loaded.
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/engine/wasmbinary"
+)
+
+// SectionName is the name of the custom section that an embedded signature is stored in. It must be the module's last
+// section, and signs every byte of the module that comes before it.
+const SectionName = "sat.signature"
+
+// DetachedSuffix is added to a module's filename to find its detached signature, which signs every byte of the module
+// and is stored base64-encoded
+const DetachedSuffix = ".sig"
+
+// the ways that a module can carry its signature
+const (
+	MethodDetached = "detached"
+	MethodEmbedded = "embedded"
+)
+
+var (
+	ErrUnsigned = errors.New("module is not signed")
+	ErrInvalid  = errors.New("invalid module signature")
+)
+
+// KeySet is the set of public keys that modules are trusted to be signed with
+type KeySet []ed25519.PublicKey
+
+// ParseKeys parses a set of trusted keys, each of which is a base64-encoded ed25519 public key or the path to a file
+// holding one
+func ParseKeys(values []string) (KeySet, error) {
+	keys := KeySet{}
+
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		key, err := ParsePublicKey(value)
+		if err != nil {
+			data, readErr := os.ReadFile(value)
+			if readErr != nil {
+				return nil, fmt.Errorf("trusted key %q is neither a base64-encoded ed25519 public key nor a readable file", value)
+			}
+
+			if key, err = ParsePublicKey(string(data)); err != nil {
+				return nil, errors.Wrapf(err, "failed to ParsePublicKey from %s", value)
+			}
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// ParsePublicKey decodes a base64-encoded ed25519 public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to DecodeString")
+	}
+
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key is %d bytes, expected %d", len(key), ed25519.PublicKeySize)
+	}
+
+	return ed25519.PublicKey(key), nil
+}
+
+// KeyID identifies a public key, and is the form that it is given in SAT_TRUSTED_KEYS
+func KeyID(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// IDs returns the ID of each key in the set
+func (k KeySet) IDs() []string {
+	ids := make([]string, len(k))
+
+	for i, key := range k {
+		ids[i] = KeyID(key)
+	}
+
+	return ids
+}
+
+// Result is the outcome of verifying a module's signature
+type Result struct {
+	Signed   bool `json:"signed"`
+	Verified bool `json:"verified"`
+	// Method is how the module carries its signature, and Key is the ID of the trusted key that it was signed with
+	Method string `json:"method,omitempty"`
+	Key    string `json:"key,omitempty"`
+}
+
+// String describes the result for logs
+func (r Result) String() string {
+	switch {
+	case r.Verified:
+		return fmt.Sprintf("verified (%s signature from key %s)", r.Method, r.Key)
+	case r.Signed:
+		return fmt.Sprintf("not verified (%s signature from an untrusted key)", r.Method)
+	default:
+		return "not signed"
+	}
+}
+
+// Verify checks that a module was signed by one of the keys. The signature is read from detached if it isn't nil,
+// which is the contents of a detached signature file, and from the module's signature section otherwise. The result
+// describes the signature even when the error is not nil.
+func Verify(module, detached []byte, keys KeySet) (Result, error) {
+	result := Result{}
+
+	signed := module
+	var sig []byte
+
+	if detached != nil {
+		result = Result{Signed: true, Method: MethodDetached}
+
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(detached)))
+		if err != nil {
+			return result, errors.Wrap(ErrInvalid, "detached signature is not base64-encoded")
+		}
+
+		sig = decoded
+	} else {
+		rest, data, ok, err := wasmbinary.TrailingCustomSection(module, SectionName)
+		if err != nil {
+			return result, errors.Wrap(err, "failed to TrailingCustomSection")
+		}
+
+		if !ok {
+			return result, ErrUnsigned
+		}
+
+		result = Result{Signed: true, Method: MethodEmbedded}
+		signed, sig = rest, data
+	}
+
+	if len(sig) != ed25519.SignatureSize {
+		return result, errors.Wrapf(ErrInvalid, "%s signature is %d bytes, expected %d", result.Method, len(sig), ed25519.SignatureSize)
+	}
+
+	for _, key := range keys {
+		if ed25519.Verify(key, signed, sig) {
+			result.Verified = true
+			result.Key = KeyID(key)
+
+			return result, nil
+		}
+	}
+
+	return result, errors.Wrap(ErrInvalid, "the signature doesn't match any trusted key")
+}
+
+// Detached signs a module, returning the contents of its detached signature file
+func Detached(module []byte, key ed25519.PrivateKey) []byte {
+	sig := ed25519.Sign(key, module)
+
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+}
+
+// Embed signs a module, returning it with the signature added as its last section. A signature that the module
+// already carries is replaced.
+func Embed(module []byte, key ed25519.PrivateKey) ([]byte, error) {
+	rest, _, ok, err := wasmbinary.TrailingCustomSection(module, SectionName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to TrailingCustomSection")
+	}
+
+	if ok {
+		module = rest
+	}
+
+	return wasmbinary.AppendCustomSection(module, SectionName, ed25519.Sign(key, module)), nil
+}
+
+// GenerateKey creates a private key file that modules can be signed with, and returns its public key. An existing
+// file is never overwritten.
+func GenerateKey(filename string) (ed25519.PublicKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to GenerateKey")
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to OpenFile")
+	}
+
+	defer file.Close()
+
+	if _, err := file.WriteString(base64.StdEncoding.EncodeToString(priv.Seed()) + "\n"); err != nil {
+		return nil, errors.Wrap(err, "failed to WriteString")
+	}
+
+	return pub, nil
+}
+
+// ReadPrivateKey reads a private key file created by GenerateKey
+func ReadPrivateKey(filename string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ReadFile")
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to DecodeString")
+	}
+
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("private key is %d bytes, expected %d", len(seed), ed25519.SeedSize)
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
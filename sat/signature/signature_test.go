@@ -0,0 +1,106 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestVerify(t *testing.T) {
+	module, err := os.ReadFile("../../examples/hello-echo/hello-echo.wasm")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadFile"))
+	}
+
+	dir := t.TempDir()
+
+	trustedPub, err := GenerateKey(filepath.Join(dir, "trusted.key"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to GenerateKey"))
+	}
+
+	trusted, err := ReadPrivateKey(filepath.Join(dir, "trusted.key"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ReadPrivateKey"))
+	}
+
+	_, untrusted, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to GenerateKey"))
+	}
+
+	if _, err := GenerateKey(filepath.Join(dir, "trusted.key")); err == nil {
+		t.Error("expected an existing key file not to be overwritten")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "trusted.pub"), []byte(KeyID(trustedPub)+"\n"), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to WriteFile"))
+	}
+
+	// keys are given inline or as files
+	keys, err := ParseKeys([]string{KeyID(untrusted.Public().(ed25519.PublicKey))[:10] + "=", filepath.Join(dir, "trusted.pub")})
+	if err == nil {
+		t.Fatal("expected an error for a malformed key")
+	}
+
+	keys, err = ParseKeys([]string{filepath.Join(dir, "trusted.pub")})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to ParseKeys"))
+	}
+
+	embedded, err := Embed(module, trusted)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Embed"))
+	}
+
+	// signing again replaces the signature rather than adding another
+	resigned, err := Embed(embedded, trusted)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Embed"))
+	}
+
+	if len(resigned) != len(embedded) {
+		t.Errorf("expected re-signing to replace the signature, the module grew from %d to %d bytes", len(embedded), len(resigned))
+	}
+
+	untrustedEmbedded, err := Embed(module, untrusted)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Embed"))
+	}
+
+	tampered := append([]byte{}, embedded...)
+	tampered[len(module)/2]++
+
+	tests := []struct {
+		name     string
+		module   []byte
+		detached []byte
+		expected Result
+		err      error
+	}{
+		{"detached", module, Detached(module, trusted), Result{Signed: true, Verified: true, Method: MethodDetached, Key: KeyID(trustedPub)}, nil},
+		{"embedded", embedded, nil, Result{Signed: true, Verified: true, Method: MethodEmbedded, Key: KeyID(trustedPub)}, nil},
+		{"unsigned", module, nil, Result{}, ErrUnsigned},
+		{"untrusted detached", module, Detached(module, untrusted), Result{Signed: true, Method: MethodDetached}, ErrInvalid},
+		{"untrusted embedded", untrustedEmbedded, nil, Result{Signed: true, Method: MethodEmbedded}, ErrInvalid},
+		{"tampered", tampered, nil, Result{Signed: true, Method: MethodEmbedded}, ErrInvalid},
+		{"detached for another module", embedded, Detached(module, trusted), Result{Signed: true, Method: MethodDetached}, ErrInvalid},
+		{"garbled detached", module, []byte("not a signature"), Result{Signed: true, Method: MethodDetached}, ErrInvalid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := Verify(test.module, test.detached, keys)
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+
+			if result != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, result)
+			}
+		})
+	}
+}
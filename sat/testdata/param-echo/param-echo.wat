@@ -0,0 +1,47 @@
+;; a module built against v2 of the host API that returns the URL param named by its input, or a run-error if the
+;; request has no such param
+(module
+  (import "sat:host/request@2.0.0" "url-param" (func $url_param (param i32 i32 i32)))
+
+  (memory (export "memory") 1)
+
+  ;; the message of the error returned for a missing param
+  (data (i32.const 64) "no such param")
+
+  ;; a bump allocator, reset once the host has read the result of run
+  (global $heap (mut i32) (i32.const 4096))
+
+  (func $cabi_realloc (export "cabi_realloc") (param $old i32) (param $oldSize i32) (param $align i32) (param $size i32) (result i32)
+    (local $pointer i32)
+    ;; align the heap
+    (local.set $pointer
+      (i32.and
+        (i32.add (global.get $heap) (i32.sub (local.get $align) (i32.const 1)))
+        (i32.sub (i32.const 0) (local.get $align))))
+    (global.set $heap (i32.add (local.get $pointer) (local.get $size)))
+    ;; grow the memory to fit
+    (if (i32.gt_u (global.get $heap) (i32.mul (memory.size) (i32.const 65536)))
+      (then
+        (drop (memory.grow
+          (i32.add
+            (i32.div_u (i32.sub (global.get $heap) (i32.mul (memory.size) (i32.const 65536))) (i32.const 65536))
+            (i32.const 1))))))
+    (local.get $pointer))
+
+  ;; run's return area is at 16, and that of request.url-param at 32
+  (func (export "run") (param $pointer i32) (param $size i32) (result i32)
+    (call $url_param (local.get $pointer) (local.get $size) (i32.const 32))
+    (if (i32.eqz (i32.load8_u (i32.const 32)))
+      (then
+        (i32.store8 (i32.const 16) (i32.const 1))
+        (i32.store16 (i32.const 20) (i32.const 404))
+        (i32.store (i32.const 24) (i32.const 64))
+        (i32.store (i32.const 28) (i32.const 13))
+        (return (i32.const 16))))
+
+    (i32.store8 (i32.const 16) (i32.const 0))
+    (i64.store (i32.const 20) (i64.load (i32.const 36)))
+    (i32.const 16))
+
+  (func (export "cabi_post_run") (param $pointer i32)
+    (global.set $heap (i32.const 4096))))
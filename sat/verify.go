@@ -0,0 +1,46 @@
+package sat
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/sat/sat/signature"
+)
+
+// verifyModule checks the signature of a route's module against the trusted keys, refusing a module that is unsigned
+// or wrongly signed when SAT_TRUSTED_KEYS is set. Modules aren't required to be signed when it isn't, and the result is
+// only reported.
+func verifyModule(config *Config, route Route, module []byte) (signature.Result, error) {
+	result, err := moduleSignature(config, route, module)
+
+	if len(config.TrustedKeys) == 0 {
+		config.Logger.Debug("module", route.Name(), "signature", result.String(), "(not required as SAT_TRUSTED_KEYS is not set)")
+		return result, nil
+	}
+
+	if err != nil {
+		return result, errors.Wrapf(err, "refusing to load module %s as SAT_TRUSTED_KEYS is set", route.Name())
+	}
+
+	config.Logger.Info("module", route.Name(), "signature", result.String())
+
+	return result, nil
+}
+
+// moduleSignature verifies the signature of a route's module. A module on disk can be signed by a detached signature
+// file next to it, and otherwise (like those from the control plane) must embed its signature.
+func moduleSignature(config *Config, route Route, module []byte) (signature.Result, error) {
+	var detached []byte
+
+	if !config.fromControlPlane(route.Name()) {
+		data, err := os.ReadFile(route.Module + signature.DetachedSuffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return signature.Result{}, errors.Wrap(err, "failed to ReadFile")
+		}
+
+		detached = data
+	}
+
+	return signature.Verify(module, detached, config.TrustedKeys)
+}
@@ -0,0 +1,121 @@
+package sat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/suborbital/vektor/vtest"
+
+	"github.com/suborbital/sat/sat/signature"
+)
+
+func TestSignedModules(t *testing.T) {
+	dir := t.TempDir()
+	module := filepath.Join(dir, "echo.wasm")
+	copyModule(t, "../examples/hello-echo/hello-echo.wasm", module)
+
+	trusted, untrusted := filepath.Join(dir, "trusted.key"), filepath.Join(dir, "untrusted.key")
+
+	out := &bytes.Buffer{}
+
+	for _, key := range []string{trusted, untrusted} {
+		if err := Sign(&Config{SignConfig: SignConfig{GenerateKey: true, Files: []string{key}}}, out); err != nil {
+			t.Fatal(errors.Wrap(err, "failed to Sign"))
+		}
+	}
+
+	// the public key to trust is printed when the key is created
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	trustedID := lines[1][strings.LastIndex(lines[1], " ")+1:]
+
+	t.Setenv("SAT_TRUSTED_KEYS", trustedID)
+
+	if _, _, err := satForFile(module); !errors.Is(err, signature.ErrUnsigned) {
+		t.Errorf("expected an unsigned module to be refused, got %v", err)
+	}
+
+	sign := func(key string, embed bool) {
+		t.Helper()
+
+		if err := Sign(&Config{SignConfig: SignConfig{Key: key, Embed: embed, Files: []string{module}}}, &bytes.Buffer{}); err != nil {
+			t.Fatal(errors.Wrap(err, "failed to Sign"))
+		}
+	}
+
+	sign(untrusted, false)
+
+	if _, _, err := satForFile(module); !errors.Is(err, signature.ErrInvalid) {
+		t.Errorf("expected a module signed by an untrusted key to be refused, got %v", err)
+	}
+
+	sign(trusted, true)
+
+	// the detached signature from the untrusted key takes precedence over the embedded one
+	if _, _, err := satForFile(module); !errors.Is(err, signature.ErrInvalid) {
+		t.Errorf("expected a module signed by an untrusted key to be refused, got %v", err)
+	}
+
+	if err := os.Remove(module + signature.DetachedSuffix); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Remove"))
+	}
+
+	sat, tp, err := satForFile(module)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to satForFile"))
+	}
+	ctx, ctxCloser := context.WithTimeout(context.Background(), time.Second)
+	defer ctxCloser()
+	defer tp.Shutdown(ctx)
+
+	sat.config.ReloadConfig.Endpoint = true
+
+	vt := vtest.New(sat.testServer())
+
+	expectEcho(t, vt, 200, "hello my friend")
+	expectSignature(t, vt, signature.Result{Signed: true, Verified: true, Method: signature.MethodEmbedded, Key: trustedID})
+
+	// a version that isn't signed is refused, leaving the signed version serving
+	copyModule(t, "../examples/return-err/return-err.wasm", module)
+
+	req, _ := http.NewRequest(http.MethodPost, ReloadPath, nil)
+	vt.Do(req, t).AssertStatus(500)
+
+	expectEcho(t, vt, 200, "hello my friend")
+
+	sign(trusted, false)
+
+	req, _ = http.NewRequest(http.MethodPost, ReloadPath, nil)
+	vt.Do(req, t).AssertStatus(200)
+
+	expectEcho(t, vt, 401, `{"status":401,"message":"don't go there"}`)
+	expectSignature(t, vt, signature.Result{Signed: true, Verified: true, Method: signature.MethodDetached, Key: trustedID})
+}
+
+// expectSignature checks the signature that the info endpoint reports for the echo module
+func expectSignature(t *testing.T, vt *vtest.VTest, expected signature.Result) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, InfoPath, nil)
+
+	resp := vt.Do(req, t)
+
+	resp.AssertStatus(200)
+
+	info := InfoResponse{}
+	if err := json.Unmarshal(resp.Body, &info); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to Unmarshal"))
+	}
+
+	if len(info.TrustedKeys) != 1 || info.Modules["echo"].Signature != expected {
+		t.Errorf("expected the echo module's signature to be %+v, got %s", expected, string(resp.Body))
+	}
+}
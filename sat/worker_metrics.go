@@ -2,29 +2,70 @@ package sat
 
 import (
 	"net/http"
+	"sync/atomic"
 
 	"github.com/pkg/errors"
 
 	"github.com/suborbital/e2core/scheduler"
-	"github.com/suborbital/vektor/vk"
+
+	wruntime "github.com/suborbital/sat/engine/runtime"
+	"github.com/suborbital/sat/sat/signature"
 )
 
+// MetricsPath reports the scheduler's metrics along with those of each module, and is served in front of the router
+const MetricsPath = "/meta/metrics"
+
 type WorkerMetricsResponse struct {
-	Scheduler scheduler.ScalerMetrics `json:"scheduler"`
+	Scheduler scheduler.ScalerMetrics  `json:"scheduler"`
+	Modules   map[string]ModuleMetrics `json:"modules"`
 }
 
-func (s *Sat) workerMetricsHandler() vk.HandlerFunc {
-	return func(r *http.Request, ctx *vk.Ctx) (interface{}, error) {
-		metrics, err := s.exec.Metrics()
-		if err != nil {
-			ctx.Log.Error(errors.Wrap(err, "failed to exec.Metrics"))
-			return nil, vk.E(http.StatusInternalServerError, "unknown error")
-		}
+// ModuleMetrics are the metrics of one of the modules that sat serves
+type ModuleMetrics struct {
+	Routes []Route `json:"routes"`
+	// Requests counts the HTTP requests routed to the module, and Errors those that it failed to serve
+	Requests uint64                  `json:"requests"`
+	Errors   uint64                  `json:"errors"`
+	Workers  scheduler.WorkerMetrics `json:"workers"`
+	Pool     wruntime.PoolHealth     `json:"pool"`
+}
+
+// moduleStats counts the requests served by a module, and holds the signature of the version being served
+type moduleStats struct {
+	requests  atomic.Uint64
+	errors    atomic.Uint64
+	signature atomic.Pointer[signature.Result]
+}
 
-		resp := &WorkerMetricsResponse{
-			Scheduler: *metrics,
+// serveWorkerMetrics responds with the metrics of the scheduler and the modules
+func (s *Sat) serveWorkerMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics, err := s.exec.Metrics()
+	if err != nil {
+		s.log.Error(errors.Wrap(err, "failed to exec.Metrics"))
+		http.Error(w, "unknown error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := &WorkerMetricsResponse{
+		Scheduler: *metrics,
+		Modules:   map[string]ModuleMetrics{},
+	}
+
+	for _, name := range s.modules {
+		resp.Modules[name] = ModuleMetrics{
+			Routes:   []Route{},
+			Requests: s.stats[name].requests.Load(),
+			Errors:   s.stats[name].errors.Load(),
+			Workers:  metrics.Workers[name],
+			Pool:     s.moduleHealth(name),
 		}
+	}
 
-		return resp, nil
+	for _, route := range s.config.routes() {
+		m := resp.Modules[route.Name()]
+		m.Routes = append(m.Routes, route)
+		resp.Modules[route.Name()] = m
 	}
+
+	s.writeMeta(w, r, http.StatusOK, resp)
 }